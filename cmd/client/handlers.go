@@ -10,8 +10,23 @@ import (
 
 func handlerPause(gs *gamelogic.GameState) func(routing.PlayingState) pubsub.AckType {
 	return func(ps routing.PlayingState) pubsub.AckType {
-		defer fmt.Print("> ")
+		defer fmt.Print(gamelogic.Prompt())
 		gs.HandlePause(ps)
 		return pubsub.Ack
 	}
 }
+
+func handlerUpdate(gs *gamelogic.GameState, tc *gamelogic.TurnClock, st *gamelogic.Standings) func(gamelogic.PlayerUpdate) pubsub.AckType {
+	return func(u gamelogic.PlayerUpdate) pubsub.AckType {
+		defer fmt.Print(gamelogic.Prompt())
+		if u.Synced != nil {
+			tc.Sync(u.Synced.Turn)
+			st.Sync(u.Synced.Over)
+		}
+		if u.Queued != "" {
+			tc.AddOrder(u.Queued)
+		}
+		gs.HandleUpdate(u)
+		return pubsub.Ack
+	}
+}
@@ -0,0 +1,18 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/gamelogic"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/pubsub"
+)
+
+// handlerChat shows chat the server passed on to us and keeps it for the
+// chat command.
+func handlerChat(cl *gamelogic.ChatLog) func(gamelogic.ChatMessage) pubsub.AckType {
+	return func(msg gamelogic.ChatMessage) pubsub.AckType {
+		defer fmt.Print(gamelogic.Prompt())
+		cl.HandleMessage(msg)
+		return pubsub.Ack
+	}
+}
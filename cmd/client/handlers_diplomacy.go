@@ -0,0 +1,16 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/gamelogic"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/pubsub"
+)
+
+func handlerDiplomacy(gs *gamelogic.GameState) func(gamelogic.DiplomacyUpdate) pubsub.AckType {
+	return func(du gamelogic.DiplomacyUpdate) pubsub.AckType {
+		defer fmt.Print(gamelogic.Prompt())
+		gs.Diplomacy().HandleUpdate(du)
+		return pubsub.Ack
+	}
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/gamelogic"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/pubsub"
+)
+
+// handlerScores only records the scoreboard; the scores command shows it.
+func handlerScores(st *gamelogic.Standings) func(gamelogic.Scoreboard) pubsub.AckType {
+	return func(b gamelogic.Scoreboard) pubsub.AckType {
+		st.HandleScores(b)
+		return pubsub.Ack
+	}
+}
+
+func handlerGameOver(st *gamelogic.Standings) func(gamelogic.GameOver) pubsub.AckType {
+	return func(over gamelogic.GameOver) pubsub.AckType {
+		defer fmt.Print(gamelogic.Prompt())
+		st.HandleGameOver(over)
+		return pubsub.Ack
+	}
+}
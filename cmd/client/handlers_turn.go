@@ -0,0 +1,24 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/gamelogic"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/pubsub"
+)
+
+func handlerTurnStart(tc *gamelogic.TurnClock) func(gamelogic.TurnStart) pubsub.AckType {
+	return func(ts gamelogic.TurnStart) pubsub.AckType {
+		defer fmt.Print(gamelogic.Prompt())
+		tc.HandleTurnStart(ts)
+		return pubsub.Ack
+	}
+}
+
+func handlerTurnEnd(tc *gamelogic.TurnClock) func(gamelogic.TurnEnd) pubsub.AckType {
+	return func(te gamelogic.TurnEnd) pubsub.AckType {
+		defer fmt.Print(gamelogic.Prompt())
+		tc.HandleTurnEnd(te)
+		return pubsub.Ack
+	}
+}
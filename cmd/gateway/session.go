@@ -0,0 +1,381 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/gamelogic"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/pubsub"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/topics"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/websocket"
+)
+
+const (
+	pingInterval   = 30 * time.Second
+	publishTimeout = 5 * time.Second
+)
+
+// clientMessage is what a browser sends, e.g.
+//
+//	{"type": "subscribe", "pattern": "army_moves.alice"}
+//	{"type": "subscribe", "exchange": "peril_direct", "pattern": "pause"}
+//	{"type": "unsubscribe", "pattern": "army_moves.alice"}
+//	{"type": "command", "command": {"Spawn": {"Location": "asia", "Rank": "infantry"}}}
+//	{"type": "command", "command": {"Move": {"UnitIDs": [1, 2], "To": "europe"}}}
+type clientMessage struct {
+	Type     string             `json:"type"`
+	Exchange string             `json:"exchange,omitempty"`
+	Pattern  string             `json:"pattern,omitempty"`
+	Command  *gamelogic.Command `json:"command,omitempty"`
+}
+
+// serverMessage is what the gateway sends back. Broker messages arrive as
+// type "message" with the body as JSON; gob game logs are converted.
+type serverMessage struct {
+	Type       string          `json:"type"`
+	Exchange   string          `json:"exchange,omitempty"`
+	RoutingKey string          `json:"routing_key,omitempty"`
+	Pattern    string          `json:"pattern,omitempty"`
+	Body       json.RawMessage `json:"body,omitempty"`
+	Error      string          `json:"error,omitempty"`
+}
+
+// session is one WebSocket connection. Spectators connect without a
+// username and can only subscribe; players pass ?username= and may send
+// commands, but only as themselves.
+type session struct {
+	gw       *gateway
+	ws       *websocket.Conn
+	username string
+	pub      pubsub.Publisher
+
+	// created on the first subscribe: a throwaway queue that every pattern
+	// of this connection is bound to
+	ch    *amqp.Channel
+	queue string
+}
+
+func (gw *gateway) handleWS(w http.ResponseWriter, r *http.Request) {
+	username := r.URL.Query().Get("username")
+	if username != "" {
+		if err := routing.ValidateUsername(username); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	ws, err := websocket.Upgrade(w, r, gw.origins)
+	if err != nil {
+		fmt.Println("[gateway] Upgrade failed:", err)
+		return
+	}
+
+	s := &session{
+		gw:       gw,
+		ws:       ws,
+		username: username,
+		// Every connection gets its own buckets, so one browser can't use up another's
+		pub: pubsub.RateLimited(gw.flow.Publisher(gw.pubCh)),
+	}
+	who := username
+	if who == "" {
+		who = "spectator"
+	}
+	fmt.Printf("[gateway] %s connected from %s\n", who, ws.RemoteAddr())
+
+	s.run()
+	fmt.Printf("[gateway] %s disconnected\n", who)
+}
+
+func (s *session) run() {
+	defer s.ws.Close()
+	defer func() {
+		if s.ch != nil {
+			// the queue is exclusive and auto-delete, so it goes with the channel
+			_ = s.ch.Close()
+		}
+	}()
+
+	done := make(chan struct{})
+	defer close(done)
+	go s.keepAlive(done)
+
+	for {
+		msgType, data, err := s.ws.ReadMessage()
+		if err != nil {
+			if !errors.Is(err, websocket.ErrClosed) {
+				fmt.Println("[gateway] Read failed:", err)
+			}
+			return
+		}
+		if msgType != websocket.TextMessage {
+			s.sendError("only JSON text messages are supported")
+			continue
+		}
+
+		var msg clientMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			s.sendError("invalid JSON: " + err.Error())
+			continue
+		}
+
+		switch msg.Type {
+		case "subscribe":
+			err = s.subscribe(msg.Exchange, msg.Pattern)
+		case "unsubscribe":
+			err = s.unsubscribe(msg.Exchange, msg.Pattern)
+		case "command":
+			err = s.publishCommand(msg.Command)
+		default:
+			err = fmt.Errorf("unknown message type %q", msg.Type)
+		}
+		if err != nil {
+			s.sendError(err.Error())
+		}
+	}
+}
+
+func (s *session) keepAlive(done <-chan struct{}) {
+	ticker := time.NewTicker(pingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if err := s.ws.Ping(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// bridgedExchange checks that exchange is one the gateway exposes; it
+// defaults to peril_topic.
+func bridgedExchange(exchange string) (string, error) {
+	switch exchange {
+	case "":
+		return routing.ExchangePerilTopic, nil
+	case routing.ExchangePerilTopic, routing.ExchangePerilDirect:
+		return exchange, nil
+	}
+	return "", fmt.Errorf("exchange %q is not available through the gateway", exchange)
+}
+
+// privateTopics are keyed by one player's username and only meant for
+// them, or for chat, sent by them to the server. Players may only bind
+// their own keys of these and spectators none, so the gateway doesn't give
+// away what the server keeps hidden.
+var privateTopics = []routing.TopicInfo{
+	topics.PlayerUpdates.Info(),
+	topics.DiplomacyUpdates.Info(),
+	topics.ArmyMoves.Info(),
+	topics.WarRecognitions.Info(),
+	topics.WarOutcomes.Info(),
+	topics.Battles.Info(),
+	topics.Scores.Info(),
+	topics.Chat.Info(),
+	topics.ChatMessages.Info(),
+}
+
+// checkPrivate refuses a pattern that would match another player's key
+// of a private topic: a wildcard where the username goes, or somebody
+// else's name.
+func (s *session) checkPrivate(exchange, pattern string) error {
+	// words to try in place of each placeholder: the pattern's own words,
+	// and names that can't be s to test wildcards against
+	words := []string{"someone_else", "someone_other"}
+	for _, w := range strings.Split(pattern, ".") {
+		if w != routing.AnyWord && w != routing.AnyWords {
+			words = append(words, w)
+		}
+	}
+	for _, t := range privateTopics {
+		if t.Exchange != exchange {
+			continue
+		}
+		for _, other := range words {
+			if other == s.username {
+				continue
+			}
+			for _, key := range concreteKeys(t.Key, other, words) {
+				if routing.Match(pattern, key) {
+					return fmt.Errorf("%s only carries messages for one player; subscribe to %s", t.Name, strings.Replace(t.Key, "{username}", "<your username>", 1))
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// concreteKeys fills in template with username for {username} and every
+// combination of words for its other placeholders, like {location}.
+func concreteKeys(template, username string, words []string) []string {
+	keys := []string{""}
+	for i, w := range strings.Split(template, ".") {
+		choices := []string{w}
+		if w == "{username}" {
+			choices = []string{username}
+		} else if strings.HasPrefix(w, "{") && strings.HasSuffix(w, "}") {
+			choices = words
+		}
+		var next []string
+		for _, key := range keys {
+			for _, c := range choices {
+				if i > 0 {
+					c = key + "." + c
+				}
+				next = append(next, c)
+			}
+		}
+		keys = next
+	}
+	return keys
+}
+
+func (s *session) subscribe(exchange, pattern string) error {
+	exchange, err := bridgedExchange(exchange)
+	if err != nil {
+		return err
+	}
+	if pattern == "" {
+		return errors.New("subscribe needs a pattern")
+	}
+	if err := s.checkPrivate(exchange, pattern); err != nil {
+		return err
+	}
+
+	if s.ch == nil {
+		if err := s.declareQueue(); err != nil {
+			return err
+		}
+	}
+	if err := s.ch.QueueBind(s.queue, pattern, exchange, false, nil); err != nil {
+		return err
+	}
+	return s.send(serverMessage{Type: "subscribed", Exchange: exchange, Pattern: pattern})
+}
+
+func (s *session) unsubscribe(exchange, pattern string) error {
+	exchange, err := bridgedExchange(exchange)
+	if err != nil {
+		return err
+	}
+	if s.ch == nil {
+		return errors.New("not subscribed")
+	}
+	if err := s.ch.QueueUnbind(s.queue, pattern, exchange, nil); err != nil {
+		return err
+	}
+	return s.send(serverMessage{Type: "unsubscribed", Exchange: exchange, Pattern: pattern})
+}
+
+func (s *session) declareQueue() error {
+	ch, err := s.gw.conn.Channel()
+	if err != nil {
+		return err
+	}
+	q, err := ch.QueueDeclare("", false, true, true, false, nil)
+	if err != nil {
+		_ = ch.Close()
+		return err
+	}
+	// Spectating never affects the game, so there is nothing to nack
+	deliveries, err := ch.Consume(q.Name, "", true, true, false, false, nil)
+	if err != nil {
+		_ = ch.Close()
+		return err
+	}
+
+	s.ch, s.queue = ch, q.Name
+	go s.forward(deliveries)
+	return nil
+}
+
+func (s *session) forward(deliveries <-chan amqp.Delivery) {
+	for msg := range deliveries {
+		body, err := bridgeBody(msg)
+		if err != nil {
+			fmt.Printf("[gateway] Dropping %s message: %v\n", msg.RoutingKey, err)
+			continue
+		}
+		if err := s.send(serverMessage{
+			Type:       "message",
+			Exchange:   msg.Exchange,
+			RoutingKey: msg.RoutingKey,
+			Body:       body,
+		}); err != nil {
+			return
+		}
+	}
+}
+
+// bridgeBody turns a broker message into JSON for the browser.
+func bridgeBody(msg amqp.Delivery) (json.RawMessage, error) {
+	switch msg.ContentType {
+	case "application/json":
+		if !json.Valid(msg.Body) {
+			return nil, errors.New("invalid JSON body")
+		}
+		return msg.Body, nil
+
+	case "application/gob":
+		// game logs are the only gob messages on the bridged exchanges
+		if !routing.Match(topics.GameLogs.Pattern(), msg.RoutingKey) {
+			return nil, errors.New("gob body of unknown type")
+		}
+		var gl routing.GameLog
+		if err := gob.NewDecoder(bytes.NewReader(msg.Body)).Decode(&gl); err != nil {
+			return nil, err
+		}
+		return json.Marshal(gl)
+	}
+	return nil, fmt.Errorf("unsupported content type %q", msg.ContentType)
+}
+
+func (s *session) publishCommand(cmd *gamelogic.Command) error {
+	if s.username == "" {
+		return errors.New("spectators can't publish; connect with ?username=")
+	}
+	if cmd == nil {
+		return errors.New("command message needs a command")
+	}
+	if cmd.Username != "" && cmd.Username != s.username {
+		return fmt.Errorf("you can only send commands as %s", s.username)
+	}
+	cmd.Username = s.username
+
+	ctx, cancel := context.WithTimeout(context.Background(), publishTimeout)
+	defer cancel()
+
+	key, err := topics.Commands.RoutingKey(s.username)
+	if err != nil {
+		return err
+	}
+	if err := pubsub.PublishWithContext(ctx, s.pub, topics.Commands, key, *cmd); err != nil {
+		return fmt.Errorf("failed to publish command: %v", err)
+	}
+	return s.send(serverMessage{Type: "published", Exchange: topics.Commands.Exchange, RoutingKey: key})
+}
+
+func (s *session) send(msg serverMessage) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return s.ws.WriteMessage(websocket.TextMessage, data)
+}
+
+func (s *session) sendError(text string) {
+	_ = s.send(serverMessage{Type: "error", Error: text})
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+)
+
+func TestCheckPrivate(t *testing.T) {
+	s := &session{username: "bob"}
+	tests := []struct {
+		pattern string
+		ok      bool
+	}{
+		{"army_moves.bob", true},
+		{"army_moves.alice", false},
+		{"army_moves.*", false},
+		{"#", false},
+		{"battles.bob.*", true},
+		{"battles.bob.europe", true},
+		{"battles.bob.#", true},
+		{"battles.alice.europe", false},
+		{"battles.alice.*", false},
+		{"battles.*.europe", false},
+		{"battles.#", false},
+		{"#.europe", false},
+		{"chat.bob", true},
+		{"chat.alice", false},
+		{"chat.to.bob", true},
+		{"chat.to.alice", false},
+		{"game_logs.*", true},
+		{"commands.*", true},
+	}
+	for _, tt := range tests {
+		err := s.checkPrivate(routing.ExchangePerilTopic, tt.pattern)
+		if ok := err == nil; ok != tt.ok {
+			t.Errorf("checkPrivate(%q) = %v, want ok %v", tt.pattern, err, tt.ok)
+		}
+	}
+}
+
+func TestConcreteKeys(t *testing.T) {
+	got := concreteKeys("battles.{username}.{location}", "alice", []string{"europe", "asia"})
+	want := []string{"battles.alice.europe", "battles.alice.asia"}
+	if len(got) != len(want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	}
+}
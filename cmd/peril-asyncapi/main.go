@@ -0,0 +1,208 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/topics"
+)
+
+// exchangeTypes are the kinds the server and clients declare the exchanges
+// with (see rabbit.sh and peril-broker).
+var exchangeTypes = map[string]string{
+	routing.ExchangePerilDirect:     "direct",
+	routing.ExchangePerilTopic:      "topic",
+	routing.ExchangePerilDLX:        "fanout",
+	routing.ExchangePerilQuarantine: "fanout",
+}
+
+// placeholderDocs describe the {placeholders} used in key templates.
+var placeholderDocs = map[string]string{
+	"username": "A player's username. It can't contain '.', '*', '#' or whitespace.",
+	"location": "A territory on the map, e.g. europe.",
+}
+
+func main() {
+	out := flag.String("o", "", "file to write the document to (default stdout)")
+	host := flag.String("host", "localhost:5672", "broker host:port to put in the document")
+	flag.Parse()
+
+	doc := buildDocument(*host)
+
+	data, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		fmt.Println("Failed to encode document:", err)
+		os.Exit(1)
+	}
+	data = append(data, '\n')
+
+	if *out == "" {
+		os.Stdout.Write(data)
+		return
+	}
+	if err := os.WriteFile(*out, data, 0o644); err != nil {
+		fmt.Println("Failed to write document:", err)
+		os.Exit(1)
+	}
+	fmt.Println("Wrote", *out)
+}
+
+// buildDocument describes every topic in the registry as an AsyncAPI 3.0
+// document. Each topic gets two channels: the routing key publishers send
+// to, and the default queue subscribers read from.
+func buildDocument(host string) map[string]any {
+	s := newSchemas()
+	channels := map[string]any{}
+	operations := map[string]any{}
+	messages := map[string]any{}
+
+	for _, t := range topics.All() {
+		msgName := t.Payload.Name()
+		msg := map[string]any{
+			"name":        msgName,
+			"title":       msgName,
+			"contentType": t.ContentType,
+			"payload":     s.schemaFor(t.Payload),
+		}
+		if t.ContentType == "application/gob" {
+			msg["description"] = "Encoded with Go's encoding/gob; the schema describes the Go struct that is encoded."
+		}
+		messages[msgName] = msg
+		msgRef := map[string]any{"$ref": "#/components/messages/" + msgName}
+
+		keyChannel := t.Name
+		channels[keyChannel] = map[string]any{
+			"address":     t.Key,
+			"description": t.Description,
+			"messages":    map[string]any{msgName: msgRef},
+			"bindings": map[string]any{
+				"amqp": map[string]any{
+					"is": "routingKey",
+					"exchange": map[string]any{
+						"name":       t.Exchange,
+						"type":       exchangeTypes[t.Exchange],
+						"durable":    true,
+						"autoDelete": false,
+						"vhost":      "/",
+					},
+					"bindingVersion": "0.3.0",
+				},
+			},
+		}
+
+		binding := bindingPattern(t)
+		queueChannel := t.Name + "_queue"
+		channels[queueChannel] = map[string]any{
+			"address":     t.Queue.Name,
+			"description": fmt.Sprintf("Default queue for %s, bound to %s with %q.", t.Name, t.Exchange, binding),
+			"messages":    map[string]any{msgName: msgRef},
+			"bindings": map[string]any{
+				"amqp": map[string]any{
+					"is": "queue",
+					"queue": map[string]any{
+						"name":       t.Queue.Name,
+						"durable":    t.Queue.Durable,
+						"exclusive":  !t.Queue.Durable,
+						"autoDelete": !t.Queue.Durable,
+						"vhost":      "/",
+					},
+					"bindingVersion": "0.3.0",
+				},
+			},
+			"x-peril-binding": map[string]any{
+				"exchange":       t.Exchange,
+				"bindingPattern": binding,
+				"deadLetter":     routing.ExchangePerilDLX,
+			},
+		}
+
+		addParameters(channels[keyChannel].(map[string]any), t.Key)
+		addParameters(channels[queueChannel].(map[string]any), t.Queue.Name)
+
+		opName := camel(t.Name)
+		operations["send"+opName] = map[string]any{
+			"action":   "send",
+			"channel":  map[string]any{"$ref": "#/channels/" + keyChannel},
+			"messages": []any{map[string]any{"$ref": "#/channels/" + keyChannel + "/messages/" + msgName}},
+		}
+		operations["receive"+opName] = map[string]any{
+			"action":   "receive",
+			"channel":  map[string]any{"$ref": "#/channels/" + queueChannel},
+			"messages": []any{map[string]any{"$ref": "#/channels/" + queueChannel + "/messages/" + msgName}},
+			"bindings": map[string]any{
+				"amqp": map[string]any{"ack": true, "bindingVersion": "0.3.0"},
+			},
+		}
+	}
+
+	return map[string]any{
+		"asyncapi": "3.0.0",
+		"info": map[string]any{
+			"title":       "Peril",
+			"version":     "1.0.0",
+			"description": "Messages exchanged by the Peril server and clients over RabbitMQ. Generated from internal/topics by peril-asyncapi; don't edit by hand.",
+		},
+		"defaultContentType": "application/json",
+		"servers": map[string]any{
+			"rabbitmq": map[string]any{
+				"host":            host,
+				"protocol":        "amqp",
+				"protocolVersion": "0.9.1",
+			},
+		},
+		"channels":   channels,
+		"operations": operations,
+		"components": map[string]any{
+			"messages": messages,
+			"schemas":  s.defs,
+		},
+	}
+}
+
+// addParameters documents the {placeholders} in a channel's address.
+// bindingPattern is how a topic's default queue is bound. A queue that
+// belongs to one player only binds that player's keys, e.g.
+// "battles.{username}.*", so {username} stays in; everything else that
+// varies is a wildcard.
+func bindingPattern(t routing.TopicInfo) string {
+	if !strings.Contains(t.Queue.Name, "{username}") {
+		return t.Pattern()
+	}
+	words := strings.Split(t.Key, ".")
+	for i, w := range words {
+		if w != "{username}" && len(w) > 2 && w[0] == '{' && w[len(w)-1] == '}' {
+			words[i] = routing.AnyWord
+		}
+	}
+	return strings.Join(words, ".")
+}
+
+func addParameters(channel map[string]any, address string) {
+	params := map[string]any{}
+	for _, w := range strings.Split(address, ".") {
+		if len(w) < 3 || w[0] != '{' || w[len(w)-1] != '}' {
+			continue
+		}
+		name := w[1 : len(w)-1]
+		params[name] = map[string]any{"description": placeholderDocs[name]}
+	}
+	if len(params) > 0 {
+		channel["parameters"] = params
+	}
+}
+
+// camel turns war_outcomes into WarOutcomes.
+func camel(name string) string {
+	var b strings.Builder
+	for _, part := range strings.Split(name, "_") {
+		if part == "" {
+			continue
+		}
+		b.WriteString(strings.ToUpper(part[:1]) + part[1:])
+	}
+	return b.String()
+}
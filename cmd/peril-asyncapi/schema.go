@@ -0,0 +1,138 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/gamelogic"
+)
+
+// enums are the known values of string types. Go has no enums, so
+// reflection can't find these on its own. Locations aren't one: they come
+// from whatever map the server loaded.
+var enums = map[reflect.Type][]any{
+	reflect.TypeOf(gamelogic.UnitRank("")):  toAny(gamelogic.AllRanks()),
+	reflect.TypeOf(gamelogic.UnitGrade("")): toAny(gamelogic.AllGrades()),
+}
+
+func toAny[T any](values []T) []any {
+	out := make([]any, len(values))
+	for i, v := range values {
+		out[i] = v
+	}
+	return out
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// schemas turns Go types into JSON Schemas the way encoding/json would
+// encode them. Named structs and enums become components referenced with
+// $ref, everything else is inlined.
+type schemas struct {
+	defs map[string]any
+}
+
+func newSchemas() *schemas {
+	return &schemas{defs: map[string]any{}}
+}
+
+func (s *schemas) ref(name string) map[string]any {
+	return map[string]any{"$ref": "#/components/schemas/" + name}
+}
+
+func (s *schemas) schemaFor(t reflect.Type) map[string]any {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	if t == timeType {
+		return map[string]any{"type": "string", "format": "date-time"}
+	}
+
+	if values, ok := enums[t]; ok {
+		if _, done := s.defs[t.Name()]; !done {
+			s.defs[t.Name()] = map[string]any{"type": "string", "enum": values}
+		}
+		return s.ref(t.Name())
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Uint8 {
+			// encoding/json sends []byte as base64
+			return map[string]any{"type": []string{"string", "null"}, "contentEncoding": "base64"}
+		}
+		if t.Kind() == reflect.Array {
+			return map[string]any{"type": "array", "items": s.schemaFor(t.Elem())}
+		}
+		// nil slices and maps are encoded as null
+		return map[string]any{"type": []string{"array", "null"}, "items": s.schemaFor(t.Elem())}
+	case reflect.Map:
+		obj := map[string]any{"type": []string{"object", "null"}, "additionalProperties": s.schemaFor(t.Elem())}
+		switch t.Key().Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			obj["propertyNames"] = map[string]any{"pattern": "^-?[0-9]+$"}
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			obj["propertyNames"] = map[string]any{"pattern": "^[0-9]+$"}
+		}
+		return obj
+	case reflect.Struct:
+		if t.Name() == "" {
+			return s.structSchema(t)
+		}
+		if _, done := s.defs[t.Name()]; !done {
+			s.defs[t.Name()] = nil // placeholder, in case the struct refers to itself
+			s.defs[t.Name()] = s.structSchema(t)
+		}
+		return s.ref(t.Name())
+	}
+	// channels, funcs and interfaces can't be described
+	return map[string]any{}
+}
+
+func (s *schemas) structSchema(t reflect.Type) map[string]any {
+	props := map[string]any{}
+	var required []string
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name := f.Name
+		omitEmpty := false
+		if tag, ok := f.Tag.Lookup("json"); ok {
+			if tag == "-" {
+				continue
+			}
+			parts := strings.Split(tag, ",")
+			if parts[0] != "" {
+				name = parts[0]
+			}
+			for _, opt := range parts[1:] {
+				if opt == "omitempty" {
+					omitEmpty = true
+				}
+			}
+		}
+		props[name] = s.schemaFor(f.Type)
+		if !omitEmpty {
+			required = append(required, name)
+		}
+	}
+
+	obj := map[string]any{"type": "object", "properties": props}
+	if len(required) > 0 {
+		obj["required"] = required
+	}
+	return obj
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/devbroker"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/gamelogic"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+)
+
+func main() {
+	addr := flag.String("addr", ":5672", "address to accept AMQP connections on")
+	user := flag.String("user", "guest", "username clients must log in with (empty accepts anyone)")
+	pass := flag.String("pass", "guest", "password clients must log in with")
+	flag.Parse()
+
+	fmt.Println("Starting Peril dev broker...")
+
+	b := devbroker.New(devbroker.Options{User: *user, Password: *pass})
+	if err := declarePeril(b); err != nil {
+		fmt.Println("Failed to declare Peril exchanges:", err)
+		os.Exit(1)
+	}
+
+	served := make(chan error, 1)
+	go func() { served <- b.ListenAndServe(*addr) }()
+	fmt.Printf("Accepting AMQP connections on %s (amqp://%s:%s@localhost%s/)\n", *addr, *user, *pass, *addr)
+
+	printHelp()
+
+	input := make(chan []string)
+	go func() {
+		for {
+			words := gamelogic.GetInput()
+			if words == nil {
+				// no terminal (running in the background): just keep serving
+				return
+			}
+			input <- words
+		}
+	}()
+
+	for {
+		select {
+		case err := <-served:
+			fmt.Println("Broker stopped:", err)
+			os.Exit(1)
+
+		case words := <-input:
+			if len(words) == 0 {
+				continue
+			}
+
+			switch words[0] {
+			case "queues":
+				printQueues(b)
+
+			case "block":
+				// lets you try client flow control without filling a disk
+				b.SetBlocked(strings.Join(words[1:], " "))
+				fmt.Println("Publishers blocked")
+
+			case "unblock":
+				b.Unblock()
+				fmt.Println("Publishers unblocked")
+
+			case "help":
+				printHelp()
+
+			case "quit":
+				fmt.Println("Exiting...")
+				b.Close()
+				return
+
+			default:
+				fmt.Println("I don't understand that command.")
+			}
+		}
+	}
+}
+
+// declarePeril sets up what you would otherwise click together in the
+// RabbitMQ management UI before the first game.
+func declarePeril(b *devbroker.Broker) error {
+	for name, kind := range map[string]string{
+		routing.ExchangePerilDirect: "direct",
+		routing.ExchangePerilTopic:  "topic",
+		routing.ExchangePerilDLX:    "fanout",
+	} {
+		if err := b.DeclareExchange(name, kind); err != nil {
+			return err
+		}
+	}
+	if err := b.DeclareQueue(routing.DeadLetterQueue, amqp.Table{}); err != nil {
+		return err
+	}
+	return b.BindQueue(routing.DeadLetterQueue, routing.ExchangePerilDLX, "")
+}
+
+func printQueues(b *devbroker.Broker) {
+	fmt.Printf("%-40s %8s %8s %10s\n", "queue", "ready", "unacked", "consumers")
+	for _, q := range b.Queues() {
+		fmt.Printf("%-40s %8d %8d %10d\n", q.Name, q.Ready, q.Unacked, q.Consumers)
+	}
+}
+
+func printHelp() {
+	fmt.Println("Possible commands:")
+	fmt.Println("* queues")
+	fmt.Println("* block [reason]")
+	fmt.Println("* unblock")
+	fmt.Println("* help")
+	fmt.Println("* quit")
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/gamelogic"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/pubsub"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/topics"
+)
+
+// Moderator sees every chat message before anybody gets it. It can pass it
+// on as it is, change it, or refuse it with an error for the sender.
+type Moderator func(gamelogic.ChatMessage) (gamelogic.ChatMessage, error)
+
+// allowAll is the moderator when there's nothing to filter.
+func allowAll(msg gamelogic.ChatMessage) (gamelogic.ChatMessage, error) {
+	return msg, nil
+}
+
+// wordFilter masks every word on the list with asterisks, ignoring case.
+func wordFilter(words []string) Moderator {
+	if len(words) == 0 {
+		return allowAll
+	}
+	quoted := make([]string, len(words))
+	for i, w := range words {
+		quoted[i] = regexp.QuoteMeta(w)
+	}
+	re := regexp.MustCompile(`(?i)\b(` + strings.Join(quoted, "|") + `)\b`)
+	return func(msg gamelogic.ChatMessage) (gamelogic.ChatMessage, error) {
+		msg.Text = re.ReplaceAllStringFunc(msg.Text, func(w string) string {
+			return strings.Repeat("*", len([]rune(w)))
+		})
+		return msg, nil
+	}
+}
+
+// loadWordFilter reads the words to mask from path, one per line. Blank
+// lines and lines starting with # are skipped.
+func loadWordFilter(path string) (Moderator, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	words := []string{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		words = append(words, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return wordFilter(words), nil
+}
+
+// handlerChat checks chat against the world and the moderator, archives it
+// next to game.log, and passes it on to everyone it's for. A refused
+// message goes back to its sender with the reason. Chat is only accepted
+// from the player in the routing key, so nobody can talk, or listen in on
+// a team, in somebody else's name.
+func handlerChat(world *gamelogic.World, pub pubsub.Publisher, moderate Moderator) func(string, gamelogic.ChatMessage) pubsub.AckType {
+	return func(key string, msg gamelogic.ChatMessage) pubsub.AckType {
+		sender, err := senderOf(topics.Chat, key)
+		if err != nil {
+			fmt.Printf("Refused chat sent as %q: %v\n", key, err)
+			return pubsub.NackDiscard
+		}
+		msg.At = time.Now()
+		msg.Rejected = ""
+
+		var recipients []string
+		if msg.From != sender {
+			err = errors.New("you can only chat as " + sender)
+			msg.From = sender
+		} else {
+			recipients, err = world.ChatRecipients(msg)
+		}
+		if err == nil {
+			msg, err = moderate(msg)
+		}
+		if err != nil {
+			msg.Rejected = err.Error()
+			recipients = []string{msg.From}
+		}
+
+		if err := gamelogic.WriteChatLog(msg); err != nil {
+			fmt.Println("Failed to archive chat:", err)
+		}
+		for _, username := range recipients {
+			key, _ := topics.ChatMessages.RoutingKey(username)
+			if err := pubsub.Publish(pub, topics.ChatMessages, key, msg); err != nil {
+				fmt.Printf("Failed to publish chat for %s: %v\n", username, err)
+			}
+		}
+		return pubsub.Ack
+	}
+}
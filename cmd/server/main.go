@@ -1,19 +1,64 @@
 package main
 
 import (
+	"errors"
+	"flag"
 	"fmt"
 	"os"
+	"strings"
+	"time"
 
 	amqp "github.com/rabbitmq/amqp091-go"
 
 	"github.com/bootdotdev/learn-pub-sub-starter/internal/gamelogic"
 	"github.com/bootdotdev/learn-pub-sub-starter/internal/pubsub"
 	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/topics"
 )
 
+// serverQueueWord names the server's own pause queue, pause.peril_server.
+const serverQueueWord = "peril_server"
+
 func main() {
+	worldPath := flag.String("world", "world.json", "file the world is loaded from and saved to")
+	autosave := flag.Duration("autosave", 10*time.Second, "how often the world is saved while the server runs; 0 only saves on save and quit")
+	logsOnly := flag.Bool("logs-only", false, "only write game logs; for extra servers next to the one running the game")
+	mapPath := flag.String("map", "", "JSON map file to play on (default: the built-in world map)")
+	tickEvery := flag.Duration("tick", 30*time.Second, "how often the server pays income; 0 turns the economy clock off")
+	turnLength := flag.Duration("turns", 0, "play in turns of this length, orders resolved together at the end; 0 is real time")
+	winTerritories := flag.Int("win-territories", 0, "own this many territories to win; 0 turns it off")
+	winHold := flag.Duration("win-hold", 0, "how long -win-territories have to be owned for")
+	winEliminate := flag.Bool("win-eliminate", true, "win by being the only player left who can fight")
+	combinedDefence := flag.Bool("combined-defence", false, "allies with units where a player is attacked defend alongside them")
+	chatFilter := flag.String("chat-filter", "", "file of words to mask in chat, one per line")
+	combatName := flag.String("combat", gamelogic.DefaultCombat, "how wars are settled: "+strings.Join(gamelogic.CombatResolverNames(), " or "))
+	flag.Parse()
+
 	fmt.Println("Starting Peril server...")
 
+	worldMap := gamelogic.DefaultMap()
+	if *mapPath != "" {
+		m, err := gamelogic.LoadMap(*mapPath)
+		if err != nil {
+			fmt.Println("Failed to load map:", err)
+			os.Exit(1)
+		}
+		worldMap = m
+	}
+	combat, err := gamelogic.CombatResolverByName(*combatName)
+	if err != nil {
+		fmt.Println("Failed to pick combat resolver:", err)
+		os.Exit(1)
+	}
+	moderate := Moderator(allowAll)
+	if *chatFilter != "" {
+		moderate, err = loadWordFilter(*chatFilter)
+		if err != nil {
+			fmt.Println("Failed to load chat filter:", err)
+			os.Exit(1)
+		}
+	}
+
 	// Show available REPL commands
 	gamelogic.PrintServerHelp()
 
@@ -27,16 +72,17 @@ func main() {
 	defer conn.Close()
 	fmt.Println("Successfully connected to RabbitMQ")
 
+	// every publish below goes through this, so a blocked broker can't hang the server
+	flow := pubsub.WatchFlowControl(conn)
+
 	// Ch6 Serialization p3 Consume Logs: Subscribe to gob-encoded game logs and write them to disk
-	logKey := routing.GameLogSlug + ".*" // capture logs from all clients
-	if err := pubsub.SubscribeGob[routing.GameLog](
-		conn,
-		routing.ExchangePerilTopic, // exchange: peril_topic
-		routing.GameLogSlug,        // queue name: game_logs
-		logKey,                     // binding key: game_logs.*
-		pubsub.SimpleQueueDurable,  // durable queue
+	if err := pubsub.Subscribe(
+		pubsub.AMQP(conn),
+		topics.GameLogs,            // gob on peril_topic
+		topics.GameLogs.Queue.Name, // queue name: game_logs
+		topics.GameLogs.Pattern(),  // binding key: game_logs.* (logs from all clients)
 		func(gl routing.GameLog) pubsub.AckType {
-			defer fmt.Print("> ")
+			defer fmt.Print(gamelogic.Prompt())
 			if err := gamelogic.WriteLog(gl); err != nil {
 				fmt.Println("Failed to write log:", err)
 				return pubsub.NackRequeue
@@ -49,12 +95,175 @@ func main() {
 	}
 
 	// Create a channel (used for publishing pause/resume)
-	ch, err := conn.Channel()
+	rawCh, err := conn.Channel()
 	if err != nil {
 		fmt.Println("Failed to open RabbitMQ channel:", err)
 		os.Exit(1)
 	}
-	defer ch.Close()
+	defer rawCh.Close()
+	ch := flow.Channel(rawCh)
+
+	// The world: every player's units as the server sees them. Only one
+	// server may run it, extra ones started with -logs-only just write logs.
+	var world *gamelogic.World
+	if !*logsOnly {
+		victory := gamelogic.Victory{Territories: *winTerritories, HoldFor: *winHold, Eliminate: *winEliminate}
+		world = gamelogic.NewWorld(worldMap, combat, victory)
+		world.SetCombinedDefence(*combinedDefence)
+		if err := world.Load(*worldPath); err == nil {
+			fmt.Printf("Loaded %d player(s) from %s\n", len(world.Players()), *worldPath)
+		} else if !errors.Is(err, os.ErrNotExist) {
+			fmt.Printf("Failed to load %s: %v\n", *worldPath, err)
+			os.Exit(1)
+		}
+		if over := world.Over(); over != nil {
+			fmt.Printf("This game is over: %s %s. Start with a new -world for another one.\n", over.Winner, over.Reason)
+		}
+
+		// commands are handled on their own goroutine, so they publish on their own channel
+		cmdCh, err := conn.Channel()
+		if err != nil {
+			fmt.Println("Failed to open RabbitMQ channel:", err)
+			os.Exit(1)
+		}
+		defer cmdCh.Close()
+
+		// a resume in turn mode sends the turn again, from the pause goroutine
+		pauseCh, err := conn.Channel()
+		if err != nil {
+			fmt.Println("Failed to open RabbitMQ channel:", err)
+			os.Exit(1)
+		}
+		defer pauseCh.Close()
+
+		pauseQueueName, _ := topics.Pause.QueueName(serverQueueWord)
+		if err := pubsub.Subscribe(
+			pubsub.AMQP(conn),
+			topics.Pause,
+			pauseQueueName,
+			topics.Pause.Pattern(),
+			handlerPause(world, flow.Publisher(pauseCh)),
+		); err != nil {
+			fmt.Println("Failed to subscribe to pause messages:", err)
+			os.Exit(1)
+		}
+
+		if err := pubsub.SubscribeKeyed(
+			pubsub.AMQP(conn),
+			topics.Commands,
+			topics.Commands.Queue.Name, // durable queue: commands
+			topics.Commands.Pattern(),  // binding key: commands.*
+			handlerCommand(world, flow.Publisher(cmdCh)),
+		); err != nil {
+			fmt.Println("Failed to subscribe to commands:", err)
+			os.Exit(1)
+		}
+
+		diplomacyCh, err := conn.Channel()
+		if err != nil {
+			fmt.Println("Failed to open RabbitMQ channel:", err)
+			os.Exit(1)
+		}
+		defer diplomacyCh.Close()
+
+		if err := pubsub.SubscribeKeyed(
+			pubsub.AMQP(conn),
+			topics.Diplomacy,
+			topics.Diplomacy.Queue.Name, // durable queue: diplomacy
+			topics.Diplomacy.Pattern(),  // binding key: diplomacy.*
+			handlerDiplomacy(world, flow.Publisher(diplomacyCh)),
+		); err != nil {
+			fmt.Println("Failed to subscribe to diplomacy:", err)
+			os.Exit(1)
+		}
+
+		chatCh, err := conn.Channel()
+		if err != nil {
+			fmt.Println("Failed to open RabbitMQ channel:", err)
+			os.Exit(1)
+		}
+		defer chatCh.Close()
+
+		if err := pubsub.SubscribeKeyed(
+			pubsub.AMQP(conn),
+			topics.Chat,
+			topics.Chat.Queue.Name, // durable queue: chat
+			topics.Chat.Pattern(),  // binding key: chat.*
+			handlerChat(world, flow.Publisher(chatCh), moderate),
+		); err != nil {
+			fmt.Println("Failed to subscribe to chat:", err)
+			os.Exit(1)
+		}
+
+		if *tickEvery > 0 {
+			clockCh, err := conn.Channel()
+			if err != nil {
+				fmt.Println("Failed to open RabbitMQ channel:", err)
+				os.Exit(1)
+			}
+			defer clockCh.Close()
+
+			done := make(chan struct{})
+			defer close(done)
+			go runClock(world, flow.Publisher(clockCh), *tickEvery, done)
+		}
+
+		if *turnLength > 0 {
+			turnCh, err := conn.Channel()
+			if err != nil {
+				fmt.Println("Failed to open RabbitMQ channel:", err)
+				os.Exit(1)
+			}
+			defer turnCh.Close()
+
+			fmt.Printf("Playing in turns of %s\n", *turnLength)
+			done := make(chan struct{})
+			defer close(done)
+			go runTurns(world, flow.Publisher(turnCh), *turnLength, done)
+		}
+
+		if *autosave > 0 {
+			done := make(chan struct{})
+			defer close(done)
+			go runAutosave(world, *worldPath, *autosave, done)
+		}
+
+		trucesCh, err := conn.Channel()
+		if err != nil {
+			fmt.Println("Failed to open RabbitMQ channel:", err)
+			os.Exit(1)
+		}
+		defer trucesCh.Close()
+
+		trucesDone := make(chan struct{})
+		defer close(trucesDone)
+		go runTruces(world, flow.Publisher(trucesCh), trucesDone)
+
+		// only a timed hold can be won while nothing happens
+		if *winTerritories > 0 && *winHold > 0 && world.Over() == nil {
+			refereeCh, err := conn.Channel()
+			if err != nil {
+				fmt.Println("Failed to open RabbitMQ channel:", err)
+				os.Exit(1)
+			}
+			defer refereeCh.Close()
+
+			done := make(chan struct{})
+			defer close(done)
+			go runReferee(world, flow.Publisher(refereeCh), done)
+		}
+	}
+
+	saveWorld := func() {
+		if world == nil {
+			return
+		}
+		if err := world.Save(*worldPath); err != nil {
+			fmt.Println("Failed to save world:", err)
+			return
+		}
+		fmt.Println("Saved world to", *worldPath)
+	}
 
 	// REPL loop
 	for {
@@ -63,6 +272,7 @@ func main() {
 		// GetInput returns nil when input fails (EOF, Ctrl+D, etc.)
 		if words == nil {
 			fmt.Println("Input closed, exiting...")
+			saveWorld()
 			return
 		}
 
@@ -72,22 +282,77 @@ func main() {
 		}
 
 		switch words[0] {
-		case "pause":
-			fmt.Println("Sending pause message...")
-			state := routing.PlayingState{IsPaused: true}
-			if err := pubsub.PublishJSON(ch, routing.ExchangePerilDirect, routing.PauseKey, state); err != nil {
-				fmt.Println("Failed to publish pause message:", err)
+		case "pause", "resume":
+			state := routing.PlayingState{IsPaused: words[0] == "pause"}
+
+			delay, err := parseSchedule(words[1:], time.Now())
+			if err != nil {
+				fmt.Println(err)
+				continue
+			}
+
+			if delay == 0 {
+				fmt.Printf("Sending %s message...\n", words[0])
+				if err := pubsub.Publish(ch, topics.Pause, topics.Pause.Key, state); err != nil {
+					fmt.Printf("Failed to publish %s message: %v\n", words[0], err)
+				}
+				continue
+			}
+
+			// Scheduled messages wait in the broker, so they still fire if this server restarts
+			at := time.Now().Add(delay.Round(time.Second))
+			if err := pubsub.PublishDelayed(ch, topics.Pause, topics.Pause.Key, state, delay); err != nil {
+				fmt.Printf("Failed to schedule %s message: %v\n", words[0], err)
+				continue
+			}
+			fmt.Printf("Scheduled %s for %s\n", words[0], at.Format("Mon 15:04:05"))
+
+		case "metrics":
+			pubsub.PrintMetrics()
+
+		case "map":
+			worldMap.Print()
+
+		case "players":
+			if world == nil {
+				fmt.Println("This server only writes logs.")
+				break
+			}
+			printPlayers(world.Players())
+
+		case "scores":
+			if world == nil {
+				fmt.Println("This server only writes logs.")
+				break
+			}
+			if over := world.Over(); over != nil {
+				fmt.Printf("Game over: %s %s.\n", over.Winner, over.Reason)
+			}
+			world.Scoreboard().Print(true)
+
+		case "pacts":
+			if world == nil {
+				fmt.Println("This server only writes logs.")
+				break
+			}
+			pacts := world.Pacts()
+			if len(pacts) == 0 {
+				fmt.Println("Nobody has made a pact.")
+			}
+			for _, pact := range pacts {
+				fmt.Printf("* %s\n", pact)
 			}
 
-		case "resume":
-			fmt.Println("Sending resume message...")
-			state := routing.PlayingState{IsPaused: false}
-			if err := pubsub.PublishJSON(ch, routing.ExchangePerilDirect, routing.PauseKey, state); err != nil {
-				fmt.Println("Failed to publish resume message:", err)
+		case "save":
+			if world == nil {
+				fmt.Println("This server only writes logs.")
+				break
 			}
+			saveWorld()
 
 		case "quit":
 			fmt.Println("Exiting...")
+			saveWorld()
 			return
 
 		default:
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// parseSchedule turns the optional tail of a pause/resume command into a delay.
+//
+//	pause              -> 0 (send now)
+//	pause in 5m        -> 5 minutes
+//	resume at 18:00    -> time until the next 18:00 local time
+func parseSchedule(args []string, now time.Time) (time.Duration, error) {
+	if len(args) == 0 {
+		return 0, nil
+	}
+	if len(args) != 2 {
+		return 0, errors.New("usage: <pause|resume> [in <duration> | at <HH:MM>]")
+	}
+
+	switch args[0] {
+	case "in":
+		d, err := time.ParseDuration(args[1])
+		if err != nil {
+			return 0, fmt.Errorf("error: %s is not a valid duration (try 30s, 5m or 1h30m)", args[1])
+		}
+		if d <= 0 {
+			return 0, errors.New("error: the delay must be positive")
+		}
+		return d, nil
+
+	case "at":
+		clock, err := time.ParseInLocation("15:04", args[1], now.Location())
+		if err != nil {
+			return 0, fmt.Errorf("error: %s is not a valid time (use HH:MM)", args[1])
+		}
+		at := time.Date(now.Year(), now.Month(), now.Day(), clock.Hour(), clock.Minute(), 0, 0, now.Location())
+		// a time that already passed today means tomorrow
+		if !at.After(now) {
+			at = at.AddDate(0, 0, 1)
+		}
+		return at.Sub(now), nil
+
+	default:
+		return 0, errors.New("usage: <pause|resume> [in <duration> | at <HH:MM>]")
+	}
+}
@@ -0,0 +1,389 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/gamelogic"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/pubsub"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/topics"
+)
+
+// senderOf is the username in the routing key a message was published
+// with, for topics keyed by {username}. Players publish under their own
+// name, so that's who sent it, whatever the body says.
+func senderOf[T any](topic routing.Topic[T], key string) (string, error) {
+	values, err := topic.Values(key)
+	if err != nil {
+		return "", err
+	}
+	if len(values) != 1 {
+		return "", fmt.Errorf("%s isn't keyed by username", topic.Name)
+	}
+	return values[0], routing.ValidateUsername(values[0])
+}
+
+// handlerCommand runs every player command through the world and publishes
+// whatever it changed. Commands are acked once the world has handled them:
+// requeueing one the world already applied would apply it twice, so a
+// failed publish is only reported (the player can resync by rejoining).
+// A command in the name of anybody but the player in its routing key is
+// refused.
+func handlerCommand(world *gamelogic.World, pub pubsub.Publisher) func(string, gamelogic.Command) pubsub.AckType {
+	return func(key string, cmd gamelogic.Command) pubsub.AckType {
+		defer fmt.Print(gamelogic.Prompt())
+
+		sender, err := senderOf(topics.Commands, key)
+		if err != nil {
+			fmt.Printf("Rejected command sent as %q: %v\n", key, err)
+			return pubsub.NackDiscard
+		}
+		if cmd.Username != sender {
+			fmt.Printf("Rejected command from %s in the name of %q\n", sender, cmd.Username)
+			publishUpdate(pub, gamelogic.PlayerUpdate{Username: sender, Rejected: "you can only send commands as " + sender})
+			return pubsub.Ack
+		}
+
+		out, err := world.Handle(cmd)
+		if err != nil {
+			fmt.Printf("Rejected command from %s: %v\n", cmd.Username, err)
+			publishUpdate(pub, gamelogic.PlayerUpdate{Username: cmd.Username, Rejected: err.Error()})
+			return pubsub.Ack
+		}
+
+		publishOutcome(pub, out)
+		return pubsub.Ack
+	}
+}
+
+// handlerDiplomacy runs pact commands through the world and tells both
+// players what happened, or the sender why not. Like commands, they are
+// only accepted from the player in the routing key.
+func handlerDiplomacy(world *gamelogic.World, pub pubsub.Publisher) func(string, gamelogic.DiplomacyCommand) pubsub.AckType {
+	return func(key string, cmd gamelogic.DiplomacyCommand) pubsub.AckType {
+		defer fmt.Print(gamelogic.Prompt())
+
+		sender, err := senderOf(topics.Diplomacy, key)
+		if err != nil {
+			fmt.Printf("Rejected diplomacy sent as %q: %v\n", key, err)
+			return pubsub.NackDiscard
+		}
+
+		var updates []gamelogic.DiplomacyUpdate
+		var out gamelogic.Outcome
+		if cmd.Username != sender {
+			err = errors.New("you can only make pacts as " + sender)
+			cmd.Username = sender
+		} else {
+			updates, out, err = world.Diplomacy(cmd)
+		}
+		if err != nil {
+			fmt.Printf("Rejected diplomacy from %s: %v\n", cmd.Username, err)
+			updates = []gamelogic.DiplomacyUpdate{{Username: cmd.Username, Rejected: err.Error()}}
+		} else {
+			fmt.Printf("%s: %s %s\n", cmd.Username, cmd.Action, cmd.With)
+		}
+
+		publishDiplomacy(pub, updates)
+		publishOutcome(pub, out)
+		return pubsub.Ack
+	}
+}
+
+func publishDiplomacy(pub pubsub.Publisher, updates []gamelogic.DiplomacyUpdate) {
+	for _, du := range updates {
+		key, _ := topics.DiplomacyUpdates.RoutingKey(du.Username)
+		if err := pubsub.Publish(pub, topics.DiplomacyUpdates, key, du); err != nil {
+			fmt.Printf("Failed to publish diplomacy update for %s: %v\n", du.Username, err)
+		}
+	}
+}
+
+// publishOutcome sends every update, move and battle the world came up
+// with, then the scores and the end of the game if either changed.
+func publishOutcome(pub pubsub.Publisher, out gamelogic.Outcome) {
+	for _, u := range out.Updates {
+		publishUpdate(pub, u)
+	}
+
+	for _, notice := range out.Moves {
+		key, _ := topics.ArmyMoves.RoutingKey(notice.Recipient)
+		if err := pubsub.Publish(pub, topics.ArmyMoves, key, notice.Move); err != nil {
+			fmt.Println("Failed to publish move:", err)
+		}
+	}
+
+	for _, b := range out.Battles {
+		publishBattle(pub, b)
+	}
+
+	for _, notice := range out.Scores {
+		key, _ := topics.Scores.RoutingKey(notice.Recipient)
+		if err := pubsub.Publish(pub, topics.Scores, key, notice.Scores); err != nil {
+			fmt.Println("Failed to publish scores:", err)
+		}
+	}
+	if out.GameOver != nil {
+		publishGameOver(pub, *out.GameOver)
+	}
+}
+
+// publishGameOver tells everyone who won and logs it.
+func publishGameOver(pub pubsub.Publisher, over gamelogic.GameOver) {
+	fmt.Printf("Game over! %s %s.\n", over.Winner, over.Reason)
+	if err := pubsub.Publish(pub, topics.GameOver, topics.GameOver.Key, over); err != nil {
+		fmt.Println("Failed to publish game over:", err)
+	}
+
+	gl := routing.GameLog{
+		CurrentTime: over.At,
+		Message:     fmt.Sprintf("%s won the game: %s", over.Winner, over.Reason),
+		Username:    over.Winner,
+	}
+	logKey, _ := topics.GameLogs.RoutingKey(over.Winner)
+	if err := pubsub.Publish(pub, topics.GameLogs, logKey, gl); err != nil {
+		fmt.Println("Failed to publish game log:", err)
+	}
+}
+
+func publishUpdate(pub pubsub.Publisher, u gamelogic.PlayerUpdate) {
+	key, _ := topics.PlayerUpdates.RoutingKey(u.Username)
+	if err := pubsub.Publish(pub, topics.PlayerUpdates, key, u); err != nil {
+		fmt.Printf("Failed to publish update for %s: %v\n", u.Username, err)
+	}
+}
+
+// publishBattle publishes each of a battle's wars, then sums the battle up
+// for everybody who was there, under the battle's location.
+func publishBattle(pub pubsub.Publisher, b gamelogic.Battle) {
+	for _, war := range b.Wars {
+		publishWar(pub, war)
+	}
+	fmt.Printf("Battle in %s between %s: %d war(s), %s left\n", b.Location, strings.Join(b.Players, ", "), len(b.Wars), strings.Join(b.Survivors, ", "))
+
+	for _, username := range b.Players {
+		key, _ := topics.Battles.RoutingKey(username, string(b.Location))
+		if err := pubsub.Publish(pub, topics.Battles, key, b); err != nil {
+			fmt.Println("Failed to publish battle:", err)
+		}
+	}
+}
+
+// publishWar announces a war the world fought to both sides, seed and
+// all, then tells them how it ended and logs it.
+func publishWar(pub pubsub.Publisher, war gamelogic.War) {
+	res := war.Result
+	fmt.Printf("War in %s between %s and %s (%s combat, seed %d)\n", res.Location, res.Attacker, res.Defender, war.Recognition.Combat, war.Recognition.Seed)
+
+	sides := append([]string{res.Attacker, res.Defender}, res.Allies...)
+	for _, username := range sides {
+		key, _ := topics.WarRecognitions.RoutingKey(username)
+		if err := pubsub.Publish(pub, topics.WarRecognitions, key, war.Recognition); err != nil {
+			fmt.Println("Failed to publish war recognition:", err)
+		}
+	}
+
+	for _, username := range sides {
+		key, _ := topics.WarOutcomes.RoutingKey(username)
+		if err := pubsub.Publish(pub, topics.WarOutcomes, key, res); err != nil {
+			fmt.Println("Failed to publish war result:", err)
+		}
+	}
+
+	msg := fmt.Sprintf("%s won a war against %s", res.Winner, res.Loser)
+	if res.Draw {
+		msg = fmt.Sprintf("A war between %s and %s resulted in a draw", res.Attacker, res.Defender)
+	}
+	gl := routing.GameLog{
+		CurrentTime: time.Now(),
+		Message:     msg,
+		Username:    res.Attacker,
+	}
+	logKey, _ := topics.GameLogs.RoutingKey(res.Attacker)
+	if err := pubsub.Publish(pub, topics.GameLogs, logKey, gl); err != nil {
+		fmt.Println("Failed to publish game log:", err)
+	}
+}
+
+// handlerPause keeps the world's pause state in step with what the players
+// see, including pauses that were scheduled earlier and fire later. In
+// turn mode a resume sends the turn again, since its end moved.
+func handlerPause(world *gamelogic.World, pub pubsub.Publisher) func(routing.PlayingState) pubsub.AckType {
+	return func(ps routing.PlayingState) pubsub.AckType {
+		if !world.SetPaused(ps.IsPaused) {
+			return pubsub.Ack
+		}
+		if turn, ok := world.CurrentTurn(); ok {
+			publishTurnStart(pub, turn)
+		}
+		return pubsub.Ack
+	}
+}
+
+// runTurns ends the open turn whenever it's due, publishes what happened
+// and opens the next one, until done is closed.
+func runTurns(world *gamelogic.World, pub pubsub.Publisher, length time.Duration, done <-chan struct{}) {
+	if world.Over() != nil {
+		return
+	}
+	publishTurnStart(pub, world.StartTurn(length))
+
+	ticker := time.NewTicker(250 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case now := <-ticker.C:
+			if !world.TurnDue(now) {
+				continue
+			}
+		}
+
+		end, out, err := world.EndTurn()
+		if err != nil {
+			fmt.Println("Failed to end turn:", err)
+			continue
+		}
+		if err := pubsub.Publish(pub, topics.TurnEnds, topics.TurnEnds.Key, end); err != nil {
+			fmt.Println("Failed to publish turn end:", err)
+		}
+		publishOutcome(pub, out)
+		fmt.Printf("Turn %d over: %d order(s), %d battle(s)\n", end.Number, end.Orders, len(out.Battles))
+		if out.GameOver != nil {
+			fmt.Print(gamelogic.Prompt())
+			return
+		}
+
+		publishTurnStart(pub, world.StartTurn(length))
+		fmt.Print(gamelogic.Prompt())
+	}
+}
+
+func publishTurnStart(pub pubsub.Publisher, turn gamelogic.TurnStart) {
+	if err := pubsub.Publish(pub, topics.TurnStarts, topics.TurnStarts.Key, turn); err != nil {
+		fmt.Println("Failed to publish turn start:", err)
+	}
+}
+
+// runClock ticks the world every interval until done is closed, and
+// publishes the income and the tick. It has its own channel since it runs
+// next to the command handler.
+func runClock(world *gamelogic.World, pub pubsub.Publisher, interval time.Duration, done <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+
+		tick, out, ok := world.Tick()
+		if !ok {
+			continue // paused, or the game is over
+		}
+		for _, u := range out.Updates {
+			publishUpdate(pub, u)
+		}
+		if err := pubsub.Publish(pub, topics.Ticks, topics.Ticks.Key, tick); err != nil {
+			fmt.Println("Failed to publish tick:", err)
+		}
+		paid := 0
+		for _, u := range out.Updates {
+			if u.Gold != nil {
+				paid += u.Gold.Amount
+			}
+		}
+		fmt.Printf("Tick %d: paid %d gold to %d player(s)\n", tick.Number, paid, len(out.Updates))
+		fmt.Print(gamelogic.Prompt())
+	}
+}
+
+// runReferee checks the victory conditions every second, so a player who
+// holds enough territories for long enough wins even if nobody moves.
+func runReferee(world *gamelogic.World, pub pubsub.Publisher, done <-chan struct{}) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case now := <-ticker.C:
+			out, over := world.CheckVictory(now)
+			publishOutcome(pub, out)
+			if over {
+				fmt.Print(gamelogic.Prompt())
+				return
+			}
+		}
+	}
+}
+
+// runAutosave saves the world every interval until done is closed, so a
+// crash loses at most that much of the game.
+func runAutosave(world *gamelogic.World, path string, interval time.Duration, done <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if err := world.Save(path); err != nil {
+				fmt.Println("Failed to autosave world:", err)
+				fmt.Print(gamelogic.Prompt())
+			}
+		}
+	}
+}
+
+// runTruces ends truces when they run out, so both players hear about it
+// and any armies left sharing a territory fight straight away.
+func runTruces(world *gamelogic.World, pub pubsub.Publisher, done <-chan struct{}) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case now := <-ticker.C:
+			updates, out := world.ExpireTruces(now)
+			if len(updates) == 0 && len(out.Battles) == 0 {
+				continue
+			}
+			for _, du := range updates {
+				if du.Username == du.Pact.Players[0] {
+					fmt.Printf("The %s ran out\n", du.Pact)
+				}
+			}
+			publishDiplomacy(pub, updates)
+			publishOutcome(pub, out)
+			fmt.Print(gamelogic.Prompt())
+		}
+	}
+}
+
+func printPlayers(players []gamelogic.State) {
+	if len(players) == 0 {
+		fmt.Println("Nobody has joined yet.")
+		return
+	}
+	for _, s := range players {
+		p := s.Player
+		fmt.Printf("%s has %d unit(s) and %d gold\n", p.Username, len(p.Units), s.Gold)
+		ids := make([]int, 0, len(p.Units))
+		for id := range p.Units {
+			ids = append(ids, id)
+		}
+		sort.Ints(ids)
+		for _, id := range ids {
+			u := p.Units[id]
+			fmt.Printf("  * %v: %v, %v (%s)\n", u.ID, u.Location, u.Rank, u.Condition())
+		}
+	}
+}
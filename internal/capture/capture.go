@@ -0,0 +1,301 @@
+// Package capture reads and writes capture files: one JSON record per
+// line for every message seen on the Peril exchanges, in arrival order.
+package capture
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"math"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// Record is one captured message.
+type Record struct {
+	Time       time.Time      `json:"time"` // when the capture tool received it
+	Exchange   string         `json:"exchange"`
+	RoutingKey string         `json:"routing_key"`
+	Properties Properties     `json:"properties"`
+	Headers    map[string]any `json:"headers,omitempty"`
+	// HeaderTypes has the AMQP type of each header ("int16", "bytes",
+	// "timestamp", ...), since JSON on its own would turn every number
+	// into a float. Tables and arrays nest.
+	HeaderTypes map[string]any `json:"header_types,omitempty"`
+	Body        []byte         `json:"body"`
+}
+
+// Properties are the AMQP message properties other than headers.
+type Properties struct {
+	ContentType     string    `json:"content_type,omitempty"`
+	ContentEncoding string    `json:"content_encoding,omitempty"`
+	DeliveryMode    uint8     `json:"delivery_mode,omitempty"`
+	Priority        uint8     `json:"priority,omitempty"`
+	CorrelationID   string    `json:"correlation_id,omitempty"`
+	ReplyTo         string    `json:"reply_to,omitempty"`
+	Expiration      string    `json:"expiration,omitempty"`
+	MessageID       string    `json:"message_id,omitempty"`
+	Timestamp       time.Time `json:"timestamp"`
+	Type            string    `json:"type,omitempty"`
+	UserID          string    `json:"user_id,omitempty"`
+	AppID           string    `json:"app_id,omitempty"`
+}
+
+// FromDelivery records msg as received at t.
+func FromDelivery(msg amqp.Delivery, t time.Time) Record {
+	return Record{
+		Time:       t,
+		Exchange:   msg.Exchange,
+		RoutingKey: msg.RoutingKey,
+		Properties: Properties{
+			ContentType:     msg.ContentType,
+			ContentEncoding: msg.ContentEncoding,
+			DeliveryMode:    msg.DeliveryMode,
+			Priority:        msg.Priority,
+			CorrelationID:   msg.CorrelationId,
+			ReplyTo:         msg.ReplyTo,
+			Expiration:      msg.Expiration,
+			MessageID:       msg.MessageId,
+			Timestamp:       msg.Timestamp,
+			Type:            msg.Type,
+			UserID:          msg.UserId,
+			AppID:           msg.AppId,
+		},
+		Headers:     msg.Headers,
+		HeaderTypes: tableTypes(msg.Headers),
+		Body:        msg.Body,
+	}
+}
+
+func tableTypes(t map[string]any) map[string]any {
+	if len(t) == 0 {
+		return nil
+	}
+	types := map[string]any{}
+	for k, v := range t {
+		types[k] = typeOf(v)
+	}
+	return types
+}
+
+// typeOf names the AMQP type of a header value: a string for plain values,
+// a map for a table and a slice for an array.
+func typeOf(v any) any {
+	switch v := v.(type) {
+	case amqp.Table:
+		return tableTypes(v)
+	case map[string]any:
+		return tableTypes(v)
+	case []any:
+		types := make([]any, len(v))
+		for i, item := range v {
+			types[i] = typeOf(item)
+		}
+		return types
+	case bool:
+		return "bool"
+	case int8:
+		return "int8"
+	case uint8:
+		return "uint8"
+	case int16:
+		return "int16"
+	case int32:
+		return "int32"
+	case int, int64:
+		return "int64"
+	case float32:
+		return "float32"
+	case float64:
+		return "float64"
+	case string:
+		return "string"
+	case []byte:
+		return "bytes"
+	case amqp.Decimal:
+		return "decimal"
+	case time.Time:
+		return "timestamp"
+	}
+	return "void"
+}
+
+// Publishing turns the record back into a message to publish, with each
+// header restored to the type in HeaderTypes.
+//
+// Captures written before HeaderTypes existed only have JSON to go on, so
+// their numeric headers come back as int64 when they are whole numbers and
+// float64 otherwise, and byte and timestamp headers come back as strings.
+func (r Record) Publishing() amqp.Publishing {
+	var headers amqp.Table
+	if len(r.Headers) > 0 {
+		headers = withType(r.Headers, r.HeaderTypes).(amqp.Table)
+	}
+	p := r.Properties
+	return amqp.Publishing{
+		Headers:         headers,
+		ContentType:     p.ContentType,
+		ContentEncoding: p.ContentEncoding,
+		DeliveryMode:    p.DeliveryMode,
+		Priority:        p.Priority,
+		CorrelationId:   p.CorrelationID,
+		ReplyTo:         p.ReplyTo,
+		Expiration:      p.Expiration,
+		MessageId:       p.MessageID,
+		Timestamp:       p.Timestamp,
+		Type:            p.Type,
+		UserId:          p.UserID,
+		AppId:           p.AppID,
+		Body:            r.Body,
+	}
+}
+
+// withType converts a header value read back from JSON to the AMQP type
+// typeOf recorded for it. Values already of the right type are left alone,
+// and anything without a recorded type falls back to fromJSON.
+func withType(v any, typ any) any {
+	switch typ := typ.(type) {
+	case map[string]any:
+		var m map[string]any
+		switch v := v.(type) {
+		case map[string]any:
+			m = v
+		case amqp.Table:
+			m = v
+		default:
+			return fromJSON(v)
+		}
+		t := amqp.Table{}
+		for k, item := range m {
+			t[k] = withType(item, typ[k])
+		}
+		return t
+	case []any:
+		items, ok := v.([]any)
+		if !ok {
+			return fromJSON(v)
+		}
+		out := make([]any, len(items))
+		for i, item := range items {
+			var itemType any
+			if i < len(typ) {
+				itemType = typ[i]
+			}
+			out[i] = withType(item, itemType)
+		}
+		return out
+	case string:
+		if typed, ok := fromJSONAs(v, typ); ok {
+			return typed
+		}
+		return v
+	}
+	return fromJSON(v)
+}
+
+// fromJSONAs converts v as encoding/json decoded it to the named type, or
+// reports false if v doesn't look like JSON for that type.
+func fromJSONAs(v any, typ string) (any, bool) {
+	switch v := v.(type) {
+	case float64:
+		switch typ {
+		case "int8":
+			return int8(v), true
+		case "uint8":
+			return uint8(v), true
+		case "int16":
+			return int16(v), true
+		case "int32":
+			return int32(v), true
+		case "int64":
+			return int64(v), true
+		case "float32":
+			return float32(v), true
+		case "float64":
+			return v, true
+		}
+	case string:
+		switch typ {
+		case "string":
+			return v, true
+		case "bytes":
+			b, err := base64.StdEncoding.DecodeString(v)
+			return b, err == nil
+		case "timestamp":
+			t, err := time.Parse(time.RFC3339Nano, v)
+			return t, err == nil
+		}
+	case map[string]any:
+		if typ == "decimal" {
+			scale, _ := v["Scale"].(float64)
+			value, _ := v["Value"].(float64)
+			return amqp.Decimal{Scale: uint8(scale), Value: int32(value)}, true
+		}
+	case nil:
+		return nil, typ == "void"
+	}
+	return nil, false
+}
+
+func fromJSON(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		t := amqp.Table{}
+		for k, item := range v {
+			t[k] = fromJSON(item)
+		}
+		return t
+	case amqp.Table:
+		return fromJSON(map[string]any(v))
+	case []any:
+		out := make([]any, len(v))
+		for i, item := range v {
+			out[i] = fromJSON(item)
+		}
+		return out
+	case float64:
+		if v == math.Trunc(v) && math.Abs(v) < 1<<53 {
+			return int64(v)
+		}
+		return v
+	}
+	return v
+}
+
+// Writer appends records to a capture file.
+type Writer struct {
+	w   *bufio.Writer
+	enc *json.Encoder
+}
+
+func NewWriter(w io.Writer) *Writer {
+	bw := bufio.NewWriter(w)
+	return &Writer{w: bw, enc: json.NewEncoder(bw)}
+}
+
+// Write adds one record. Records are buffered until Flush.
+func (w *Writer) Write(r Record) error {
+	return w.enc.Encode(r)
+}
+
+func (w *Writer) Flush() error {
+	return w.w.Flush()
+}
+
+// Reader reads records back in the order they were written.
+type Reader struct {
+	dec *json.Decoder
+}
+
+func NewReader(r io.Reader) *Reader {
+	return &Reader{dec: json.NewDecoder(bufio.NewReader(r))}
+}
+
+// Read returns the next record, or io.EOF after the last one.
+func (r *Reader) Read() (Record, error) {
+	var rec Record
+	err := r.dec.Decode(&rec)
+	return rec, err
+}
@@ -0,0 +1,72 @@
+package capture
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+func roundTrip(t *testing.T, rec Record) Record {
+	t.Helper()
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	if err := w.Write(rec); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+	r := NewReader(&buf)
+	got, err := r.Read()
+	if err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	if _, err := r.Read(); err != io.EOF {
+		t.Fatalf("second Read = %v, want io.EOF", err)
+	}
+	return got
+}
+
+func TestHeaderTypesRoundTrip(t *testing.T) {
+	stamp := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	headers := amqp.Table{
+		"bool":      true,
+		"int8":      int8(-8),
+		"uint8":     uint8(8),
+		"int16":     int16(-16),
+		"int32":     int32(32),
+		"int64":     int64(1) << 40,
+		"float32":   float32(1.5),
+		"float64":   2.0,
+		"string":    "s",
+		"bytes":     []byte{0, 1, 2},
+		"decimal":   amqp.Decimal{Scale: 2, Value: 314},
+		"timestamp": stamp,
+		"void":      nil,
+		"table":     amqp.Table{"n": int16(1), "deeper": amqp.Table{"b": []byte("x")}},
+		"array":     []any{int32(1), "two", float32(3)},
+	}
+	msg := amqp.Delivery{Exchange: "peril_topic", RoutingKey: "war.alice", Headers: headers, Body: []byte("{}")}
+
+	got := roundTrip(t, FromDelivery(msg, stamp)).Publishing()
+	if err := got.Headers.Validate(); err != nil {
+		t.Fatalf("replayed headers aren't valid AMQP: %v", err)
+	}
+	if !reflect.DeepEqual(got.Headers, headers) {
+		t.Errorf("headers came back as\n%#v\nwant\n%#v", got.Headers, headers)
+	}
+}
+
+func TestHeadersWithoutTypes(t *testing.T) {
+	// captures from before header_types still replay, with JSON's guesses
+	rec := Record{Headers: map[string]any{"n": 3.0, "f": 1.5, "s": "x", "t": map[string]any{"m": 2.0}}}
+	got := roundTrip(t, rec).Publishing()
+	want := amqp.Table{"n": int64(3), "f": 1.5, "s": "x", "t": amqp.Table{"m": int64(2)}}
+	if !reflect.DeepEqual(got.Headers, want) {
+		t.Errorf("headers = %#v, want %#v", got.Headers, want)
+	}
+}
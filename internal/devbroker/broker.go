@@ -0,0 +1,605 @@
+// Package devbroker is an in-memory broker speaking the subset of AMQP
+// 0-9-1 that amqp091-go uses in this repo: connection and channel
+// handshakes, exchange/queue declare/bind, basic.publish/consume/get/ack/
+// nack/reject/qos, publisher confirms, and the queue arguments Peril relies
+// on (dead-lettering, message TTL, queue expiry, single active consumer).
+//
+// It is meant for development on machines without RabbitMQ. Nothing is
+// written to disk: durable only affects declare equivalence checks, and a
+// restart loses every queue and message.
+package devbroker
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+)
+
+// janitorInterval is how often message TTLs and queue expiry are checked.
+const janitorInterval = 50 * time.Millisecond
+
+// Options configure a Broker.
+type Options struct {
+	// User and Password are the only accepted PLAIN credentials. An empty
+	// User accepts any login.
+	User     string
+	Password string
+}
+
+// Broker holds every exchange, queue and connection. One mutex guards all
+// of it; connections only do network writes outside of it.
+type Broker struct {
+	opts Options
+
+	mu        sync.Mutex
+	unblocked *sync.Cond
+	exchanges map[string]*exchange
+	queues    map[string]*queue
+	conns     map[*conn]struct{}
+	dirty     map[*queue]bool
+	blocked   string // reason, "" when publishers may publish
+	closed    bool
+
+	listeners []net.Listener
+	done      chan struct{}
+}
+
+type exchange struct {
+	name       string
+	kind       string
+	durable    bool
+	autoDelete bool
+	internal   bool
+	bindings   []binding
+}
+
+type binding struct {
+	queue string
+	key   string
+}
+
+// New creates a broker with the default exchange and the standard amq.*
+// exchanges.
+func New(opts Options) *Broker {
+	b := &Broker{
+		opts:      opts,
+		exchanges: map[string]*exchange{},
+		queues:    map[string]*queue{},
+		conns:     map[*conn]struct{}{},
+		dirty:     map[*queue]bool{},
+		done:      make(chan struct{}),
+	}
+	b.unblocked = sync.NewCond(&b.mu)
+
+	b.exchanges[""] = &exchange{name: "", kind: "direct", durable: true}
+	for name, kind := range map[string]string{
+		"amq.direct": "direct",
+		"amq.fanout": "fanout",
+		"amq.topic":  "topic",
+	} {
+		b.exchanges[name] = &exchange{name: name, kind: kind, durable: true}
+	}
+
+	go b.janitor()
+	return b
+}
+
+// DeclareExchange creates a durable exchange, or checks an existing one.
+func (b *Broker) DeclareExchange(name, kind string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.declareExchange(name, kind, true, false, false)
+}
+
+// DeclareQueue creates a durable queue, or checks an existing one.
+func (b *Broker) DeclareQueue(name string, args amqp.Table) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	_, err := b.declareQueue(nil, name, true, false, false, args)
+	return err
+}
+
+// BindQueue binds a queue to an exchange.
+func (b *Broker) BindQueue(queue, exchange, key string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.bind(nil, queue, exchange, key)
+}
+
+// ListenAndServe accepts AMQP connections on addr until Close.
+func (b *Broker) ListenAndServe(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return b.Serve(ln)
+}
+
+// Serve accepts AMQP connections on ln until Close.
+func (b *Broker) Serve(ln net.Listener) error {
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		ln.Close()
+		return errors.New("broker closed")
+	}
+	b.listeners = append(b.listeners, ln)
+	b.mu.Unlock()
+
+	for {
+		nc, err := ln.Accept()
+		if err != nil {
+			select {
+			case <-b.done:
+				return nil
+			default:
+				return err
+			}
+		}
+		go b.serveConn(nc)
+	}
+}
+
+// Close stops listening and drops every connection.
+func (b *Broker) Close() {
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return
+	}
+	b.closed = true
+	close(b.done)
+	listeners := b.listeners
+	conns := make([]*conn, 0, len(b.conns))
+	for c := range b.conns {
+		conns = append(conns, c)
+	}
+	b.blocked = ""
+	b.unblocked.Broadcast()
+	b.mu.Unlock()
+
+	for _, ln := range listeners {
+		ln.Close()
+	}
+	for _, c := range conns {
+		c.nc.Close()
+	}
+}
+
+// SetBlocked sends connection.blocked to every client and holds back
+// publishes until Unblock, the way RabbitMQ behaves in a resource alarm.
+func (b *Broker) SetBlocked(reason string) {
+	if reason == "" {
+		reason = "blocked"
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.blocked != "" {
+		return
+	}
+	b.blocked = reason
+	for c := range b.conns {
+		c.sendMethod(0, methodPayload(connectionBlocked).shortstr(reason))
+	}
+}
+
+// Unblock lifts SetBlocked.
+func (b *Broker) Unblock() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.blocked == "" {
+		return
+	}
+	b.blocked = ""
+	b.unblocked.Broadcast()
+	for c := range b.conns {
+		c.sendMethod(0, methodPayload(connectionUnblock))
+	}
+}
+
+// QueueStats describes one queue for listing.
+type QueueStats struct {
+	Name      string
+	Ready     int
+	Unacked   int
+	Consumers int
+}
+
+// Queues lists every queue by name.
+func (b *Broker) Queues() []QueueStats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	stats := make([]QueueStats, 0, len(b.queues))
+	for _, q := range b.queues {
+		stats = append(stats, QueueStats{
+			Name:      q.name,
+			Ready:     len(q.messages),
+			Unacked:   q.unacked,
+			Consumers: len(q.consumers),
+		})
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Name < stats[j].Name })
+	return stats
+}
+
+func (b *Broker) janitor() {
+	ticker := time.NewTicker(janitorInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-b.done:
+			return
+		case now := <-ticker.C:
+			b.mu.Lock()
+			for _, q := range b.queues {
+				if q.expires > 0 && len(q.consumers) == 0 && now.Sub(q.lastUsed) > q.expires {
+					fmt.Printf("[broker] Queue %s expired\n", q.name)
+					b.deleteQueue(q)
+					continue
+				}
+				if len(q.messages) > 0 && q.headExpired(now) {
+					b.dirty[q] = true
+				}
+			}
+			b.pump()
+			b.mu.Unlock()
+		}
+	}
+}
+
+// pump dispatches every queue that got new messages or consumer capacity
+// until nothing changes. Dispatching can dead-letter expired messages into
+// other queues, which is why this loops instead of recursing.
+func (b *Broker) pump() {
+	for len(b.dirty) > 0 {
+		for q := range b.dirty {
+			delete(b.dirty, q)
+			if b.queues[q.name] == q {
+				q.dispatch(b)
+			}
+		}
+	}
+}
+
+func (b *Broker) declareExchange(name, kind string, durable, autoDelete, internal bool) error {
+	if ex, ok := b.exchanges[name]; ok {
+		if ex.kind != kind || ex.durable != durable || ex.autoDelete != autoDelete || ex.internal != internal {
+			return softError(replyPrecondition, fmt.Sprintf("PRECONDITION_FAILED - inequivalent arg for exchange '%s'", name))
+		}
+		return nil
+	}
+	if name == "" || strings.HasPrefix(name, "amq.") {
+		return softError(replyAccessRefused, fmt.Sprintf("ACCESS_REFUSED - exchange name '%s' contains reserved prefix 'amq.*'", name))
+	}
+	switch kind {
+	case "direct", "fanout", "topic":
+	case "headers":
+		return hardError(replyNotImplemented, "NOT_IMPLEMENTED - headers exchanges are not supported by peril-broker")
+	default:
+		return hardError(replyCommandInvalid, fmt.Sprintf("COMMAND_INVALID - unknown exchange type '%s'", kind))
+	}
+	b.exchanges[name] = &exchange{name: name, kind: kind, durable: durable, autoDelete: autoDelete, internal: internal}
+	return nil
+}
+
+func (b *Broker) deleteExchange(name string, ifUnused bool) error {
+	ex, ok := b.exchanges[name]
+	if !ok {
+		return nil
+	}
+	if name == "" || strings.HasPrefix(name, "amq.") {
+		return softError(replyAccessRefused, fmt.Sprintf("ACCESS_REFUSED - cannot delete exchange '%s'", name))
+	}
+	if ifUnused && len(ex.bindings) > 0 {
+		return softError(replyPrecondition, fmt.Sprintf("PRECONDITION_FAILED - exchange '%s' in use", name))
+	}
+	delete(b.exchanges, name)
+	return nil
+}
+
+func (b *Broker) declareQueue(owner *conn, name string, durable, exclusive, autoDelete bool, args amqp.Table) (*queue, error) {
+	generated := name == ""
+	if generated {
+		name = "amq.gen-" + randomID()
+	}
+	if q, ok := b.queues[name]; ok {
+		if err := q.checkOwner(owner); err != nil {
+			return nil, err
+		}
+		if q.durable != durable || q.exclusive != exclusive || q.autoDelete != autoDelete {
+			return nil, softError(replyPrecondition, fmt.Sprintf("PRECONDITION_FAILED - inequivalent arg for queue '%s'", name))
+		}
+		if arg, ok := inequivalentArg(q.args, args); !ok {
+			return nil, softError(replyPrecondition, fmt.Sprintf("PRECONDITION_FAILED - inequivalent arg '%s' for queue '%s'", arg, name))
+		}
+		q.lastUsed = time.Now()
+		return q, nil
+	}
+	if owner != nil && !generated && strings.HasPrefix(name, "amq.") {
+		return nil, softError(replyAccessRefused, fmt.Sprintf("ACCESS_REFUSED - queue name '%s' contains reserved prefix 'amq.*'", name))
+	}
+
+	q, err := newQueue(name, durable, exclusive, autoDelete, args)
+	if err != nil {
+		return nil, err
+	}
+	if exclusive {
+		q.owner = owner
+	}
+	b.queues[name] = q
+	return q, nil
+}
+
+func (b *Broker) lookupQueue(owner *conn, name string) (*queue, error) {
+	q, ok := b.queues[name]
+	if !ok {
+		return nil, softError(replyNotFound, fmt.Sprintf("NOT_FOUND - no queue '%s' in vhost '/'", name))
+	}
+	if err := q.checkOwner(owner); err != nil {
+		return nil, err
+	}
+	return q, nil
+}
+
+func (b *Broker) lookupExchange(name string) (*exchange, error) {
+	ex, ok := b.exchanges[name]
+	if !ok {
+		return nil, softError(replyNotFound, fmt.Sprintf("NOT_FOUND - no exchange '%s' in vhost '/'", name))
+	}
+	return ex, nil
+}
+
+func (b *Broker) bind(owner *conn, queueName, exchangeName, key string) error {
+	q, err := b.lookupQueue(owner, queueName)
+	if err != nil {
+		return err
+	}
+	ex, err := b.lookupExchange(exchangeName)
+	if err != nil {
+		return err
+	}
+	if ex.name == "" {
+		return softError(replyAccessRefused, "ACCESS_REFUSED - operation not permitted on the default exchange")
+	}
+	for _, bd := range ex.bindings {
+		if bd.queue == q.name && bd.key == key {
+			return nil
+		}
+	}
+	ex.bindings = append(ex.bindings, binding{queue: q.name, key: key})
+	return nil
+}
+
+func (b *Broker) unbind(owner *conn, queueName, exchangeName, key string) error {
+	if _, err := b.lookupQueue(owner, queueName); err != nil {
+		return err
+	}
+	ex, err := b.lookupExchange(exchangeName)
+	if err != nil {
+		return err
+	}
+	for i, bd := range ex.bindings {
+		if bd.queue == queueName && bd.key == key {
+			ex.bindings = append(ex.bindings[:i], ex.bindings[i+1:]...)
+			break
+		}
+	}
+	b.maybeDeleteExchange(ex)
+	return nil
+}
+
+func (b *Broker) maybeDeleteExchange(ex *exchange) {
+	if ex.autoDelete && len(ex.bindings) == 0 {
+		delete(b.exchanges, ex.name)
+	}
+}
+
+// deleteQueue removes q with its bindings and cancels its consumers.
+// Messages still unacked are dropped when they are settled.
+func (b *Broker) deleteQueue(q *queue) int {
+	if b.queues[q.name] != q {
+		return 0
+	}
+	delete(b.queues, q.name)
+	delete(b.dirty, q)
+
+	for _, ex := range b.exchanges {
+		kept := ex.bindings[:0]
+		for _, bd := range ex.bindings {
+			if bd.queue != q.name {
+				kept = append(kept, bd)
+			}
+		}
+		ex.bindings = kept
+		b.maybeDeleteExchange(ex)
+	}
+
+	for _, cons := range q.consumers {
+		delete(cons.ch.consumers, cons.tag)
+		// consumer_cancel_notify: tell the client its consumer is gone
+		cons.ch.c.sendMethod(cons.ch.id, methodPayload(basicCancel).shortstr(cons.tag).bit(true))
+	}
+	q.consumers = nil
+
+	n := len(q.messages)
+	q.messages = nil
+	return n
+}
+
+// route finds the queues a message published to ex with key goes to.
+func (b *Broker) route(ex *exchange, key string) []*queue {
+	if ex.name == "" {
+		if q, ok := b.queues[key]; ok {
+			return []*queue{q}
+		}
+		return nil
+	}
+
+	seen := map[string]bool{}
+	var queues []*queue
+	for _, bd := range ex.bindings {
+		if seen[bd.queue] {
+			continue
+		}
+		var match bool
+		switch ex.kind {
+		case "fanout":
+			match = true
+		case "direct":
+			match = bd.key == key
+		case "topic":
+			match = routing.Match(bd.key, key)
+		}
+		if q, ok := b.queues[bd.queue]; match && ok {
+			seen[bd.queue] = true
+			queues = append(queues, q)
+		}
+	}
+	return queues
+}
+
+// publish routes msg and enqueues a copy in every matching queue. It
+// reports whether any queue took it.
+func (b *Broker) publish(ex *exchange, msg *message) bool {
+	queues := b.route(ex, msg.routingKey)
+	for _, q := range queues {
+		b.enqueue(q, msg.copy())
+	}
+	return len(queues) > 0
+}
+
+func (b *Broker) enqueue(q *queue, msg *message) {
+	msg.setExpiry(q, time.Now())
+	q.messages = append(q.messages, msg)
+	b.dirty[q] = true
+}
+
+// deadLetter sends msg on to the queue's dead-letter exchange, if it has
+// one, recording why in the x-death header like RabbitMQ does.
+func (b *Broker) deadLetter(q *queue, msg *message, reason string) {
+	if q.dlx == nil {
+		return
+	}
+	ex, ok := b.exchanges[*q.dlx]
+	if !ok {
+		return
+	}
+
+	dead := msg.copy()
+	dead.redelivered = false
+	// the expiration would only make the dead-lettered copy expire again
+	dead.props.Expiration = ""
+	dead.props.Headers = withDeath(msg.props.Headers, q.name, reason, msg.exchange, msg.routingKey)
+	if q.dlrk != nil {
+		dead.routingKey = *q.dlrk
+	}
+	dead.exchange = ex.name
+
+	b.publish(ex, dead)
+}
+
+func withDeath(headers amqp.Table, queueName, reason, exchange, key string) amqp.Table {
+	out := amqp.Table{}
+	for k, v := range headers {
+		out[k] = v
+	}
+
+	deaths, _ := headers["x-death"].([]any)
+	var kept []any
+	count := int64(1)
+	for _, d := range deaths {
+		t, ok := d.(amqp.Table)
+		if ok && t["queue"] == queueName && t["reason"] == reason {
+			if n, ok := t["count"].(int64); ok {
+				count = n + 1
+			}
+			continue
+		}
+		kept = append(kept, d)
+	}
+	death := amqp.Table{
+		"count":        count,
+		"reason":       reason,
+		"queue":        queueName,
+		"time":         time.Now(),
+		"exchange":     exchange,
+		"routing-keys": []any{key},
+	}
+	out["x-death"] = append([]any{death}, kept...)
+	if _, ok := out["x-first-death-queue"]; !ok {
+		out["x-first-death-queue"] = queueName
+		out["x-first-death-reason"] = reason
+		out["x-first-death-exchange"] = exchange
+	}
+	return out
+}
+
+func randomID() string {
+	var buf [12]byte
+	_, _ = rand.Read(buf[:])
+	return base64.RawURLEncoding.EncodeToString(buf[:])
+}
+
+// toInt64 reads a numeric argument whatever integer type the client used.
+func toInt64(v any) (int64, bool) {
+	switch n := v.(type) {
+	case int:
+		return int64(n), true
+	case int8:
+		return int64(n), true
+	case int16:
+		return int64(n), true
+	case int32:
+		return int64(n), true
+	case int64:
+		return n, true
+	case uint8:
+		return int64(n), true
+	case uint16:
+		return int64(n), true
+	case uint32:
+		return int64(n), true
+	}
+	return 0, false
+}
+
+// inequivalentArg compares the arguments of two declarations and returns
+// the first one that differs.
+func inequivalentArg(a, b amqp.Table) (string, bool) {
+	keys := map[string]bool{}
+	for k := range a {
+		keys[k] = true
+	}
+	for k := range b {
+		keys[k] = true
+	}
+	sorted := make([]string, 0, len(keys))
+	for k := range keys {
+		sorted = append(sorted, k)
+	}
+	sort.Strings(sorted)
+
+	for _, k := range sorted {
+		av, bv := a[k], b[k]
+		if an, ok := toInt64(av); ok {
+			if bn, ok := toInt64(bv); ok && an == bn {
+				continue
+			}
+			return k, false
+		}
+		if fmt.Sprint(av) != fmt.Sprint(bv) {
+			return k, false
+		}
+	}
+	return "", true
+}
@@ -0,0 +1,377 @@
+package devbroker
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// start runs a broker on a free port and returns a channel on a
+// connection to it.
+func start(t *testing.T) (*Broker, *amqp.Connection, *amqp.Channel) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	b := New(Options{User: "guest", Password: "guest"})
+	go b.Serve(ln)
+	t.Cleanup(b.Close)
+
+	conn, err := amqp.Dial("amqp://guest:guest@" + ln.Addr().String() + "/")
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	ch, err := conn.Channel()
+	if err != nil {
+		t.Fatalf("Channel: %v", err)
+	}
+	return b, conn, ch
+}
+
+func declare(t *testing.T, ch *amqp.Channel, queue string, args amqp.Table) {
+	t.Helper()
+	if _, err := ch.QueueDeclare(queue, false, false, false, false, args); err != nil {
+		t.Fatalf("QueueDeclare(%s): %v", queue, err)
+	}
+}
+
+func bind(t *testing.T, ch *amqp.Channel, queue, key, exchange string) {
+	t.Helper()
+	if err := ch.QueueBind(queue, key, exchange, false, nil); err != nil {
+		t.Fatalf("QueueBind(%s, %s, %s): %v", queue, key, exchange, err)
+	}
+}
+
+func publish(t *testing.T, ch *amqp.Channel, exchange, key string, msg amqp.Publishing) {
+	t.Helper()
+	if err := ch.Publish(exchange, key, false, false, msg); err != nil {
+		t.Fatalf("Publish(%s, %s): %v", exchange, key, err)
+	}
+}
+
+func text(body string) amqp.Publishing {
+	return amqp.Publishing{ContentType: "text/plain", Body: []byte(body)}
+}
+
+// get polls queue until a message arrives, since publishing doesn't wait
+// for the broker.
+func get(t *testing.T, ch *amqp.Channel, queue string) amqp.Delivery {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		msg, ok, err := ch.Get(queue, true)
+		if err != nil {
+			t.Fatalf("Get(%s): %v", queue, err)
+		}
+		if ok {
+			return msg
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("nothing arrived in %s", queue)
+	return amqp.Delivery{}
+}
+
+func empty(t *testing.T, ch *amqp.Channel, queue string) {
+	t.Helper()
+	q, err := ch.QueueDeclarePassive(queue, false, false, false, false, nil)
+	if err != nil {
+		t.Fatalf("QueueDeclarePassive(%s): %v", queue, err)
+	}
+	if q.Messages != 0 {
+		msg, _, _ := ch.Get(queue, true)
+		t.Errorf("%s has %d message(s), first %q", queue, q.Messages, msg.Body)
+	}
+}
+
+func receive(t *testing.T, deliveries <-chan amqp.Delivery) amqp.Delivery {
+	t.Helper()
+	select {
+	case msg := <-deliveries:
+		return msg
+	case <-time.After(time.Second):
+		t.Fatal("no delivery")
+	}
+	return amqp.Delivery{}
+}
+
+func nothing(t *testing.T, deliveries <-chan amqp.Delivery) {
+	t.Helper()
+	select {
+	case msg := <-deliveries:
+		t.Fatalf("unexpected delivery %q", msg.Body)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestExchangeKinds(t *testing.T) {
+	_, _, ch := start(t)
+
+	for _, ex := range []struct{ name, kind string }{
+		{"d", "direct"}, {"f", "fanout"}, {"t", "topic"},
+	} {
+		if err := ch.ExchangeDeclare(ex.name, ex.kind, false, false, false, false, nil); err != nil {
+			t.Fatalf("ExchangeDeclare(%s): %v", ex.name, err)
+		}
+	}
+	for _, q := range []string{"direct_a", "direct_b", "fanout_a", "fanout_b"} {
+		declare(t, ch, q, nil)
+	}
+	bind(t, ch, "direct_a", "a", "d")
+	bind(t, ch, "direct_b", "b", "d")
+	bind(t, ch, "fanout_a", "ignored", "f")
+	bind(t, ch, "fanout_b", "", "f")
+
+	publish(t, ch, "d", "a", text("to a"))
+	publish(t, ch, "d", "c", text("to nobody"))
+	publish(t, ch, "f", "anything", text("to all"))
+	// the default exchange routes straight to the queue named by the key
+	publish(t, ch, "", "direct_b", text("default"))
+
+	if msg := get(t, ch, "direct_a"); string(msg.Body) != "to a" || msg.RoutingKey != "a" {
+		t.Errorf("direct_a got %q with key %q", msg.Body, msg.RoutingKey)
+	}
+	if msg := get(t, ch, "direct_b"); string(msg.Body) != "default" {
+		t.Errorf("direct_b got %q", msg.Body)
+	}
+	for _, q := range []string{"fanout_a", "fanout_b"} {
+		if msg := get(t, ch, q); string(msg.Body) != "to all" {
+			t.Errorf("%s got %q", q, msg.Body)
+		}
+	}
+	for _, q := range []string{"direct_a", "direct_b", "fanout_a", "fanout_b"} {
+		empty(t, ch, q)
+	}
+
+	// redeclaring with another kind is refused and closes the channel
+	if err := ch.ExchangeDeclare("d", "topic", false, false, false, false, nil); err == nil {
+		t.Error("redeclared a direct exchange as topic")
+	}
+}
+
+func TestTopicMatching(t *testing.T) {
+	tests := []struct {
+		pattern string
+		key     string
+		match   bool
+	}{
+		{"army_moves.*", "army_moves.alice", true},
+		{"army_moves.*", "army_moves", false},
+		{"army_moves.*", "army_moves.alice.bob", false},
+		{"war.#", "war", true},
+		{"war.#", "war.alice.bob", true},
+		{"#", "anything.at.all", true},
+		{"#.bob", "war.alice.bob", true},
+		{"#.bob", "war.alice", false},
+		{"a.*.c", "a.b.c", true},
+		{"a.*.c", "a..c", true},
+		{"a.*.c", "a.c", false},
+		{"pause", "pause", true},
+		{"pause", "pauses", false},
+	}
+
+	_, _, ch := start(t)
+	if err := ch.ExchangeDeclare("peril_topic", "topic", false, false, false, false, nil); err != nil {
+		t.Fatalf("ExchangeDeclare: %v", err)
+	}
+	for _, tt := range tests {
+		t.Run(tt.pattern+" "+tt.key, func(t *testing.T) {
+			declare(t, ch, "q", nil)
+			bind(t, ch, "q", tt.pattern, "peril_topic")
+			publish(t, ch, "peril_topic", tt.key, text(tt.key))
+			// a marker on the default exchange shows the publish was handled
+			publish(t, ch, "", "q", text("marker"))
+
+			first := get(t, ch, "q")
+			if got := string(first.Body) == tt.key; got != tt.match {
+				t.Errorf("Match(%q, %q) = %v, want %v", tt.pattern, tt.key, got, tt.match)
+			}
+			if tt.match {
+				get(t, ch, "q")
+			}
+			if _, err := ch.QueueDelete("q", false, false, false); err != nil {
+				t.Fatalf("QueueDelete: %v", err)
+			}
+		})
+	}
+}
+
+func TestMessageTTL(t *testing.T) {
+	_, _, ch := start(t)
+	if err := ch.ExchangeDeclare("peril_dlx", "fanout", false, false, false, false, nil); err != nil {
+		t.Fatalf("ExchangeDeclare: %v", err)
+	}
+	declare(t, ch, "dead", nil)
+	bind(t, ch, "dead", "", "peril_dlx")
+	declare(t, ch, "short", amqp.Table{"x-message-ttl": int32(50), "x-dead-letter-exchange": "peril_dlx"})
+	declare(t, ch, "long", amqp.Table{"x-dead-letter-exchange": "peril_dlx"})
+
+	publish(t, ch, "", "short", text("queue ttl"))
+	publish(t, ch, "", "long", amqp.Publishing{Body: []byte("message ttl"), Expiration: "50"})
+	publish(t, ch, "", "long", text("no ttl"))
+
+	got := map[string]amqp.Delivery{}
+	for len(got) < 2 {
+		msg := get(t, ch, "dead")
+		got[string(msg.Body)] = msg
+	}
+	for body, queue := range map[string]string{"queue ttl": "short", "message ttl": "long"} {
+		msg, ok := got[body]
+		if !ok {
+			t.Errorf("%q wasn't dead-lettered", body)
+			continue
+		}
+		if msg.Expiration != "" {
+			t.Errorf("%q kept expiration %q", body, msg.Expiration)
+		}
+		if msg.Headers["x-first-death-queue"] != queue || msg.Headers["x-first-death-reason"] != "expired" {
+			t.Errorf("%q died in %v because %v", body, msg.Headers["x-first-death-queue"], msg.Headers["x-first-death-reason"])
+		}
+	}
+	if msg := get(t, ch, "long"); string(msg.Body) != "no ttl" {
+		t.Errorf("long kept %q", msg.Body)
+	}
+	empty(t, ch, "short")
+}
+
+func TestDeadLettering(t *testing.T) {
+	_, _, ch := start(t)
+	if err := ch.ExchangeDeclare("peril_dlx", "topic", false, false, false, false, nil); err != nil {
+		t.Fatalf("ExchangeDeclare: %v", err)
+	}
+	declare(t, ch, "dead", nil)
+	bind(t, ch, "dead", "#", "peril_dlx")
+	declare(t, ch, "work", amqp.Table{"x-dead-letter-exchange": "peril_dlx"})
+	declare(t, ch, "rekeyed", amqp.Table{"x-dead-letter-exchange": "peril_dlx", "x-dead-letter-routing-key": "rekeyed.dead"})
+	declare(t, ch, "capped", amqp.Table{"x-dead-letter-exchange": "peril_dlx", "x-max-length": int32(1)})
+
+	publish(t, ch, "", "work", amqp.Publishing{Body: []byte("rejected"), Headers: amqp.Table{"kept": "yes"}})
+	deliveries, err := ch.Consume("work", "", false, false, false, false, nil)
+	if err != nil {
+		t.Fatalf("Consume: %v", err)
+	}
+	msg := receive(t, deliveries)
+	if err := msg.Nack(false, true); err != nil {
+		t.Fatalf("Nack: %v", err)
+	}
+	msg = receive(t, deliveries)
+	if !msg.Redelivered {
+		t.Error("requeued message isn't marked redelivered")
+	}
+	if err := msg.Nack(false, false); err != nil {
+		t.Fatalf("Nack: %v", err)
+	}
+
+	dead := get(t, ch, "dead")
+	if string(dead.Body) != "rejected" || dead.RoutingKey != "work" || dead.Headers["kept"] != "yes" {
+		t.Errorf("dead-lettered %q with key %q and headers %v", dead.Body, dead.RoutingKey, dead.Headers)
+	}
+	if dead.Redelivered {
+		t.Error("dead-lettered copy is marked redelivered")
+	}
+	deaths, _ := dead.Headers["x-death"].([]any)
+	if len(deaths) != 1 {
+		t.Fatalf("x-death = %v", dead.Headers["x-death"])
+	}
+	death := deaths[0].(amqp.Table)
+	if death["reason"] != "rejected" || death["queue"] != "work" || death["count"] != int64(1) {
+		t.Errorf("x-death = %v", death)
+	}
+
+	publish(t, ch, "", "rekeyed", text("rekeyed"))
+	msg, _, err = ch.Get("rekeyed", false)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	msg.Reject(false)
+	if dead := get(t, ch, "dead"); dead.RoutingKey != "rekeyed.dead" {
+		t.Errorf("x-dead-letter-routing-key ignored: key %q", dead.RoutingKey)
+	}
+
+	publish(t, ch, "", "capped", text("oldest"))
+	publish(t, ch, "", "capped", text("newest"))
+	if dead := get(t, ch, "dead"); string(dead.Body) != "oldest" || dead.Headers["x-first-death-reason"] != "maxlen" {
+		t.Errorf("x-max-length dead-lettered %q because %v", dead.Body, dead.Headers["x-first-death-reason"])
+	}
+	if msg := get(t, ch, "capped"); string(msg.Body) != "newest" {
+		t.Errorf("capped kept %q", msg.Body)
+	}
+}
+
+func TestPrefetch(t *testing.T) {
+	_, _, ch := start(t)
+	declare(t, ch, "work", nil)
+	if err := ch.Qos(2, 0, false); err != nil {
+		t.Fatalf("Qos: %v", err)
+	}
+	deliveries, err := ch.Consume("work", "", false, false, false, false, nil)
+	if err != nil {
+		t.Fatalf("Consume: %v", err)
+	}
+	for _, body := range []string{"1", "2", "3", "4"} {
+		publish(t, ch, "", "work", text(body))
+	}
+
+	first := receive(t, deliveries)
+	receive(t, deliveries)
+	nothing(t, deliveries)
+
+	// acking one makes room for exactly one more
+	if err := first.Ack(false); err != nil {
+		t.Fatalf("Ack: %v", err)
+	}
+	third := receive(t, deliveries)
+	if string(third.Body) != "3" {
+		t.Errorf("third delivery = %q", third.Body)
+	}
+	nothing(t, deliveries)
+
+	// multiple acks everything up to the tag
+	if err := third.Ack(true); err != nil {
+		t.Fatalf("Ack: %v", err)
+	}
+	if msg := receive(t, deliveries); string(msg.Body) != "4" {
+		t.Errorf("fourth delivery = %q", msg.Body)
+	}
+}
+
+func TestSingleActiveConsumer(t *testing.T) {
+	_, conn, ch := start(t)
+	declare(t, ch, "sac", amqp.Table{"x-single-active-consumer": true})
+
+	other, err := conn.Channel()
+	if err != nil {
+		t.Fatalf("Channel: %v", err)
+	}
+	active, err := ch.Consume("sac", "active", true, false, false, false, nil)
+	if err != nil {
+		t.Fatalf("Consume: %v", err)
+	}
+	standby, err := other.Consume("sac", "standby", true, false, false, false, nil)
+	if err != nil {
+		t.Fatalf("Consume: %v", err)
+	}
+
+	for _, body := range []string{"1", "2", "3"} {
+		publish(t, ch, "", "sac", text(body))
+	}
+	for _, want := range []string{"1", "2", "3"} {
+		if msg := receive(t, active); string(msg.Body) != want {
+			t.Errorf("active got %q, want %q", msg.Body, want)
+		}
+	}
+	nothing(t, standby)
+
+	// the standby takes over once the active consumer goes away
+	if err := ch.Cancel("active", false); err != nil {
+		t.Fatalf("Cancel: %v", err)
+	}
+	publish(t, other, "", "sac", text("4"))
+	if msg := receive(t, standby); string(msg.Body) != "4" {
+		t.Errorf("standby got %q", msg.Body)
+	}
+}
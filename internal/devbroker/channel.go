@@ -0,0 +1,445 @@
+package devbroker
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// handleMethod runs one method on an open channel with the broker locked.
+func (ch *channel) handleMethod(id methodID, d *decoder) error {
+	b := ch.c.b
+
+	switch id {
+	case channelFlow:
+		ch.active = d.bit()
+		if d.err != nil {
+			return syntaxError(d)
+		}
+		ch.c.sendMethod(ch.id, methodPayload(channelFlowOk).bit(ch.active))
+		ch.markQueuesDirty()
+
+	case channelClose:
+		ch.cleanup()
+		delete(ch.c.channels, ch.id)
+		ch.c.sendMethod(ch.id, methodPayload(channelCloseOk))
+
+	case exchangeDeclare:
+		d.short() // reserved
+		name := d.shortstr()
+		kind := d.shortstr()
+		passive, durable, autoDelete, internal, noWait := d.bit(), d.bit(), d.bit(), d.bit(), d.bit()
+		d.table()
+		if d.err != nil {
+			return syntaxError(d)
+		}
+		if passive {
+			if _, err := b.lookupExchange(name); err != nil {
+				return err
+			}
+		} else if err := b.declareExchange(name, kind, durable, autoDelete, internal); err != nil {
+			return err
+		}
+		if !noWait {
+			ch.c.sendMethod(ch.id, methodPayload(exchangeDeclareOk))
+		}
+
+	case exchangeDelete:
+		d.short()
+		name := d.shortstr()
+		ifUnused, noWait := d.bit(), d.bit()
+		if d.err != nil {
+			return syntaxError(d)
+		}
+		if err := b.deleteExchange(name, ifUnused); err != nil {
+			return err
+		}
+		if !noWait {
+			ch.c.sendMethod(ch.id, methodPayload(exchangeDeleteOk))
+		}
+
+	case queueDeclare:
+		d.short()
+		name := d.shortstr()
+		passive, durable, exclusive, autoDelete, noWait := d.bit(), d.bit(), d.bit(), d.bit(), d.bit()
+		args := d.table()
+		if d.err != nil {
+			return syntaxError(d)
+		}
+
+		var q *queue
+		var err error
+		if passive {
+			q, err = b.lookupQueue(ch.c, name)
+		} else {
+			q, err = b.declareQueue(ch.c, name, durable, exclusive, autoDelete, args)
+		}
+		if err != nil {
+			return err
+		}
+		ch.lastQueue = q.name
+		if !noWait {
+			ch.c.sendMethod(ch.id, methodPayload(queueDeclareOk).
+				shortstr(q.name).
+				long(uint32(len(q.messages))).
+				long(uint32(len(q.consumers))))
+		}
+
+	case queueBind:
+		d.short()
+		queueName := ch.queueName(d.shortstr())
+		exchangeName := d.shortstr()
+		key := d.shortstr()
+		noWait := d.bit()
+		d.table()
+		if d.err != nil {
+			return syntaxError(d)
+		}
+		if err := b.bind(ch.c, queueName, exchangeName, key); err != nil {
+			return err
+		}
+		if !noWait {
+			ch.c.sendMethod(ch.id, methodPayload(queueBindOk))
+		}
+
+	case queueUnbind:
+		d.short()
+		queueName := ch.queueName(d.shortstr())
+		exchangeName := d.shortstr()
+		key := d.shortstr()
+		d.table()
+		if d.err != nil {
+			return syntaxError(d)
+		}
+		if err := b.unbind(ch.c, queueName, exchangeName, key); err != nil {
+			return err
+		}
+		ch.c.sendMethod(ch.id, methodPayload(queueUnbindOk))
+
+	case queuePurge:
+		d.short()
+		name := ch.queueName(d.shortstr())
+		noWait := d.bit()
+		if d.err != nil {
+			return syntaxError(d)
+		}
+		q, err := b.lookupQueue(ch.c, name)
+		if err != nil {
+			return err
+		}
+		n := len(q.messages)
+		q.messages = nil
+		if !noWait {
+			ch.c.sendMethod(ch.id, methodPayload(queuePurgeOk).long(uint32(n)))
+		}
+
+	case queueDelete:
+		d.short()
+		name := ch.queueName(d.shortstr())
+		ifUnused, ifEmpty, noWait := d.bit(), d.bit(), d.bit()
+		if d.err != nil {
+			return syntaxError(d)
+		}
+		n := 0
+		if q, ok := b.queues[name]; ok {
+			if err := q.checkOwner(ch.c); err != nil {
+				return err
+			}
+			if ifUnused && len(q.consumers) > 0 {
+				return softError(replyPrecondition, fmt.Sprintf("PRECONDITION_FAILED - queue '%s' in use", name))
+			}
+			if ifEmpty && len(q.messages) > 0 {
+				return softError(replyPrecondition, fmt.Sprintf("PRECONDITION_FAILED - queue '%s' not empty", name))
+			}
+			n = b.deleteQueue(q)
+		}
+		if !noWait {
+			ch.c.sendMethod(ch.id, methodPayload(queueDeleteOk).long(uint32(n)))
+		}
+
+	case basicQos:
+		d.long() // prefetch-size, which RabbitMQ ignores too
+		count := int(d.short())
+		global := d.bit()
+		if d.err != nil {
+			return syntaxError(d)
+		}
+		if global {
+			ch.globalPrefetch = count
+		} else {
+			ch.prefetch = count
+		}
+		ch.c.sendMethod(ch.id, methodPayload(basicQosOk))
+		ch.markQueuesDirty()
+
+	case basicConsume:
+		d.short()
+		queueName := ch.queueName(d.shortstr())
+		tag := d.shortstr()
+		_, noAck, exclusive, noWait := d.bit(), d.bit(), d.bit(), d.bit() // no-local is not supported by RabbitMQ either
+		d.table()
+		if d.err != nil {
+			return syntaxError(d)
+		}
+		return ch.consume(queueName, tag, noAck, exclusive, noWait)
+
+	case basicCancel:
+		tag := d.shortstr()
+		noWait := d.bit()
+		if d.err != nil {
+			return syntaxError(d)
+		}
+		if cons, ok := ch.consumers[tag]; ok {
+			delete(ch.consumers, tag)
+			cons.q.removeConsumer(b, cons)
+		}
+		if !noWait {
+			ch.c.sendMethod(ch.id, methodPayload(basicCancelOk).shortstr(tag))
+		}
+
+	case basicPublish:
+		d.short()
+		exchangeName := d.shortstr()
+		key := d.shortstr()
+		mandatory, immediate := d.bit(), d.bit()
+		if d.err != nil {
+			return syntaxError(d)
+		}
+		if immediate {
+			return hardError(replyNotImplemented, "NOT_IMPLEMENTED - immediate=true")
+		}
+		ex, err := b.lookupExchange(exchangeName)
+		if err != nil {
+			return err
+		}
+		if ex.internal {
+			return softError(replyAccessRefused, fmt.Sprintf("ACCESS_REFUSED - cannot publish to internal exchange '%s'", ex.name))
+		}
+		ch.pending = &publishing{exchange: exchangeName, key: key, mandatory: mandatory}
+
+	case basicGet:
+		d.short()
+		name := ch.queueName(d.shortstr())
+		noAck := d.bit()
+		if d.err != nil {
+			return syntaxError(d)
+		}
+		return ch.get(name, noAck)
+
+	case basicAck:
+		tag := d.longlong()
+		multiple := d.bit()
+		if d.err != nil {
+			return syntaxError(d)
+		}
+		return ch.settle(tag, multiple, func(*delivery) {})
+
+	case basicReject:
+		tag := d.longlong()
+		requeue := d.bit()
+		if d.err != nil {
+			return syntaxError(d)
+		}
+		return ch.settle(tag, false, ch.reject(requeue))
+
+	case basicNack:
+		tag := d.longlong()
+		multiple, requeue := d.bit(), d.bit()
+		if d.err != nil {
+			return syntaxError(d)
+		}
+		return ch.settle(tag, multiple, ch.reject(requeue))
+
+	case basicRecover:
+		d.bit() // requeue; redelivering to the same consumer is not supported, so always requeue
+		if d.err != nil {
+			return syntaxError(d)
+		}
+		if err := ch.settle(0, true, ch.reject(true)); err != nil {
+			return err
+		}
+		ch.c.sendMethod(ch.id, methodPayload(basicRecoverOk))
+
+	case confirmSelect:
+		noWait := d.bit()
+		if d.err != nil {
+			return syntaxError(d)
+		}
+		ch.confirm = true
+		if !noWait {
+			ch.c.sendMethod(ch.id, methodPayload(confirmSelectOk))
+		}
+
+	default:
+		return hardError(replyNotImplemented, fmt.Sprintf("NOT_IMPLEMENTED - method %d.%d is not supported by peril-broker", id.class, id.method))
+	}
+	return nil
+}
+
+func syntaxError(d *decoder) error {
+	return hardError(replySyntaxError, "SYNTAX_ERROR - "+d.err.Error())
+}
+
+// queueName resolves the empty queue name to the channel's last declared
+// queue, as the spec allows.
+func (ch *channel) queueName(name string) string {
+	if name == "" {
+		return ch.lastQueue
+	}
+	return name
+}
+
+func (ch *channel) markQueuesDirty() {
+	for _, cons := range ch.consumers {
+		ch.c.b.dirty[cons.q] = true
+	}
+}
+
+func (ch *channel) consume(queueName, tag string, noAck, exclusive, noWait bool) error {
+	b := ch.c.b
+	q, err := b.lookupQueue(ch.c, queueName)
+	if err != nil {
+		return err
+	}
+	if tag == "" {
+		tag = "amq.ctag-" + randomID()
+	}
+	if _, ok := ch.consumers[tag]; ok {
+		return hardError(replyNotAllowed, fmt.Sprintf("NOT_ALLOWED - attempt to reuse consumer tag '%s'", tag))
+	}
+	for _, other := range q.consumers {
+		if other.exclusive {
+			return softError(replyAccessRefused, fmt.Sprintf("ACCESS_REFUSED - queue '%s' has an exclusive consumer", q.name))
+		}
+	}
+	if exclusive && len(q.consumers) > 0 {
+		return softError(replyAccessRefused, fmt.Sprintf("ACCESS_REFUSED - queue '%s' in exclusive use", q.name))
+	}
+
+	cons := &consumer{tag: tag, ch: ch, q: q, noAck: noAck, exclusive: exclusive, prefetch: ch.prefetch}
+	ch.consumers[tag] = cons
+	q.consumers = append(q.consumers, cons)
+	q.lastUsed = time.Now()
+
+	// consume-ok has to reach the client before the first delivery
+	if !noWait {
+		ch.c.sendMethod(ch.id, methodPayload(basicConsumeOk).shortstr(tag))
+	}
+	b.dirty[q] = true
+	return nil
+}
+
+func (ch *channel) get(queueName string, noAck bool) error {
+	b := ch.c.b
+	q, err := b.lookupQueue(ch.c, queueName)
+	if err != nil {
+		return err
+	}
+	q.lastUsed = time.Now()
+
+	now := time.Now()
+	for len(q.messages) > 0 && q.messages[0].expired(now) {
+		expired := q.messages[0]
+		q.messages = q.messages[1:]
+		b.deadLetter(q, expired, "expired")
+	}
+	if len(q.messages) == 0 {
+		ch.c.sendMethod(ch.id, methodPayload(basicGetEmpty).shortstr(""))
+		return nil
+	}
+
+	msg := q.messages[0]
+	q.messages = q.messages[1:]
+	ch.nextTag++
+	tag := ch.nextTag
+	if !noAck {
+		ch.unacked[tag] = &delivery{tag: tag, msg: msg, q: q}
+		q.unacked++
+	}
+	ch.c.sendContent(ch.id, methodPayload(basicGetOk).
+		longlong(tag).
+		bit(msg.redelivered).
+		shortstr(msg.exchange).
+		shortstr(msg.routingKey).
+		long(uint32(len(q.messages))), msg.props, msg.body)
+	return nil
+}
+
+// settle acks, nacks or rejects deliveries. Tag 0 with multiple covers
+// everything outstanding on the channel.
+func (ch *channel) settle(tag uint64, multiple bool, fn func(*delivery)) error {
+	var tags []uint64
+	if multiple {
+		for t := range ch.unacked {
+			if tag == 0 || t <= tag {
+				tags = append(tags, t)
+			}
+		}
+		// newest first, so requeued messages end up in their original order
+		sort.Slice(tags, func(i, j int) bool { return tags[i] > tags[j] })
+	} else {
+		if _, ok := ch.unacked[tag]; !ok {
+			return softError(replyPrecondition, fmt.Sprintf("PRECONDITION_FAILED - unknown delivery tag %d", tag))
+		}
+		tags = []uint64{tag}
+	}
+
+	for _, t := range tags {
+		d := ch.unacked[t]
+		delete(ch.unacked, t)
+		d.q.unacked--
+		if d.cons != nil {
+			d.cons.unacked--
+		}
+		fn(d)
+		ch.c.b.dirty[d.q] = true
+	}
+	// a global prefetch frees capacity for every consumer of the channel
+	ch.markQueuesDirty()
+	return nil
+}
+
+// reject returns what happens to a nacked or rejected delivery.
+func (ch *channel) reject(requeue bool) func(*delivery) {
+	b := ch.c.b
+	return func(d *delivery) {
+		if b.queues[d.q.name] != d.q {
+			return
+		}
+		if requeue {
+			d.q.requeue(d.msg)
+			return
+		}
+		b.deadLetter(d.q, d.msg, "rejected")
+	}
+}
+
+func (ch *channel) completePublish() error {
+	b := ch.c.b
+	p := ch.pending
+	ch.pending = nil
+
+	// hold publishers back while blocked, like a RabbitMQ resource alarm
+	for b.blocked != "" && !b.closed {
+		b.unblocked.Wait()
+	}
+
+	ex, ok := b.exchanges[p.exchange]
+	routed := false
+	msg := &message{exchange: p.exchange, routingKey: p.key, props: p.props, body: p.body}
+	if ok {
+		routed = b.publish(ex, msg)
+	}
+	if !routed && p.mandatory {
+		ch.c.sendContent(ch.id, methodPayload(basicReturn).
+			short(replyNoRoute).
+			shortstr("NO_ROUTE").
+			shortstr(p.exchange).
+			shortstr(p.key), p.props, p.body)
+	}
+	if ch.confirm {
+		ch.publishSeq++
+		ch.c.sendMethod(ch.id, methodPayload(basicAck).longlong(ch.publishSeq).bit(false))
+	}
+	return nil
+}
@@ -0,0 +1,549 @@
+package devbroker
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+const (
+	handshakeTimeout = 10 * time.Second
+	writeTimeout     = 10 * time.Second
+
+	// what we offer in connection.tune
+	channelMax = 2047
+	frameMax   = 128 * 1024
+	heartbeat  = 60 * time.Second
+)
+
+var serverProperties = amqp.Table{
+	"product":     "peril-broker",
+	"version":     "0.1.0",
+	"platform":    "Go",
+	"information": "In-memory AMQP 0-9-1 broker for Peril development",
+	"capabilities": amqp.Table{
+		"publisher_confirms":           true,
+		"basic.nack":                   true,
+		"consumer_cancel_notify":       true,
+		"connection.blocked":           true,
+		"authentication_failure_close": true,
+		"per_consumer_qos":             true,
+		"exchange_exchange_bindings":   false,
+	},
+}
+
+// errClientClosed ends the read loop after a clean connection.close.
+var errClientClosed = errors.New("client closed the connection")
+
+type conn struct {
+	b    *Broker
+	nc   net.Conn
+	r    *bufio.Reader
+	user string
+
+	frameMax  uint32
+	heartbeat time.Duration
+
+	channels map[uint16]*channel // guarded by b.mu
+
+	// outgoing frames are queued and written by writeLoop, so nothing that
+	// holds the broker lock ever waits on the network
+	omu       sync.Mutex
+	ocond     *sync.Cond
+	out       []byte
+	closing   bool
+	lastWrite time.Time
+	written   chan struct{}
+}
+
+type channel struct {
+	id      uint16
+	c       *conn
+	closing bool // we sent channel.close and wait for close-ok
+	active  bool // channel.flow
+
+	prefetch       int // basic.qos for new consumers
+	globalPrefetch int // basic.qos global=true
+	confirm        bool
+	publishSeq     uint64
+	nextTag        uint64
+	lastQueue      string // queue.declare result, for the empty queue name shorthand
+
+	consumers map[string]*consumer
+	unacked   map[uint64]*delivery
+	pending   *publishing
+}
+
+type delivery struct {
+	tag  uint64
+	msg  *message
+	q    *queue
+	cons *consumer // nil for basic.get
+}
+
+// publishing is a basic.publish waiting for its content frames.
+type publishing struct {
+	exchange   string
+	key        string
+	mandatory  bool
+	headerSeen bool
+	size       uint64
+	props      properties
+	body       []byte
+}
+
+func (b *Broker) serveConn(nc net.Conn) {
+	c := &conn{
+		b:        b,
+		nc:       nc,
+		r:        bufio.NewReader(nc),
+		frameMax: frameMax,
+		channels: map[uint16]*channel{},
+		written:  make(chan struct{}),
+	}
+	c.ocond = sync.NewCond(&c.omu)
+
+	_ = nc.SetDeadline(time.Now().Add(handshakeTimeout))
+	var header [8]byte
+	if _, err := io.ReadFull(c.r, header[:]); err != nil {
+		nc.Close()
+		return
+	}
+	if !bytes.Equal(header[:], protocolHeader) {
+		// tell the client which protocol we do speak
+		_, _ = nc.Write(protocolHeader)
+		nc.Close()
+		return
+	}
+
+	go c.writeLoop()
+	defer c.shutdown()
+
+	if err := c.handshake(); err != nil {
+		fmt.Printf("[broker] Handshake with %s failed: %v\n", nc.RemoteAddr(), err)
+		return
+	}
+	_ = nc.SetDeadline(time.Time{})
+
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return
+	}
+	b.conns[c] = struct{}{}
+	if b.blocked != "" {
+		c.sendMethod(0, methodPayload(connectionBlocked).shortstr(b.blocked))
+	}
+	b.mu.Unlock()
+	fmt.Printf("[broker] %s connected from %s\n", c.user, nc.RemoteAddr())
+
+	if c.heartbeat > 0 {
+		go c.heartbeatLoop()
+	}
+
+	err := c.readLoop()
+	if err != nil && !errors.Is(err, errClientClosed) {
+		fmt.Printf("[broker] Connection from %s closed: %v\n", nc.RemoteAddr(), err)
+	} else {
+		fmt.Printf("[broker] %s disconnected\n", c.user)
+	}
+
+	b.mu.Lock()
+	c.cleanup()
+	b.mu.Unlock()
+}
+
+func (c *conn) handshake() error {
+	c.sendMethod(0, methodPayload(connectionStart).
+		octet(0).octet(9).
+		table(serverProperties).
+		longstr([]byte("PLAIN")).
+		longstr([]byte("en_US")))
+
+	d, err := c.expect(connectionStartOk)
+	if err != nil {
+		return err
+	}
+	d.table() // client properties
+	mechanism := d.shortstr()
+	response := d.longstr()
+	d.shortstr() // locale
+	if d.err != nil {
+		return d.err
+	}
+	if mechanism != "PLAIN" {
+		c.closeConnection(hardError(replyAccessRefused, fmt.Sprintf("ACCESS_REFUSED - unsupported mechanism %s", mechanism)))
+		return fmt.Errorf("unsupported mechanism %s", mechanism)
+	}
+	// PLAIN is "\x00user\x00password"
+	parts := bytes.SplitN(response, []byte{0}, 3)
+	if len(parts) != 3 {
+		c.closeConnection(hardError(replyAccessRefused, "ACCESS_REFUSED - malformed PLAIN response"))
+		return errors.New("malformed PLAIN response")
+	}
+	c.user = string(parts[1])
+	if c.b.opts.User != "" && (c.user != c.b.opts.User || string(parts[2]) != c.b.opts.Password) {
+		c.closeConnection(hardError(replyAccessRefused, "ACCESS_REFUSED - Login was refused using authentication mechanism PLAIN"))
+		return fmt.Errorf("login refused for %q", c.user)
+	}
+
+	c.sendMethod(0, methodPayload(connectionTune).
+		short(channelMax).
+		long(frameMax).
+		short(uint16(heartbeat/time.Second)))
+
+	d, err = c.expect(connectionTuneOk)
+	if err != nil {
+		return err
+	}
+	d.short() // channel-max
+	if fm := d.long(); fm > 0 && fm < c.frameMax {
+		c.frameMax = fm
+	}
+	c.heartbeat = time.Duration(d.short()) * time.Second
+	if d.err != nil {
+		return d.err
+	}
+
+	d, err = c.expect(connectionOpen)
+	if err != nil {
+		return err
+	}
+	vhost := d.shortstr()
+	if d.err != nil {
+		return d.err
+	}
+	if vhost != "/" {
+		c.closeConnection(hardError(replyNotFound, fmt.Sprintf("NOT_ALLOWED - vhost '%s' not found", vhost)))
+		return fmt.Errorf("unknown vhost %q", vhost)
+	}
+	c.sendMethod(0, methodPayload(connectionOpenOk).shortstr(""))
+	return nil
+}
+
+// expect reads the next method during the handshake.
+func (c *conn) expect(want methodID) (*decoder, error) {
+	for {
+		f, err := readFrame(c.r, c.frameMax)
+		if err != nil {
+			return nil, err
+		}
+		if f.typ == frameHeartbeat {
+			continue
+		}
+		if f.typ != frameMethod || f.channel != 0 {
+			return nil, errors.New("unexpected frame during handshake")
+		}
+		d := &decoder{b: f.payload}
+		got := methodID{d.short(), d.short()}
+		if got != want {
+			return nil, fmt.Errorf("expected method %v, got %v", want, got)
+		}
+		return d, nil
+	}
+}
+
+func (c *conn) readLoop() error {
+	for {
+		if c.heartbeat > 0 {
+			// be lenient: two missed heartbeats and then some
+			_ = c.nc.SetReadDeadline(time.Now().Add(3 * c.heartbeat))
+		}
+		f, err := readFrame(c.r, c.frameMax)
+		if err != nil {
+			return err
+		}
+		if f.typ == frameHeartbeat {
+			continue
+		}
+		if err := c.handleFrame(f); err != nil {
+			return err
+		}
+	}
+}
+
+func (c *conn) handleFrame(f frame) error {
+	if f.channel == 0 {
+		if f.typ != frameMethod {
+			return c.closeConnection(hardError(replyUnexpected, "UNEXPECTED_FRAME - content on channel 0"))
+		}
+		d := &decoder{b: f.payload}
+		switch id := (methodID{d.short(), d.short()}); id {
+		case connectionClose:
+			c.sendMethod(0, methodPayload(connectionCloseOk))
+			return errClientClosed
+		case connectionCloseOk:
+			return errClientClosed
+		default:
+			return c.closeConnection(hardError(replyCommandInvalid, fmt.Sprintf("COMMAND_INVALID - unexpected method %v on channel 0", id)))
+		}
+	}
+
+	b := c.b
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	defer b.pump()
+
+	ch := c.channels[f.channel]
+	var err error
+	switch f.typ {
+	case frameMethod:
+		d := &decoder{b: f.payload}
+		id := methodID{d.short(), d.short()}
+		if d.err != nil {
+			return c.closeConnection(hardError(replyFrameError, "FRAME_ERROR - short method frame"))
+		}
+
+		if id == channelOpen {
+			if ch != nil {
+				return c.closeConnection(hardError(replyChannelError, "CHANNEL_ERROR - second 'channel.open' seen"))
+			}
+			c.channels[f.channel] = &channel{
+				id:        f.channel,
+				c:         c,
+				active:    true,
+				consumers: map[string]*consumer{},
+				unacked:   map[uint64]*delivery{},
+			}
+			c.sendMethod(f.channel, methodPayload(channelOpenOk).longstr(nil))
+			return nil
+		}
+		if ch == nil {
+			return c.closeConnection(hardError(replyChannelError, "CHANNEL_ERROR - expected 'channel.open'"))
+		}
+		if ch.closing {
+			switch id {
+			case channelCloseOk:
+				delete(c.channels, ch.id)
+			case channelClose:
+				c.sendMethod(ch.id, methodPayload(channelCloseOk))
+				delete(c.channels, ch.id)
+			}
+			return nil
+		}
+		if ch.pending != nil {
+			return c.closeConnection(hardError(replyUnexpected, "UNEXPECTED_FRAME - expected content header"))
+		}
+		err = ch.handleMethod(id, d)
+		if err != nil {
+			var ae *amqpError
+			if errors.As(err, &ae) && ae.method == (methodID{}) {
+				ae.method = id
+			}
+		}
+
+	case frameHeader:
+		if ch == nil || ch.closing {
+			return nil
+		}
+		if ch.pending == nil || ch.pending.headerSeen {
+			return c.closeConnection(hardError(replyUnexpected, "UNEXPECTED_FRAME - content header without basic.publish"))
+		}
+		class, size, props, derr := decodeHeader(f.payload)
+		if derr != nil || class != classBasic {
+			return c.closeConnection(hardError(replyFrameError, "FRAME_ERROR - malformed content header"))
+		}
+		ch.pending.headerSeen = true
+		ch.pending.size = size
+		ch.pending.props = props
+		if size == 0 {
+			err = ch.completePublish()
+		}
+
+	case frameBody:
+		if ch == nil || ch.closing {
+			return nil
+		}
+		if ch.pending == nil || !ch.pending.headerSeen {
+			return c.closeConnection(hardError(replyUnexpected, "UNEXPECTED_FRAME - content body without header"))
+		}
+		ch.pending.body = append(ch.pending.body, f.payload...)
+		switch size := uint64(len(ch.pending.body)); {
+		case size > ch.pending.size:
+			return c.closeConnection(hardError(replyFrameError, "FRAME_ERROR - content body larger than announced"))
+		case size == ch.pending.size:
+			err = ch.completePublish()
+		}
+
+	default:
+		return c.closeConnection(hardError(replyFrameError, fmt.Sprintf("FRAME_ERROR - unknown frame type %d", f.typ)))
+	}
+
+	if err == nil {
+		return nil
+	}
+	var ae *amqpError
+	if !errors.As(err, &ae) {
+		ae = hardError(replyInternalError, "INTERNAL_ERROR - "+err.Error())
+		ae.hard = true
+	}
+	if ae.hard {
+		return c.closeConnection(ae)
+	}
+	ch.fail(ae)
+	return nil
+}
+
+// closeConnection sends connection.close for a hard error. The returned
+// error ends the read loop.
+func (c *conn) closeConnection(e *amqpError) error {
+	c.sendMethod(0, methodPayload(connectionClose).
+		short(e.code).
+		shortstr(e.text).
+		short(e.method.class).
+		short(e.method.method))
+	return e
+}
+
+// fail closes the channel for a soft error.
+func (ch *channel) fail(e *amqpError) {
+	ch.cleanup()
+	ch.closing = true
+	ch.c.sendMethod(ch.id, methodPayload(channelClose).
+		short(e.code).
+		shortstr(e.text).
+		short(e.method.class).
+		short(e.method.method))
+}
+
+// cleanup cancels the channel's consumers and requeues what it never acked.
+func (ch *channel) cleanup() {
+	b := ch.c.b
+
+	tags := make([]uint64, 0, len(ch.unacked))
+	for tag := range ch.unacked {
+		tags = append(tags, tag)
+	}
+	// requeue newest first so the oldest ends up at the head again
+	sort.Slice(tags, func(i, j int) bool { return tags[i] > tags[j] })
+	for _, tag := range tags {
+		d := ch.unacked[tag]
+		delete(ch.unacked, tag)
+		d.q.unacked--
+		if b.queues[d.q.name] == d.q {
+			d.q.requeue(d.msg)
+			b.dirty[d.q] = true
+		}
+	}
+
+	for tag, cons := range ch.consumers {
+		delete(ch.consumers, tag)
+		cons.q.removeConsumer(b, cons)
+	}
+	ch.pending = nil
+}
+
+// cleanup runs when the connection is gone, with the broker locked.
+func (c *conn) cleanup() {
+	b := c.b
+	for id, ch := range c.channels {
+		ch.cleanup()
+		delete(c.channels, id)
+	}
+	for _, q := range b.queues {
+		if q.exclusive && q.owner == c {
+			b.deleteQueue(q)
+		}
+	}
+	delete(b.conns, c)
+	b.pump()
+}
+
+func (c *conn) send(buf []byte) {
+	c.omu.Lock()
+	defer c.omu.Unlock()
+	if c.closing {
+		return
+	}
+	c.out = append(c.out, buf...)
+	c.ocond.Signal()
+}
+
+func (c *conn) sendMethod(channel uint16, e *encoder) {
+	c.send(appendFrame(nil, frame{typ: frameMethod, channel: channel, payload: e.b}))
+}
+
+// sendContent queues a method with its content header and body frames in
+// one go, so nothing can end up between them.
+func (c *conn) sendContent(channel uint16, method *encoder, props properties, body []byte) {
+	buf := appendFrame(nil, frame{typ: frameMethod, channel: channel, payload: method.b})
+	buf = appendFrame(buf, frame{typ: frameHeader, channel: channel, payload: encodeHeader(uint64(len(body)), props)})
+
+	chunk := int(c.frameMax) - 8
+	for len(body) > 0 {
+		n := min(chunk, len(body))
+		buf = appendFrame(buf, frame{typ: frameBody, channel: channel, payload: body[:n]})
+		body = body[n:]
+	}
+	c.send(buf)
+}
+
+func (c *conn) writeLoop() {
+	defer close(c.written)
+	for {
+		c.omu.Lock()
+		for len(c.out) == 0 && !c.closing {
+			c.ocond.Wait()
+		}
+		if len(c.out) == 0 {
+			c.omu.Unlock()
+			return
+		}
+		buf := c.out
+		c.out = nil
+		c.lastWrite = time.Now()
+		c.omu.Unlock()
+
+		_ = c.nc.SetWriteDeadline(time.Now().Add(writeTimeout))
+		if _, err := c.nc.Write(buf); err != nil {
+			c.omu.Lock()
+			c.closing = true
+			c.out = nil
+			c.omu.Unlock()
+			c.nc.Close()
+			return
+		}
+	}
+}
+
+func (c *conn) heartbeatLoop() {
+	ticker := time.NewTicker(c.heartbeat / 2)
+	defer ticker.Stop()
+	hb := appendFrame(nil, frame{typ: frameHeartbeat})
+	for {
+		select {
+		case <-c.written:
+			return
+		case <-ticker.C:
+			c.omu.Lock()
+			idle := time.Since(c.lastWrite) >= c.heartbeat/2
+			c.omu.Unlock()
+			if idle {
+				c.send(hb)
+			}
+		}
+	}
+}
+
+// shutdown flushes what is queued (a connection.close, say) and closes the
+// socket.
+func (c *conn) shutdown() {
+	c.omu.Lock()
+	c.closing = true
+	c.ocond.Signal()
+	c.omu.Unlock()
+
+	select {
+	case <-c.written:
+	case <-time.After(time.Second):
+	}
+	c.nc.Close()
+}
@@ -0,0 +1,238 @@
+package devbroker
+
+import (
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// content headers only exist for the basic class
+const classBasic = 60
+
+// methodID is a class id and method id, e.g. {60, 40} for basic.publish.
+type methodID struct {
+	class, method uint16
+}
+
+var (
+	connectionStart   = methodID{10, 10}
+	connectionStartOk = methodID{10, 11}
+	connectionTune    = methodID{10, 30}
+	connectionTuneOk  = methodID{10, 31}
+	connectionOpen    = methodID{10, 40}
+	connectionOpenOk  = methodID{10, 41}
+	connectionClose   = methodID{10, 50}
+	connectionCloseOk = methodID{10, 51}
+	connectionBlocked = methodID{10, 60}
+	connectionUnblock = methodID{10, 61}
+	channelOpen       = methodID{20, 10}
+	channelOpenOk     = methodID{20, 11}
+	channelFlow       = methodID{20, 20}
+	channelFlowOk     = methodID{20, 21}
+	channelClose      = methodID{20, 40}
+	channelCloseOk    = methodID{20, 41}
+	exchangeDeclare   = methodID{40, 10}
+	exchangeDeclareOk = methodID{40, 11}
+	exchangeDelete    = methodID{40, 20}
+	exchangeDeleteOk  = methodID{40, 21}
+	queueDeclare      = methodID{50, 10}
+	queueDeclareOk    = methodID{50, 11}
+	queueBind         = methodID{50, 20}
+	queueBindOk       = methodID{50, 21}
+	queuePurge        = methodID{50, 30}
+	queuePurgeOk      = methodID{50, 31}
+	queueDelete       = methodID{50, 40}
+	queueDeleteOk     = methodID{50, 41}
+	queueUnbind       = methodID{50, 50}
+	queueUnbindOk     = methodID{50, 51}
+	basicQos          = methodID{60, 10}
+	basicQosOk        = methodID{60, 11}
+	basicConsume      = methodID{60, 20}
+	basicConsumeOk    = methodID{60, 21}
+	basicCancel       = methodID{60, 30}
+	basicCancelOk     = methodID{60, 31}
+	basicPublish      = methodID{60, 40}
+	basicReturn       = methodID{60, 50}
+	basicDeliver      = methodID{60, 60}
+	basicGet          = methodID{60, 70}
+	basicGetOk        = methodID{60, 71}
+	basicGetEmpty     = methodID{60, 72}
+	basicAck          = methodID{60, 80}
+	basicReject       = methodID{60, 90}
+	basicRecover      = methodID{60, 110}
+	basicRecoverOk    = methodID{60, 111}
+	basicNack         = methodID{60, 120}
+	confirmSelect     = methodID{85, 10}
+	confirmSelectOk   = methodID{85, 11}
+)
+
+// reply codes
+const (
+	replyNoRoute        = 312
+	replyAccessRefused  = 403
+	replyNotFound       = 404
+	replyLocked         = 405
+	replyPrecondition   = 406
+	replyFrameError     = 501
+	replySyntaxError    = 502
+	replyCommandInvalid = 503
+	replyChannelError   = 504
+	replyUnexpected     = 505
+	replyNotAllowed     = 530
+	replyNotImplemented = 540
+	replyInternalError  = 541
+)
+
+// amqpError is a protocol exception. Soft errors close the channel, hard
+// ones the whole connection.
+type amqpError struct {
+	code   uint16
+	text   string
+	method methodID
+	hard   bool
+}
+
+func (e *amqpError) Error() string {
+	return e.text
+}
+
+func softError(code uint16, text string) *amqpError {
+	return &amqpError{code: code, text: text}
+}
+
+func hardError(code uint16, text string) *amqpError {
+	return &amqpError{code: code, text: text, hard: true}
+}
+
+func methodPayload(id methodID) *encoder {
+	return newEncoder().short(id.class).short(id.method)
+}
+
+// properties are the basic class content properties.
+type properties struct {
+	ContentType     string
+	ContentEncoding string
+	Headers         amqp.Table
+	DeliveryMode    uint8
+	Priority        uint8
+	CorrelationID   string
+	ReplyTo         string
+	Expiration      string
+	MessageID       string
+	Timestamp       time.Time
+	Type            string
+	UserID          string
+	AppID           string
+}
+
+const (
+	flagContentType     = 0x8000
+	flagContentEncoding = 0x4000
+	flagHeaders         = 0x2000
+	flagDeliveryMode    = 0x1000
+	flagPriority        = 0x0800
+	flagCorrelationID   = 0x0400
+	flagReplyTo         = 0x0200
+	flagExpiration      = 0x0100
+	flagMessageID       = 0x0080
+	flagTimestamp       = 0x0040
+	flagType            = 0x0020
+	flagUserID          = 0x0010
+	flagAppID           = 0x0008
+	flagReserved        = 0x0004
+)
+
+// decodeHeader reads a content header frame.
+func decodeHeader(payload []byte) (uint16, uint64, properties, error) {
+	d := &decoder{b: payload}
+	class := d.short()
+	d.short() // weight
+	size := d.longlong()
+	flags := d.short()
+
+	var p properties
+	if flags&flagContentType != 0 {
+		p.ContentType = d.shortstr()
+	}
+	if flags&flagContentEncoding != 0 {
+		p.ContentEncoding = d.shortstr()
+	}
+	if flags&flagHeaders != 0 {
+		p.Headers = d.table()
+	}
+	if flags&flagDeliveryMode != 0 {
+		p.DeliveryMode = d.octet()
+	}
+	if flags&flagPriority != 0 {
+		p.Priority = d.octet()
+	}
+	if flags&flagCorrelationID != 0 {
+		p.CorrelationID = d.shortstr()
+	}
+	if flags&flagReplyTo != 0 {
+		p.ReplyTo = d.shortstr()
+	}
+	if flags&flagExpiration != 0 {
+		p.Expiration = d.shortstr()
+	}
+	if flags&flagMessageID != 0 {
+		p.MessageID = d.shortstr()
+	}
+	if flags&flagTimestamp != 0 {
+		p.Timestamp = time.Unix(int64(d.longlong()), 0)
+	}
+	if flags&flagType != 0 {
+		p.Type = d.shortstr()
+	}
+	if flags&flagUserID != 0 {
+		p.UserID = d.shortstr()
+	}
+	if flags&flagAppID != 0 {
+		p.AppID = d.shortstr()
+	}
+	if flags&flagReserved != 0 {
+		d.shortstr()
+	}
+	return class, size, p, d.err
+}
+
+func encodeHeader(size uint64, p properties) []byte {
+	var flags uint16
+	props := newEncoder()
+	str := func(flag uint16, s string) {
+		if s != "" {
+			flags |= flag
+			props.shortstr(s)
+		}
+	}
+
+	str(flagContentType, p.ContentType)
+	str(flagContentEncoding, p.ContentEncoding)
+	if len(p.Headers) > 0 {
+		flags |= flagHeaders
+		props.table(p.Headers)
+	}
+	if p.DeliveryMode != 0 {
+		flags |= flagDeliveryMode
+		props.octet(p.DeliveryMode)
+	}
+	if p.Priority != 0 {
+		flags |= flagPriority
+		props.octet(p.Priority)
+	}
+	str(flagCorrelationID, p.CorrelationID)
+	str(flagReplyTo, p.ReplyTo)
+	str(flagExpiration, p.Expiration)
+	str(flagMessageID, p.MessageID)
+	if !p.Timestamp.IsZero() {
+		flags |= flagTimestamp
+		props.longlong(uint64(p.Timestamp.Unix()))
+	}
+	str(flagType, p.Type)
+	str(flagUserID, p.UserID)
+	str(flagAppID, p.AppID)
+
+	e := newEncoder().short(classBasic).short(0).longlong(size).short(flags)
+	e.b = append(e.b, props.b...)
+	return e.b
+}
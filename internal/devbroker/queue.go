@@ -0,0 +1,242 @@
+package devbroker
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+type message struct {
+	exchange    string
+	routingKey  string
+	props       properties
+	body        []byte
+	expiresAt   time.Time // zero when the message never expires
+	redelivered bool
+}
+
+func (m *message) copy() *message {
+	c := *m
+	return &c
+}
+
+// setExpiry applies the queue's x-message-ttl or the message's own
+// expiration, whichever is shorter.
+func (m *message) setExpiry(q *queue, now time.Time) {
+	ttl := q.ttl
+	if ms, err := strconv.ParseInt(m.props.Expiration, 10, 64); err == nil && ms >= 0 {
+		if d := time.Duration(ms) * time.Millisecond; ttl < 0 || d < ttl {
+			ttl = d
+		}
+	}
+	m.expiresAt = time.Time{}
+	if ttl >= 0 {
+		m.expiresAt = now.Add(ttl)
+	}
+}
+
+func (m *message) expired(now time.Time) bool {
+	return !m.expiresAt.IsZero() && now.After(m.expiresAt)
+}
+
+type queue struct {
+	name       string
+	durable    bool
+	exclusive  bool
+	autoDelete bool
+	owner      *conn // connection an exclusive queue belongs to
+	args       amqp.Table
+
+	ttl          time.Duration // x-message-ttl, -1 when unset
+	expires      time.Duration // x-expires, 0 when unset
+	maxLength    int           // x-max-length, -1 when unset
+	dlx          *string       // x-dead-letter-exchange
+	dlrk         *string       // x-dead-letter-routing-key
+	singleActive bool          // x-single-active-consumer
+
+	messages  []*message
+	consumers []*consumer
+	next      int // round-robin position in consumers
+	unacked   int
+	lastUsed  time.Time
+}
+
+type consumer struct {
+	tag       string
+	ch        *channel
+	q         *queue
+	noAck     bool
+	exclusive bool
+	prefetch  int // 0 means unlimited
+	unacked   int
+}
+
+func newQueue(name string, durable, exclusive, autoDelete bool, args amqp.Table) (*queue, error) {
+	q := &queue{
+		name:       name,
+		durable:    durable,
+		exclusive:  exclusive,
+		autoDelete: autoDelete,
+		args:       args,
+		ttl:        -1,
+		maxLength:  -1,
+		lastUsed:   time.Now(),
+	}
+
+	invalid := func(arg string) error {
+		return softError(replyPrecondition, fmt.Sprintf("PRECONDITION_FAILED - invalid arg '%s' for queue '%s': %v", arg, name, args[arg]))
+	}
+	for arg, v := range args {
+		switch arg {
+		case "x-message-ttl":
+			ms, ok := toInt64(v)
+			if !ok || ms < 0 {
+				return nil, invalid(arg)
+			}
+			q.ttl = time.Duration(ms) * time.Millisecond
+		case "x-expires":
+			ms, ok := toInt64(v)
+			if !ok || ms <= 0 {
+				return nil, invalid(arg)
+			}
+			q.expires = time.Duration(ms) * time.Millisecond
+		case "x-max-length":
+			n, ok := toInt64(v)
+			if !ok || n < 0 {
+				return nil, invalid(arg)
+			}
+			q.maxLength = int(n)
+		case "x-dead-letter-exchange":
+			s, ok := v.(string)
+			if !ok {
+				return nil, invalid(arg)
+			}
+			q.dlx = &s
+		case "x-dead-letter-routing-key":
+			s, ok := v.(string)
+			if !ok {
+				return nil, invalid(arg)
+			}
+			q.dlrk = &s
+		case "x-single-active-consumer":
+			b, ok := v.(bool)
+			if !ok {
+				return nil, invalid(arg)
+			}
+			q.singleActive = b
+		}
+	}
+	return q, nil
+}
+
+func (q *queue) checkOwner(owner *conn) error {
+	if q.exclusive && owner != nil && q.owner != nil && q.owner != owner {
+		return softError(replyLocked, fmt.Sprintf("RESOURCE_LOCKED - cannot obtain exclusive access to locked queue '%s' in vhost '/'", q.name))
+	}
+	return nil
+}
+
+func (q *queue) headExpired(now time.Time) bool {
+	return q.messages[0].expired(now)
+}
+
+// dispatch hands ready messages to consumers with capacity, dead-lettering
+// expired ones and anything over x-max-length on the way.
+func (q *queue) dispatch(b *Broker) {
+	for q.maxLength >= 0 && len(q.messages) > q.maxLength {
+		dropped := q.messages[0]
+		q.messages = q.messages[1:]
+		b.deadLetter(q, dropped, "maxlen")
+	}
+
+	now := time.Now()
+	for len(q.messages) > 0 {
+		msg := q.messages[0]
+		if msg.expired(now) {
+			q.messages = q.messages[1:]
+			b.deadLetter(q, msg, "expired")
+			continue
+		}
+		cons := q.nextConsumer()
+		if cons == nil {
+			return
+		}
+		q.messages = q.messages[1:]
+		cons.deliver(msg)
+	}
+}
+
+func (q *queue) nextConsumer() *consumer {
+	if len(q.consumers) == 0 {
+		return nil
+	}
+	if q.singleActive {
+		// only the oldest consumer is active; the others take over in order
+		if cons := q.consumers[0]; cons.ready() {
+			return cons
+		}
+		return nil
+	}
+	for i := range q.consumers {
+		idx := (q.next + i) % len(q.consumers)
+		if cons := q.consumers[idx]; cons.ready() {
+			q.next = idx + 1
+			return cons
+		}
+	}
+	return nil
+}
+
+// requeue puts a message back at the head of the queue.
+func (q *queue) requeue(msg *message) {
+	msg.redelivered = true
+	q.messages = append([]*message{msg}, q.messages...)
+}
+
+func (q *queue) removeConsumer(b *Broker, cons *consumer) {
+	for i, c := range q.consumers {
+		if c == cons {
+			q.consumers = append(q.consumers[:i], q.consumers[i+1:]...)
+			break
+		}
+	}
+	q.lastUsed = time.Now()
+	b.dirty[q] = true
+	if q.autoDelete && len(q.consumers) == 0 {
+		b.deleteQueue(q)
+	}
+}
+
+func (cons *consumer) ready() bool {
+	ch := cons.ch
+	if !ch.active || ch.closing {
+		return false
+	}
+	if cons.noAck {
+		return true
+	}
+	if cons.prefetch > 0 && cons.unacked >= cons.prefetch {
+		return false
+	}
+	return ch.globalPrefetch == 0 || len(ch.unacked) < ch.globalPrefetch
+}
+
+func (cons *consumer) deliver(msg *message) {
+	ch := cons.ch
+	ch.nextTag++
+	tag := ch.nextTag
+	if !cons.noAck {
+		ch.unacked[tag] = &delivery{tag: tag, msg: msg, q: cons.q, cons: cons}
+		cons.unacked++
+		cons.q.unacked++
+	}
+
+	ch.c.sendContent(ch.id, methodPayload(basicDeliver).
+		shortstr(cons.tag).
+		longlong(tag).
+		bit(msg.redelivered).
+		shortstr(msg.exchange).
+		shortstr(msg.routingKey), msg.props, msg.body)
+}
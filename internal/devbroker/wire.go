@@ -0,0 +1,338 @@
+package devbroker
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// frame types
+const (
+	frameMethod    = 1
+	frameHeader    = 2
+	frameBody      = 3
+	frameHeartbeat = 8
+	frameEnd       = 0xce
+)
+
+var protocolHeader = []byte("AMQP\x00\x00\x09\x01")
+
+var errMalformed = errors.New("malformed frame")
+
+type frame struct {
+	typ     byte
+	channel uint16
+	payload []byte
+}
+
+func readFrame(r *bufio.Reader, maxSize uint32) (frame, error) {
+	var head [7]byte
+	if _, err := io.ReadFull(r, head[:]); err != nil {
+		return frame{}, err
+	}
+	f := frame{typ: head[0], channel: binary.BigEndian.Uint16(head[1:3])}
+	size := binary.BigEndian.Uint32(head[3:7])
+	if maxSize > 0 && size > maxSize {
+		return frame{}, fmt.Errorf("frame of %d bytes is over frame-max", size)
+	}
+	f.payload = make([]byte, size)
+	if _, err := io.ReadFull(r, f.payload); err != nil {
+		return frame{}, err
+	}
+	end, err := r.ReadByte()
+	if err != nil {
+		return frame{}, err
+	}
+	if end != frameEnd {
+		return frame{}, errMalformed
+	}
+	return f, nil
+}
+
+func appendFrame(b []byte, f frame) []byte {
+	b = append(b, f.typ)
+	b = binary.BigEndian.AppendUint16(b, f.channel)
+	b = binary.BigEndian.AppendUint32(b, uint32(len(f.payload)))
+	b = append(b, f.payload...)
+	return append(b, frameEnd)
+}
+
+// decoder reads AMQP data types from a frame payload. The first error
+// sticks, so callers check err once at the end.
+type decoder struct {
+	b   []byte
+	err error
+
+	// consecutive bit fields share an octet
+	bits    byte
+	bitsPos int
+}
+
+func (d *decoder) take(n int) []byte {
+	d.bitsPos = 0
+	if d.err != nil {
+		return nil
+	}
+	if len(d.b) < n {
+		d.err = errMalformed
+		return nil
+	}
+	v := d.b[:n]
+	d.b = d.b[n:]
+	return v
+}
+
+func (d *decoder) octet() byte {
+	if v := d.take(1); v != nil {
+		return v[0]
+	}
+	return 0
+}
+
+func (d *decoder) short() uint16 {
+	if v := d.take(2); v != nil {
+		return binary.BigEndian.Uint16(v)
+	}
+	return 0
+}
+
+func (d *decoder) long() uint32 {
+	if v := d.take(4); v != nil {
+		return binary.BigEndian.Uint32(v)
+	}
+	return 0
+}
+
+func (d *decoder) longlong() uint64 {
+	if v := d.take(8); v != nil {
+		return binary.BigEndian.Uint64(v)
+	}
+	return 0
+}
+
+func (d *decoder) shortstr() string {
+	n := d.octet()
+	return string(d.take(int(n)))
+}
+
+func (d *decoder) longstr() []byte {
+	n := d.long()
+	return d.take(int(n))
+}
+
+func (d *decoder) bit() bool {
+	if d.bitsPos == 0 || d.bitsPos == 8 {
+		d.bits = d.octet()
+		d.bitsPos = 0
+	}
+	v := d.bits&(1<<d.bitsPos) != 0
+	d.bitsPos++
+	return v
+}
+
+func (d *decoder) table() amqp.Table {
+	raw := d.longstr()
+	if d.err != nil {
+		return nil
+	}
+	t := amqp.Table{}
+	nested := &decoder{b: raw}
+	for len(nested.b) > 0 && nested.err == nil {
+		key := nested.shortstr()
+		t[key] = nested.field()
+	}
+	if nested.err != nil {
+		d.err = nested.err
+	}
+	return t
+}
+
+// field decodes one field value the way amqp091-go does.
+func (d *decoder) field() any {
+	switch typ := d.octet(); typ {
+	case 't':
+		return d.octet() != 0
+	case 'B':
+		return d.octet()
+	case 'b':
+		return int8(d.octet())
+	case 's':
+		return int16(d.short())
+	case 'u':
+		return d.short()
+	case 'I':
+		return int32(d.long())
+	case 'i':
+		return d.long()
+	case 'l':
+		return int64(d.longlong())
+	case 'f':
+		return math.Float32frombits(d.long())
+	case 'd':
+		return math.Float64frombits(d.longlong())
+	case 'D':
+		return amqp.Decimal{Scale: d.octet(), Value: int32(d.long())}
+	case 'S':
+		return string(d.longstr())
+	case 'x':
+		return append([]byte(nil), d.longstr()...)
+	case 'T':
+		return time.Unix(int64(d.longlong()), 0)
+	case 'F':
+		// a nested table is encoded exactly like a top level one
+		return d.table()
+	case 'A':
+		raw := d.longstr()
+		nested := &decoder{b: raw}
+		var values []any
+		for len(nested.b) > 0 && nested.err == nil {
+			values = append(values, nested.field())
+		}
+		if nested.err != nil {
+			d.err = nested.err
+		}
+		return values
+	case 'V':
+		return nil
+	default:
+		if d.err == nil {
+			d.err = fmt.Errorf("unknown field type %q", typ)
+		}
+		return nil
+	}
+}
+
+// encoder builds a frame payload.
+type encoder struct {
+	b []byte
+
+	bitsAt  int // index of the octet collecting bits, -1 when none
+	bitsPos int
+}
+
+func newEncoder() *encoder {
+	return &encoder{bitsAt: -1}
+}
+
+func (e *encoder) octet(v byte) *encoder {
+	e.bitsAt = -1
+	e.b = append(e.b, v)
+	return e
+}
+
+func (e *encoder) short(v uint16) *encoder {
+	e.bitsAt = -1
+	e.b = binary.BigEndian.AppendUint16(e.b, v)
+	return e
+}
+
+func (e *encoder) long(v uint32) *encoder {
+	e.bitsAt = -1
+	e.b = binary.BigEndian.AppendUint32(e.b, v)
+	return e
+}
+
+func (e *encoder) longlong(v uint64) *encoder {
+	e.bitsAt = -1
+	e.b = binary.BigEndian.AppendUint64(e.b, v)
+	return e
+}
+
+func (e *encoder) shortstr(s string) *encoder {
+	if len(s) > 255 {
+		s = s[:255]
+	}
+	e.octet(byte(len(s)))
+	e.b = append(e.b, s...)
+	return e
+}
+
+func (e *encoder) longstr(s []byte) *encoder {
+	e.long(uint32(len(s)))
+	e.b = append(e.b, s...)
+	return e
+}
+
+func (e *encoder) bit(v bool) *encoder {
+	if e.bitsAt < 0 || e.bitsPos == 8 {
+		e.b = append(e.b, 0)
+		e.bitsAt = len(e.b) - 1
+		e.bitsPos = 0
+	}
+	if v {
+		e.b[e.bitsAt] |= 1 << e.bitsPos
+	}
+	e.bitsPos++
+	return e
+}
+
+func (e *encoder) table(t amqp.Table) *encoder {
+	nested := newEncoder()
+	keys := make([]string, 0, len(t))
+	for k := range t {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		nested.shortstr(k)
+		nested.field(t[k])
+	}
+	return e.longstr(nested.b)
+}
+
+func (e *encoder) field(v any) {
+	switch v := v.(type) {
+	case bool:
+		e.octet('t')
+		if v {
+			e.octet(1)
+		} else {
+			e.octet(0)
+		}
+	case byte:
+		e.octet('B').octet(v)
+	case int8:
+		e.octet('b').octet(byte(v))
+	case int16:
+		e.octet('s').short(uint16(v))
+	case uint16:
+		e.octet('u').short(v)
+	case int:
+		e.octet('l').longlong(uint64(v))
+	case int32:
+		e.octet('I').long(uint32(v))
+	case uint32:
+		e.octet('i').long(v)
+	case int64:
+		e.octet('l').longlong(uint64(v))
+	case float32:
+		e.octet('f').long(math.Float32bits(v))
+	case float64:
+		e.octet('d').longlong(math.Float64bits(v))
+	case amqp.Decimal:
+		e.octet('D').octet(v.Scale).long(uint32(v.Value))
+	case string:
+		e.octet('S').longstr([]byte(v))
+	case []byte:
+		e.octet('x').longstr(v)
+	case time.Time:
+		e.octet('T').longlong(uint64(v.Unix()))
+	case amqp.Table:
+		e.octet('F').table(v)
+	case []any:
+		nested := newEncoder()
+		for _, item := range v {
+			nested.field(item)
+		}
+		e.octet('A').longstr(nested.b)
+	default:
+		e.octet('V')
+	}
+}
@@ -0,0 +1,104 @@
+package devbroker
+
+import (
+	"bufio"
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+func TestFrameRoundTrip(t *testing.T) {
+	frames := []frame{
+		{typ: frameMethod, channel: 1, payload: []byte{0, 60, 0, 40}},
+		{typ: frameBody, channel: 65535, payload: bytes.Repeat([]byte{frameEnd}, 10)},
+		{typ: frameHeartbeat, channel: 0, payload: []byte{}},
+	}
+	var wire []byte
+	for _, f := range frames {
+		wire = appendFrame(wire, f)
+	}
+
+	r := bufio.NewReader(bytes.NewReader(wire))
+	for _, want := range frames {
+		got, err := readFrame(r, 0)
+		if err != nil {
+			t.Fatalf("readFrame: %v", err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got %+v, want %+v", got, want)
+		}
+	}
+}
+
+func TestReadFrameErrors(t *testing.T) {
+	good := appendFrame(nil, frame{typ: frameBody, channel: 1, payload: []byte("hello")})
+
+	badEnd := append([]byte(nil), good...)
+	badEnd[len(badEnd)-1] = 0
+	if _, err := readFrame(bufio.NewReader(bytes.NewReader(badEnd)), 0); err != errMalformed {
+		t.Errorf("bad frame-end: err = %v, want %v", err, errMalformed)
+	}
+
+	if _, err := readFrame(bufio.NewReader(bytes.NewReader(good)), 4); err == nil {
+		t.Error("frame over frame-max was read")
+	}
+	if _, err := readFrame(bufio.NewReader(bytes.NewReader(good[:len(good)-3])), 0); err == nil {
+		t.Error("truncated frame was read")
+	}
+}
+
+func TestTableRoundTrip(t *testing.T) {
+	want := amqp.Table{
+		"bool":    true,
+		"byte":    byte(7),
+		"int8":    int8(-7),
+		"int16":   int16(-300),
+		"uint16":  uint16(60000),
+		"int32":   int32(-70000),
+		"uint32":  uint32(4000000000),
+		"int64":   int64(-1 << 40),
+		"float32": float32(1.5),
+		"float64": 2.25,
+		"decimal": amqp.Decimal{Scale: 2, Value: 1234},
+		"string":  "peril",
+		"bytes":   []byte{0, 1, 2},
+		"time":    time.Unix(1700000000, 0),
+		"table":   amqp.Table{"nested": "yes"},
+		"array":   []any{"a", int64(1), amqp.Table{"b": false}},
+		"void":    nil,
+	}
+
+	e := newEncoder().table(want).shortstr("after")
+	d := &decoder{b: e.b}
+	got := d.table()
+	after := d.shortstr()
+	if d.err != nil {
+		t.Fatalf("decode: %v", d.err)
+	}
+	if after != "after" {
+		t.Errorf("read %q after the table, want %q", after, "after")
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got  %#v\nwant %#v", got, want)
+	}
+}
+
+func TestDecoderErrors(t *testing.T) {
+	// a table claiming more bytes than there are
+	d := &decoder{b: []byte{0, 0, 0, 9, 1, 'k'}}
+	d.table()
+	if d.err == nil {
+		t.Error("short table decoded")
+	}
+
+	// an unknown field type
+	raw := newEncoder().shortstr("k").octet('?').b
+	d = &decoder{b: newEncoder().longstr(raw).b}
+	d.table()
+	if d.err == nil {
+		t.Error("unknown field type decoded")
+	}
+}
@@ -0,0 +1,130 @@
+package gamelogic
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Battle is all the fighting in one contested location. Everybody with
+// units there takes part. Wars are still fought one pair of armies at a
+// time, each pair at most once, so every war can be checked with
+// ResolveWar.
+type Battle struct {
+	Location  Location
+	Terrain   Terrain
+	Players   []string // everyone with units there when it started
+	Wars      []War    // in the order they were fought
+	Survivors []string // everyone with units left there afterwards
+}
+
+// Holder is the only player left in the location, if there is one.
+func (b Battle) Holder() string {
+	if len(b.Survivors) != 1 {
+		return ""
+	}
+	return b.Survivors[0]
+}
+
+// presentLocked lists the players with units in loc, by username.
+func (w *World) presentLocked(loc Location) []string {
+	present := []string{}
+	for _, name := range w.usernamesLocked() {
+		for _, u := range w.players[name].Player.Units {
+			if u.Location == loc {
+				present = append(present, name)
+				break
+			}
+		}
+	}
+	return present
+}
+
+// contestedLocked reports whether two players without a pact both have
+// units in loc.
+func (w *World) contestedLocked(loc Location) bool {
+	present := w.presentLocked(loc)
+	for i, a := range present {
+		for _, b := range present[i+1:] {
+			if !w.atPeaceLocked(a, b) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// battleLocked fights it out in loc. The attackers, the players who just
+// moved in, go first in the order given; then everyone else there takes
+// their turn, in username order. Each attacks every other player still
+// there that they haven't fought yet in this battle, so a draw isn't
+// fought again until the next battle.
+func (w *World) battleLocked(out *Outcome, loc Location, attackers []string) (Battle, bool) {
+	if !w.contestedLocked(loc) {
+		return Battle{}, false
+	}
+	b := Battle{Location: loc, Players: w.presentLocked(loc)}
+	if t, ok := w.worldMap.Territory(loc); ok {
+		b.Terrain = t.Terrain
+	}
+
+	order := []string{}
+	seen := map[string]bool{}
+	for _, name := range append(append([]string{}, attackers...), b.Players...) {
+		if !seen[name] {
+			seen[name] = true
+			order = append(order, name)
+		}
+	}
+
+	// allies who defended alongside someone count as having fought
+	fought := map[string]bool{}
+	for _, attacker := range order {
+		for _, defender := range order {
+			if defender == attacker || fought[pairKey(attacker, defender)] {
+				continue
+			}
+			war, ok := w.warLocked(out, attacker, defender, loc)
+			if !ok {
+				continue
+			}
+			fought[pairKey(attacker, defender)] = true
+			for _, ally := range war.Result.Allies {
+				fought[pairKey(attacker, ally)] = true
+			}
+			b.Wars = append(b.Wars, war)
+		}
+	}
+	if len(b.Wars) == 0 {
+		return Battle{}, false
+	}
+	b.Survivors = w.presentLocked(loc)
+	return b, true
+}
+
+// HandleBattle sums up a battle this player was in, after the results of
+// its wars.
+func (gs *GameState) HandleBattle(b Battle) {
+	defer fmt.Println("------------------------")
+	fmt.Println()
+	fmt.Println("==== Battle Report ====")
+	fmt.Printf("Battle in %s (%s) between %s: %d war(s)\n", b.Location, b.Terrain, strings.Join(b.Players, ", "), len(b.Wars))
+	for _, war := range b.Wars {
+		res := war.Result
+		if res.Draw {
+			fmt.Printf("* %s and %s drew\n", res.Attacker, res.Defender)
+		} else {
+			fmt.Printf("* %s beat %s\n", res.Winner, res.Loser)
+		}
+	}
+
+	switch holder := b.Holder(); {
+	case holder == gs.GetUsername():
+		fmt.Printf("You hold %s.\n", b.Location)
+	case holder != "":
+		fmt.Printf("%s holds %s.\n", holder, b.Location)
+	case len(b.Survivors) == 0:
+		fmt.Printf("Nobody is left in %s.\n", b.Location)
+	default:
+		fmt.Printf("%s still share %s.\n", strings.Join(b.Survivors, ", "), b.Location)
+	}
+}
@@ -0,0 +1,109 @@
+package gamelogic
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestMultiPartyBattles(t *testing.T) {
+	allied := func(t *testing.T, w *World) {
+		diplomacy(t, w, DiplomacyCommand{Username: "alice", Action: DiplomacyAlly, With: "bob"})
+		diplomacy(t, w, DiplomacyCommand{Username: "bob", Action: DiplomacyAlly, With: "alice"})
+		move(t, w, "bob", "b", 1)
+	}
+
+	tests := []struct {
+		name     string
+		combined bool
+		at       [3]Location // where alice, bob and carol start
+		ranks    [3]UnitRank
+		setup    func(t *testing.T, w *World)
+		attack   func(t *testing.T, w *World) Outcome
+
+		wars      [][2]string // attacker and defender, in order
+		allies    []string    // who joined the first war
+		survivors []string
+	}{
+		{
+			name:  "whoever moved in attacks first",
+			at:    [3]Location{"b", "a", "c"},
+			ranks: [3]UnitRank{RankInfantry, RankArtillery, RankCavalry},
+			attack: func(t *testing.T, w *World) Outcome {
+				w.StartTurn(time.Minute)
+				move(t, w, "carol", "b", 1)
+				move(t, w, "bob", "b", 1)
+				_, out, err := w.EndTurn()
+				if err != nil {
+					t.Fatal(err)
+				}
+				return out
+			},
+			// bob and carol moved in, so they go first, by name, and
+			// attack in the same order; bob beats carol, then alice, and
+			// carol has nobody left to attack
+			wars:      [][2]string{{"bob", "carol"}, {"bob", "alice"}},
+			survivors: []string{"bob"},
+		},
+		{
+			name:  "allies don't fight each other",
+			at:    [3]Location{"b", "c", "d"},
+			ranks: [3]UnitRank{RankInfantry, RankInfantry, RankArtillery},
+			setup: allied,
+			attack: func(t *testing.T, w *World) Outcome {
+				move(t, w, "carol", "c", 1)
+				return move(t, w, "carol", "b", 1)
+			},
+			wars:      [][2]string{{"carol", "alice"}, {"carol", "bob"}},
+			survivors: []string{"carol"},
+		},
+		{
+			name:     "combined defence",
+			combined: true,
+			at:       [3]Location{"b", "c", "d"},
+			ranks:    [3]UnitRank{RankInfantry, RankInfantry, RankCavalry},
+			setup:    allied,
+			attack: func(t *testing.T, w *World) Outcome {
+				move(t, w, "carol", "c", 1)
+				return move(t, w, "carol", "b", 1)
+			},
+			wars:      [][2]string{{"carol", "alice"}},
+			allies:    []string{"bob"},
+			survivors: []string{"carol"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := newTestWorld(t)
+			w.SetCombinedDefence(tt.combined)
+			for i, name := range []string{"alice", "bob", "carol"} {
+				spawn(t, w, name, tt.at[i], tt.ranks[i])
+			}
+			if tt.setup != nil {
+				tt.setup(t, w)
+			}
+
+			out := tt.attack(t, w)
+			if len(out.Battles) != 1 {
+				t.Fatalf("battles %+v, want one in b", out.Battles)
+			}
+			b := out.Battles[0]
+			if b.Location != "b" || !reflect.DeepEqual(b.Players, []string{"alice", "bob", "carol"}) {
+				t.Errorf("battle in %s between %v, want b between all three", b.Location, b.Players)
+			}
+			wars := [][2]string{}
+			for _, war := range b.Wars {
+				wars = append(wars, [2]string{war.Result.Attacker, war.Result.Defender})
+			}
+			if !reflect.DeepEqual(wars, tt.wars) {
+				t.Errorf("wars %v, want %v", wars, tt.wars)
+			}
+			if len(b.Wars) > 0 && !reflect.DeepEqual(b.Wars[0].Result.Allies, tt.allies) {
+				t.Errorf("allies %v, want %v", b.Wars[0].Result.Allies, tt.allies)
+			}
+			if !reflect.DeepEqual(b.Survivors, tt.survivors) {
+				t.Errorf("survivors %v, want %v", b.Survivors, tt.survivors)
+			}
+		})
+	}
+}
@@ -0,0 +1,178 @@
+package gamelogic
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+)
+
+type ChatChannel string
+
+const (
+	ChatSay     = "say"     // everyone in the game
+	ChatWhisper = "whisper" // one other player
+	ChatTeam    = "team"    // the sender's allies
+)
+
+// MaxChatLength is how long a chat message may be, in characters.
+const MaxChatLength = 280
+
+// ChatMessage is one line of chat. Players send it to the server, which
+// checks it, archives it and passes it on to everyone it's for with At
+// set. A message the server refused goes back to its sender only, with
+// Rejected set.
+type ChatMessage struct {
+	From     string
+	Channel  ChatChannel
+	To       string    `json:",omitempty"` // whispers only
+	Text     string    `json:",omitempty"`
+	At       time.Time `json:",omitempty"`
+	Rejected string    `json:",omitempty"`
+}
+
+func (m ChatMessage) String() string {
+	switch m.Channel {
+	case ChatWhisper:
+		return fmt.Sprintf("[%s] %s -> %s: %s", m.At.Format("15:04"), m.From, m.To, m.Text)
+	case ChatTeam:
+		return fmt.Sprintf("[%s] (team) %s: %s", m.At.Format("15:04"), m.From, m.Text)
+	}
+	return fmt.Sprintf("[%s] %s: %s", m.At.Format("15:04"), m.From, m.Text)
+}
+
+// ChatRecipients checks msg and works out who gets it: everyone in the
+// game for say, the two players for a whisper, and the sender and their
+// allies for team. The sender always gets their own message back.
+func (w *World) ChatRecipients(msg ChatMessage) ([]string, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if err := routing.ValidateUsername(msg.From); err != nil {
+		return nil, err
+	}
+	if _, ok := w.players[msg.From]; !ok {
+		return nil, errors.New("join the game before you chat")
+	}
+	if strings.TrimSpace(msg.Text) == "" {
+		return nil, errors.New("there's nothing to say")
+	}
+	if utf8.RuneCountInString(msg.Text) > MaxChatLength {
+		return nil, fmt.Errorf("chat messages can be at most %d characters", MaxChatLength)
+	}
+
+	switch msg.Channel {
+	case ChatSay:
+		return w.usernamesLocked(), nil
+	case ChatWhisper:
+		if msg.To == msg.From {
+			return nil, errors.New("you can't whisper to yourself")
+		}
+		if _, ok := w.players[msg.To]; !ok {
+			return nil, fmt.Errorf("nobody called %s is in the game", msg.To)
+		}
+		return []string{msg.From, msg.To}, nil
+	case ChatTeam:
+		allies := w.alliesLocked(msg.From)
+		if len(allies) == 0 {
+			return nil, errors.New("you have no allies to talk to")
+		}
+		return append([]string{msg.From}, allies...), nil
+	}
+	return nil, fmt.Errorf("%s is not a chat channel", msg.Channel)
+}
+
+// CommandChat turns say, whisper and team commands into a message for the
+// server.
+func (gs *GameState) CommandChat(words []string) (ChatMessage, error) {
+	if len(words) == 0 {
+		return ChatMessage{}, errors.New("no chat command")
+	}
+	msg := ChatMessage{From: gs.GetUsername(), Channel: ChatChannel(words[0])}
+	switch msg.Channel {
+	case ChatSay, ChatTeam:
+		if len(words) < 2 {
+			return ChatMessage{}, fmt.Errorf("usage: %s <message>", words[0])
+		}
+		msg.Text = strings.Join(words[1:], " ")
+	case ChatWhisper:
+		if len(words) < 3 {
+			return ChatMessage{}, errors.New("usage: whisper <player> <message>")
+		}
+		msg.To = words[1]
+		if err := routing.ValidateUsername(msg.To); err != nil {
+			return ChatMessage{}, err
+		}
+		if msg.To == msg.From {
+			return ChatMessage{}, errors.New("you can't whisper to yourself")
+		}
+		msg.Text = strings.Join(words[2:], " ")
+	default:
+		return ChatMessage{}, fmt.Errorf("%s is not a chat command", words[0])
+	}
+	if utf8.RuneCountInString(msg.Text) > MaxChatLength {
+		return ChatMessage{}, fmt.Errorf("chat messages can be at most %d characters", MaxChatLength)
+	}
+	return msg, nil
+}
+
+// ChatHistory is how many chat messages a client keeps to scroll back
+// through.
+const ChatHistory = 200
+
+// ChatLog is a client's scroll-back of the chat it received. Like the
+// Standings it isn't saved with the GameState.
+type ChatLog struct {
+	mu       sync.Mutex
+	messages []ChatMessage
+}
+
+func NewChatLog() *ChatLog {
+	return &ChatLog{}
+}
+
+// HandleMessage shows a chat message as it arrives and keeps it.
+func (cl *ChatLog) HandleMessage(msg ChatMessage) {
+	if msg.Rejected != "" {
+		fmt.Println()
+		fmt.Println("Your message wasn't sent:", msg.Rejected)
+		return
+	}
+	cl.mu.Lock()
+	cl.messages = append(cl.messages, msg)
+	if len(cl.messages) > ChatHistory {
+		cl.messages = cl.messages[len(cl.messages)-ChatHistory:]
+	}
+	cl.mu.Unlock()
+
+	fmt.Println()
+	fmt.Println(msg)
+}
+
+// CommandChat prints the last n messages, 20 unless words says otherwise.
+func (cl *ChatLog) CommandChat(words []string) error {
+	n := 20
+	if len(words) > 1 {
+		var err error
+		n, err = strconv.Atoi(words[1])
+		if err != nil || n < 1 {
+			return errors.New("usage: chat [n]")
+		}
+	}
+
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	if len(cl.messages) == 0 {
+		fmt.Println("Nobody has said anything yet.")
+		return nil
+	}
+	for _, msg := range cl.messages[max(0, len(cl.messages)-n):] {
+		fmt.Println(msg)
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package gamelogic
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestChatRecipients(t *testing.T) {
+	w := newTestWorld(t)
+	spawn(t, w, "alice", "a", RankInfantry)
+	spawn(t, w, "bob", "c", RankInfantry)
+	spawn(t, w, "carol", "e", RankInfantry)
+	diplomacy(t, w, DiplomacyCommand{Username: "alice", Action: DiplomacyAlly, With: "carol"})
+	diplomacy(t, w, DiplomacyCommand{Username: "carol", Action: DiplomacyAlly, With: "alice"})
+	// a truce doesn't put bob on anybody's team
+	diplomacy(t, w, DiplomacyCommand{Username: "alice", Action: DiplomacyTruce, With: "bob", Duration: time.Minute})
+	diplomacy(t, w, DiplomacyCommand{Username: "bob", Action: DiplomacyTruce, With: "alice", Duration: time.Minute})
+
+	tests := []struct {
+		name string
+		msg  ChatMessage
+		want []string // nil if it's refused
+	}{
+		{"say", ChatMessage{From: "bob", Channel: ChatSay, Text: "hi"}, []string{"alice", "bob", "carol"}},
+		{"whisper", ChatMessage{From: "bob", Channel: ChatWhisper, To: "carol", Text: "psst"}, []string{"bob", "carol"}},
+		{"team", ChatMessage{From: "carol", Channel: ChatTeam, Text: "now"}, []string{"carol", "alice"}},
+		{"team without allies", ChatMessage{From: "bob", Channel: ChatTeam, Text: "anyone?"}, nil},
+		{"whisper to yourself", ChatMessage{From: "bob", Channel: ChatWhisper, To: "bob", Text: "hm"}, nil},
+		{"whisper to a stranger", ChatMessage{From: "bob", Channel: ChatWhisper, To: "dave", Text: "hi"}, nil},
+		{"not in the game", ChatMessage{From: "dave", Channel: ChatSay, Text: "hi"}, nil},
+		{"bad username", ChatMessage{From: "da*ve", Channel: ChatSay, Text: "hi"}, nil},
+		{"blank", ChatMessage{From: "bob", Channel: ChatSay, Text: "  "}, nil},
+		{"too long", ChatMessage{From: "bob", Channel: ChatSay, Text: strings.Repeat("é", MaxChatLength+1)}, nil},
+		{"longest", ChatMessage{From: "bob", Channel: ChatSay, Text: strings.Repeat("é", MaxChatLength)}, []string{"alice", "bob", "carol"}},
+		{"unknown channel", ChatMessage{From: "bob", Channel: "shout", Text: "hi"}, nil},
+	}
+	for _, tt := range tests {
+		got, err := w.ChatRecipients(tt.msg)
+		if tt.want == nil {
+			if err == nil {
+				t.Errorf("%s: sent to %v", tt.name, got)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: %v, %v; want %v", tt.name, got, err, tt.want)
+		}
+	}
+}
+
+func TestCommandChat(t *testing.T) {
+	gs := NewGameState("alice", nil)
+	tests := []struct {
+		words []string
+		want  ChatMessage
+		ok    bool
+	}{
+		{[]string{"say", "hello", "there"}, ChatMessage{From: "alice", Channel: ChatSay, Text: "hello there"}, true},
+		{[]string{"team", "go"}, ChatMessage{From: "alice", Channel: ChatTeam, Text: "go"}, true},
+		{[]string{"whisper", "bob", "psst", "bob"}, ChatMessage{From: "alice", Channel: ChatWhisper, To: "bob", Text: "psst bob"}, true},
+		{[]string{"say"}, ChatMessage{}, false},
+		{[]string{"whisper", "bob"}, ChatMessage{}, false},
+		{[]string{"whisper", "alice", "hi"}, ChatMessage{}, false},
+		{[]string{"whisper", "b.b", "hi"}, ChatMessage{}, false},
+		{[]string{"shout", "hi"}, ChatMessage{}, false},
+	}
+	for _, tt := range tests {
+		got, err := gs.CommandChat(tt.words)
+		if (err == nil) != tt.ok || got != tt.want {
+			t.Errorf("%v = %+v, %v; want %+v, ok %v", tt.words, got, err, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestChatLogKeepsTheLatest(t *testing.T) {
+	cl := NewChatLog()
+	for i := 0; i < ChatHistory+5; i++ {
+		cl.HandleMessage(ChatMessage{From: "bob", Channel: ChatSay, Text: fmt.Sprint(i)})
+	}
+	cl.HandleMessage(ChatMessage{From: "alice", Channel: ChatSay, Rejected: "too loud"})
+	if len(cl.messages) != ChatHistory {
+		t.Fatalf("kept %d messages, want %d", len(cl.messages), ChatHistory)
+	}
+	if first, last := cl.messages[0].Text, cl.messages[ChatHistory-1].Text; first != "5" || last != fmt.Sprint(ChatHistory+4) {
+		t.Errorf("kept messages %s to %s", first, last)
+	}
+}
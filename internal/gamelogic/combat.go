@@ -0,0 +1,270 @@
+package gamelogic
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+)
+
+// CombatResolver settles a war between the units the attacker and
+// defender have in rw.Location. It must only use what rw carries, seed
+// included, so the same recognition always gives the same result.
+type CombatResolver interface {
+	Name() string
+	Resolve(rw RecognitionOfWar) WarResult
+}
+
+// DefaultCombat is the resolver used when a recognition doesn't name one.
+const DefaultCombat = "dice"
+
+var combatResolvers = map[string]CombatResolver{
+	DiceResolver{}.Name():  DiceResolver{},
+	PowerResolver{}.Name(): PowerResolver{},
+}
+
+// CombatResolverByName looks up a resolver; an empty name is the default.
+func CombatResolverByName(name string) (CombatResolver, error) {
+	if name == "" {
+		name = DefaultCombat
+	}
+	r, ok := combatResolvers[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown combat resolver %q", name)
+	}
+	return r, nil
+}
+
+// CombatResolverNames lists the resolvers, e.g. for flag help.
+func CombatResolverNames() []string {
+	names := []string{}
+	for name := range combatResolvers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// DiceRounds is how many rounds a dice war lasts at most. If both sides
+// still have units after that, it's a draw.
+const DiceRounds = 5
+
+// DiceResolver fights Risk-style rounds. Each round the attacker's three
+// strongest units and the defender's two strongest each roll a die plus
+// their rank and grade bonus; the defender also gets the terrain bonus.
+// The highest rolls are paired off and the lower roll of each pair costs
+// its unit one HP. The defender wins ties. A unit is destroyed when it has
+// no HP left; the rest gain XP for surviving and for every kill.
+type DiceResolver struct{}
+
+func (DiceResolver) Name() string { return "dice" }
+
+func (DiceResolver) Resolve(rw RecognitionOfWar) WarResult {
+	rng := rand.New(rand.NewSource(rw.Seed))
+	attackers := newSide(unitsInLocation(rw.Attacker, rw.Location))
+	defenders := newSide(unitsInLocation(rw.Defender, rw.Location))
+	res := newWarResult(rw)
+
+	type roll struct {
+		id    int
+		score int
+	}
+	rollFor := func(s *side, n, bonus int) []roll {
+		rolls := []roll{}
+		for _, u := range s.units[:min(n, len(s.units))] {
+			rolls = append(rolls, roll{id: u.ID, score: rng.Intn(6) + 1 + unitBonus(u) + bonus})
+		}
+		// stable, so equal scores keep the strongest-first order
+		sort.SliceStable(rolls, func(i, j int) bool { return rolls[i].score > rolls[j].score })
+		return rolls
+	}
+
+	for round := 0; round < DiceRounds && len(attackers.units) > 0 && len(defenders.units) > 0; round++ {
+		aRolls := rollFor(attackers, 3, 0)
+		dRolls := rollFor(defenders, 2, terrainDefence(rw.Terrain))
+		for i := 0; i < len(aRolls) && i < len(dRolls); i++ {
+			if aRolls[i].score > dRolls[i].score {
+				if defenders.hit(dRolls[i].id) {
+					attackers.kills[aRolls[i].id]++
+				}
+			} else {
+				if attackers.hit(aRolls[i].id) {
+					defenders.kills[dRolls[i].id]++
+				}
+			}
+		}
+	}
+
+	switch {
+	case len(defenders.units) == 0:
+		res.Winner, res.Loser = res.Attacker, res.Defender
+	case len(attackers.units) == 0:
+		res.Winner, res.Loser = res.Defender, res.Attacker
+	default:
+		res.Winner, res.Loser = res.Attacker, res.Defender
+		res.Draw = true
+	}
+	res.AttackerLosses, res.AttackerSurvivors = attackers.outcome()
+	res.DefenderLosses, res.DefenderSurvivors = defenders.outcome()
+	return res
+}
+
+// side is one army during a dice war.
+type side struct {
+	units  []Unit // still alive, strongest first
+	kills  map[int]int
+	losses []int
+}
+
+func newSide(units []Unit) *side {
+	return &side{units: strongestFirst(units), kills: map[int]int{}}
+}
+
+// hit takes one HP from unit id and reports whether that destroyed it.
+func (s *side) hit(id int) bool {
+	for i, u := range s.units {
+		if u.ID != id {
+			continue
+		}
+		u.HP--
+		if u.HP > 0 {
+			s.units[i] = u
+			return false
+		}
+		s.units = append(s.units[:i], s.units[i+1:]...)
+		s.losses = append(s.losses, id)
+		return true
+	}
+	return false
+}
+
+// outcome is the destroyed units and the survivors, with the XP they
+// earned: one for surviving and one per kill.
+func (s *side) outcome() ([]int, []Unit) {
+	survivors := []Unit{}
+	for _, u := range s.units {
+		survivors = append(survivors, gainXP(u, 1+s.kills[u.ID]))
+	}
+	sortUnits(survivors)
+	losses := append([]int(nil), s.losses...)
+	sort.Ints(losses)
+	return losses, survivors
+}
+
+// PowerResolver is the original rule: each side adds up its units' power
+// (artillery 10, cavalry 5, infantry 1, doubled for veterans and tripled
+// for elites) and the weaker side loses every unit there. A draw wipes out
+// both. The winners gain one XP. It ignores HP, the seed and terrain.
+type PowerResolver struct{}
+
+func (PowerResolver) Name() string { return "power" }
+
+func (PowerResolver) Resolve(rw RecognitionOfWar) WarResult {
+	attackers := unitsInLocation(rw.Attacker, rw.Location)
+	defenders := unitsInLocation(rw.Defender, rw.Location)
+	res := newWarResult(rw)
+
+	survived := func(units []Unit) []Unit {
+		out := []Unit{}
+		for _, u := range units {
+			out = append(out, gainXP(u, 1))
+		}
+		sortUnits(out)
+		return out
+	}
+
+	attackerPower := unitsToPowerLevel(attackers)
+	defenderPower := unitsToPowerLevel(defenders)
+	switch {
+	case attackerPower > defenderPower:
+		res.Winner, res.Loser = rw.Attacker.Username, rw.Defender.Username
+		res.DefenderLosses = unitIDs(defenders)
+		res.AttackerSurvivors = survived(attackers)
+	case defenderPower > attackerPower:
+		res.Winner, res.Loser = rw.Defender.Username, rw.Attacker.Username
+		res.AttackerLosses = unitIDs(attackers)
+		res.DefenderSurvivors = survived(defenders)
+	default:
+		res.Winner, res.Loser = rw.Attacker.Username, rw.Defender.Username
+		res.Draw = true
+		res.AttackerLosses = unitIDs(attackers)
+		res.DefenderLosses = unitIDs(defenders)
+	}
+	return res
+}
+
+func newWarResult(rw RecognitionOfWar) WarResult {
+	return WarResult{
+		Attacker: rw.Attacker.Username,
+		Defender: rw.Defender.Username,
+		Location: rw.Location,
+	}
+}
+
+func rankBonus(rank UnitRank) int {
+	switch rank {
+	case RankArtillery:
+		return 2
+	case RankCavalry:
+		return 1
+	}
+	return 0
+}
+
+// terrainDefence is what a territory adds to every defender's roll.
+func terrainDefence(t Terrain) int {
+	switch t {
+	case TerrainMountains:
+		return 2
+	case TerrainForest, TerrainJungle, TerrainIce:
+		return 1
+	}
+	return 0
+}
+
+// unitBonus is what a unit adds to its dice rolls.
+func unitBonus(u Unit) int {
+	return rankBonus(u.Rank) + gradeBonus(u.Grade)
+}
+
+// strongestFirst sorts units by their bonus, then ID, so the same units
+// always roll in the same order.
+func strongestFirst(units []Unit) []Unit {
+	sort.Slice(units, func(i, j int) bool {
+		bi, bj := unitBonus(units[i]), unitBonus(units[j])
+		if bi != bj {
+			return bi > bj
+		}
+		return units[i].ID < units[j].ID
+	})
+	return units
+}
+
+func sortUnits(units []Unit) {
+	sort.Slice(units, func(i, j int) bool { return units[i].ID < units[j].ID })
+}
+
+func unitIDs(units []Unit) []int {
+	ids := make([]int, 0, len(units))
+	for _, u := range units {
+		ids = append(ids, u.ID)
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+func unitsToPowerLevel(units []Unit) int {
+	power := 0
+	for _, unit := range units {
+		grade := 1 + gradeBonus(unit.Grade)
+		if unit.Rank == RankArtillery {
+			power += 10 * grade
+		}
+		if unit.Rank == RankCavalry {
+			power += 5 * grade
+		}
+		if unit.Rank == RankInfantry {
+			power += 1 * grade
+		}
+	}
+	return power
+}
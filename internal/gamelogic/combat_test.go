@@ -0,0 +1,164 @@
+package gamelogic
+
+import (
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+// army builds a player whose units are inserted into the map in the order
+// rng shuffles them into, so two calls give maps with the same contents
+// but different histories.
+func army(rng *rand.Rand, username string, units []Unit) Player {
+	p := Player{Username: username, Units: map[int]Unit{}}
+	for _, i := range rng.Perm(len(units)) {
+		p.Units[units[i].ID] = units[i]
+	}
+	return p
+}
+
+func warUnits(loc Location) (attackers, defenders []Unit) {
+	attackers = []Unit{
+		NewUnit(1, RankInfantry, loc),
+		NewUnit(2, RankInfantry, loc),
+		NewUnit(3, RankCavalry, loc),
+		NewUnit(4, RankArtillery, loc),
+		NewUnit(5, RankInfantry, loc),
+		NewUnit(6, RankInfantry, "asia"), // not in this war
+	}
+	defenders = []Unit{
+		NewUnit(1, RankInfantry, loc),
+		NewUnit(2, RankInfantry, loc),
+		NewUnit(3, RankInfantry, loc),
+		NewUnit(4, RankCavalry, loc),
+		NewUnit(5, RankInfantry, loc),
+	}
+	defenders[3].Grade = GradeVeteran
+	return attackers, defenders
+}
+
+func TestDiceResolveDeterministic(t *testing.T) {
+	attackers, defenders := warUnits("europe")
+	shuffle := rand.New(rand.NewSource(1))
+
+	for seed := int64(0); seed < 20; seed++ {
+		var first WarResult
+		for i := 0; i < 20; i++ {
+			rw := RecognitionOfWar{
+				Attacker: army(shuffle, "alice", attackers),
+				Defender: army(shuffle, "bob", defenders),
+				Location: "europe",
+				Terrain:  TerrainForest,
+				Seed:     seed,
+			}
+			res, err := ResolveWar(rw)
+			if err != nil {
+				t.Fatalf("ResolveWar: %v", err)
+			}
+			if i == 0 {
+				first = res
+				continue
+			}
+			if !reflect.DeepEqual(res, first) {
+				t.Fatalf("seed %d gave two results:\n%+v\n%+v", seed, first, res)
+			}
+		}
+	}
+}
+
+func TestDiceCasualtiesArePartial(t *testing.T) {
+	attackers, defenders := warUnits("europe")
+	shuffle := rand.New(rand.NewSource(1))
+
+	// check every side adds up: each unit that fought is either lost or
+	// survived, and a side only loses units it had there
+	check := func(seed int64, name string, units []Unit, losses []int, survivors []Unit) {
+		fought := map[int]Unit{}
+		for _, u := range units {
+			if u.Location == "europe" {
+				fought[u.ID] = u
+			}
+		}
+		seen := map[int]bool{}
+		for _, id := range losses {
+			if _, ok := fought[id]; !ok || seen[id] {
+				t.Errorf("seed %d: %s lost unit %d that didn't fight", seed, name, id)
+			}
+			seen[id] = true
+		}
+		for _, u := range survivors {
+			before, ok := fought[u.ID]
+			if !ok || seen[u.ID] {
+				t.Errorf("seed %d: %s unit %d survived but didn't fight or was lost", seed, name, u.ID)
+				continue
+			}
+			seen[u.ID] = true
+			if u.HP < 1 || u.HP > before.HP {
+				t.Errorf("seed %d: %s unit %d has %d HP after the war, %d before", seed, name, u.ID, u.HP, before.HP)
+			}
+			if u.XP < before.XP+1 {
+				t.Errorf("seed %d: %s unit %d didn't gain XP for surviving", seed, name, u.ID)
+			}
+		}
+		if len(seen) != len(fought) {
+			t.Errorf("seed %d: %s had %d units there, %d accounted for", seed, name, len(fought), len(seen))
+		}
+	}
+
+	partial := 0
+	for seed := int64(0); seed < 200; seed++ {
+		res, err := ResolveWar(RecognitionOfWar{
+			Attacker: army(shuffle, "alice", attackers),
+			Defender: army(shuffle, "bob", defenders),
+			Location: "europe",
+			Seed:     seed,
+		})
+		if err != nil {
+			t.Fatalf("ResolveWar: %v", err)
+		}
+		check(seed, "alice", attackers, res.AttackerLosses, res.AttackerSurvivors)
+		check(seed, "bob", defenders, res.DefenderLosses, res.DefenderSurvivors)
+
+		// at most two hits a round, and each side has more HP than five
+		// rounds of that, so nobody is wiped out
+		if len(res.AttackerSurvivors) == 0 || len(res.DefenderSurvivors) == 0 {
+			t.Errorf("seed %d wiped out a side: %+v", seed, res)
+		}
+		if !res.Draw {
+			t.Errorf("seed %d: %s won with both sides left", seed, res.Winner)
+		}
+		if len(res.AttackerLosses)+len(res.DefenderLosses) > 0 {
+			partial++
+		}
+	}
+	if partial == 0 {
+		t.Error("no seed destroyed a single unit")
+	}
+}
+
+func TestPowerResolve(t *testing.T) {
+	loc := Location("europe")
+	strong := Player{Username: "alice", Units: map[int]Unit{1: NewUnit(1, RankArtillery, loc), 2: NewUnit(2, RankInfantry, "asia")}}
+	weak := Player{Username: "bob", Units: map[int]Unit{1: NewUnit(1, RankCavalry, loc), 2: NewUnit(2, RankInfantry, loc)}}
+
+	res, err := ResolveWar(RecognitionOfWar{Attacker: weak, Defender: strong, Combat: "power"})
+	if err != nil {
+		t.Fatalf("ResolveWar: %v", err)
+	}
+	if res.Location != loc || res.Winner != "alice" || res.Draw {
+		t.Errorf("got %+v", res)
+	}
+	if !reflect.DeepEqual(res.AttackerLosses, []int{1, 2}) || len(res.DefenderLosses) != 0 {
+		t.Errorf("losses %v / %v", res.AttackerLosses, res.DefenderLosses)
+	}
+	if len(res.DefenderSurvivors) != 1 || res.DefenderSurvivors[0].ID != 1 || res.DefenderSurvivors[0].XP != 1 {
+		t.Errorf("survivors %+v", res.DefenderSurvivors)
+	}
+
+	if _, err := ResolveWar(RecognitionOfWar{Attacker: weak, Defender: strong, Combat: "chess"}); err == nil {
+		t.Error("unknown resolver accepted")
+	}
+	if _, err := ResolveWar(RecognitionOfWar{Attacker: weak, Defender: Player{Username: "carol"}}); err == nil {
+		t.Error("war with nobody to fight accepted")
+	}
+}
@@ -0,0 +1,92 @@
+package gamelogic
+
+// Command is something a player asks the server to do. Exactly one of the
+// fields besides Username is set. Nothing happens until the server accepts
+// it and sends back a PlayerUpdate.
+type Command struct {
+	Username string
+	Join     *JoinCommand  `json:",omitempty"`
+	Spawn    *SpawnCommand `json:",omitempty"`
+	Move     *MoveCommand  `json:",omitempty"`
+}
+
+// JoinCommand asks the server for the player's current state, creating the
+// player if the server has never seen them.
+type JoinCommand struct{}
+
+type SpawnCommand struct {
+	Location Location
+	Rank     UnitRank
+}
+
+type MoveCommand struct {
+	UnitIDs []int
+	To      Location
+}
+
+// PlayerUpdate is a change the server accepted for one player, an order it
+// queued for the end of the turn, or the reason it refused one of their
+// commands. Exactly one field besides Username is set.
+type PlayerUpdate struct {
+	Username  string
+	Synced    *Synced         `json:",omitempty"`
+	Spawned   *UnitSpawned    `json:",omitempty"`
+	Moved     *UnitMoved      `json:",omitempty"`
+	Destroyed *UnitsDestroyed `json:",omitempty"`
+	Updated   *UnitsUpdated   `json:",omitempty"`
+	Gold      *GoldReceived   `json:",omitempty"`
+	Queued    string          `json:",omitempty"` // an order waiting for the end of the turn
+	Rejected  string          `json:",omitempty"`
+}
+
+// Synced replaces the whole state with the server's copy, e.g. when a
+// player joins.
+type Synced struct {
+	Player Player
+	Paused bool
+	Gold   int
+	Turn   *TurnStart `json:",omitempty"` // the open turn, in turn mode
+	Over   *GameOver  `json:",omitempty"` // how the game ended, once it has
+	Pacts  []Pact     `json:",omitempty"`
+}
+
+func (Synced) EventName() string { return "synced" }
+
+func updateFor(username string, e Event) PlayerUpdate {
+	u := PlayerUpdate{Username: username}
+	switch e := e.(type) {
+	case Synced:
+		u.Synced = &e
+	case UnitSpawned:
+		u.Spawned = &e
+	case UnitMoved:
+		u.Moved = &e
+	case UnitsDestroyed:
+		u.Destroyed = &e
+	case UnitsUpdated:
+		u.Updated = &e
+	case GoldReceived:
+		u.Gold = &e
+	}
+	return u
+}
+
+// Event is the event the update carries, or nil for a rejection or a
+// queued order.
+func (u PlayerUpdate) Event() Event {
+	switch {
+	case u.Synced != nil:
+		return *u.Synced
+	case u.Spawned != nil:
+		return *u.Spawned
+	case u.Moved != nil:
+		return *u.Moved
+	case u.Destroyed != nil:
+		return *u.Destroyed
+	case u.Updated != nil:
+		return *u.Updated
+	case u.Gold != nil:
+		return *u.Gold
+	}
+	return nil
+}
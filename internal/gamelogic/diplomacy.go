@@ -0,0 +1,458 @@
+package gamelogic
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+)
+
+type PactKind string
+
+const (
+	PactAlliance = "alliance" // until one side breaks it
+	PactTruce    = "truce"    // until it runs out, or one side breaks it
+)
+
+// Pact is an agreement between two players not to fight. Allies can also
+// defend each other, if the server allows it.
+type Pact struct {
+	Kind    PactKind
+	Players [2]string // sorted
+	Until   time.Time `json:",omitempty"` // when a truce runs out
+}
+
+func newPact(kind PactKind, a, b string, until time.Time) Pact {
+	players := [2]string{a, b}
+	if b < a {
+		players = [2]string{b, a}
+	}
+	return Pact{Kind: kind, Players: players, Until: until}
+}
+
+// With is the other player in the pact.
+func (p Pact) With(username string) string {
+	if p.Players[0] == username {
+		return p.Players[1]
+	}
+	return p.Players[0]
+}
+
+// Active reports whether the pact still holds at now. Truces run out on
+// their own, alliances never do.
+func (p Pact) Active(now time.Time) bool {
+	return p.Kind != PactTruce || now.Before(p.Until)
+}
+
+func (p Pact) String() string {
+	if p.Kind == PactTruce {
+		return fmt.Sprintf("truce between %s and %s until %s", p.Players[0], p.Players[1], p.Until.Format("15:04:05"))
+	}
+	return fmt.Sprintf("alliance between %s and %s", p.Players[0], p.Players[1])
+}
+
+type DiplomacyAction string
+
+const (
+	DiplomacyAlly  = "ally"
+	DiplomacyTruce = "truce"
+	DiplomacyBreak = "break"
+)
+
+// DiplomacyCommand is a player proposing, accepting or breaking a pact.
+// Proposing the same pact back accepts it; a truce then lasts for the
+// shorter of the two durations. Break ends a pact, or withdraws or turns
+// down a proposal.
+type DiplomacyCommand struct {
+	Username string
+	Action   DiplomacyAction
+	With     string
+	Duration time.Duration `json:",omitempty"` // truces only
+}
+
+// Proposal is a pact one player has offered and the other hasn't accepted
+// yet.
+type Proposal struct {
+	From     string
+	To       string
+	Kind     PactKind
+	Duration time.Duration `json:",omitempty"`
+}
+
+// DiplomacyUpdate tells a player what happened to a pact or proposal
+// they're part of. Both sides get one.
+type DiplomacyUpdate struct {
+	Username string    // who it's for
+	Action   string    // proposed, withdrawn, declined, agreed, broken or expired; empty for a rejection
+	By       string    `json:",omitempty"` // who did it
+	Proposal *Proposal `json:",omitempty"`
+	Pact     *Pact     `json:",omitempty"`
+	Rejected string    `json:",omitempty"`
+}
+
+func pairKey(a, b string) string {
+	if b < a {
+		a, b = b, a
+	}
+	return a + "/" + b
+}
+
+// Diplomacy handles a pact command. Nothing changes if it isn't allowed,
+// and the error is meant for the player who sent it. Breaking a pact can
+// start battles, which come back in the Outcome.
+func (w *World) Diplomacy(cmd DiplomacyCommand) ([]DiplomacyUpdate, Outcome, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	var out Outcome
+	updates, err := w.diplomacyLocked(cmd)
+	if err == nil && len(updates) > 0 && updates[0].Action == "broken" {
+		w.pactEndedLocked(&out)
+	}
+	return updates, out, err
+}
+
+func (w *World) diplomacyLocked(cmd DiplomacyCommand) ([]DiplomacyUpdate, error) {
+	if err := routing.ValidateUsername(cmd.Username); err != nil {
+		return nil, err
+	}
+	if w.over != nil {
+		return nil, fmt.Errorf("the game is over, %s won", w.over.Winner)
+	}
+	if cmd.With == cmd.Username {
+		return nil, errors.New("you can't make a pact with yourself")
+	}
+	if _, ok := w.players[cmd.With]; !ok {
+		return nil, fmt.Errorf("there's no player called %s", cmd.With)
+	}
+
+	now := time.Now()
+	both := func(action string, p *Proposal, pact *Pact) []DiplomacyUpdate {
+		return []DiplomacyUpdate{
+			{Username: cmd.Username, Action: action, By: cmd.Username, Proposal: p, Pact: pact},
+			{Username: cmd.With, Action: action, By: cmd.Username, Proposal: p, Pact: pact},
+		}
+	}
+
+	switch cmd.Action {
+	case DiplomacyAlly, DiplomacyTruce:
+		kind := PactKind(PactAlliance)
+		if cmd.Action == DiplomacyTruce {
+			kind = PactTruce
+			if cmd.Duration <= 0 {
+				return nil, errors.New("a truce needs a duration, e.g. 10m")
+			}
+		}
+		if pact, ok := w.pactLocked(cmd.Username, cmd.With, now); ok {
+			if pact.Kind == PactAlliance {
+				return nil, fmt.Errorf("you're already allied with %s", cmd.With)
+			}
+			if kind == PactTruce {
+				return nil, fmt.Errorf("you already have a truce with %s", cmd.With)
+			}
+		}
+
+		theirs, ok := w.proposals[cmd.With+">"+cmd.Username]
+		if !ok || theirs.Kind != kind {
+			p := Proposal{From: cmd.Username, To: cmd.With, Kind: kind, Duration: cmd.Duration}
+			w.proposals[cmd.Username+">"+cmd.With] = p
+			return both("proposed", &p, nil), nil
+		}
+
+		var until time.Time
+		if kind == PactTruce {
+			until = now.Add(min(theirs.Duration, cmd.Duration))
+		}
+		pact := newPact(kind, cmd.Username, cmd.With, until)
+		w.pacts[pairKey(cmd.Username, cmd.With)] = pact
+		delete(w.proposals, cmd.With+">"+cmd.Username)
+		delete(w.proposals, cmd.Username+">"+cmd.With)
+		return both("agreed", nil, &pact), nil
+
+	case DiplomacyBreak:
+		if pact, ok := w.pactLocked(cmd.Username, cmd.With, now); ok {
+			delete(w.pacts, pairKey(cmd.Username, cmd.With))
+			return both("broken", nil, &pact), nil
+		}
+		if p, ok := w.proposals[cmd.Username+">"+cmd.With]; ok {
+			delete(w.proposals, cmd.Username+">"+cmd.With)
+			return both("withdrawn", &p, nil), nil
+		}
+		if p, ok := w.proposals[cmd.With+">"+cmd.Username]; ok {
+			delete(w.proposals, cmd.With+">"+cmd.Username)
+			return both("declined", &p, nil), nil
+		}
+		return nil, fmt.Errorf("you have no pact or proposal with %s", cmd.With)
+	}
+	return nil, fmt.Errorf("%q is not a diplomacy action", cmd.Action)
+}
+
+// pactLocked is the pact between a and b, if they have one that still
+// holds. Truces that ran out stay in w.pacts until ExpireTruces tells both
+// sides.
+func (w *World) pactLocked(a, b string, now time.Time) (Pact, bool) {
+	pact, ok := w.pacts[pairKey(a, b)]
+	if !ok || !pact.Active(now) {
+		return Pact{}, false
+	}
+	return pact, true
+}
+
+// ExpireTruces forgets every truce that has run out and tells both sides.
+// The server calls it every second. A pact ending can leave enemies
+// sharing a territory, so it also fights any battles that start.
+func (w *World) ExpireTruces(now time.Time) ([]DiplomacyUpdate, Outcome) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	var updates []DiplomacyUpdate
+	var out Outcome
+	if w.over != nil {
+		return nil, out
+	}
+	keys := make([]string, 0, len(w.pacts))
+	for key := range w.pacts {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		pact := w.pacts[key]
+		if pact.Active(now) {
+			continue
+		}
+		delete(w.pacts, key)
+		for _, name := range pact.Players {
+			updates = append(updates, DiplomacyUpdate{Username: name, Action: "expired", Pact: &pact})
+		}
+	}
+	if len(updates) > 0 || w.pactEnded {
+		w.pactEndedLocked(&out)
+	}
+	return updates, out
+}
+
+// pactEndedLocked fights a battle wherever a pact ending left enemies
+// together, the way the end of a turn does. In turn mode the end of the
+// turn takes care of it; while the game is paused it waits for
+// ExpireTruces after the resume.
+func (w *World) pactEndedLocked(out *Outcome) {
+	if w.turn.Number > 0 {
+		return
+	}
+	if w.paused {
+		w.pactEnded = true
+		return
+	}
+	w.pactEnded = false
+	for _, loc := range w.worldMap.Locations() {
+		if b, ok := w.battleLocked(out, loc, nil); ok {
+			out.Battles = append(out.Battles, b)
+		}
+	}
+	w.settleLocked(out, time.Now())
+}
+
+// atPeaceLocked reports whether a and b have agreed not to fight.
+func (w *World) atPeaceLocked(a, b string) bool {
+	_, ok := w.pactLocked(a, b, time.Now())
+	return ok
+}
+
+// alliesLocked lists username's allies. A truce doesn't make an ally.
+func (w *World) alliesLocked(username string) []string {
+	allies := []string{}
+	for _, name := range w.usernamesLocked() {
+		if name == username {
+			continue
+		}
+		if pact, ok := w.pactLocked(username, name, time.Now()); ok && pact.Kind == PactAlliance {
+			allies = append(allies, name)
+		}
+	}
+	return allies
+}
+
+// pactsLocked lists every pact username is in.
+func (w *World) pactsLocked(username string) []Pact {
+	pacts := []Pact{}
+	for _, name := range w.usernamesLocked() {
+		if pact, ok := w.pactLocked(username, name, time.Now()); ok && name != username {
+			pacts = append(pacts, pact)
+		}
+	}
+	return pacts
+}
+
+// Pacts lists every pact that still holds, e.g. for the server REPL.
+func (w *World) Pacts() []Pact {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	pacts := []Pact{}
+	for _, pact := range w.pacts {
+		if _, ok := w.pactLocked(pact.Players[0], pact.Players[1], time.Now()); ok {
+			pacts = append(pacts, pact)
+		}
+	}
+	sort.Slice(pacts, func(i, j int) bool {
+		return pairKey(pacts[i].Players[0], pacts[i].Players[1]) < pairKey(pacts[j].Players[0], pacts[j].Players[1])
+	})
+	return pacts
+}
+
+// CommandDiplomacy turns an ally, truce or break command into a request
+// for the server. Nothing changes until the other player agrees.
+func (gs *GameState) CommandDiplomacy(words []string) (DiplomacyCommand, error) {
+	usage := map[string]string{
+		DiplomacyAlly:  "usage: ally <player>",
+		DiplomacyBreak: "usage: break <player>",
+		DiplomacyTruce: "usage: truce <player> <duration>",
+	}
+	if len(words) == 0 {
+		return DiplomacyCommand{}, errors.New("no diplomacy command")
+	}
+	action := words[0]
+	want := 2
+	if action == DiplomacyTruce {
+		want = 3
+	}
+	if _, ok := usage[action]; !ok || len(words) != want {
+		return DiplomacyCommand{}, errors.New(usage[action])
+	}
+
+	cmd := DiplomacyCommand{Username: gs.GetUsername(), Action: DiplomacyAction(action), With: words[1]}
+	if err := routing.ValidateUsername(cmd.With); err != nil {
+		return DiplomacyCommand{}, err
+	}
+	if cmd.With == cmd.Username {
+		return DiplomacyCommand{}, errors.New("you can't make a pact with yourself")
+	}
+	if action == DiplomacyTruce {
+		d, err := time.ParseDuration(words[2])
+		if err != nil || d <= 0 {
+			return DiplomacyCommand{}, fmt.Errorf("%q is not a duration, e.g. 10m", words[2])
+		}
+		cmd.Duration = d
+	}
+	return cmd, nil
+}
+
+// Diplomacy is a client's view of its pacts and of the proposals it has
+// sent or received. Like the TurnClock it isn't saved with the profile;
+// the server sends the pacts again on join.
+type Diplomacy struct {
+	mu        sync.Mutex
+	username  string
+	pacts     map[string]Pact     // by the other player
+	proposals map[string]Proposal // by From+">"+To
+}
+
+func NewDiplomacy(username string) *Diplomacy {
+	return &Diplomacy{username: username, pacts: map[string]Pact{}, proposals: map[string]Proposal{}}
+}
+
+// AtPeace is the pact this player has with username, if it still holds.
+func (d *Diplomacy) AtPeace(username string) (Pact, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	pact, ok := d.pacts[username]
+	if !ok || !pact.Active(time.Now()) {
+		return Pact{}, false
+	}
+	return pact, true
+}
+
+// Sync replaces the pacts with the server's list.
+func (d *Diplomacy) Sync(pacts []Pact) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.pacts = map[string]Pact{}
+	for _, pact := range pacts {
+		d.pacts[pact.With(d.username)] = pact
+	}
+}
+
+func (d *Diplomacy) HandleUpdate(du DiplomacyUpdate) {
+	defer fmt.Println("------------------------")
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	fmt.Println()
+	fmt.Println("==== Diplomacy ====")
+	if du.Rejected != "" {
+		fmt.Println("The server refused:", du.Rejected)
+		return
+	}
+	switch {
+	case du.Proposal != nil:
+		p := *du.Proposal
+		key := p.From + ">" + p.To
+		if du.Action == "proposed" {
+			d.proposals[key] = p
+		} else {
+			delete(d.proposals, key)
+		}
+		fmt.Println(describeProposal(d.username, du.Action, p))
+	case du.Pact != nil:
+		pact := *du.Pact
+		other := pact.With(d.username)
+		delete(d.proposals, d.username+">"+other)
+		delete(d.proposals, other+">"+d.username)
+		if du.Action == "agreed" {
+			d.pacts[other] = pact
+			fmt.Printf("Agreed: %s.\n", pact)
+			fmt.Printf("You and %s won't fight each other.\n", other)
+		} else if du.Action == "expired" {
+			delete(d.pacts, other)
+			fmt.Printf("The truce with %s ran out.\n", other)
+		} else {
+			delete(d.pacts, other)
+			fmt.Printf("%s broke the %s.\n", du.By, pact)
+		}
+	}
+}
+
+func describeProposal(username, action string, p Proposal) string {
+	what := "an alliance"
+	if p.Kind == PactTruce {
+		what = "a truce for " + p.Duration.String()
+	}
+	switch {
+	case action == "proposed" && p.From == username:
+		return fmt.Sprintf("You offered %s %s. It holds once they offer the same back.", p.To, what)
+	case action == "proposed":
+		accept := "ally " + p.From
+		if p.Kind == PactTruce {
+			accept = fmt.Sprintf("truce %s %s", p.From, p.Duration)
+		}
+		return fmt.Sprintf("%s offers you %s. Send `%s` to accept or `break %s` to decline.", p.From, what, accept, p.From)
+	case action == "withdrawn":
+		return fmt.Sprintf("%s withdrew the offer of %s.", p.From, what)
+	default:
+		return fmt.Sprintf("%s turned down the offer of %s.", p.To, what)
+	}
+}
+
+// CommandPacts lists this player's pacts and open proposals.
+func (d *Diplomacy) CommandPacts() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	lines := []string{}
+	for _, pact := range d.pacts {
+		if pact.Active(time.Now()) {
+			lines = append(lines, "* "+pact.String())
+		}
+	}
+	for _, p := range d.proposals {
+		lines = append(lines, "* "+describeProposal(d.username, "proposed", p))
+	}
+	if len(lines) == 0 {
+		fmt.Println("You have no pacts and no open offers.")
+		return
+	}
+	sort.Strings(lines)
+	fmt.Println(strings.Join(lines, "\n"))
+}
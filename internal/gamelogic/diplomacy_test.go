@@ -0,0 +1,148 @@
+package gamelogic
+
+import (
+	"testing"
+	"time"
+)
+
+func diplomacy(t *testing.T, w *World, cmd DiplomacyCommand) ([]DiplomacyUpdate, Outcome) {
+	t.Helper()
+	updates, out, err := w.Diplomacy(cmd)
+	if err != nil {
+		t.Fatalf("Diplomacy(%+v): %v", cmd, err)
+	}
+	return updates, out
+}
+
+// neighbours puts alice in a and bob in b under a pact of the given kind,
+// then moves alice in with bob.
+func neighbours(t *testing.T, kind DiplomacyAction, d time.Duration) *World {
+	t.Helper()
+	w := newTestWorld(t)
+	spawn(t, w, "alice", "a", RankInfantry)
+	spawn(t, w, "bob", "b", RankInfantry)
+	diplomacy(t, w, DiplomacyCommand{Username: "alice", Action: kind, With: "bob", Duration: d})
+	updates, _ := diplomacy(t, w, DiplomacyCommand{Username: "bob", Action: kind, With: "alice", Duration: d})
+	if len(updates) == 0 || updates[0].Action != "agreed" {
+		t.Fatalf("pact wasn't agreed: %+v", updates)
+	}
+	if out := move(t, w, "alice", "b", 1); len(out.Battles) != 0 {
+		t.Fatalf("moving in with a partner fought %+v", out.Battles)
+	}
+	return w
+}
+
+func TestBreakingAPactFights(t *testing.T) {
+	w := neighbours(t, DiplomacyAlly, 0)
+	_, out := diplomacy(t, w, DiplomacyCommand{Username: "bob", Action: DiplomacyBreak, With: "alice"})
+	if len(out.Battles) != 1 || out.Battles[0].Location != "b" {
+		t.Errorf("breaking the alliance fought %+v, want one battle in b", out.Battles)
+	}
+}
+
+func TestExpiredTruceFights(t *testing.T) {
+	w := neighbours(t, DiplomacyTruce, time.Minute)
+
+	updates, out := w.ExpireTruces(time.Now())
+	if len(updates) != 0 || len(out.Battles) != 0 {
+		t.Fatalf("a running truce expired: %+v %+v", updates, out.Battles)
+	}
+
+	updates, out = w.ExpireTruces(time.Now().Add(2 * time.Minute))
+	got := map[string]bool{}
+	for _, du := range updates {
+		if du.Action == "expired" {
+			got[du.Username] = true
+		}
+	}
+	if !got["alice"] || !got["bob"] {
+		t.Errorf("expiry told %v, want both players", got)
+	}
+	if len(out.Battles) != 1 || out.Battles[0].Location != "b" {
+		t.Errorf("the truce running out fought %+v, want one battle in b", out.Battles)
+	}
+	if len(w.Pacts()) != 0 {
+		t.Errorf("pacts left over: %v", w.Pacts())
+	}
+}
+
+func TestPactsNeedBothSides(t *testing.T) {
+	ally := func(from, to string) DiplomacyCommand {
+		return DiplomacyCommand{Username: from, Action: DiplomacyAlly, With: to}
+	}
+	truce := func(from, to string, d time.Duration) DiplomacyCommand {
+		return DiplomacyCommand{Username: from, Action: DiplomacyTruce, With: to, Duration: d}
+	}
+	breakOff := func(from, to string) DiplomacyCommand {
+		return DiplomacyCommand{Username: from, Action: DiplomacyBreak, With: to}
+	}
+
+	tests := []struct {
+		name   string
+		cmds   []DiplomacyCommand
+		last   string   // the last command's action, "" if it was refused
+		pact   PactKind // what holds afterwards, "" for nothing
+		length time.Duration
+	}{
+		{"offer", []DiplomacyCommand{ally("alice", "bob")}, "proposed", "", 0},
+		{"same offer twice", []DiplomacyCommand{ally("alice", "bob"), ally("alice", "bob")}, "proposed", "", 0},
+		{"accepted", []DiplomacyCommand{ally("alice", "bob"), ally("bob", "alice")}, "agreed", PactAlliance, 0},
+		{"answered with a truce", []DiplomacyCommand{ally("alice", "bob"), truce("bob", "alice", time.Minute)}, "proposed", "", 0},
+		{"shorter truce wins", []DiplomacyCommand{truce("alice", "bob", time.Hour), truce("bob", "alice", time.Minute)}, "agreed", PactTruce, time.Minute},
+		{"withdrawn", []DiplomacyCommand{ally("alice", "bob"), breakOff("alice", "bob"), ally("bob", "alice")}, "proposed", "", 0},
+		{"declined", []DiplomacyCommand{ally("alice", "bob"), breakOff("bob", "alice")}, "declined", "", 0},
+		{"broken", []DiplomacyCommand{ally("alice", "bob"), ally("bob", "alice"), breakOff("bob", "alice")}, "broken", "", 0},
+		{"ally twice", []DiplomacyCommand{ally("alice", "bob"), ally("bob", "alice"), ally("alice", "bob")}, "", PactAlliance, 0},
+		{"truce into alliance", []DiplomacyCommand{truce("alice", "bob", time.Hour), truce("bob", "alice", time.Hour), ally("alice", "bob"), ally("bob", "alice")}, "agreed", PactAlliance, 0},
+		{"truce without a duration", []DiplomacyCommand{truce("alice", "bob", 0)}, "", "", 0},
+		{"with yourself", []DiplomacyCommand{ally("alice", "alice")}, "", "", 0},
+		{"with a stranger", []DiplomacyCommand{ally("alice", "carol")}, "", "", 0},
+		{"nothing to break", []DiplomacyCommand{breakOff("alice", "bob")}, "", "", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := newTestWorld(t)
+			spawn(t, w, "alice", "a", RankInfantry)
+			spawn(t, w, "bob", "e", RankInfantry)
+
+			var updates []DiplomacyUpdate
+			var err error
+			for _, cmd := range tt.cmds {
+				updates, _, err = w.Diplomacy(cmd)
+			}
+			switch {
+			case tt.last == "" && err == nil:
+				t.Errorf("last command was allowed: %+v", updates)
+			case tt.last != "" && err != nil:
+				t.Errorf("last command was refused: %v", err)
+			case tt.last != "" && (len(updates) != 2 || updates[0].Action != tt.last || updates[1].Action != tt.last):
+				t.Errorf("updates %+v, want %s for both", updates, tt.last)
+			}
+
+			pacts := w.Pacts()
+			if tt.pact == "" {
+				if len(pacts) != 0 {
+					t.Errorf("pacts %v, want none", pacts)
+				}
+				return
+			}
+			if len(pacts) != 1 || pacts[0].Kind != tt.pact {
+				t.Fatalf("pacts %v, want a(n) %s", pacts, tt.pact)
+			}
+			if tt.length > 0 {
+				if left := time.Until(pacts[0].Until); left > tt.length || left < tt.length-time.Minute/2 {
+					t.Errorf("truce runs out in %s, want %s", left, tt.length)
+				}
+			}
+		})
+	}
+}
+
+func TestPartnersDontFight(t *testing.T) {
+	for _, kind := range []DiplomacyAction{DiplomacyAlly, DiplomacyTruce} {
+		w := neighbours(t, kind, time.Hour) // moves alice in with bob
+		if got := w.Scoreboard().Owners["b"]; got != "bob" {
+			t.Errorf("%s: b is owned by %q while they share it, want bob still", kind, got)
+		}
+	}
+}
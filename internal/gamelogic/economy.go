@@ -0,0 +1,53 @@
+package gamelogic
+
+import (
+	"fmt"
+	"time"
+)
+
+// StartingGold is what a new player gets when they first join.
+const StartingGold = 10
+
+// UnitCost is how much gold spawning a unit of rank takes.
+func UnitCost(rank UnitRank) int {
+	switch rank {
+	case RankArtillery:
+		return 6
+	case RankCavalry:
+		return 4
+	}
+	return 2
+}
+
+// Tick is one beat of the server's clock. Every tick the server pays each
+// player the income of the territories they hold, and broadcasts the tick.
+// What each player got only goes to them, as GoldReceived, since everyone's
+// income would show what they own.
+type Tick struct {
+	Number int
+	At     time.Time
+}
+
+// GoldReceived is gold added to a player's treasury: income from the
+// territories they held on a tick, or the starting gold (Tick 0).
+type GoldReceived struct {
+	Tick        int
+	Amount      int
+	Territories []Location `json:",omitempty"`
+}
+
+func (GoldReceived) EventName() string { return "gold_received" }
+
+func describeGold(e GoldReceived) string {
+	if e.Tick == 0 {
+		return fmt.Sprintf("received %d starting gold", e.Amount)
+	}
+	return fmt.Sprintf("earned %d gold from %s (tick %d)", e.Amount, joinLocations(e.Territories), e.Tick)
+}
+
+// GetGold is how much gold is in the player's treasury.
+func (gs *GameState) GetGold() int {
+	gs.mu.RLock()
+	defer gs.mu.RUnlock()
+	return gs.state.Gold
+}
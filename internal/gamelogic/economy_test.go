@@ -0,0 +1,115 @@
+package gamelogic
+
+import (
+	"testing"
+)
+
+func gold(t *testing.T, w *World, username string) int {
+	t.Helper()
+	for _, s := range w.Players() {
+		if s.Player.Username == username {
+			return s.Gold
+		}
+	}
+	t.Fatalf("%s isn't in the world", username)
+	return 0
+}
+
+func TestIncome(t *testing.T) {
+	tests := []struct {
+		name  string
+		moves []Location // alice's one unit walks these, starting in a
+		want  int        // what a tick then pays her
+	}{
+		{"home", nil, 1},
+		{"two plains", []Location{"b"}, 2},
+		{"up the mountain", []Location{"b", "c"}, 1 + 1 + 3},
+		{"and back", []Location{"b", "c", "b", "a"}, 1 + 1 + 3}, // owners keep what they leave
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := newTestWorld(t)
+			spawn(t, w, "alice", "a", RankInfantry)
+			for _, loc := range tt.moves {
+				move(t, w, "alice", loc, 1)
+			}
+			before := gold(t, w, "alice")
+			if _, _, ok := w.Tick(); !ok {
+				t.Fatal("Tick didn't tick")
+			}
+			if got := gold(t, w, "alice") - before; got != tt.want {
+				t.Errorf("earned %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNoIncomeWhilePausedOrOver(t *testing.T) {
+	w := newTestWorld(t)
+	spawn(t, w, "alice", "a", RankInfantry)
+
+	w.SetPaused(true)
+	if _, out, ok := w.Tick(); ok || len(out.Updates) > 0 {
+		t.Errorf("a paused world ticked: %+v", out)
+	}
+	w.SetPaused(false)
+	if tick, _, ok := w.Tick(); !ok || tick.Number != 1 {
+		t.Errorf("first tick after the pause is %d, want 1", tick.Number)
+	}
+
+	w.over = &GameOver{Winner: "alice"}
+	if _, _, ok := w.Tick(); ok {
+		t.Error("a finished game ticked")
+	}
+}
+
+func TestSpawnCosts(t *testing.T) {
+	tests := []struct {
+		ranks []UnitRank // spawned in a, in order
+		ok    bool       // whether the last one is allowed
+		left  int
+	}{
+		{[]UnitRank{RankInfantry}, true, StartingGold - 2},
+		{[]UnitRank{RankCavalry}, true, StartingGold - 4},
+		{[]UnitRank{RankArtillery}, true, StartingGold - 6},
+		{[]UnitRank{RankArtillery, RankCavalry}, true, 0},
+		{[]UnitRank{RankArtillery, RankArtillery}, false, StartingGold - 6},
+		{[]UnitRank{RankCavalry, RankCavalry, RankInfantry, RankInfantry}, false, 0},
+	}
+	for _, tt := range tests {
+		w := newTestWorld(t)
+		var err error
+		for _, rank := range tt.ranks {
+			_, err = w.Handle(Command{Username: "alice", Spawn: &SpawnCommand{Location: "a", Rank: rank}})
+		}
+		if (err == nil) != tt.ok {
+			t.Errorf("%v: last spawn err = %v, want ok %v", tt.ranks, err, tt.ok)
+		}
+		if got := gold(t, w, "alice"); got != tt.left {
+			t.Errorf("%v: %d gold left, want %d", tt.ranks, got, tt.left)
+		}
+	}
+}
+
+func TestSpawnOnlyWhereYouHold(t *testing.T) {
+	w := newTestWorld(t)
+	spawn(t, w, "alice", "a", RankInfantry)
+	spawn(t, w, "bob", "c", RankInfantry)
+	move(t, w, "alice", "b", 1)
+
+	tests := []struct {
+		loc Location
+		ok  bool
+	}{
+		{"a", true}, // still hers after she left
+		{"b", true},
+		{"c", false}, // bob's
+		{"d", false}, // nobody's
+	}
+	for _, tt := range tests {
+		_, err := w.Handle(Command{Username: "alice", Spawn: &SpawnCommand{Location: tt.loc, Rank: RankInfantry}})
+		if (err == nil) != tt.ok {
+			t.Errorf("spawn in %s: err = %v, want ok %v", tt.loc, err, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package gamelogic
+
+import (
+	"fmt"
+	"time"
+)
+
+// Event is something that happened to this player's game. Events are the
+// only way a GameState changes: they are appended to its log and folded
+// into the state by Reduce, so the log explains how the army got to where
+// it is.
+type Event interface {
+	EventName() string
+}
+
+type UnitSpawned struct {
+	Unit Unit
+	Cost int `json:",omitempty"` // gold taken from the treasury
+}
+
+type UnitMoved struct {
+	UnitIDs []int
+	To      Location
+}
+
+// UnitsDestroyed lists the units that were lost, not just where, so the
+// log keeps an audit of every unit that died.
+type UnitsDestroyed struct {
+	Location Location
+	UnitIDs  []int
+	Cause    string
+}
+
+// UnitsUpdated carries units as they are after a war: damaged, with more
+// XP, maybe promoted. Units that no longer exist are ignored.
+type UnitsUpdated struct {
+	Location Location
+	Units    []Unit
+	Cause    string
+}
+
+type Paused struct{}
+
+type Resumed struct{}
+
+func (UnitSpawned) EventName() string    { return "unit_spawned" }
+func (UnitMoved) EventName() string      { return "unit_moved" }
+func (UnitsDestroyed) EventName() string { return "units_destroyed" }
+func (UnitsUpdated) EventName() string   { return "units_updated" }
+func (Paused) EventName() string         { return "paused" }
+func (Resumed) EventName() string        { return "resumed" }
+
+// LoggedEvent is an event as it sits in the log.
+type LoggedEvent struct {
+	Seq   int // 1 for the first event
+	At    time.Time
+	Event Event
+}
+
+// State is everything the events of one player add up to. Gold is kept
+// out of Player, which is shown to other players.
+type State struct {
+	Player Player
+	Paused bool
+	Gold   int
+}
+
+// NewState is the state before any event.
+func NewState(username string) State {
+	return State{
+		Player: Player{
+			Username: username,
+			Units:    map[int]Unit{},
+		},
+	}
+}
+
+// Reduce applies one event to s and returns the new state. It never changes
+// s itself, so old states (and snapshots) stay valid. States share their
+// units map until an event changes the units, so pausing or getting gold
+// doesn't copy the whole army.
+func Reduce(s State, e Event) State {
+	switch e.(type) {
+	case UnitSpawned, UnitMoved, UnitsDestroyed, UnitsUpdated:
+		s.Player.Units = copyUnits(s.Player.Units)
+	}
+	apply(&s, e)
+	return s
+}
+
+// Fold applies events to s in order. Nobody sees the states in between, so
+// the units are copied once and then changed in place.
+func Fold(s State, events []LoggedEvent) State {
+	s.Player.Units = copyUnits(s.Player.Units)
+	for _, le := range events {
+		apply(&s, le.Event)
+	}
+	return s
+}
+
+// apply changes s in place, units map included.
+func apply(s *State, e Event) {
+	switch e := e.(type) {
+	case UnitSpawned:
+		s.Player.Units[e.Unit.ID] = upgradeUnit(e.Unit)
+		s.Gold -= e.Cost
+	case GoldReceived:
+		s.Gold += e.Amount
+	case UnitMoved:
+		for _, id := range e.UnitIDs {
+			if u, ok := s.Player.Units[id]; ok {
+				u.Location = e.To
+				s.Player.Units[id] = u
+			}
+		}
+	case UnitsDestroyed:
+		for _, id := range e.UnitIDs {
+			delete(s.Player.Units, id)
+		}
+	case UnitsUpdated:
+		for _, u := range e.Units {
+			if _, ok := s.Player.Units[u.ID]; ok {
+				s.Player.Units[u.ID] = u
+			}
+		}
+	case Paused:
+		s.Paused = true
+	case Resumed:
+		s.Paused = false
+	case Synced:
+		s.Paused = e.Paused
+		s.Gold = e.Gold
+		s.Player.Units = make(map[int]Unit, len(e.Player.Units))
+		for id, u := range e.Player.Units {
+			s.Player.Units[id] = upgradeUnit(u)
+		}
+	}
+}
+
+func copyUnits(units map[int]Unit) map[int]Unit {
+	out := make(map[int]Unit, len(units))
+	for id, u := range units {
+		out[id] = u
+	}
+	return out
+}
+
+// Replay rebuilds a player's state from scratch, e.g. from a saved log.
+// The same events always give the same state.
+func Replay(username string, events []LoggedEvent) State {
+	return Fold(NewState(username), events)
+}
+
+// Snapshot is the state right after event Seq, so rebuilding doesn't have
+// to fold the whole log.
+type Snapshot struct {
+	Seq   int
+	State State
+}
+
+// Describe is a one-line summary of e for the history command.
+func Describe(e Event) string {
+	switch e := e.(type) {
+	case UnitSpawned:
+		return fmt.Sprintf("spawned %s %d in %s for %d gold", e.Unit.Rank, e.Unit.ID, e.Unit.Location, e.Cost)
+	case UnitMoved:
+		return fmt.Sprintf("moved %v to %s", e.UnitIDs, e.To)
+	case UnitsDestroyed:
+		return fmt.Sprintf("lost %v in %s (%s)", e.UnitIDs, e.Location, e.Cause)
+	case UnitsUpdated:
+		ids := []int{}
+		for _, u := range e.Units {
+			ids = append(ids, u.ID)
+		}
+		return fmt.Sprintf("%v in %s came out of a war (%s)", ids, e.Location, e.Cause)
+	case Paused:
+		return "game paused"
+	case Resumed:
+		return "game resumed"
+	case Synced:
+		return fmt.Sprintf("synced with the server (%d units, %d gold)", len(e.Player.Units), e.Gold)
+	case GoldReceived:
+		return describeGold(e)
+	}
+	return e.EventName()
+}
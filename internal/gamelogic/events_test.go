@@ -0,0 +1,140 @@
+package gamelogic
+
+import (
+	"math/rand"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// randomEvents is a made-up but plausible log: spawns, moves, losses,
+// damage, gold, pauses and the odd sync from the server.
+func randomEvents(rng *rand.Rand, n int) []LoggedEvent {
+	locs := []Location{"americas", "europe", "africa", "asia"}
+	ranks := []UnitRank{RankInfantry, RankCavalry, RankArtillery}
+	nextID := 1
+	var events []LoggedEvent
+	for seq := 1; seq <= n; seq++ {
+		var e Event
+		switch rng.Intn(8) {
+		case 0, 1:
+			e = UnitSpawned{Unit: NewUnit(nextID, ranks[rng.Intn(len(ranks))], locs[rng.Intn(len(locs))]), Cost: 1 + rng.Intn(5)}
+			nextID++
+		case 2:
+			e = UnitMoved{UnitIDs: []int{1 + rng.Intn(nextID), 1 + rng.Intn(nextID)}, To: locs[rng.Intn(len(locs))]}
+		case 3:
+			e = UnitsDestroyed{Location: locs[rng.Intn(len(locs))], UnitIDs: []int{1 + rng.Intn(nextID)}, Cause: "war"}
+		case 4:
+			u := NewUnit(1+rng.Intn(nextID), RankCavalry, locs[rng.Intn(len(locs))])
+			u.HP, u.XP = 1, 3
+			e = UnitsUpdated{Location: u.Location, Units: []Unit{u}, Cause: "war"}
+		case 5:
+			e = GoldReceived{Tick: seq, Amount: rng.Intn(10)}
+		case 6:
+			if rng.Intn(2) == 0 {
+				e = Paused{}
+			} else {
+				e = Resumed{}
+			}
+		case 7:
+			if rng.Intn(4) != 0 {
+				e = GoldReceived{Tick: seq, Amount: 1}
+				break
+			}
+			e = Synced{
+				Player: Player{Username: "alice", Units: map[int]Unit{nextID: NewUnit(nextID, RankArtillery, "asia")}},
+				Paused: rng.Intn(2) == 0,
+				Gold:   rng.Intn(100),
+			}
+			nextID++
+		}
+		events = append(events, LoggedEvent{Seq: seq, At: time.Unix(int64(seq), 0), Event: e})
+	}
+	return events
+}
+
+func copyState(s State) State {
+	s.Player.Units = copyUnits(s.Player.Units)
+	return s
+}
+
+func TestFoldFromSnapshot(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for run := 0; run < 10; run++ {
+		events := randomEvents(rng, 3*SnapshotEvery+7)
+		want := Replay("alice", events)
+
+		// one Reduce at a time, the way GameState.apply does it
+		s := NewState("alice")
+		for _, le := range events {
+			s = Reduce(s, le.Event)
+		}
+		if !reflect.DeepEqual(s, want) {
+			t.Fatalf("run %d: reducing one at a time gave %+v, folding gave %+v", run, s, want)
+		}
+
+		// and from a snapshot taken after any event
+		for k := 0; k <= len(events); k++ {
+			snap := Snapshot{Seq: k, State: Replay("alice", events[:k])}
+			before := copyState(snap.State)
+			if got := Fold(snap.State, events[k:]); !reflect.DeepEqual(got, want) {
+				t.Fatalf("run %d: folding from snapshot %d gave %+v, want %+v", run, k, got, want)
+			}
+			if !reflect.DeepEqual(snap.State, before) {
+				t.Fatalf("run %d: folding changed snapshot %d", run, k)
+			}
+		}
+	}
+}
+
+func TestGameStateSnapshots(t *testing.T) {
+	events := randomEvents(rand.New(rand.NewSource(2)), 2*SnapshotEvery+5)
+	gs := NewGameState("alice", nil)
+	for _, le := range events {
+		gs.apply(le.Event)
+	}
+	if len(gs.snapshots) != 3 {
+		t.Fatalf("%d snapshots after %d events", len(gs.snapshots), len(events))
+	}
+	for _, snap := range gs.snapshots {
+		if want := Replay("alice", events[:snap.Seq]); !reflect.DeepEqual(snap.State, want) {
+			t.Errorf("snapshot %d is %+v, want %+v", snap.Seq, snap.State, want)
+		}
+	}
+
+	// undo back past a snapshot: each step must match replaying the rest
+	for n := len(events) - 1; n >= SnapshotEvery-2; n-- {
+		if _, err := gs.Undo(); err != nil {
+			t.Fatalf("Undo: %v", err)
+		}
+		if want := Replay("alice", events[:n]); !reflect.DeepEqual(gs.state, want) {
+			t.Fatalf("after undoing to %d events got %+v, want %+v", n, gs.state, want)
+		}
+	}
+}
+
+func TestReduceLeavesStateAlone(t *testing.T) {
+	s := NewState("alice")
+	s = Reduce(s, UnitSpawned{Unit: NewUnit(1, RankInfantry, "europe"), Cost: 1})
+	s = Reduce(s, UnitSpawned{Unit: NewUnit(2, RankCavalry, "asia"), Cost: 3})
+
+	events := []Event{
+		UnitSpawned{Unit: NewUnit(3, RankArtillery, "africa"), Cost: 5},
+		UnitMoved{UnitIDs: []int{1}, To: "asia"},
+		UnitsDestroyed{Location: "asia", UnitIDs: []int{2}},
+		UnitsUpdated{Location: "europe", Units: []Unit{{ID: 1, Rank: RankInfantry, Location: "europe", HP: 1, XP: 2}}},
+		GoldReceived{Amount: 4},
+		Paused{},
+		Synced{Player: Player{Username: "alice", Units: map[int]Unit{9: NewUnit(9, RankInfantry, "asia")}}},
+	}
+	for _, e := range events {
+		before := copyState(s)
+		next := Reduce(s, e)
+		if !reflect.DeepEqual(s, before) {
+			t.Errorf("Reduce(%s) changed its input", e.EventName())
+		}
+		if reflect.DeepEqual(next, before) {
+			t.Errorf("Reduce(%s) changed nothing", e.EventName())
+		}
+	}
+}
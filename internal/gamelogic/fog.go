@@ -0,0 +1,69 @@
+package gamelogic
+
+// MoveNotice is an accepted move as one player gets to see it. The server
+// sends each one on that player's own routing key.
+type MoveNotice struct {
+	Recipient string
+	Move      ArmyMove
+}
+
+// visibleLocked is every territory username can see: the ones they have
+// units in, and every territory bordering those.
+func (w *World) visibleLocked(username string) map[Location]bool {
+	visible := map[Location]bool{}
+	occupied := map[Location]bool{}
+	for _, u := range w.players[username].Player.Units {
+		// a territory may already be visible from next door, but its own
+		// neighbours still have to be added
+		if occupied[u.Location] {
+			continue
+		}
+		occupied[u.Location] = true
+		visible[u.Location] = true
+		if t, ok := w.worldMap.Territory(u.Location); ok {
+			for _, n := range t.Neighbours {
+				visible[n] = true
+			}
+		}
+	}
+	return visible
+}
+
+// noticesLocked works out who gets to see move. The mover sees all of it.
+// Anybody else only hears about it if they can see where it went, and
+// then only sees the mover's units in territories they can see.
+func (w *World) noticesLocked(move ArmyMove) []MoveNotice {
+	notices := []MoveNotice{{Recipient: move.Player.Username, Move: move}}
+	for _, name := range w.usernamesLocked() {
+		if name == move.Player.Username {
+			continue
+		}
+		visible := w.visibleLocked(name)
+		if !visible[move.ToLocation] {
+			continue
+		}
+		notices = append(notices, MoveNotice{Recipient: name, Move: ArmyMove{
+			Player:     redact(move.Player, visible),
+			Units:      move.Units, // all in ToLocation, which they can see
+			ToLocation: move.ToLocation,
+		}})
+	}
+	return notices
+}
+
+// redact keeps only p's units in visible territories.
+func redact(p Player, visible map[Location]bool) Player {
+	units := map[int]Unit{}
+	for id, u := range p.Units {
+		if visible[u.Location] {
+			units[id] = u
+		}
+	}
+	return Player{Username: p.Username, Units: units}
+}
+
+// inLocation keeps only p's units in loc. Wars only need those, so
+// recognitions don't give away the rest of either army.
+func inLocation(p Player, loc Location) Player {
+	return redact(p, map[Location]bool{loc: true})
+}
@@ -0,0 +1,145 @@
+package gamelogic
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestScoresUnderFog(t *testing.T) {
+	w := newTestWorld(t)
+	spawn(t, w, "alice", "a", RankInfantry)
+	out := spawn(t, w, "bob", "e", RankInfantry)
+
+	// both get bob's score, but alice can't see that he owns e
+	board, ok := scoresFor(out, "alice")
+	if !ok {
+		t.Fatal("alice wasn't sent the new scores")
+	}
+	if want := map[Location]string{"a": "alice"}; !reflect.DeepEqual(board.Owners, want) {
+		t.Errorf("alice sees owners %v, want %v", board.Owners, want)
+	}
+	board, ok = scoresFor(out, "bob")
+	if !ok {
+		t.Fatal("bob wasn't sent his scores")
+	}
+	if want := map[Location]string{"e": "bob"}; !reflect.DeepEqual(board.Owners, want) {
+		t.Errorf("bob sees owners %v, want %v", board.Owners, want)
+	}
+	if len(board.Scores) != 2 {
+		t.Errorf("bob sees %d scores, want everyone's", len(board.Scores))
+	}
+
+	// once alice is next to d she sees who owns it, and not e
+	move(t, w, "alice", "b", 1)
+	out = move(t, w, "alice", "c", 1)
+	board, ok = scoresFor(out, "alice")
+	if !ok {
+		t.Fatal("alice wasn't sent scores after moving")
+	}
+	if board.Owners["e"] != "" {
+		t.Errorf("alice sees e is owned by %s from c", board.Owners["e"])
+	}
+	for _, loc := range []Location{"a", "b", "c"} {
+		if board.Owners[loc] != "alice" {
+			t.Errorf("alice sees %s owned by %q, want her own", loc, board.Owners[loc])
+		}
+	}
+
+	// a player who joins gets their scores even if nothing changed
+	out = handle(t, w, Command{Username: "alice", Join: &JoinCommand{}})
+	if _, ok := scoresFor(out, "alice"); !ok {
+		t.Error("joining didn't send alice her scores")
+	}
+}
+
+func TestTickUnderFog(t *testing.T) {
+	w := newTestWorld(t)
+	spawn(t, w, "alice", "a", RankInfantry)
+	spawn(t, w, "bob", "e", RankInfantry)
+
+	tick, out, ok := w.Tick()
+	if !ok {
+		t.Fatal("Tick didn't tick")
+	}
+	if tick.Number != 1 {
+		t.Errorf("tick %d, want 1", tick.Number)
+	}
+	// the only word of anyone's income is their own gold update
+	paid := map[string]int{}
+	for _, u := range out.Updates {
+		if u.Gold == nil {
+			t.Errorf("unexpected update %+v", u)
+			continue
+		}
+		paid[u.Username] += u.Gold.Amount
+	}
+	if want := map[string]int{"alice": 1, "bob": 1}; !reflect.DeepEqual(paid, want) {
+		t.Errorf("paid %v, want %v", paid, want)
+	}
+}
+
+func TestMovesUnderFog(t *testing.T) {
+	w := newTestWorld(t)
+	spawn(t, w, "alice", "a", RankInfantry)
+	spawn(t, w, "alice", "a", RankInfantry)
+	move(t, w, "alice", "b", 2) // alice sees a, b and c
+	spawn(t, w, "bob", "e", RankInfantry)
+	spawn(t, w, "bob", "e", RankInfantry)
+
+	tests := []struct {
+		to        Location
+		aliceSees map[int]Location // bob's units in alice's notice; nil for no notice
+	}{
+		{"d", nil},
+		{"c", map[int]Location{1: "c"}}, // not unit 2, back in e
+	}
+	for _, tt := range tests {
+		out := move(t, w, "bob", tt.to, 1)
+		got := map[string]ArmyMove{}
+		for _, n := range out.Moves {
+			got[n.Recipient] = n.Move
+		}
+		if mv, ok := got["bob"]; !ok || len(mv.Player.Units) != 2 {
+			t.Errorf("bob to %s: bob's own notice is %+v, want all of his army", tt.to, mv)
+		}
+		mv, ok := got["alice"]
+		if tt.aliceSees == nil {
+			if ok {
+				t.Errorf("bob to %s: alice saw %+v", tt.to, mv)
+			}
+			continue
+		}
+		if !ok {
+			t.Errorf("bob to %s: alice didn't see it", tt.to)
+			continue
+		}
+		seen := map[int]Location{}
+		for id, u := range mv.Player.Units {
+			seen[id] = u.Location
+		}
+		if !reflect.DeepEqual(seen, tt.aliceSees) {
+			t.Errorf("bob to %s: alice sees %v, want %v", tt.to, seen, tt.aliceSees)
+		}
+	}
+}
+
+func TestRecognitionsOnlyShowTheWar(t *testing.T) {
+	w := newTestWorld(t)
+	spawn(t, w, "alice", "b", RankArtillery)
+	spawn(t, w, "bob", "d", RankInfantry)
+	spawn(t, w, "bob", "d", RankInfantry)
+	move(t, w, "bob", "c", 1)
+
+	out := move(t, w, "bob", "b", 1)
+	if len(out.Battles) != 1 || len(out.Battles[0].Wars) != 1 {
+		t.Fatalf("battles %+v, want one war", out.Battles)
+	}
+	rw := out.Battles[0].Wars[0].Recognition
+	for _, p := range []Player{rw.Attacker, rw.Defender} {
+		for id, u := range p.Units {
+			if u.Location != "b" {
+				t.Errorf("%s's unit %d in %s is in the recognition", p.Username, id, u.Location)
+			}
+		}
+	}
+}
@@ -17,21 +17,75 @@ type Unit struct {
 	ID       int
 	Rank     UnitRank
 	Location Location
+	HP       int // a unit with no HP left is destroyed
+	XP       int
+	Grade    UnitGrade
 }
 
+// ArmyMove is a move the server accepted, as the player it's sent to can
+// see it: only the mover's units in territories they can see.
 type ArmyMove struct {
 	Player     Player
 	Units      []Unit
 	ToLocation Location
 }
 
+// RecognitionOfWar is everything needed to settle a war. Given the same
+// recognition, the named combat resolver always gives the same result, so
+// anyone who receives it can check the server's dice.
 type RecognitionOfWar struct {
 	Attacker Player
 	Defender Player
+	Location Location `json:",omitempty"` // empty: the first shared location, by name
+	Terrain  Terrain  `json:",omitempty"`
+	Combat   string   `json:",omitempty"` // resolver name, empty for the default
+	Seed     int64
+	// units of the defender's allies fighting alongside it, by the ID they
+	// have in Defender for this war
+	Allies map[int]AlliedUnit `json:",omitempty"`
+}
+
+// AlliedUnit is whose a unit defending as an ally is, and its own ID.
+type AlliedUnit struct {
+	Username string
+	UnitID   int
+}
+
+// DefenderUnit is who owns the unit with id in rw.Defender, and its ID in
+// their army.
+func (rw RecognitionOfWar) DefenderUnit(id int) (string, int) {
+	if a, ok := rw.Allies[id]; ok {
+		return a.Username, a.UnitID
+	}
+	return rw.Defender.Username, id
+}
+
+// WarResult is the settled outcome of a war, sent to both sides. Draw means
+// both sides still have units there when the fighting stops.
+type WarResult struct {
+	Attacker       string
+	Defender       string
+	Location       Location
+	Winner         string
+	Loser          string
+	Draw           bool
+	AttackerLosses []int `json:",omitempty"` // unit IDs
+	DefenderLosses []int `json:",omitempty"`
+	// the units that fought and survived, as they are after the war
+	AttackerSurvivors []Unit `json:",omitempty"`
+	DefenderSurvivors []Unit `json:",omitempty"`
+	// allies who defended alongside the defender and share its fate; the
+	// recognition says which units were theirs
+	Allies []string `json:",omitempty"`
 }
 
 type Location string
 
+// AllRanks lists every unit rank, e.g. for documentation.
+func AllRanks() []UnitRank {
+	return []UnitRank{RankInfantry, RankCavalry, RankArtillery}
+}
+
 func getAllRanks() map[UnitRank]struct{} {
 	return map[UnitRank]struct{}{
 		RankInfantry:  {},
@@ -39,14 +93,3 @@ func getAllRanks() map[UnitRank]struct{} {
 		RankArtillery: {},
 	}
 }
-
-func getAllLocations() map[Location]struct{} {
-	return map[Location]struct{}{
-		"americas":   {},
-		"europe":     {},
-		"africa":     {},
-		"asia":       {},
-		"australia":  {},
-		"antarctica": {},
-	}
-}
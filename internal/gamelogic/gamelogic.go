@@ -7,6 +7,8 @@ import (
 	"math/rand"
 	"os"
 	"strings"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
 )
 
 func PrintClientHelp() {
@@ -17,7 +19,27 @@ func PrintClientHelp() {
 	fmt.Println("* spawn <location> <rank>")
 	fmt.Println("    example:")
 	fmt.Println("    spawn europe infantry")
+	fmt.Printf("    costs: infantry %d, cavalry %d, artillery %d gold\n", UnitCost(RankInfantry), UnitCost(RankCavalry), UnitCost(RankArtillery))
 	fmt.Println("* status")
+	fmt.Println("* orders")
+	fmt.Println("* scores")
+	fmt.Println("* ally <player>")
+	fmt.Println("* truce <player> <duration>")
+	fmt.Println("    example:")
+	fmt.Println("    truce bob 10m")
+	fmt.Println("* break <player>")
+	fmt.Println("* pacts")
+	fmt.Println("* say <message>")
+	fmt.Println("* whisper <player> <message>")
+	fmt.Println("* team <message>")
+	fmt.Println("    talks to your allies")
+	fmt.Println("* chat [n]")
+	fmt.Println("    shows the last n chat messages (default 20)")
+	fmt.Println("* map")
+	fmt.Println("* history")
+	fmt.Println("* save")
+	fmt.Println("* load")
+	fmt.Println("* metrics")
 	fmt.Println("* spam <n>")
 	fmt.Println("    example:")
 	fmt.Println("    spam 5")
@@ -28,26 +50,73 @@ func PrintClientHelp() {
 func ClientWelcome() (string, error) {
 	fmt.Println("Welcome to the Peril client!")
 	fmt.Println("Please enter your username:")
-	words := GetInput()
-	if len(words) == 0 {
-		return "", errors.New("you must enter a username. goodbye")
+	var username string
+	for {
+		words := GetInput()
+		if len(words) == 0 {
+			return "", errors.New("you must enter a username. goodbye")
+		}
+		// the username ends up in routing keys, so it can't be allowed to
+		// add words or wildcards to them
+		if err := routing.ValidateUsername(words[0]); err != nil {
+			fmt.Println("Sorry,", err)
+			fmt.Println("Please enter another username:")
+			continue
+		}
+		username = words[0]
+		break
 	}
-	username := words[0]
 	fmt.Printf("Welcome, %s!\n", username)
 	PrintClientHelp()
 	return username, nil
 }
 
+// PrintDevHelp lists the commands only available with -dev.
+func PrintDevHelp() {
+	fmt.Println("Development commands:")
+	fmt.Println("* undo")
+	fmt.Println("    drops your last event locally; anything already published stays published")
+}
+
 func PrintServerHelp() {
 	fmt.Println("Possible commands:")
-	fmt.Println("* pause")
-	fmt.Println("* resume")
+	fmt.Println("* pause [in <duration> | at <HH:MM>]")
+	fmt.Println("    example:")
+	fmt.Println("    pause in 5m")
+	fmt.Println("* resume [in <duration> | at <HH:MM>]")
+	fmt.Println("    example:")
+	fmt.Println("    resume at 18:00")
+	fmt.Println("* players")
+	fmt.Println("* scores")
+	fmt.Println("* pacts")
+	fmt.Println("* map")
+	fmt.Println("* save")
+	fmt.Println("* metrics")
 	fmt.Println("* quit")
 	fmt.Println("* help")
 }
 
+// promptStatus, when set, shows a short status in front of the prompt.
+var promptStatus func() string
+
+// SetPromptStatus sets a function whose non-empty result is shown in the
+// prompt, e.g. "[broker blocked] > ".
+func SetPromptStatus(f func() string) {
+	promptStatus = f
+}
+
+// Prompt returns the REPL prompt.
+func Prompt() string {
+	if promptStatus != nil {
+		if status := promptStatus(); status != "" {
+			return "[" + status + "] > "
+		}
+	}
+	return "> "
+}
+
 func GetInput() []string {
-	fmt.Print("> ")
+	fmt.Print(Prompt())
 	scanner := bufio.NewScanner(os.Stdin)
 	scanned := scanner.Scan()
 	if !scanned {
@@ -86,7 +155,30 @@ func (gs *GameState) CommandStatus() {
 
 	p := gs.GetPlayerSnap()
 	fmt.Printf("You are %s, and you have %d units.\n", p.Username, len(p.Units))
+	fmt.Printf("Treasury: %d gold\n", gs.GetGold())
 	for _, unit := range p.Units {
-		fmt.Printf("* %v: %v, %v\n", unit.ID, unit.Location, unit.Rank)
+		fmt.Printf("* %v: %v, %v (%s)\n", unit.ID, unit.Location, unit.Rank, unit.Condition())
+	}
+}
+
+// CommandHistory prints the event log, oldest first.
+func (gs *GameState) CommandHistory() {
+	events := gs.Events()
+	if len(events) == 0 {
+		fmt.Println("Nothing has happened yet.")
+		return
+	}
+	for _, le := range events {
+		fmt.Printf("%4d %s %s\n", le.Seq, le.At.Format("15:04:05"), Describe(le.Event))
+	}
+}
+
+// CommandUndo drops the last event.
+func (gs *GameState) CommandUndo() error {
+	le, err := gs.Undo()
+	if err != nil {
+		return err
 	}
+	fmt.Printf("Undid #%d: %s\n", le.Seq, Describe(le.Event))
+	return nil
 }
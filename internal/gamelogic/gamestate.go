@@ -1,75 +1,120 @@
 package gamelogic
 
 import (
+	"errors"
 	"sync"
+	"time"
 )
 
+// SnapshotEvery is how many events go by between snapshots.
+const SnapshotEvery = 20
+
+// GameState is one player's game, rebuilt from its event log. Nothing
+// changes it except apply; the current State is kept alongside so reads
+// don't have to fold the log every time.
 type GameState struct {
-	Player Player
-	Paused bool
-	mu     *sync.RWMutex
+	mu        *sync.RWMutex
+	state     State
+	events    []LoggedEvent
+	snapshots []Snapshot // snapshots[0] is the empty state, at Seq 0
+	worldMap  *Map       // never changes, so it isn't behind mu
+	diplomacy *Diplomacy // pacts aren't events, they come and go with the server
 }
 
-func NewGameState(username string) *GameState {
+func NewGameState(username string, m *Map) *GameState {
+	s := NewState(username)
 	return &GameState{
-		Player: Player{
-			Username: username,
-			Units:    map[int]Unit{},
-		},
-		Paused: false,
-		mu:     &sync.RWMutex{},
+		state:     s,
+		snapshots: []Snapshot{{Seq: 0, State: s}},
+		mu:        &sync.RWMutex{},
+		worldMap:  m,
+		diplomacy: NewDiplomacy(username),
 	}
 }
 
-func (gs *GameState) resumeGame() {
-	gs.mu.Lock()
-	defer gs.mu.Unlock()
-	gs.Paused = false
+// Diplomacy is this player's pacts and open offers.
+func (gs *GameState) Diplomacy() *Diplomacy {
+	return gs.diplomacy
 }
 
-func (gs *GameState) pauseGame() {
+// Map is the map this game is played on.
+func (gs *GameState) Map() *Map {
+	return gs.worldMap
+}
+
+// apply appends e to the log and folds it into the state.
+func (gs *GameState) apply(e Event) {
 	gs.mu.Lock()
 	defer gs.mu.Unlock()
-	gs.Paused = true
+	gs.applyLocked(e)
 }
 
-func (gs *GameState) isPaused() bool {
+func (gs *GameState) applyLocked(e Event) {
+	le := LoggedEvent{Seq: len(gs.events) + 1, At: time.Now(), Event: e}
+	gs.events = append(gs.events, le)
+	gs.state = Reduce(gs.state, e)
+	if le.Seq%SnapshotEvery == 0 {
+		gs.snapshots = append(gs.snapshots, Snapshot{Seq: le.Seq, State: gs.state})
+	}
+}
+
+// rebuildLocked recomputes the state from the latest snapshot and the
+// events after it.
+func (gs *GameState) rebuildLocked() {
+	snap := gs.snapshots[len(gs.snapshots)-1]
+	gs.state = Fold(snap.State, gs.events[snap.Seq:])
+}
+
+// Events returns a copy of the event log.
+func (gs *GameState) Events() []LoggedEvent {
 	gs.mu.RLock()
 	defer gs.mu.RUnlock()
-	return gs.Paused
+	return append([]LoggedEvent(nil), gs.events...)
 }
 
-func (gs *GameState) addUnit(u Unit) {
+// Undo drops the last event and rebuilds the state without it. It only
+// changes this client's view: anything already published stays published,
+// so it's meant for development.
+func (gs *GameState) Undo() (LoggedEvent, error) {
 	gs.mu.Lock()
 	defer gs.mu.Unlock()
-	gs.Player.Units[u.ID] = u
+	if len(gs.events) == 0 {
+		return LoggedEvent{}, errors.New("nothing to undo")
+	}
+	last := gs.events[len(gs.events)-1]
+	gs.events = gs.events[:len(gs.events)-1]
+	for len(gs.snapshots) > 1 && gs.snapshots[len(gs.snapshots)-1].Seq > len(gs.events) {
+		gs.snapshots = gs.snapshots[:len(gs.snapshots)-1]
+	}
+	gs.rebuildLocked()
+	return last, nil
 }
 
-func (gs *GameState) removeUnitsInLocation(loc Location) {
-	gs.mu.Lock()
-	defer gs.mu.Unlock()
-	for k, v := range gs.Player.Units {
-		if v.Location == loc {
-			delete(gs.Player.Units, k)
-		}
-	}
+func (gs *GameState) resumeGame() {
+	gs.apply(Resumed{})
 }
 
-func (gs *GameState) UpdateUnit(u Unit) {
-	gs.mu.Lock()
-	defer gs.mu.Unlock()
-	gs.Player.Units[u.ID] = u
+func (gs *GameState) pauseGame() {
+	gs.apply(Paused{})
+}
+
+func (gs *GameState) isPaused() bool {
+	gs.mu.RLock()
+	defer gs.mu.RUnlock()
+	return gs.state.Paused
 }
 
 func (gs *GameState) GetUsername() string {
-	return gs.Player.Username
+	gs.mu.RLock()
+	defer gs.mu.RUnlock()
+	return gs.state.Player.Username
 }
 
 func (gs *GameState) getUnitsSnap() []Unit {
 	gs.mu.RLock()
 	defer gs.mu.RUnlock()
 	Units := []Unit{}
-	for _, v := range gs.Player.Units {
+	for _, v := range gs.state.Player.Units {
 		Units = append(Units, v)
 	}
 	return Units
@@ -78,7 +123,7 @@ func (gs *GameState) getUnitsSnap() []Unit {
 func (gs *GameState) GetUnit(id int) (Unit, bool) {
 	gs.mu.RLock()
 	defer gs.mu.RUnlock()
-	u, ok := gs.Player.Units[id]
+	u, ok := gs.state.Player.Units[id]
 	return u, ok
 }
 
@@ -86,11 +131,11 @@ func (gs *GameState) GetPlayerSnap() Player {
 	gs.mu.RLock()
 	defer gs.mu.RUnlock()
 	Units := map[int]Unit{}
-	for k, v := range gs.Player.Units {
+	for k, v := range gs.state.Player.Units {
 		Units[k] = v
 	}
 	return Player{
-		Username: gs.Player.Username,
+		Username: gs.state.Player.Username,
 		Units:    Units,
 	}
 }
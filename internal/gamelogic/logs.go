@@ -30,3 +30,28 @@ func WriteLog(gamelog routing.GameLog) error {
 	}
 	return nil
 }
+
+const chatLogFile = "chat.log"
+
+// WriteChatLog archives a chat message next to the game log, including the
+// ones the server refused, with the reason.
+func WriteChatLog(msg ChatMessage) error {
+	f, err := os.OpenFile(chatLogFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("could not open chat log: %v", err)
+	}
+	defer f.Close()
+
+	to := string(msg.Channel)
+	if msg.Channel == ChatWhisper {
+		to = msg.To
+	}
+	str := fmt.Sprintf("%v %v -> %v: %v\n", msg.At.Format(time.RFC3339), msg.From, to, msg.Text)
+	if msg.Rejected != "" {
+		str = fmt.Sprintf("%v %v -> %v (refused: %v): %v\n", msg.At.Format(time.RFC3339), msg.From, to, msg.Rejected, msg.Text)
+	}
+	if _, err := f.WriteString(str); err != nil {
+		return fmt.Errorf("could not write to chat log: %v", err)
+	}
+	return nil
+}
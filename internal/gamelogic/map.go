@@ -0,0 +1,237 @@
+package gamelogic
+
+import (
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+type Terrain string
+
+const (
+	TerrainPlains    = "plains"
+	TerrainForest    = "forest"
+	TerrainMountains = "mountains"
+	TerrainDesert    = "desert"
+	TerrainJungle    = "jungle"
+	TerrainIce       = "ice"
+)
+
+func getAllTerrains() map[Terrain]struct{} {
+	return map[Terrain]struct{}{
+		TerrainPlains:    {},
+		TerrainForest:    {},
+		TerrainMountains: {},
+		TerrainDesert:    {},
+		TerrainJungle:    {},
+		TerrainIce:       {},
+	}
+}
+
+// Territory is one location on a map and the ones you can reach from it
+// in a single move. Income is what holding it earns every tick.
+type Territory struct {
+	Name       Location   `json:"name"`
+	Terrain    Terrain    `json:"terrain"`
+	Income     int        `json:"income"`
+	Neighbours []Location `json:"neighbours"`
+}
+
+// DefaultIncome is what a territory earns if its map doesn't say.
+const DefaultIncome = 1
+
+// Map is the board: its territories and which of them border each other.
+// Borders always go both ways. A Map never changes once it's loaded, so
+// it can be shared between goroutines.
+type Map struct {
+	Name        string
+	territories map[Location]Territory
+}
+
+type mapFile struct {
+	Name        string `json:"name"`
+	Territories []struct {
+		Territory
+		Income *int `json:"income"` // missing means DefaultIncome, 0 is allowed
+	} `json:"territories"`
+}
+
+//go:embed maps/world.json
+var defaultMapJSON []byte
+
+var defaultMap = mustParseMap(defaultMapJSON)
+
+func mustParseMap(data []byte) *Map {
+	m, err := ParseMap(data)
+	if err != nil {
+		panic("built-in map: " + err.Error())
+	}
+	return m
+}
+
+// DefaultMap is the built-in world map: the six continents.
+func DefaultMap() *Map {
+	return defaultMap
+}
+
+// LoadMap reads a JSON map file, see maps/world.json for the format.
+func LoadMap(path string) (*Map, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	m, err := ParseMap(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return m, nil
+}
+
+// ParseMap checks a map and fills in the way back for every border that
+// is only listed on one side.
+func ParseMap(data []byte) (*Map, error) {
+	var f mapFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("could not read map: %v", err)
+	}
+	if len(f.Territories) == 0 {
+		return nil, errors.New("map has no territories")
+	}
+
+	m := &Map{Name: f.Name, territories: map[Location]Territory{}}
+	for _, t := range f.Territories {
+		if t.Name == "" || strings.ContainsAny(string(t.Name), " \t\n") {
+			return nil, fmt.Errorf("%q is not a valid territory name", t.Name)
+		}
+		if _, ok := m.territories[t.Name]; ok {
+			return nil, fmt.Errorf("territory %s is listed twice", t.Name)
+		}
+		if t.Terrain == "" {
+			t.Terrain = TerrainPlains
+		}
+		if _, ok := getAllTerrains()[t.Terrain]; !ok {
+			return nil, fmt.Errorf("territory %s has unknown terrain %q", t.Name, t.Terrain)
+		}
+		income := DefaultIncome
+		if t.Income != nil {
+			income = *t.Income
+		}
+		if income < 0 {
+			return nil, fmt.Errorf("territory %s has negative income", t.Name)
+		}
+		m.territories[t.Name] = Territory{Name: t.Name, Terrain: t.Terrain, Income: income}
+	}
+
+	edges := map[Location]map[Location]struct{}{}
+	for _, t := range f.Territories {
+		for _, n := range t.Neighbours {
+			if _, ok := m.territories[n]; !ok {
+				return nil, fmt.Errorf("territory %s borders %s, which isn't on the map", t.Name, n)
+			}
+			if n == t.Name {
+				return nil, fmt.Errorf("territory %s can't border itself", t.Name)
+			}
+			for _, e := range [][2]Location{{t.Name, n}, {n, t.Name}} {
+				if edges[e[0]] == nil {
+					edges[e[0]] = map[Location]struct{}{}
+				}
+				edges[e[0]][e[1]] = struct{}{}
+			}
+		}
+	}
+	for loc, t := range m.territories {
+		for n := range edges[loc] {
+			t.Neighbours = append(t.Neighbours, n)
+		}
+		sortLocations(t.Neighbours)
+		m.territories[loc] = t
+	}
+	return m, nil
+}
+
+// Has reports whether loc is on the map.
+func (m *Map) Has(loc Location) bool {
+	_, ok := m.territories[loc]
+	return ok
+}
+
+func (m *Map) Territory(loc Location) (Territory, bool) {
+	t, ok := m.territories[loc]
+	return t, ok
+}
+
+// Locations lists every territory in alphabetical order.
+func (m *Map) Locations() []Location {
+	locations := make([]Location, 0, len(m.territories))
+	for loc := range m.territories {
+		locations = append(locations, loc)
+	}
+	sortLocations(locations)
+	return locations
+}
+
+// Adjacent reports whether a unit can move from a to b in one step.
+func (m *Map) Adjacent(a, b Location) bool {
+	for _, n := range m.territories[a].Neighbours {
+		if n == b {
+			return true
+		}
+	}
+	return false
+}
+
+// Path is the shortest route from one territory to another: every stop
+// after from, ending with to. It's nil if to can't be reached.
+func (m *Map) Path(from, to Location) []Location {
+	if !m.Has(from) || !m.Has(to) || from == to {
+		return nil
+	}
+	prev := map[Location]Location{from: ""}
+	queue := []Location{from}
+	for len(queue) > 0 {
+		loc := queue[0]
+		queue = queue[1:]
+		if loc == to {
+			break
+		}
+		for _, n := range m.territories[loc].Neighbours {
+			if _, seen := prev[n]; !seen {
+				prev[n] = loc
+				queue = append(queue, n)
+			}
+		}
+	}
+	if _, ok := prev[to]; !ok {
+		return nil
+	}
+
+	path := []Location{}
+	for loc := to; loc != from; loc = prev[loc] {
+		path = append([]Location{loc}, path...)
+	}
+	return path
+}
+
+func (m *Map) Print() {
+	fmt.Printf("Map: %s\n", m.Name)
+	for _, loc := range m.Locations() {
+		t := m.territories[loc]
+		fmt.Printf("* %s (%s, %d gold), borders %s\n", t.Name, t.Terrain, t.Income, joinLocations(t.Neighbours))
+	}
+}
+
+func sortLocations(locations []Location) {
+	sort.Slice(locations, func(i, j int) bool { return locations[i] < locations[j] })
+}
+
+func joinLocations(locations []Location) string {
+	words := make([]string, len(locations))
+	for i, loc := range locations {
+		words[i] = string(loc)
+	}
+	return strings.Join(words, ", ")
+}
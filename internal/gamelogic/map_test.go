@@ -0,0 +1,152 @@
+package gamelogic
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseMapRefuses(t *testing.T) {
+	tests := []struct {
+		name string
+		json string
+	}{
+		{"not JSON", `{`},
+		{"no territories", `{"name": "empty", "territories": []}`},
+		{"no name", `{"territories": [{"name": ""}]}`},
+		{"space in name", `{"territories": [{"name": "new york"}]}`},
+		{"listed twice", `{"territories": [{"name": "a"}, {"name": "a"}]}`},
+		{"unknown terrain", `{"territories": [{"name": "a", "terrain": "lava"}]}`},
+		{"negative income", `{"territories": [{"name": "a", "income": -1}]}`},
+		{"border off the map", `{"territories": [{"name": "a", "neighbours": ["b"]}]}`},
+		{"borders itself", `{"territories": [{"name": "a", "neighbours": ["a"]}]}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if m, err := ParseMap([]byte(tt.json)); err == nil {
+				t.Errorf("ParseMap accepted it: %+v", m)
+			}
+		})
+	}
+}
+
+func TestParseMap(t *testing.T) {
+	m, err := ParseMap([]byte(`{"name": "tiny", "territories": [
+		{"name": "a", "neighbours": ["b"]},
+		{"name": "b", "terrain": "ice", "income": 0},
+		{"name": "c", "neighbours": ["b", "a"]}
+	]}`))
+	if err != nil {
+		t.Fatalf("ParseMap: %v", err)
+	}
+	tests := []struct {
+		loc        Location
+		terrain    Terrain
+		income     int
+		neighbours []Location
+	}{
+		// borders listed on one side go both ways
+		{"a", TerrainPlains, DefaultIncome, []Location{"b", "c"}},
+		{"b", TerrainIce, 0, []Location{"a", "c"}},
+		{"c", TerrainPlains, DefaultIncome, []Location{"a", "b"}},
+	}
+	for _, tt := range tests {
+		terr, ok := m.Territory(tt.loc)
+		if !ok {
+			t.Errorf("%s is missing", tt.loc)
+			continue
+		}
+		if terr.Terrain != tt.terrain || terr.Income != tt.income || !reflect.DeepEqual(terr.Neighbours, tt.neighbours) {
+			t.Errorf("%s is %+v, want %s, %d gold, bordering %v", tt.loc, terr, tt.terrain, tt.income, tt.neighbours)
+		}
+	}
+	if got := m.Locations(); !reflect.DeepEqual(got, []Location{"a", "b", "c"}) {
+		t.Errorf("Locations() = %v", got)
+	}
+}
+
+func TestPath(t *testing.T) {
+	m, err := ParseMap([]byte(lineMapJSON))
+	if err != nil {
+		t.Fatal(err)
+	}
+	island, err := ParseMap([]byte(`{"territories": [{"name": "a", "neighbours": ["b"]}, {"name": "b"}, {"name": "x"}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		m        *Map
+		from, to Location
+		want     []Location
+	}{
+		{m, "a", "b", []Location{"b"}},
+		{m, "a", "e", []Location{"b", "c", "d", "e"}},
+		{m, "d", "b", []Location{"c", "b"}},
+		{m, "a", "a", nil},
+		{m, "a", "atlantis", nil},
+		{island, "a", "x", nil},
+	}
+	for _, tt := range tests {
+		if got := tt.m.Path(tt.from, tt.to); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Path(%s, %s) = %v, want %v", tt.from, tt.to, got, tt.want)
+		}
+	}
+}
+
+func TestMoveOnlyToNeighbours(t *testing.T) {
+	w := newTestWorld(t)
+	spawn(t, w, "alice", "a", RankInfantry)
+
+	tests := []struct {
+		to Location
+		ok bool
+	}{
+		{"c", false}, // two steps away
+		{"e", false},
+		{"a", false}, // already there
+		{"b", true},
+	}
+	for _, tt := range tests {
+		_, err := w.Handle(Command{Username: "alice", Move: &MoveCommand{UnitIDs: []int{1}, To: tt.to}})
+		if (err == nil) != tt.ok {
+			t.Errorf("move from a to %s: err = %v, want ok %v", tt.to, err, tt.ok)
+		}
+	}
+	if u := w.Players()[0].Player.Units[1]; u.Location != "b" {
+		t.Errorf("unit is in %s, want b", u.Location)
+	}
+}
+
+func TestCommandMoveSplitsLongMoves(t *testing.T) {
+	m, err := ParseMap([]byte(lineMapJSON))
+	if err != nil {
+		t.Fatal(err)
+	}
+	gs := NewGameState("alice", m)
+	gs.apply(UnitSpawned{Unit: NewUnit(1, RankInfantry, "a")})
+	gs.apply(UnitSpawned{Unit: NewUnit(2, RankInfantry, "a")})
+	gs.apply(UnitSpawned{Unit: NewUnit(3, RankInfantry, "c")})
+
+	tests := []struct {
+		words []string
+		want  []MoveCommand
+	}{
+		{[]string{"move", "b", "1", "2"}, []MoveCommand{{UnitIDs: []int{1, 2}, To: "b"}}},
+		{[]string{"move", "d", "1"}, []MoveCommand{{UnitIDs: []int{1}, To: "b"}, {UnitIDs: []int{1}, To: "c"}, {UnitIDs: []int{1}, To: "d"}}},
+		{[]string{"move", "b", "1", "3"}, []MoveCommand{{UnitIDs: []int{1, 3}, To: "b"}}},
+		{[]string{"move", "e", "1", "3"}, nil}, // two starting points, not all next to e
+		{[]string{"move", "a", "1"}, nil},      // already there
+		{[]string{"move", "atlantis", "1"}, nil},
+	}
+	for _, tt := range tests {
+		got, err := gs.CommandMove(tt.words)
+		if tt.want == nil {
+			if err == nil {
+				t.Errorf("%v was accepted: %+v", tt.words, got)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%v = %+v, %v; want %+v", tt.words, got, err, tt.want)
+		}
+	}
+}
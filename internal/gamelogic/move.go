@@ -12,6 +12,8 @@ const (
 	MoveOutcomeSamePlayer MoveOutcome = iota
 	MoveOutComeSafe
 	MoveOutcomeMakeWar
+	MoveOutcomeThreatened
+	MoveOutcomeAtPeace
 )
 
 func (gs *GameState) HandleMove(move ArmyMove) MoveOutcome {
@@ -29,64 +31,121 @@ func (gs *GameState) HandleMove(move ArmyMove) MoveOutcome {
 		return MoveOutcomeSamePlayer
 	}
 
-	overlappingLocation := getOverlappingLocation(player, move.Player)
-	if overlappingLocation != "" {
-		fmt.Printf("You have units in %s! You are at war with %s!\n", overlappingLocation, move.Player.Username)
+	if pact, ok := gs.diplomacy.AtPeace(move.Player.Username); ok {
+		fmt.Printf("You have a %s with %s; their units won't fight yours.\n", pact.Kind, move.Player.Username)
+		return MoveOutcomeAtPeace
+	}
+
+	if overlapping := getOverlappingLocations(player, move.Player); len(overlapping) > 0 {
+		fmt.Printf("You have units in %s! You are at war with %s!\n", joinLocations(overlapping), move.Player.Username)
+		fmt.Println("The server will settle the war.")
 		return MoveOutcomeMakeWar
 	}
+
+	if threatened := gs.threatenedBy(player, move.ToLocation); len(threatened) > 0 {
+		fmt.Printf("%s's units in %s border your units in %s!\n", move.Player.Username, move.ToLocation, joinLocations(threatened))
+		return MoveOutcomeThreatened
+	}
 	fmt.Printf("You are safe from %s's units.\n", move.Player.Username)
 	return MoveOutComeSafe
 }
 
-func getOverlappingLocation(p1 Player, p2 Player) Location {
+// threatenedBy lists the locations where the player has units that an
+// enemy in loc could reach with its next move.
+func (gs *GameState) threatenedBy(player Player, loc Location) []Location {
+	seen := map[Location]bool{}
+	threatened := []Location{}
+	for _, unit := range player.Units {
+		if !seen[unit.Location] && gs.worldMap.Adjacent(loc, unit.Location) {
+			seen[unit.Location] = true
+			threatened = append(threatened, unit.Location)
+		}
+	}
+	sortLocations(threatened)
+	return threatened
+}
+
+// getOverlappingLocations lists every location both players have units
+// in, sorted.
+func getOverlappingLocations(p1 Player, p2 Player) []Location {
+	seen := map[Location]bool{}
+	overlapping := []Location{}
 	for _, u1 := range p1.Units {
 		for _, u2 := range p2.Units {
-			if u1.Location == u2.Location {
-				return u1.Location
+			if u1.Location == u2.Location && !seen[u1.Location] {
+				seen[u1.Location] = true
+				overlapping = append(overlapping, u1.Location)
 			}
 		}
 	}
-	return ""
+	sortLocations(overlapping)
+	return overlapping
 }
 
-func (gs *GameState) CommandMove(words []string) (ArmyMove, error) {
+// CommandMove checks a move command against what this client knows and
+// returns it for the server, which has the final say. The units only move
+// once the server accepts it.
+//
+// Units only move to a neighbouring territory. If the destination is
+// further away and the units are all in one place, the move is split into
+// one command per step along the shortest path.
+func (gs *GameState) CommandMove(words []string) ([]MoveCommand, error) {
 	if gs.isPaused() {
-		return ArmyMove{}, errors.New("the game is paused, you can not move units")
+		return nil, errors.New("the game is paused, you can not move units")
 	}
 	if len(words) < 3 {
-		return ArmyMove{}, errors.New("usage: move <location> <unitID> <unitID> <unitID> etc")
+		return nil, errors.New("usage: move <location> <unitID> <unitID> <unitID> etc")
 	}
 	newLocation := Location(words[1])
-	locations := getAllLocations()
-	if _, ok := locations[newLocation]; !ok {
-		return ArmyMove{}, fmt.Errorf("error: %s is not a valid location", newLocation)
+	if !gs.worldMap.Has(newLocation) {
+		return nil, fmt.Errorf("error: %s is not a valid location", newLocation)
 	}
 	unitIDs := []int{}
 	for _, word := range words[2:] {
 		id := word
 		unitID, err := strconv.Atoi(id)
 		if err != nil {
-			return ArmyMove{}, fmt.Errorf("error: %s is not a valid unit ID", id)
+			return nil, fmt.Errorf("error: %s is not a valid unit ID", id)
 		}
 		unitIDs = append(unitIDs, unitID)
 	}
 
-	newUnits := []Unit{}
+	from := map[Location]struct{}{}
 	for _, unitID := range unitIDs {
 		unit, ok := gs.GetUnit(unitID)
 		if !ok {
-			return ArmyMove{}, fmt.Errorf("error: unit with ID %v not found", unitID)
+			return nil, fmt.Errorf("error: unit with ID %v not found", unitID)
+		}
+		if unit.Location == newLocation {
+			return nil, fmt.Errorf("error: unit %v is already in %s", unitID, newLocation)
+		}
+		from[unit.Location] = struct{}{}
+	}
+
+	adjacent := true
+	for loc := range from {
+		if !gs.worldMap.Adjacent(loc, newLocation) {
+			adjacent = false
 		}
-		unit.Location = newLocation
-		gs.UpdateUnit(unit)
-		newUnits = append(newUnits, unit)
+	}
+	if adjacent {
+		return []MoveCommand{{UnitIDs: unitIDs, To: newLocation}}, nil
 	}
 
-	mv := ArmyMove{
-		ToLocation: newLocation,
-		Units:      newUnits,
-		Player:     gs.GetPlayerSnap(),
+	if len(from) > 1 {
+		return nil, fmt.Errorf("error: %s doesn't border all of those units; move them one territory at a time", newLocation)
+	}
+	var start Location
+	for loc := range from {
+		start = loc
+	}
+	path := gs.worldMap.Path(start, newLocation)
+	if path == nil {
+		return nil, fmt.Errorf("error: there is no way from %s to %s", start, newLocation)
+	}
+	moves := []MoveCommand{}
+	for _, step := range path {
+		moves = append(moves, MoveCommand{UnitIDs: unitIDs, To: step})
 	}
-	fmt.Printf("Moved %v units to %s\n", len(mv.Units), mv.ToLocation)
-	return mv, nil
+	return moves, nil
 }
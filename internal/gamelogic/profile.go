@@ -0,0 +1,159 @@
+package gamelogic
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"time"
+)
+
+// ProfileVersion is bumped whenever the profile format changes in a way
+// older clients can't read, which includes adding an event type or
+// changing one. Version 2 added units_updated, gold_received and synced.
+const ProfileVersion = 2
+
+// oldestProfileVersion is the oldest profile this client still reads.
+// Version 1 profiles only have events this client still understands.
+const oldestProfileVersion = 1
+
+// profile is what a saved game looks like on disk: the player's event log,
+// with the State it folds into kept next to it so a profile can be read
+// without replaying anything, and to catch logs that were edited by hand.
+//
+// Since the server owns the world, a profile is the player's history, not
+// their army: whatever it says, the client asks the server for the army
+// after loading it, and the server's Synced replaces the state.
+type profile struct {
+	Version  int          `json:"version"`
+	Username string       `json:"username"`
+	SavedAt  time.Time    `json:"saved_at"`
+	State    State        `json:"state"`
+	Events   []savedEvent `json:"events"`
+}
+
+type savedEvent struct {
+	Seq  int             `json:"seq"`
+	At   time.Time       `json:"at"`
+	Type string          `json:"type"`
+	Data json.RawMessage `json:"data"`
+}
+
+// eventTypes maps EventName back to the event type when loading.
+var eventTypes = map[string]func() Event{
+	UnitSpawned{}.EventName():    func() Event { return &UnitSpawned{} },
+	UnitMoved{}.EventName():      func() Event { return &UnitMoved{} },
+	UnitsDestroyed{}.EventName(): func() Event { return &UnitsDestroyed{} },
+	UnitsUpdated{}.EventName():   func() Event { return &UnitsUpdated{} },
+	GoldReceived{}.EventName():   func() Event { return &GoldReceived{} },
+	Paused{}.EventName():         func() Event { return &Paused{} },
+	Resumed{}.EventName():        func() Event { return &Resumed{} },
+	Synced{}.EventName():         func() Event { return &Synced{} },
+}
+
+// ProfilePath is where username's profile lives in dir.
+func ProfilePath(dir, username string) string {
+	return filepath.Join(dir, username+".json")
+}
+
+// Save writes the game to path. It writes a temporary file first and
+// renames it, so a crash halfway never leaves a broken profile behind.
+func (gs *GameState) Save(path string) error {
+	gs.mu.RLock()
+	p := profile{
+		Version:  ProfileVersion,
+		Username: gs.state.Player.Username,
+		SavedAt:  time.Now(),
+		State:    gs.state,
+		Events:   make([]savedEvent, 0, len(gs.events)),
+	}
+	for _, le := range gs.events {
+		data, err := json.Marshal(le.Event)
+		if err != nil {
+			gs.mu.RUnlock()
+			return err
+		}
+		p.Events = append(p.Events, savedEvent{Seq: le.Seq, At: le.At, Type: le.Event.EventName(), Data: data})
+	}
+	gs.mu.RUnlock()
+
+	data, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("could not create profile directory: %v", err)
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return fmt.Errorf("could not write profile: %v", err)
+	}
+	return os.Rename(tmp, path)
+}
+
+// Load replaces the game with the profile at path, which has to belong to
+// the same player. A missing profile is reported with an error that
+// satisfies errors.Is(err, os.ErrNotExist).
+func (gs *GameState) Load(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var p profile
+	if err := json.Unmarshal(data, &p); err != nil {
+		return fmt.Errorf("could not read profile: %v", err)
+	}
+	if p.Version < oldestProfileVersion || p.Version > ProfileVersion {
+		return fmt.Errorf("profile version %d is not supported (this client reads versions %d to %d)", p.Version, oldestProfileVersion, ProfileVersion)
+	}
+
+	username := gs.GetUsername()
+	if p.Username != username {
+		return fmt.Errorf("profile belongs to %s, not %s", p.Username, username)
+	}
+
+	events := make([]LoggedEvent, 0, len(p.Events))
+	for i, se := range p.Events {
+		newEvent, ok := eventTypes[se.Type]
+		if !ok {
+			return fmt.Errorf("profile has an unknown event type %q", se.Type)
+		}
+		if se.Seq != i+1 {
+			return errors.New("profile events are out of order")
+		}
+		ptr := newEvent()
+		if err := json.Unmarshal(se.Data, ptr); err != nil {
+			return fmt.Errorf("could not read event %d: %v", se.Seq, err)
+		}
+		// the reducer works on values, not pointers
+		e := reflect.ValueOf(ptr).Elem().Interface().(Event)
+		events = append(events, LoggedEvent{Seq: se.Seq, At: se.At, Event: e})
+	}
+
+	// rebuild the snapshots the same way apply would have taken them
+	s := NewState(username)
+	snapshots := []Snapshot{{Seq: 0, State: s}}
+	for _, le := range events {
+		s = Reduce(s, le.Event)
+		if le.Seq%SnapshotEvery == 0 {
+			snapshots = append(snapshots, Snapshot{Seq: le.Seq, State: s})
+		}
+	}
+	// profiles from before units had HP replay with full HP
+	for id, u := range p.State.Player.Units {
+		p.State.Player.Units[id] = upgradeUnit(u)
+	}
+	if !reflect.DeepEqual(s, p.State) {
+		return errors.New("profile state doesn't match its event log")
+	}
+
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	gs.state = s
+	gs.events = events
+	gs.snapshots = snapshots
+	return nil
+}
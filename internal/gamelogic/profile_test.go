@@ -0,0 +1,105 @@
+package gamelogic
+
+import (
+	"encoding/json"
+	"errors"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func savedGame(t *testing.T, username string) (*GameState, string) {
+	t.Helper()
+	gs := NewGameState(username, nil)
+	for _, le := range randomEvents(rand.New(rand.NewSource(3)), SnapshotEvery+7) {
+		gs.apply(le.Event)
+	}
+	path := ProfilePath(t.TempDir(), username)
+	if err := gs.Save(path); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	return gs, path
+}
+
+func TestProfileRoundTrip(t *testing.T) {
+	saved, path := savedGame(t, "alice")
+
+	gs := NewGameState("alice", nil)
+	if err := gs.Load(path); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if !reflect.DeepEqual(gs.state, saved.state) {
+		t.Errorf("loaded %+v, saved %+v", gs.state, saved.state)
+	}
+	if len(gs.events) != len(saved.events) || len(gs.snapshots) != len(saved.snapshots) {
+		t.Errorf("loaded %d events and %d snapshots, saved %d and %d",
+			len(gs.events), len(gs.snapshots), len(saved.events), len(saved.snapshots))
+	}
+	if _, err := os.Stat(path + ".tmp"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("temporary file left behind: %v", err)
+	}
+}
+
+func TestProfileLoadRefuses(t *testing.T) {
+	// edit rewrites the saved profile before it's loaded
+	tests := []struct {
+		name     string
+		username string
+		edit     func(p map[string]any)
+	}{
+		{name: "somebody else's", username: "bob"},
+		{name: "too new", username: "alice", edit: func(p map[string]any) { p["version"] = ProfileVersion + 1 }},
+		{name: "too old", username: "alice", edit: func(p map[string]any) { p["version"] = 0 }},
+		{name: "state edited", username: "alice", edit: func(p map[string]any) {
+			p["state"].(map[string]any)["Gold"] = 1000000
+		}},
+		{name: "unknown event", username: "alice", edit: func(p map[string]any) {
+			p["events"].([]any)[0].(map[string]any)["type"] = "unit_teleported"
+		}},
+		{name: "events out of order", username: "alice", edit: func(p map[string]any) {
+			events := p["events"].([]any)
+			events[0], events[1] = events[1], events[0]
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, path := savedGame(t, "alice")
+			if tt.edit != nil {
+				data, err := os.ReadFile(path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				var p map[string]any
+				if err := json.Unmarshal(data, &p); err != nil {
+					t.Fatal(err)
+				}
+				tt.edit(p)
+				if data, err = json.Marshal(p); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(path, data, 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			gs := NewGameState(tt.username, nil)
+			before := gs.state
+			if err := gs.Load(path); err == nil {
+				t.Fatal("Load accepted the profile")
+			}
+			if !reflect.DeepEqual(gs.state, before) {
+				t.Errorf("a refused profile changed the state to %+v", gs.state)
+			}
+		})
+	}
+}
+
+func TestProfileMissing(t *testing.T) {
+	gs := NewGameState("alice", nil)
+	err := gs.Load(filepath.Join(t.TempDir(), "nobody.json"))
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Load of a missing profile: %v, want os.ErrNotExist", err)
+	}
+}
@@ -0,0 +1,367 @@
+package gamelogic
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Points a player scores for each territory they own, each war they win and
+// each enemy unit they destroy.
+const (
+	PointsPerTerritory = 3
+	PointsPerWarWon    = 2
+	PointsPerKill      = 1
+)
+
+// Score is how a player is doing. Territories is what they own right now;
+// wars won and units destroyed add up over the whole game.
+type Score struct {
+	Username       string
+	Territories    int
+	WarsWon        int
+	UnitsDestroyed int
+	Points         int
+}
+
+// Scoreboard is everyone's score, best first, and who owns each territory.
+// The server sends each player their own copy whenever it changes, with
+// Owners cut down to what they can see.
+type Scoreboard struct {
+	Scores []Score
+	Owners map[Location]string `json:",omitempty"`
+}
+
+// ScoresNotice is the scoreboard as one player gets to see it. The server
+// sends each one on that player's own routing key.
+type ScoresNotice struct {
+	Recipient string
+	Scores    Scoreboard
+}
+
+// Victory is what it takes to win. The zero Victory never ends the game.
+type Victory struct {
+	Territories int           // own at least this many territories...
+	HoldFor     time.Duration // ...for this long; 0 wins as soon as they do
+	Eliminate   bool          // or be the only player left who can fight
+}
+
+// GameOver is the end of the game. After it the server refuses every spawn
+// and move, until it's started with a new world.
+type GameOver struct {
+	Winner string
+	Reason string
+	At     time.Time
+	Scores Scoreboard
+}
+
+// claimLocked gives every territory with only one player's units in it to
+// that player. A territory stays with its owner when they leave it, until
+// somebody else holds it.
+func (w *World) claimLocked() {
+	for _, loc := range w.worldMap.Locations() {
+		if holder := w.holderLocked(loc); holder != "" {
+			w.owners[loc] = holder
+		}
+	}
+}
+
+// ownedLocked lists the territories username owns.
+func (w *World) ownedLocked(username string) []Location {
+	owned := []Location{}
+	for loc, owner := range w.owners {
+		if owner == username {
+			owned = append(owned, loc)
+		}
+	}
+	sortLocations(owned)
+	return owned
+}
+
+// scoreWarLocked adds a war's result to both sides' scores.
+func (w *World) scoreWarLocked(res WarResult) {
+	attacker, defender := w.stats[res.Attacker], w.stats[res.Defender]
+	attacker.UnitsDestroyed += len(res.DefenderLosses)
+	defender.UnitsDestroyed += len(res.AttackerLosses)
+	if !res.Draw {
+		if res.Winner == res.Attacker {
+			attacker.WarsWon++
+		} else {
+			defender.WarsWon++
+		}
+	}
+	w.stats[res.Attacker], w.stats[res.Defender] = attacker, defender
+}
+
+func (w *World) scoreboardLocked() Scoreboard {
+	board := Scoreboard{Scores: []Score{}, Owners: map[Location]string{}}
+	for loc, owner := range w.owners {
+		board.Owners[loc] = owner
+	}
+	for _, name := range w.usernamesLocked() {
+		s := w.stats[name]
+		s.Username = name
+		s.Territories = len(w.ownedLocked(name))
+		s.Points = s.Territories*PointsPerTerritory + s.WarsWon*PointsPerWarWon + s.UnitsDestroyed*PointsPerKill
+		board.Scores = append(board.Scores, s)
+	}
+	sort.SliceStable(board.Scores, func(i, j int) bool { return board.Scores[i].Points > board.Scores[j].Points })
+	return board
+}
+
+// scoresForLocked is board as username gets to see it: only the owners of
+// the territories they can see, or own themselves. Owners elsewhere would
+// give away where everyone's armies are.
+func (w *World) scoresForLocked(username string, board Scoreboard) Scoreboard {
+	visible := w.visibleLocked(username)
+	view := Scoreboard{Scores: board.Scores, Owners: map[Location]string{}}
+	for loc, owner := range board.Owners {
+		if visible[loc] || owner == username {
+			view.Owners[loc] = owner
+		}
+	}
+	return view
+}
+
+// settleLocked brings ownership up to date after a change, and adds the
+// scoreboard for every player whose view of it moved to out, and the end
+// of the game if somebody just won.
+func (w *World) settleLocked(out *Outcome, now time.Time) {
+	w.claimLocked()
+	board := w.scoreboardLocked()
+	for _, name := range w.usernamesLocked() {
+		view := w.scoresForLocked(name, board)
+		if last, ok := w.boards[name]; ok && sameScoreboard(view, last) {
+			continue
+		}
+		w.boards[name] = view
+		out.Scores = append(out.Scores, ScoresNotice{Recipient: name, Scores: view})
+	}
+	if w.over != nil {
+		return
+	}
+	if over, ok := w.victoryLocked(now); ok {
+		w.over = &over
+		out.GameOver = &over
+	}
+}
+
+func sameScoreboard(a, b Scoreboard) bool {
+	if len(a.Scores) != len(b.Scores) || len(a.Owners) != len(b.Owners) {
+		return false
+	}
+	for i := range a.Scores {
+		if a.Scores[i] != b.Scores[i] {
+			return false
+		}
+	}
+	for loc, owner := range a.Owners {
+		if b.Owners[loc] != owner {
+			return false
+		}
+	}
+	return true
+}
+
+// victoryLocked checks the victory conditions. A player who owns enough
+// territories starts a timer, which stops as soon as they own fewer.
+func (w *World) victoryLocked(now time.Time) (GameOver, bool) {
+	names := w.usernamesLocked()
+	if w.victory.Eliminate && len(names) > 1 {
+		alive := []string{}
+		for _, name := range names {
+			if !w.eliminatedLocked(name) {
+				alive = append(alive, name)
+			}
+		}
+		if len(alive) == 1 {
+			return w.gameOverLocked(alive[0], "was the last player standing", now), true
+		}
+	}
+
+	if w.victory.Territories == 0 {
+		return GameOver{}, false
+	}
+	for _, name := range names {
+		owned := len(w.ownedLocked(name))
+		if owned < w.victory.Territories {
+			delete(w.holding, name)
+			continue
+		}
+		since, ok := w.holding[name]
+		if !ok {
+			since = now
+			w.holding[name] = now
+		}
+		if now.Sub(since) >= w.victory.HoldFor {
+			reason := fmt.Sprintf("owned %d territories", owned)
+			if w.victory.HoldFor > 0 {
+				reason += " for " + w.victory.HoldFor.String()
+			}
+			return w.gameOverLocked(name, reason, now), true
+		}
+	}
+	return GameOver{}, false
+}
+
+// eliminatedLocked reports whether username is out of the game: no units
+// left, and no gold or nowhere to start again.
+func (w *World) eliminatedLocked(username string) bool {
+	s := w.players[username]
+	if len(s.Player.Units) > 0 {
+		return false
+	}
+	if s.Gold < UnitCost(RankInfantry) {
+		return true
+	}
+	for _, loc := range w.worldMap.Locations() {
+		if w.canStartInLocked(username, loc) {
+			return false
+		}
+	}
+	return true
+}
+
+// canStartInLocked reports whether a player without units may set up in
+// loc: nobody has units there and nobody else owns it.
+func (w *World) canStartInLocked(username string, loc Location) bool {
+	if owner := w.owners[loc]; owner != "" && owner != username {
+		return false
+	}
+	for _, s := range w.players {
+		for _, u := range s.Player.Units {
+			if u.Location == loc {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (w *World) gameOverLocked(winner, reason string, now time.Time) GameOver {
+	return GameOver{Winner: winner, Reason: reason, At: now, Scores: w.scoreboardLocked()}
+}
+
+// CheckVictory checks the victory conditions without anything else
+// happening, so a timed hold can end the game. It never ends a paused
+// game; the hold timers don't run during a pause.
+func (w *World) CheckVictory(now time.Time) (Outcome, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	var out Outcome
+	if w.paused || w.over != nil {
+		return out, false
+	}
+	w.settleLocked(&out, now)
+	return out, out.GameOver != nil
+}
+
+// Scoreboard is the current scores.
+func (w *World) Scoreboard() Scoreboard {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.claimLocked()
+	return w.scoreboardLocked()
+}
+
+// Over is the end of the game, or nil while it's still on.
+func (w *World) Over() *GameOver {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.over == nil {
+		return nil
+	}
+	over := *w.over
+	return &over
+}
+
+// Print shows the scores and, if owners is set, who owns which territory.
+func (b Scoreboard) Print(owners bool) {
+	if len(b.Scores) == 0 {
+		fmt.Println("Nobody has scored yet.")
+		return
+	}
+	for i, s := range b.Scores {
+		fmt.Printf("%d. %s: %d point(s) (owns %d, %d war(s) won, %d unit(s) destroyed)\n",
+			i+1, s.Username, s.Points, s.Territories, s.WarsWon, s.UnitsDestroyed)
+	}
+	if !owners || len(b.Owners) == 0 {
+		return
+	}
+	locations := []Location{}
+	for loc := range b.Owners {
+		locations = append(locations, loc)
+	}
+	sortLocations(locations)
+	fmt.Println("Territories:")
+	for _, loc := range locations {
+		fmt.Printf("* %s: %s\n", loc, b.Owners[loc])
+	}
+}
+
+// Standings is a client's view of the scores and whether the game is over.
+// Like the TurnClock it isn't saved with the GameState.
+type Standings struct {
+	mu       sync.Mutex
+	username string
+	board    Scoreboard
+	over     *GameOver
+}
+
+func NewStandings(username string) *Standings {
+	return &Standings{username: username}
+}
+
+// Over reports whether somebody has won.
+func (st *Standings) Over() bool {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return st.over != nil
+}
+
+func (st *Standings) HandleScores(b Scoreboard) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.board = b
+}
+
+func (st *Standings) HandleGameOver(over GameOver) {
+	defer fmt.Println("------------------------")
+	st.mu.Lock()
+	st.over = &over
+	st.board = over.Scores
+	st.mu.Unlock()
+
+	fmt.Println()
+	fmt.Println("==== Game Over ====")
+	fmt.Printf("%s %s!\n", over.Winner, over.Reason)
+	if over.Winner == st.username {
+		fmt.Println("You have won the game!")
+	} else {
+		fmt.Println("You have lost the game!")
+	}
+	over.Scores.Print(false)
+}
+
+// Sync picks up a game that was already over when this player joined.
+func (st *Standings) Sync(over *GameOver) {
+	if over == nil {
+		return
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.over = over
+	st.board = over.Scores
+	fmt.Printf("This game is over: %s %s.\n", over.Winner, over.Reason)
+}
+
+// CommandScores prints the latest scoreboard from the server.
+func (st *Standings) CommandScores() {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.over != nil {
+		fmt.Printf("Game over: %s %s.\n", st.over.Winner, st.over.Reason)
+	}
+	st.board.Print(true)
+}
@@ -0,0 +1,142 @@
+package gamelogic
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func usernames(board Scoreboard) []string {
+	names := []string{}
+	for _, s := range board.Scores {
+		names = append(names, s.Username)
+	}
+	return names
+}
+
+func TestScoreTies(t *testing.T) {
+	w := newTestWorld(t)
+	// joined in the opposite order, ties still go by username
+	spawn(t, w, "carol", "e", RankInfantry)
+	spawn(t, w, "bob", "c", RankInfantry)
+	spawn(t, w, "alice", "a", RankInfantry)
+	if got, want := usernames(w.Scoreboard()), []string{"alice", "bob", "carol"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("tied scores in order %v, want %v", got, want)
+	}
+
+	move(t, w, "carol", "d", 1)
+	if got, want := usernames(w.Scoreboard()), []string{"carol", "alice", "bob"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("scores in order %v, want %v", got, want)
+	}
+}
+
+func TestPoints(t *testing.T) {
+	w := newTestWorld(t)
+	spawn(t, w, "alice", "b", RankArtillery)
+	spawn(t, w, "bob", "c", RankInfantry)
+	move(t, w, "bob", "b", 1) // and loses
+
+	want := []Score{
+		{Username: "alice", Territories: 1, WarsWon: 1, UnitsDestroyed: 1,
+			Points: PointsPerTerritory + PointsPerWarWon + PointsPerKill},
+		{Username: "bob", Territories: 1, Points: PointsPerTerritory}, // c stays his
+	}
+	if got := w.Scoreboard().Scores; !reflect.DeepEqual(got, want) {
+		t.Errorf("scores %+v, want %+v", got, want)
+	}
+}
+
+func TestVictory(t *testing.T) {
+	tests := []struct {
+		name    string
+		victory Victory
+		play    func(t *testing.T, w *World) Outcome
+		winner  string // "" if nobody has won yet
+	}{
+		{
+			name:    "enough territories",
+			victory: Victory{Territories: 2},
+			play: func(t *testing.T, w *World) Outcome {
+				return move(t, w, "alice", "b", 1)
+			},
+			winner: "alice",
+		},
+		{
+			name:    "held too briefly",
+			victory: Victory{Territories: 2, HoldFor: time.Minute},
+			play: func(t *testing.T, w *World) Outcome {
+				return move(t, w, "alice", "b", 1)
+			},
+		},
+		{
+			name:    "held long enough",
+			victory: Victory{Territories: 2, HoldFor: time.Minute},
+			play: func(t *testing.T, w *World) Outcome {
+				move(t, w, "alice", "b", 1)
+				out, _ := w.CheckVictory(time.Now().Add(2 * time.Minute))
+				return out
+			},
+			winner: "alice",
+		},
+		{
+			name:    "lost the territory before the hold was up",
+			victory: Victory{Territories: 2, HoldFor: time.Minute},
+			play: func(t *testing.T, w *World) Outcome {
+				move(t, w, "alice", "b", 1)
+				move(t, w, "bob", "d", 1)
+				move(t, w, "bob", "c", 1)
+				move(t, w, "bob", "b", 1) // wipes alice out
+				out, _ := w.CheckVictory(time.Now().Add(2 * time.Minute))
+				return out
+			},
+			winner: "bob", // who has held b, c, d and e since
+		},
+		{
+			name:    "last player standing",
+			victory: Victory{Eliminate: true},
+			play: func(t *testing.T, w *World) Outcome {
+				// alice spends the rest of her gold and throws it all away
+				for i := 0; i < 3; i++ {
+					spawn(t, w, "alice", "a", RankInfantry)
+				}
+				var out Outcome
+				for _, loc := range []Location{"b", "c", "d", "e"} {
+					out = move(t, w, "alice", loc, 1, 2, 3, 4)
+				}
+				return out
+			},
+			winner: "bob",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := ParseMap([]byte(lineMapJSON))
+			if err != nil {
+				t.Fatal(err)
+			}
+			w := NewWorld(m, PowerResolver{}, tt.victory)
+			spawn(t, w, "alice", "a", RankCavalry)
+			spawn(t, w, "bob", "e", RankArtillery)
+
+			out := tt.play(t, w)
+			switch {
+			case tt.winner == "" && out.GameOver != nil:
+				t.Fatalf("%s won early: %+v", out.GameOver.Winner, out.GameOver)
+			case tt.winner == "":
+				return
+			case out.GameOver == nil:
+				t.Fatalf("nobody won, want %s", tt.winner)
+			case out.GameOver.Winner != tt.winner:
+				t.Fatalf("%s won, want %s", out.GameOver.Winner, tt.winner)
+			}
+
+			// and then nothing more happens
+			if _, err := w.Handle(Command{Username: "alice", Spawn: &SpawnCommand{Location: "a", Rank: RankInfantry}}); err == nil {
+				t.Error("spawned after the game was over")
+			}
+			if _, _, ok := w.Tick(); ok {
+				t.Error("ticked after the game was over")
+			}
+		})
+	}
+}
@@ -5,30 +5,32 @@ import (
 	"fmt"
 )
 
-func (gs *GameState) CommandSpawn(words []string) error {
+// CommandSpawn checks a spawn command and returns it for the server. The
+// unit only appears once the server accepts it, with an ID the server picks.
+func (gs *GameState) CommandSpawn(words []string) (SpawnCommand, error) {
 	if len(words) < 3 {
-		return errors.New("usage: spawn <location> <rank>")
+		return SpawnCommand{}, errors.New("usage: spawn <location> <rank>")
 	}
 
 	locationName := words[1]
-	locations := getAllLocations()
-	if _, ok := locations[Location(locationName)]; !ok {
-		return fmt.Errorf("error: %s is not a valid location", locationName)
+	if !gs.worldMap.Has(Location(locationName)) {
+		return SpawnCommand{}, fmt.Errorf("error: %s is not a valid location", locationName)
 	}
 
 	rank := words[2]
 	units := getAllRanks()
 	if _, ok := units[UnitRank(rank)]; !ok {
-		return fmt.Errorf("error: %s is not a valid unit", rank)
+		return SpawnCommand{}, fmt.Errorf("error: %s is not a valid unit", rank)
 	}
 
-	id := len(gs.getUnitsSnap()) + 1
-	gs.addUnit(Unit{
-		ID:       id,
-		Rank:     UnitRank(rank),
-		Location: Location(locationName),
-	})
+	// the server also checks nobody else is there, which we can't see
+	player := gs.GetPlayerSnap()
+	if len(player.Units) > 0 && len(unitsInLocation(player, Location(locationName))) == 0 {
+		return SpawnCommand{}, fmt.Errorf("error: you have no units in %s, spawn where your army is", locationName)
+	}
+	if cost, gold := UnitCost(UnitRank(rank)), gs.GetGold(); gold < cost {
+		return SpawnCommand{}, fmt.Errorf("error: a(n) %s costs %d gold and you have %d", rank, cost, gold)
+	}
 
-	fmt.Printf("Spawned a(n) %s in %s with id %v\n", rank, locationName, id)
-	return nil
+	return SpawnCommand{Location: Location(locationName), Rank: UnitRank(rank)}, nil
 }
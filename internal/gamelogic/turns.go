@@ -0,0 +1,239 @@
+package gamelogic
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// TurnStart opens a turn. Orders sent until EndsAt are queued and all
+// resolved together when the turn ends. A pause pushes EndsAt back, and
+// the server sends the turn again with the new time when the game resumes.
+type TurnStart struct {
+	Number   int
+	EndsAt   time.Time
+	Duration time.Duration
+}
+
+// TurnEnd closes a turn. The results of its orders follow as player
+// updates, moves and wars.
+type TurnEnd struct {
+	Number int
+	Orders int // how many orders were queued
+}
+
+// StartTurn switches the world to turn mode, if it isn't already, and
+// opens the next turn.
+func (w *World) StartTurn(d time.Duration) TurnStart {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.turn = TurnStart{Number: w.turn.Number + 1, EndsAt: time.Now().Add(d), Duration: d}
+	w.orders = nil
+	return w.turn
+}
+
+// CurrentTurn is the open turn; false in real-time mode.
+func (w *World) CurrentTurn() (TurnStart, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.turn, w.turn.Number > 0
+}
+
+// TurnDue reports whether the open turn is over. The clock doesn't run
+// while the game is paused, and stops for good once somebody has won.
+func (w *World) TurnDue(now time.Time) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.turn.Number > 0 && !w.paused && w.over == nil && !now.Before(w.turn.EndsAt)
+}
+
+// queueLocked checks an order against the world as it is now and keeps it
+// for the end of the turn, when it is checked again.
+func (w *World) queueLocked(cmd Command) (Outcome, error) {
+	switch {
+	case cmd.Spawn != nil:
+		if _, err := w.checkSpawnLocked(cmd.Username, *cmd.Spawn); err != nil {
+			return Outcome{}, err
+		}
+	case cmd.Move != nil:
+		if err := w.checkMoveLocked(cmd.Username, *cmd.Move); err != nil {
+			return Outcome{}, err
+		}
+		for _, order := range w.orders {
+			if order.Username != cmd.Username || order.Move == nil {
+				continue
+			}
+			for _, id := range cmd.Move.UnitIDs {
+				for _, ordered := range order.Move.UnitIDs {
+					if id == ordered {
+						return Outcome{}, fmt.Errorf("unit %v already has orders this turn", id)
+					}
+				}
+			}
+		}
+	}
+
+	w.orders = append(w.orders, cmd)
+	u := PlayerUpdate{Username: cmd.Username, Queued: fmt.Sprintf("%s (turn %d)", DescribeOrder(cmd), w.turn.Number)}
+	return Outcome{Updates: []PlayerUpdate{u}}, nil
+}
+
+// EndTurn resolves every order of the open turn at once: first all the
+// spawns, then all the moves, and only then the battles, so nobody gains
+// anything by ordering first. Orders that are no longer allowed are
+// rejected.
+func (w *World) EndTurn() (TurnEnd, Outcome, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.turn.Number == 0 {
+		return TurnEnd{}, Outcome{}, errors.New("the world isn't in turn mode")
+	}
+	if w.over != nil {
+		return TurnEnd{}, Outcome{}, errors.New("the game is over")
+	}
+
+	end := TurnEnd{Number: w.turn.Number, Orders: len(w.orders)}
+	orders := append([]Command(nil), w.orders...)
+	w.orders = nil
+	// spawns before moves, otherwise in the order they came in
+	sort.SliceStable(orders, func(i, j int) bool {
+		return orders[i].Spawn != nil && orders[j].Spawn == nil
+	})
+
+	var out Outcome
+	arrivals := map[Location]map[string]bool{} // who moved in where
+	for _, cmd := range orders {
+		res, err := w.runLocked(cmd, false)
+		if err != nil {
+			out.Updates = append(out.Updates, PlayerUpdate{
+				Username: cmd.Username,
+				Rejected: fmt.Sprintf("turn %d: %s: %v", end.Number, DescribeOrder(cmd), err),
+			})
+			continue
+		}
+		out.Updates = append(out.Updates, res.Updates...)
+		out.Moves = append(out.Moves, res.Moves...)
+		if cmd.Move != nil {
+			if arrivals[cmd.Move.To] == nil {
+				arrivals[cmd.Move.To] = map[string]bool{}
+			}
+			arrivals[cmd.Move.To][cmd.Username] = true
+		}
+	}
+
+	// then there's a battle wherever enemies share a territory, whether or
+	// not anybody moved there; whoever moved in attacks first
+	for _, loc := range w.worldMap.Locations() {
+		attackers := []string{}
+		for name := range arrivals[loc] {
+			attackers = append(attackers, name)
+		}
+		sort.Strings(attackers)
+		if b, ok := w.battleLocked(&out, loc, attackers); ok {
+			out.Battles = append(out.Battles, b)
+		}
+	}
+	w.settleLocked(&out, time.Now())
+	return end, out, nil
+}
+
+// DescribeOrder is a one-line summary of a spawn or move command.
+func DescribeOrder(cmd Command) string {
+	switch {
+	case cmd.Spawn != nil:
+		return fmt.Sprintf("spawn %s in %s", cmd.Spawn.Rank, cmd.Spawn.Location)
+	case cmd.Move != nil:
+		return fmt.Sprintf("move %v to %s", cmd.Move.UnitIDs, cmd.Move.To)
+	}
+	return "nothing"
+}
+
+// TurnClock is a client's view of the server's turns: whether the game is
+// in turn mode, when the turn ends, and the orders the server queued for
+// this player. It isn't part of the GameState since it's never saved.
+type TurnClock struct {
+	mu     sync.Mutex
+	turn   TurnStart
+	orders []string
+}
+
+func NewTurnClock() *TurnClock {
+	return &TurnClock{}
+}
+
+// Active reports whether the server is running turns.
+func (tc *TurnClock) Active() bool {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	return tc.turn.Number > 0
+}
+
+func (tc *TurnClock) HandleTurnStart(ts TurnStart) {
+	defer fmt.Println("------------------------")
+	tc.mu.Lock()
+	resumed := ts.Number == tc.turn.Number
+	if !resumed {
+		tc.orders = nil
+	}
+	tc.turn = ts
+	tc.mu.Unlock()
+
+	fmt.Println()
+	if resumed {
+		fmt.Printf("==== Turn %d Resumed ====\n", ts.Number)
+	} else {
+		fmt.Printf("==== Turn %d ====\n", ts.Number)
+	}
+	fmt.Printf("Send your orders before %s (%s left).\n", ts.EndsAt.Format("15:04:05"), time.Until(ts.EndsAt).Round(time.Second))
+}
+
+func (tc *TurnClock) HandleTurnEnd(te TurnEnd) {
+	defer fmt.Println("------------------------")
+	tc.mu.Lock()
+	// the next turn's start comes on its own queue and may have won the race
+	if te.Number == tc.turn.Number {
+		tc.orders = nil
+	}
+	tc.mu.Unlock()
+
+	fmt.Println()
+	fmt.Printf("==== Turn %d Over ====\n", te.Number)
+	fmt.Printf("Resolving %d order(s) from every player at once...\n", te.Orders)
+}
+
+// Sync picks up the turn that was running when this player joined.
+func (tc *TurnClock) Sync(ts *TurnStart) {
+	if ts == nil {
+		return
+	}
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	tc.turn = *ts
+}
+
+// AddOrder records an order the server queued.
+func (tc *TurnClock) AddOrder(desc string) {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	tc.orders = append(tc.orders, desc)
+}
+
+// CommandOrders lists this turn's queued orders.
+func (tc *TurnClock) CommandOrders() {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	if tc.turn.Number == 0 {
+		fmt.Println("The game isn't in turn mode; orders happen straight away.")
+		return
+	}
+	fmt.Printf("Turn %d ends at %s.\n", tc.turn.Number, tc.turn.EndsAt.Format("15:04:05"))
+	if len(tc.orders) == 0 {
+		fmt.Println("You have no orders this turn.")
+		return
+	}
+	for _, o := range tc.orders {
+		fmt.Printf("* %s\n", o)
+	}
+}
@@ -0,0 +1,128 @@
+package gamelogic
+
+import (
+	"testing"
+	"time"
+)
+
+func TestOrdersWaitForTheEndOfTheTurn(t *testing.T) {
+	w := newTestWorld(t)
+	spawn(t, w, "alice", "a", RankInfantry)
+	w.StartTurn(time.Minute)
+
+	out := move(t, w, "alice", "b", 1)
+	if len(out.Updates) != 1 || out.Updates[0].Queued == "" {
+		t.Fatalf("order wasn't queued: %+v", out.Updates)
+	}
+	if u := w.Players()[0].Player.Units[1]; u.Location != "a" {
+		t.Fatalf("unit moved to %s before the turn ended", u.Location)
+	}
+	if _, err := w.Handle(Command{Username: "alice", Move: &MoveCommand{UnitIDs: []int{1}, To: "b"}}); err == nil {
+		t.Error("a second order for the same unit was queued")
+	}
+
+	end, _, err := w.EndTurn()
+	if err != nil {
+		t.Fatalf("EndTurn: %v", err)
+	}
+	if end.Number != 1 || end.Orders != 1 {
+		t.Errorf("turn end %+v, want turn 1 with 1 order", end)
+	}
+	if u := w.Players()[0].Player.Units[1]; u.Location != "b" {
+		t.Errorf("unit is in %s after the turn, want b", u.Location)
+	}
+}
+
+func TestTurnOrderDoesNotMatter(t *testing.T) {
+	// alice and bob both head for c, and alice pulls her other unit back
+	// to a. Whoever orders first, they meet and fight in c.
+	orders := []Command{
+		{Username: "bob", Move: &MoveCommand{UnitIDs: []int{1}, To: "c"}},
+		{Username: "bob", Move: &MoveCommand{UnitIDs: []int{2}, To: "c"}},
+		{Username: "alice", Move: &MoveCommand{UnitIDs: []int{1}, To: "c"}},
+		{Username: "alice", Move: &MoveCommand{UnitIDs: []int{2}, To: "a"}},
+	}
+	tests := []struct {
+		name  string
+		order []int
+	}{
+		{"bob first", []int{0, 1, 2, 3}},
+		{"alice first", []int{2, 3, 0, 1}},
+		{"mixed", []int{3, 0, 2, 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := newTestWorld(t)
+			spawn(t, w, "alice", "b", RankInfantry)
+			spawn(t, w, "alice", "b", RankInfantry)
+			spawn(t, w, "bob", "d", RankInfantry)
+			spawn(t, w, "bob", "d", RankInfantry)
+			w.StartTurn(time.Minute)
+			for _, i := range tt.order {
+				handle(t, w, orders[i])
+			}
+			_, out, err := w.EndTurn()
+			if err != nil {
+				t.Fatalf("EndTurn: %v", err)
+			}
+			if len(out.Battles) != 1 || out.Battles[0].Location != "c" {
+				t.Fatalf("battles %+v, want one in c", out.Battles)
+			}
+			if players := out.Battles[0].Players; len(players) != 2 {
+				t.Errorf("battle in c between %v", players)
+			}
+		})
+	}
+}
+
+func TestOrdersCheckedAgainAtTheEnd(t *testing.T) {
+	w := newTestWorld(t)
+	spawn(t, w, "alice", "a", RankInfantry)
+	w.StartTurn(time.Minute)
+
+	// each is affordable on its own, not both
+	spawnOrder := Command{Username: "alice", Spawn: &SpawnCommand{Location: "a", Rank: RankArtillery}}
+	handle(t, w, spawnOrder)
+	handle(t, w, spawnOrder)
+
+	_, out, err := w.EndTurn()
+	if err != nil {
+		t.Fatalf("EndTurn: %v", err)
+	}
+	spawned, rejected := 0, 0
+	for _, u := range out.Updates {
+		if u.Spawned != nil {
+			spawned++
+		}
+		if u.Rejected != "" {
+			rejected++
+		}
+	}
+	if spawned != 1 || rejected != 1 {
+		t.Errorf("%d spawned and %d rejected, want one each", spawned, rejected)
+	}
+}
+
+func TestPauseFreezesTheTurn(t *testing.T) {
+	w := newTestWorld(t)
+	ts := w.StartTurn(time.Minute)
+
+	w.SetPaused(true)
+	if w.TurnDue(ts.EndsAt.Add(time.Second)) {
+		t.Error("turn ended during a pause")
+	}
+	w.pausedAt = w.pausedAt.Add(-time.Hour) // as if it's been paused an hour
+	if !w.SetPaused(false) {
+		t.Fatal("resuming didn't move the end of the turn")
+	}
+	turn, _ := w.CurrentTurn()
+	if pushed := turn.EndsAt.Sub(ts.EndsAt); pushed < time.Hour || pushed > time.Hour+time.Minute {
+		t.Errorf("turn end pushed back %s, want about an hour", pushed)
+	}
+	if w.TurnDue(ts.EndsAt.Add(time.Second)) {
+		t.Error("turn is due at its old end")
+	}
+	if !w.TurnDue(turn.EndsAt) {
+		t.Error("turn isn't due at its new end")
+	}
+}
@@ -0,0 +1,78 @@
+package gamelogic
+
+import "fmt"
+
+// UnitGrade is how experienced a unit is. Units start out regular and are
+// promoted as they gain XP from the wars they survive.
+type UnitGrade string
+
+const (
+	GradeRegular = "regular"
+	GradeVeteran = "veteran"
+	GradeElite   = "elite"
+)
+
+// XP needed for each promotion.
+const (
+	VeteranXP = 3
+	EliteXP   = 6
+)
+
+// AllGrades lists every unit grade, e.g. for documentation.
+func AllGrades() []UnitGrade {
+	return []UnitGrade{GradeRegular, GradeVeteran, GradeElite}
+}
+
+// NewUnit is a freshly spawned unit: full HP, no XP, regular.
+func NewUnit(id int, rank UnitRank, loc Location) Unit {
+	return Unit{ID: id, Rank: rank, Location: loc, HP: MaxHP(rank), Grade: GradeRegular}
+}
+
+// MaxHP is how many hits a unit of rank can take.
+func MaxHP(rank UnitRank) int {
+	switch rank {
+	case RankCavalry, RankArtillery:
+		return 3
+	}
+	return 2
+}
+
+// gradeBonus is what a unit's grade adds to its combat strength.
+func gradeBonus(grade UnitGrade) int {
+	switch grade {
+	case GradeElite:
+		return 2
+	case GradeVeteran:
+		return 1
+	}
+	return 0
+}
+
+// gainXP adds xp to u and promotes it if it has earned it.
+func gainXP(u Unit, xp int) Unit {
+	u.XP += xp
+	switch {
+	case u.XP >= EliteXP:
+		u.Grade = GradeElite
+	case u.XP >= VeteranXP && u.Grade != GradeElite:
+		u.Grade = GradeVeteran
+	}
+	return u
+}
+
+// upgradeUnit fills in the stats of a unit saved before units had them.
+// No living unit has 0 HP, so that always means "from an older file".
+func upgradeUnit(u Unit) Unit {
+	if u.HP == 0 {
+		u.HP = MaxHP(u.Rank)
+	}
+	if u.Grade == "" {
+		u.Grade = GradeRegular
+	}
+	return u
+}
+
+// Condition is a short description of a unit's health and experience.
+func (u Unit) Condition() string {
+	return fmt.Sprintf("%d/%d HP, %d XP, %s", u.HP, MaxHP(u.Rank), u.XP, u.Grade)
+}
@@ -0,0 +1,116 @@
+package gamelogic
+
+import (
+	"testing"
+)
+
+func TestGainXP(t *testing.T) {
+	tests := []struct {
+		xp, gain int
+		grade    UnitGrade
+		want     UnitGrade
+	}{
+		{0, 1, GradeRegular, GradeRegular},
+		{0, VeteranXP - 1, GradeRegular, GradeRegular},
+		{VeteranXP - 1, 1, GradeRegular, GradeVeteran},
+		{0, EliteXP, GradeRegular, GradeElite}, // straight past veteran
+		{EliteXP - 1, 1, GradeVeteran, GradeElite},
+		{EliteXP, 1, GradeElite, GradeElite},
+	}
+	for _, tt := range tests {
+		u := NewUnit(1, RankInfantry, "a")
+		u.XP, u.Grade = tt.xp, tt.grade
+		got := gainXP(u, tt.gain)
+		if got.XP != tt.xp+tt.gain || got.Grade != tt.want {
+			t.Errorf("%s with %d XP gaining %d: %d XP, %s; want %d XP, %s", tt.grade, tt.xp, tt.gain, got.XP, got.Grade, tt.xp+tt.gain, tt.want)
+		}
+	}
+}
+
+func TestNewUnitsAndOldFiles(t *testing.T) {
+	tests := []struct {
+		rank UnitRank
+		hp   int
+	}{
+		{RankInfantry, 2},
+		{RankCavalry, 3},
+		{RankArtillery, 3},
+	}
+	for _, tt := range tests {
+		u := NewUnit(1, tt.rank, "a")
+		if u.HP != tt.hp || u.XP != 0 || u.Grade != GradeRegular {
+			t.Errorf("new %s is %s, want %d HP, regular", tt.rank, u.Condition(), tt.hp)
+		}
+		// a unit saved before HP and grades comes back fresh
+		old := upgradeUnit(Unit{ID: 1, Rank: tt.rank, Location: "a"})
+		if old != u {
+			t.Errorf("old %s upgraded to %+v, want %+v", tt.rank, old, u)
+		}
+	}
+}
+
+// scratchResolver never decides anything: every unit takes one hit and
+// gains one XP, and is lost when it runs out of HP.
+type scratchResolver struct{}
+
+func (scratchResolver) Name() string { return "scratch" }
+
+func (scratchResolver) Resolve(rw RecognitionOfWar) WarResult {
+	res := newWarResult(rw)
+	res.Winner, res.Loser, res.Draw = rw.Attacker.Username, rw.Defender.Username, true
+	scratch := func(p Player) (losses []int, survivors []Unit) {
+		for _, u := range unitsInLocation(p, rw.Location) {
+			u.HP--
+			if u.HP == 0 {
+				losses = append(losses, u.ID)
+				continue
+			}
+			survivors = append(survivors, gainXP(u, 1))
+		}
+		return losses, survivors
+	}
+	res.AttackerLosses, res.AttackerSurvivors = scratch(rw.Attacker)
+	res.DefenderLosses, res.DefenderSurvivors = scratch(rw.Defender)
+	return res
+}
+
+func TestWoundsAndXPCarryOver(t *testing.T) {
+	m, err := ParseMap([]byte(lineMapJSON))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := NewWorld(m, scratchResolver{}, Victory{})
+	spawn(t, w, "alice", "b", RankInfantry)
+	spawn(t, w, "bob", "c", RankInfantry)
+
+	unit := func(username string) (Unit, bool) {
+		for _, s := range w.Players() {
+			if s.Player.Username == username {
+				u, ok := s.Player.Units[1]
+				return u, ok
+			}
+		}
+		return Unit{}, false
+	}
+
+	if out := move(t, w, "bob", "b", 1); len(out.Battles) != 1 {
+		t.Fatalf("moving in fought %d battles, want 1", len(out.Battles))
+	}
+	for _, name := range []string{"alice", "bob"} {
+		if u, ok := unit(name); !ok || u.HP != 1 || u.XP != 1 {
+			t.Errorf("%s's unit is %+v after one war, want 1 HP and 1 XP", name, u)
+		}
+	}
+
+	// walking away and back doesn't heal anybody
+	move(t, w, "bob", "c", 1)
+	if u, _ := unit("bob"); u.HP != 1 || u.XP != 1 || u.Location != "c" {
+		t.Errorf("bob's unit is %+v after moving, want 1 HP and 1 XP in c", u)
+	}
+	move(t, w, "bob", "b", 1)
+	for _, name := range []string{"alice", "bob"} {
+		if u, ok := unit(name); ok {
+			t.Errorf("%s's unit survived a second war with %+v", name, u)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package gamelogic
+
+import (
+	"fmt"
+)
+
+// HandleUpdate applies a change the server accepted for this player, or
+// reports why it refused one.
+func (gs *GameState) HandleUpdate(u PlayerUpdate) {
+	defer fmt.Println("------------------------")
+	fmt.Println()
+
+	if u.Rejected != "" {
+		fmt.Println("==== Command Rejected ====")
+		fmt.Println("The server refused your command:", u.Rejected)
+		return
+	}
+	if u.Queued != "" {
+		fmt.Println("==== Order Queued ====")
+		fmt.Println("It happens at the end of the turn:", u.Queued)
+		return
+	}
+
+	e := u.Event()
+	if e == nil {
+		fmt.Println("==== Empty Update ====")
+		return
+	}
+
+	fmt.Println("==== Server Update ====")
+	before := gs.GetPlayerSnap()
+	gs.apply(e)
+	switch e := e.(type) {
+	case UnitSpawned:
+		fmt.Printf("Spawned a(n) %s in %s with id %v for %d gold\n", e.Unit.Rank, e.Unit.Location, e.Unit.ID, e.Cost)
+	case UnitMoved:
+		fmt.Printf("Moved %v units to %s\n", len(e.UnitIDs), e.To)
+	case UnitsDestroyed:
+		fmt.Printf("You lost %d unit(s) in %s (%s).\n", len(e.UnitIDs), e.Location, e.Cause)
+	case UnitsUpdated:
+		fmt.Printf("Your units in %s came out of the war (%s):\n", e.Location, e.Cause)
+		for _, u := range e.Units {
+			fmt.Printf("* %v: %v, %s\n", u.ID, u.Rank, u.Condition())
+			if old, ok := before.Units[u.ID]; ok && old.Grade != u.Grade {
+				fmt.Printf("  promoted to %s!\n", u.Grade)
+			}
+		}
+	case GoldReceived:
+		fmt.Printf("You %s. Treasury: %d gold.\n", describeGold(e), gs.GetGold())
+	case Synced:
+		gs.diplomacy.Sync(e.Pacts)
+		fmt.Println(Describe(e))
+	default:
+		fmt.Println(Describe(e))
+	}
+}
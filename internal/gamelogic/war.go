@@ -1,105 +1,118 @@
 package gamelogic
 
 import (
+	"errors"
 	"fmt"
+	"sort"
+	"strings"
 )
 
-type WarOutcome int
-
-const (
-	WarOutcomeNotInvolved WarOutcome = iota
-	WarOutcomeNoUnits
-	WarOutcomeYouWon
-	WarOutcomeOpponentWon
-	WarOutcomeDraw
-)
+// ResolveWar settles rw with the resolver it names, using only what it
+// carries. The server builds the snapshots from its own world, never from
+// a client, and anyone with the recognition can call this to check the
+// result they were sent.
+func ResolveWar(rw RecognitionOfWar) (WarResult, error) {
+	if rw.Location == "" {
+		if overlapping := getOverlappingLocations(rw.Attacker, rw.Defender); len(overlapping) > 0 {
+			rw.Location = overlapping[0]
+		}
+	}
+	if rw.Location == "" {
+		return WarResult{}, errors.New("the players have no units in the same location")
+	}
+	r, err := CombatResolverByName(rw.Combat)
+	if err != nil {
+		return WarResult{}, err
+	}
+	return r.Resolve(rw), nil
+}
 
-func (gs *GameState) HandleWar(rw RecognitionOfWar) (outcome WarOutcome, winner string, loser string) {
+// HandleWarRecognition shows a war this player is in before the result
+// arrives, working out from the seed how it will end.
+func (gs *GameState) HandleWarRecognition(rw RecognitionOfWar) {
 	defer fmt.Println("------------------------")
 	fmt.Println()
 	fmt.Println("==== War Declared ====")
-	fmt.Printf("%s has declared war on %s!\n", rw.Attacker.Username, rw.Defender.Username)
-
-	player := gs.GetPlayerSnap()
-
-	if player.Username == rw.Defender.Username {
-		fmt.Printf("%s, you published the war.\n", player.Username)
-		return WarOutcomeNotInvolved, "", ""
+	fmt.Printf("%s attacked %s in %s (%s)\n", rw.Attacker.Username, rw.Defender.Username, rw.Location, rw.Terrain)
+	if allies := rw.alliedUsernames(); len(allies) > 0 {
+		fmt.Printf("Defending alongside %s: %s\n", rw.Defender.Username, strings.Join(allies, ", "))
 	}
 
-	if player.Username != rw.Attacker.Username {
-		fmt.Printf("%s, you are not involved in this war.\n", player.Username)
-		return WarOutcomeNotInvolved, "", ""
+	res, err := ResolveWar(rw)
+	if err != nil {
+		fmt.Println("Can't work out the result:", err)
+		return
+	}
+	losses := res.AttackerLosses
+	if gs.GetUsername() != res.Attacker {
+		losses = nil
+		for _, id := range res.DefenderLosses {
+			if owner, _ := rw.DefenderUnit(id); owner == gs.GetUsername() {
+				losses = append(losses, id)
+			}
+		}
+	}
+	combat := rw.Combat
+	if combat == "" {
+		combat = DefaultCombat
 	}
+	fmt.Printf("Worked out with %s combat (seed %d): you will lose %d unit(s).\n", combat, rw.Seed, len(losses))
+}
 
-	overlappingLocation := getOverlappingLocation(rw.Attacker, rw.Defender)
-	if overlappingLocation == "" {
-		fmt.Printf("Error! No units are in the same location. No war will be fought.\n")
-		return WarOutcomeNoUnits, "", ""
+// HandleWarResult shows how a war this player fought ended. The casualties
+// arrive separately, as a UnitsDestroyed update from the server.
+func (gs *GameState) HandleWarResult(res WarResult) {
+	defer fmt.Println("------------------------")
+	fmt.Println()
+	fmt.Println("==== War Result ====")
+
+	username := gs.GetUsername()
+	if res.Draw {
+		fmt.Printf("The war between %s and %s in %s ended in a draw!\n", res.Attacker, res.Defender, res.Location)
+	} else {
+		fmt.Printf("%s has won the war against %s in %s!\n", res.Winner, res.Loser, res.Location)
 	}
 
-	attackerUnits := []Unit{}
-	defenderUnits := []Unit{}
-	for _, unit := range rw.Attacker.Units {
-		if unit.Location == overlappingLocation {
-			attackerUnits = append(attackerUnits, unit)
-		}
+	ally := false
+	for _, name := range res.Allies {
+		ally = ally || name == username
 	}
-	for _, unit := range rw.Defender.Units {
-		if unit.Location == overlappingLocation {
-			defenderUnits = append(defenderUnits, unit)
-		}
+	if ally {
+		fmt.Printf("You defended alongside %s.\n", res.Defender)
 	}
 
-	fmt.Printf("%s's units:\n", rw.Attacker.Username)
-	for _, unit := range attackerUnits {
-		fmt.Printf("  * %v\n", unit.Rank)
-	}
-	fmt.Printf("%s's units:\n", rw.Defender.Username)
-	for _, unit := range defenderUnits {
-		fmt.Printf("  * %v\n", unit.Rank)
+	switch {
+	case res.Draw:
+		fmt.Println("Neither side was wiped out.")
+	case res.Winner == username, ally && res.Winner == res.Defender:
+		fmt.Println("You have won the war!")
+	default:
+		fmt.Println("You have lost the war!")
 	}
-	attackerPower := unitsToPowerLevel(attackerUnits)
-	defenderPower := unitsToPowerLevel(defenderUnits)
-	fmt.Printf("Attacker has a power level of %v\n", attackerPower)
-	fmt.Printf("Defender has a power level of %v\n", defenderPower)
-	if attackerPower > defenderPower {
-		fmt.Printf("%s has won the war!\n", rw.Attacker.Username)
-		if player.Username == rw.Defender.Username {
-			fmt.Println("You have lost the war!")
-			gs.removeUnitsInLocation(overlappingLocation)
-			fmt.Printf("Your units in %s have been killed.\n", overlappingLocation)
-			return WarOutcomeOpponentWon, rw.Attacker.Username, rw.Defender.Username
-		}
-		return WarOutcomeYouWon, rw.Attacker.Username, rw.Defender.Username
-	} else if defenderPower > attackerPower {
-		fmt.Printf("%s has won the war!\n", rw.Defender.Username)
-		if player.Username == rw.Attacker.Username {
-			fmt.Println("You have lost the war!")
-			gs.removeUnitsInLocation(overlappingLocation)
-			fmt.Printf("Your units in %s have been killed.\n", overlappingLocation)
-			return WarOutcomeOpponentWon, rw.Defender.Username, rw.Attacker.Username
+
+	fmt.Printf("%s lost %d unit(s), %s lost %d unit(s).\n", res.Attacker, len(res.AttackerLosses), res.Defender, len(res.DefenderLosses))
+}
+
+// alliedUsernames lists who defended alongside the defender.
+func (rw RecognitionOfWar) alliedUsernames() []string {
+	seen := map[string]bool{}
+	names := []string{}
+	for _, a := range rw.Allies {
+		if !seen[a.Username] {
+			seen[a.Username] = true
+			names = append(names, a.Username)
 		}
-		return WarOutcomeYouWon, rw.Defender.Username, rw.Attacker.Username
 	}
-	fmt.Println("The war ended in a draw!")
-	fmt.Printf("Your units in %s have been killed.\n", overlappingLocation)
-	gs.removeUnitsInLocation(overlappingLocation)
-	return WarOutcomeDraw, rw.Attacker.Username, rw.Defender.Username
+	sort.Strings(names)
+	return names
 }
 
-func unitsToPowerLevel(units []Unit) int {
-	power := 0
-	for _, unit := range units {
-		if unit.Rank == RankArtillery {
-			power += 10
-		}
-		if unit.Rank == RankCavalry {
-			power += 5
-		}
-		if unit.Rank == RankInfantry {
-			power += 1
+func unitsInLocation(p Player, loc Location) []Unit {
+	units := []Unit{}
+	for _, unit := range p.Units {
+		if unit.Location == loc {
+			units = append(units, unit)
 		}
 	}
-	return power
+	return units
 }
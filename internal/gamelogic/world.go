@@ -0,0 +1,588 @@
+package gamelogic
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+)
+
+// World is the server's copy of every player's game. Commands are checked
+// against it, never against what a client says it has, and every change is
+// one of the same events a client's GameState is built from.
+type World struct {
+	mu       sync.Mutex
+	saveMu   sync.Mutex // one Save at a time, they share the temporary file
+	worldMap *Map
+	combat   CombatResolver
+	players  map[string]State
+	nextID   map[string]int // next unit ID per player, so IDs are never reused
+	paused   bool
+	pausedAt time.Time
+	tick     int // number of the last tick
+
+	// who owns what, and the scores that don't come from owning things
+	victory Victory
+	owners  map[Location]string
+	stats   map[string]Score
+	holding map[string]time.Time  // since when each player has owned enough to win
+	boards  map[string]Scoreboard // the last scoreboard sent to each player
+	over    *GameOver
+
+	// diplomacy: pacts by pairKey, and offers by from>to
+	pacts           map[string]Pact
+	proposals       map[string]Proposal
+	combinedDefence bool
+	pactEnded       bool // a pact ended during a pause, so look for battles after it
+
+	// turn mode: the open turn (Number 0 in real-time mode) and the
+	// orders waiting for its end
+	turn   TurnStart
+	orders []Command
+}
+
+func NewWorld(m *Map, combat CombatResolver, victory Victory) *World {
+	return &World{
+		worldMap:  m,
+		combat:    combat,
+		victory:   victory,
+		players:   map[string]State{},
+		nextID:    map[string]int{},
+		owners:    map[Location]string{},
+		stats:     map[string]Score{},
+		holding:   map[string]time.Time{},
+		boards:    map[string]Scoreboard{},
+		pacts:     map[string]Pact{},
+		proposals: map[string]Proposal{},
+	}
+}
+
+// SetCombinedDefence lets allies with units where a player is attacked
+// defend alongside them, as one army.
+func (w *World) SetCombinedDefence(on bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.combinedDefence = on
+}
+
+// Map is the map the world is played on.
+func (w *World) Map() *Map {
+	return w.worldMap
+}
+
+// Outcome is everything that follows from one accepted command, or from
+// the end of a turn.
+type Outcome struct {
+	Updates  []PlayerUpdate
+	Moves    []MoveNotice   // accepted moves, as each player who can see them sees them
+	Battles  []Battle       // one per location fought over
+	Scores   []ScoresNotice // for each player whose view of the scores changed
+	GameOver *GameOver      // set if somebody just won
+}
+
+// War is a war the server fought: who was involved, as the server saw them
+// when it started, and how it ended.
+type War struct {
+	Recognition RecognitionOfWar
+	Result      WarResult
+}
+
+// Handle checks cmd against the world and applies it. A command that isn't
+// allowed changes nothing and comes back as an error to pass on to the
+// player.
+func (w *World) Handle(cmd Command) (Outcome, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if err := routing.ValidateUsername(cmd.Username); err != nil {
+		return Outcome{}, err
+	}
+
+	var out Outcome
+	switch {
+	case cmd.Join != nil:
+		s := w.playerLocked(&out, cmd.Username)
+		synced := Synced{Player: s.Player, Paused: w.paused, Gold: s.Gold, Over: w.over, Pacts: w.pactsLocked(cmd.Username)}
+		if w.turn.Number > 0 {
+			turn := w.turn
+			synced.Turn = &turn
+		}
+		out.Updates = append(out.Updates, updateFor(cmd.Username, synced))
+		// the new player needs the scores even if they didn't change
+		delete(w.boards, cmd.Username)
+		w.settleLocked(&out, time.Now())
+		return out, nil
+
+	case cmd.Spawn != nil, cmd.Move != nil:
+		if w.over != nil {
+			return Outcome{}, fmt.Errorf("the game is over, %s won", w.over.Winner)
+		}
+		if w.turn.Number > 0 {
+			return w.queueLocked(cmd)
+		}
+		out, err := w.runLocked(cmd, true)
+		if err != nil {
+			return Outcome{}, err
+		}
+		w.settleLocked(&out, time.Now())
+		return out, nil
+	}
+	return Outcome{}, errors.New("empty command")
+}
+
+// runLocked checks a spawn or move and applies it. A move that runs into
+// other players starts a battle straight away if fight is set; at the end
+// of a turn the battles wait until every order has moved.
+func (w *World) runLocked(cmd Command, fight bool) (Outcome, error) {
+	var out Outcome
+	switch {
+	case cmd.Spawn != nil:
+		cost, err := w.checkSpawnLocked(cmd.Username, *cmd.Spawn)
+		if err != nil {
+			return Outcome{}, err
+		}
+		w.spawnLocked(&out, cmd.Username, *cmd.Spawn, cost)
+
+	case cmd.Move != nil:
+		if err := w.checkMoveLocked(cmd.Username, *cmd.Move); err != nil {
+			return Outcome{}, err
+		}
+		w.moveLocked(&out, cmd.Username, *cmd.Move)
+		if !fight {
+			break
+		}
+		if b, ok := w.battleLocked(&out, cmd.Move.To, []string{cmd.Username}); ok {
+			out.Battles = append(out.Battles, b)
+		}
+	}
+	return out, nil
+}
+
+// checkSpawnLocked returns what the spawn costs, or why it isn't allowed.
+func (w *World) checkSpawnLocked(username string, sp SpawnCommand) (int, error) {
+	if !w.worldMap.Has(sp.Location) {
+		return 0, fmt.Errorf("%s is not a valid location", sp.Location)
+	}
+	if _, ok := getAllRanks()[sp.Rank]; !ok {
+		return 0, fmt.Errorf("%s is not a valid unit", sp.Rank)
+	}
+
+	// check against the state the player would have after joining, so a
+	// refused spawn doesn't add them to the world
+	s, ok := w.players[username]
+	if !ok {
+		s = NewState(username)
+		s.Gold = StartingGold
+	}
+	switch {
+	case len(s.Player.Units) == 0:
+		// a player with no army may set up anywhere that's empty and
+		// nobody else owns
+		if !w.canStartInLocked(username, sp.Location) {
+			return 0, fmt.Errorf("%s is taken, start somewhere nobody owns or has units", sp.Location)
+		}
+	case w.owners[sp.Location] != username:
+		return 0, fmt.Errorf("you can only spawn in territories you own, and you don't own %s", sp.Location)
+	case w.enemiesInLocked(username, sp.Location):
+		return 0, fmt.Errorf("there are enemy units in %s", sp.Location)
+	}
+	cost := UnitCost(sp.Rank)
+	if s.Gold < cost {
+		return 0, fmt.Errorf("a(n) %s costs %d gold and you have %d", sp.Rank, cost, s.Gold)
+	}
+	return cost, nil
+}
+
+func (w *World) spawnLocked(out *Outcome, username string, sp SpawnCommand, cost int) {
+	w.playerLocked(out, username)
+	w.nextID[username]++
+	unit := NewUnit(w.nextID[username], sp.Rank, sp.Location)
+	w.applyLocked(out, username, UnitSpawned{Unit: unit, Cost: cost})
+}
+
+// holderLocked is the player holding loc: the only one with units there.
+// It's empty if nobody has units there, or more than one player does.
+func (w *World) holderLocked(loc Location) string {
+	holder := ""
+	for name, s := range w.players {
+		for _, u := range s.Player.Units {
+			if u.Location != loc {
+				continue
+			}
+			if holder != "" && holder != name {
+				return ""
+			}
+			holder = name
+			break
+		}
+	}
+	return holder
+}
+
+// enemiesInLocked reports whether anybody but username has units in loc.
+func (w *World) enemiesInLocked(username string, loc Location) bool {
+	for name, s := range w.players {
+		if name == username {
+			continue
+		}
+		for _, u := range s.Player.Units {
+			if u.Location == loc {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Tick pays every player the income of the territories they own. Nothing
+// is paid, and the clock doesn't advance, while the game is paused or
+// after it's over.
+func (w *World) Tick() (Tick, Outcome, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.paused || w.over != nil {
+		return Tick{}, Outcome{}, false
+	}
+
+	w.tick++
+	t := Tick{Number: w.tick, At: time.Now()}
+	var out Outcome
+	for _, name := range w.usernamesLocked() {
+		owned := w.ownedLocked(name)
+		income := 0
+		for _, loc := range owned {
+			terr, _ := w.worldMap.Territory(loc)
+			income += terr.Income
+		}
+		if income > 0 {
+			w.applyLocked(&out, name, GoldReceived{Tick: w.tick, Amount: income, Territories: owned})
+		}
+	}
+	return t, out, true
+}
+
+func (w *World) checkMoveLocked(username string, mv MoveCommand) error {
+	if w.paused {
+		return errors.New("the game is paused, you can not move units")
+	}
+	if !w.worldMap.Has(mv.To) {
+		return fmt.Errorf("%s is not a valid location", mv.To)
+	}
+	if len(mv.UnitIDs) == 0 {
+		return errors.New("a move needs at least one unit")
+	}
+	s := w.players[username]
+	for _, id := range mv.UnitIDs {
+		u, ok := s.Player.Units[id]
+		if !ok {
+			return fmt.Errorf("unit with ID %v not found", id)
+		}
+		// one step at a time; clients split longer moves up
+		if !w.worldMap.Adjacent(u.Location, mv.To) {
+			return fmt.Errorf("unit %v is in %s, which doesn't border %s", id, u.Location, mv.To)
+		}
+	}
+	return nil
+}
+
+func (w *World) moveLocked(out *Outcome, username string, mv MoveCommand) {
+	w.applyLocked(out, username, UnitMoved{UnitIDs: mv.UnitIDs, To: mv.To})
+
+	mover := w.snapshotLocked(username)
+	moved := []Unit{}
+	for _, id := range mv.UnitIDs {
+		moved = append(moved, mover.Units[id])
+	}
+	out.Moves = append(out.Moves, w.noticesLocked(ArmyMove{Player: mover, Units: moved, ToLocation: mv.To})...)
+}
+
+// warLocked fights one war in loc, if both players have units there and
+// no pact keeps them apart, and removes the casualties. Every war gets a
+// fresh seed, which goes out with the recognition.
+func (w *World) warLocked(out *Outcome, attacker, defender string, loc Location) (War, bool) {
+	if w.atPeaceLocked(attacker, defender) {
+		return War{}, false
+	}
+	rw := RecognitionOfWar{
+		Attacker: inLocation(w.snapshotLocked(attacker), loc),
+		Defender: inLocation(w.snapshotLocked(defender), loc),
+		Location: loc,
+		Combat:   w.combat.Name(),
+		Seed:     rand.Int63(),
+	}
+	if len(unitsInLocation(rw.Attacker, loc)) == 0 || len(unitsInLocation(rw.Defender, loc)) == 0 {
+		return War{}, false
+	}
+	if t, ok := w.worldMap.Territory(rw.Location); ok {
+		rw.Terrain = t.Terrain
+	}
+	allies := w.reinforceLocked(&rw)
+	res := w.combat.Resolve(rw)
+	res.Allies = allies
+	w.scoreWarLocked(res)
+
+	w.destroyLocked(out, res.Attacker, res.Location, res.AttackerLosses, "fell attacking "+res.Defender)
+	w.updateLocked(out, res.Attacker, res.Location, res.AttackerSurvivors, "attacked "+res.Defender)
+
+	// hand the defending side's losses and survivors back to their owners
+	losses := map[string][]int{}
+	survivors := map[string][]Unit{}
+	for _, id := range res.DefenderLosses {
+		owner, unitID := rw.DefenderUnit(id)
+		losses[owner] = append(losses[owner], unitID)
+	}
+	for _, u := range res.DefenderSurvivors {
+		owner, unitID := rw.DefenderUnit(u.ID)
+		u.ID = unitID
+		survivors[owner] = append(survivors[owner], u)
+	}
+	w.destroyLocked(out, res.Defender, res.Location, losses[res.Defender], "fell defending against "+res.Attacker)
+	w.updateLocked(out, res.Defender, res.Location, survivors[res.Defender], "defended against "+res.Attacker)
+	for _, ally := range allies {
+		w.destroyLocked(out, ally, res.Location, losses[ally], fmt.Sprintf("fell defending %s against %s", res.Defender, res.Attacker))
+		w.updateLocked(out, ally, res.Location, survivors[ally], fmt.Sprintf("defended %s against %s", res.Defender, res.Attacker))
+	}
+	return War{Recognition: rw, Result: res}, true
+}
+
+// reinforceLocked adds the units of the defender's allies in the war's
+// location to the defending army, under new IDs, if the server allows
+// combined defence. Allies who have a pact with the attacker stay out of
+// it. It returns who joined.
+func (w *World) reinforceLocked(rw *RecognitionOfWar) []string {
+	if !w.combinedDefence {
+		return nil
+	}
+	nextID := 0
+	for id := range rw.Defender.Units {
+		nextID = max(nextID, id)
+	}
+	joined := []string{}
+	for _, ally := range w.alliesLocked(rw.Defender.Username) {
+		if ally == rw.Attacker.Username || w.atPeaceLocked(ally, rw.Attacker.Username) {
+			continue
+		}
+		units := unitsInLocation(w.snapshotLocked(ally), rw.Location)
+		if len(units) == 0 {
+			continue
+		}
+		sortUnits(units)
+		if rw.Allies == nil {
+			rw.Allies = map[int]AlliedUnit{}
+		}
+		for _, u := range units {
+			nextID++
+			rw.Allies[nextID] = AlliedUnit{Username: ally, UnitID: u.ID}
+			u.ID = nextID
+			rw.Defender.Units[nextID] = u
+		}
+		joined = append(joined, ally)
+	}
+	return joined
+}
+
+func (w *World) destroyLocked(out *Outcome, username string, loc Location, ids []int, cause string) {
+	if len(ids) == 0 {
+		return
+	}
+	w.applyLocked(out, username, UnitsDestroyed{Location: loc, UnitIDs: ids, Cause: cause})
+}
+
+func (w *World) updateLocked(out *Outcome, username string, loc Location, units []Unit, cause string) {
+	if len(units) == 0 {
+		return
+	}
+	w.applyLocked(out, username, UnitsUpdated{Location: loc, Units: units, Cause: cause})
+}
+
+func (w *World) applyLocked(out *Outcome, username string, e Event) {
+	w.players[username] = Reduce(w.players[username], e)
+	out.Updates = append(out.Updates, updateFor(username, e))
+}
+
+// playerLocked returns the player's state, adding them to the world with
+// their starting gold first if they are new.
+func (w *World) playerLocked(out *Outcome, username string) State {
+	if _, ok := w.players[username]; !ok {
+		w.players[username] = NewState(username)
+		w.applyLocked(out, username, GoldReceived{Amount: StartingGold})
+	}
+	return w.players[username]
+}
+
+func (w *World) snapshotLocked(username string) Player {
+	s := w.players[username]
+	units := make(map[int]Unit, len(s.Player.Units))
+	for id, u := range s.Player.Units {
+		units[id] = u
+	}
+	return Player{Username: username, Units: units}
+}
+
+func (w *World) usernamesLocked() []string {
+	names := make([]string, 0, len(w.players))
+	for name := range w.players {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// SetPaused records whether the game is paused; moves are refused while it
+// is. The turn timer and the victory hold timers are frozen during a
+// pause: resuming pushes them back by however long the pause lasted, and
+// returns true when that moved the end of the open turn.
+func (w *World) SetPaused(paused bool) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if paused == w.paused {
+		return false
+	}
+	w.paused = paused
+	if paused {
+		w.pausedAt = time.Now()
+		return false
+	}
+	if w.pausedAt.IsZero() {
+		return false
+	}
+	pause := time.Since(w.pausedAt)
+	for name, since := range w.holding {
+		w.holding[name] = since.Add(pause)
+	}
+	if w.turn.Number == 0 {
+		return false
+	}
+	w.turn.EndsAt = w.turn.EndsAt.Add(pause)
+	return true
+}
+
+// Players returns every player's state, sorted by username.
+func (w *World) Players() []State {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	players := []State{}
+	for _, name := range w.usernamesLocked() {
+		s := w.players[name]
+		s.Player = w.snapshotLocked(name)
+		players = append(players, s)
+	}
+	return players
+}
+
+// WorldVersion is the version of the world file format.
+const WorldVersion = 1
+
+type worldFile struct {
+	Version int              `json:"version"`
+	SavedAt time.Time        `json:"saved_at"`
+	Paused  bool             `json:"paused"`
+	Tick    int              `json:"tick"`
+	Players map[string]State `json:"players"`
+	NextID  map[string]int   `json:"next_id"`
+
+	Owners map[Location]string `json:"owners,omitempty"`
+	Scores map[string]Score    `json:"scores,omitempty"` // wars won and units destroyed
+	Over   *GameOver           `json:"game_over,omitempty"`
+	Pacts  map[string]Pact     `json:"pacts,omitempty"`
+}
+
+// Save writes the world to path, through a temporary file like
+// GameState.Save.
+func (w *World) Save(path string) error {
+	w.mu.Lock()
+	data, err := json.MarshalIndent(worldFile{
+		Version: WorldVersion,
+		SavedAt: time.Now(),
+		Paused:  w.paused,
+		Tick:    w.tick,
+		Players: w.players,
+		NextID:  w.nextID,
+		Owners:  w.owners,
+		Scores:  w.stats,
+		Over:    w.over,
+		Pacts:   w.pacts,
+	}, "", "  ")
+	w.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	w.saveMu.Lock()
+	defer w.saveMu.Unlock()
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return fmt.Errorf("could not write world: %v", err)
+	}
+	return os.Rename(tmp, path)
+}
+
+// Load replaces the world with the one saved at path. Every unit has to be
+// on the world's map. A missing file is reported with an error that
+// satisfies errors.Is(err, os.ErrNotExist).
+func (w *World) Load(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var f worldFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return fmt.Errorf("could not read world: %v", err)
+	}
+	if f.Version != WorldVersion {
+		return fmt.Errorf("world version %d is not supported (this server reads version %d)", f.Version, WorldVersion)
+	}
+	if f.Players == nil {
+		f.Players = map[string]State{}
+	}
+	if f.NextID == nil {
+		f.NextID = map[string]int{}
+	}
+	if f.Owners == nil {
+		f.Owners = map[Location]string{}
+	}
+	if f.Scores == nil {
+		f.Scores = map[string]Score{}
+	}
+	if f.Pacts == nil {
+		f.Pacts = map[string]Pact{}
+	}
+	for loc := range f.Owners {
+		if !w.worldMap.Has(loc) {
+			return fmt.Errorf("%s is owned but isn't on map %s", loc, w.worldMap.Name)
+		}
+	}
+	for name, s := range f.Players {
+		if s.Player.Units == nil {
+			s.Player.Units = map[int]Unit{}
+			f.Players[name] = s
+		}
+		for id, u := range s.Player.Units {
+			if !w.worldMap.Has(u.Location) {
+				return fmt.Errorf("unit %v of %s is in %s, which isn't on map %s", id, name, u.Location, w.worldMap.Name)
+			}
+			s.Player.Units[id] = upgradeUnit(u)
+		}
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.players = f.Players
+	w.nextID = f.NextID
+	w.paused = f.Paused
+	w.tick = f.Tick
+	w.owners = f.Owners
+	w.stats = f.Scores
+	w.over = f.Over
+	w.pacts = f.Pacts
+	w.proposals = map[string]Proposal{}
+	// worlds saved before ownership give everyone what they hold
+	w.claimLocked()
+	return nil
+}
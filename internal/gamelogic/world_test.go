@@ -0,0 +1,165 @@
+package gamelogic
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// lineMapJSON is five plains territories in a row, a-b-c-d-e, each worth
+// one gold, except the mountains in the middle.
+const lineMapJSON = `{"name": "line", "territories": [
+	{"name": "a", "neighbours": ["b"]},
+	{"name": "b", "neighbours": ["c"]},
+	{"name": "c", "terrain": "mountains", "income": 3, "neighbours": ["d"]},
+	{"name": "d", "neighbours": ["e"]},
+	{"name": "e"}
+]}`
+
+func newTestWorld(t *testing.T) *World {
+	t.Helper()
+	m, err := ParseMap([]byte(lineMapJSON))
+	if err != nil {
+		t.Fatalf("ParseMap: %v", err)
+	}
+	return NewWorld(m, PowerResolver{}, Victory{})
+}
+
+func handle(t *testing.T, w *World, cmd Command) Outcome {
+	t.Helper()
+	out, err := w.Handle(cmd)
+	if err != nil {
+		t.Fatalf("Handle(%+v): %v", cmd, err)
+	}
+	return out
+}
+
+func spawn(t *testing.T, w *World, username string, loc Location, rank UnitRank) Outcome {
+	t.Helper()
+	return handle(t, w, Command{Username: username, Spawn: &SpawnCommand{Location: loc, Rank: rank}})
+}
+
+func move(t *testing.T, w *World, username string, to Location, ids ...int) Outcome {
+	t.Helper()
+	return handle(t, w, Command{Username: username, Move: &MoveCommand{UnitIDs: ids, To: to}})
+}
+
+// scoresFor finds the scoreboard out sends to username.
+func scoresFor(out Outcome, username string) (Scoreboard, bool) {
+	for _, n := range out.Scores {
+		if n.Recipient == username {
+			return n.Scores, true
+		}
+	}
+	return Scoreboard{}, false
+}
+
+func TestHandleRefuses(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(w *World)
+		cmd   Command
+	}{
+		{name: "bad username", cmd: Command{Username: "al.ice", Spawn: &SpawnCommand{Location: "a", Rank: RankInfantry}}},
+		{name: "empty command", cmd: Command{Username: "alice"}},
+		{name: "unknown location", cmd: Command{Username: "alice", Spawn: &SpawnCommand{Location: "atlantis", Rank: RankInfantry}}},
+		{name: "unknown rank", cmd: Command{Username: "alice", Spawn: &SpawnCommand{Location: "a", Rank: "dragon"}}},
+		{
+			name:  "start where somebody is",
+			setup: func(w *World) { spawn(t, w, "bob", "a", RankInfantry) },
+			cmd:   Command{Username: "alice", Spawn: &SpawnCommand{Location: "a", Rank: RankInfantry}},
+		},
+		{
+			name:  "spawn outside your territory",
+			setup: func(w *World) { spawn(t, w, "alice", "a", RankInfantry) },
+			cmd:   Command{Username: "alice", Spawn: &SpawnCommand{Location: "c", Rank: RankInfantry}},
+		},
+		{
+			name:  "move someone else's unit",
+			setup: func(w *World) { spawn(t, w, "bob", "a", RankInfantry) },
+			cmd:   Command{Username: "alice", Move: &MoveCommand{UnitIDs: []int{1}, To: "b"}},
+		},
+		{
+			name:  "move no units",
+			setup: func(w *World) { spawn(t, w, "alice", "a", RankInfantry) },
+			cmd:   Command{Username: "alice", Move: &MoveCommand{To: "b"}},
+		},
+		{
+			name: "move while paused",
+			setup: func(w *World) {
+				spawn(t, w, "alice", "a", RankInfantry)
+				w.SetPaused(true)
+			},
+			cmd: Command{Username: "alice", Move: &MoveCommand{UnitIDs: []int{1}, To: "b"}},
+		},
+		{
+			name: "spawn after the game is over",
+			setup: func(w *World) {
+				w.over = &GameOver{Winner: "bob"}
+			},
+			cmd: Command{Username: "alice", Spawn: &SpawnCommand{Location: "a", Rank: RankInfantry}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := newTestWorld(t)
+			if tt.setup != nil {
+				tt.setup(w)
+			}
+			before := w.Players()
+			out, err := w.Handle(tt.cmd)
+			if err == nil {
+				t.Fatalf("Handle accepted it: %+v", out)
+			}
+			if after := w.Players(); !reflect.DeepEqual(after, before) {
+				t.Errorf("a refused command changed the world from %+v to %+v", before, after)
+			}
+		})
+	}
+}
+
+func TestWorldSaveLoad(t *testing.T) {
+	w := newTestWorld(t)
+	spawn(t, w, "alice", "a", RankInfantry)
+	spawn(t, w, "alice", "a", RankCavalry)
+	move(t, w, "alice", "b", 2)
+	spawn(t, w, "bob", "e", RankArtillery)
+	diplomacy(t, w, DiplomacyCommand{Username: "alice", Action: DiplomacyAlly, With: "bob"})
+	diplomacy(t, w, DiplomacyCommand{Username: "bob", Action: DiplomacyAlly, With: "alice"})
+	w.Tick()
+
+	path := filepath.Join(t.TempDir(), "world.json")
+	if err := w.Save(path); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	loaded := newTestWorld(t)
+	if err := loaded.Load(path); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if !reflect.DeepEqual(loaded.Players(), w.Players()) {
+		t.Errorf("players %+v, want %+v", loaded.Players(), w.Players())
+	}
+	if !reflect.DeepEqual(loaded.Scoreboard(), w.Scoreboard()) {
+		t.Errorf("scores %+v, want %+v", loaded.Scoreboard(), w.Scoreboard())
+	}
+	if len(loaded.Pacts()) != 1 {
+		t.Errorf("pacts %v, want the alliance", loaded.Pacts())
+	}
+
+	// unit IDs carry on where they left off
+	out := spawn(t, loaded, "alice", "a", RankInfantry)
+	for _, u := range out.Updates {
+		if u.Spawned != nil && u.Spawned.Unit.ID != 3 {
+			t.Errorf("new unit got ID %d after a load, want 3", u.Spawned.Unit.ID)
+		}
+	}
+
+	// a world with units off the map isn't loaded
+	other, err := ParseMap([]byte(`{"name": "island", "territories": [{"name": "x"}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := NewWorld(other, PowerResolver{}, Victory{}).Load(path); err == nil {
+		t.Error("loaded a world onto a map without its territories")
+	}
+}
@@ -0,0 +1,70 @@
+package pubsub
+
+import (
+	"errors"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// ErrUnsupported is returned by a Channel for operations its transport has
+// no way to do, like declaring exchanges over STOMP.
+var ErrUnsupported = errors.New("operation not supported by this transport")
+
+// Channel is what a subscription needs next to its deliveries: somewhere to
+// publish quarantined and throttled messages, and to declare where they go.
+// *amqp.Channel satisfies it.
+type Channel interface {
+	Publisher
+	ExchangeDeclare(name, kind string, durable, autoDelete, internal, noWait bool, args amqp.Table) error
+	QueueDeclare(name string, durable, autoDelete, exclusive, noWait bool, args amqp.Table) (amqp.Queue, error)
+	QueueBind(name, key, exchange string, noWait bool, args amqp.Table) error
+	Close() error
+}
+
+// Broker is the transport the subscribe helpers run on. Consume declares
+// the queue, binds it to exchange with key and starts consuming it with a
+// prefetch of 10. The stomp package provides a Broker over STOMP.
+type Broker interface {
+	Consume(exchange, queueName, key string, queueType SimpleQueueType) (Channel, <-chan amqp.Delivery, error)
+}
+
+// AMQP is the Broker for an AMQP 0-9-1 connection. What subscribers
+// publish (throttled and quarantined messages) goes through the
+// connection's FlowControl.
+func AMQP(conn *amqp.Connection) Broker {
+	return amqpBroker{conn: conn}
+}
+
+type amqpBroker struct {
+	conn *amqp.Connection
+}
+
+func (b amqpBroker) Consume(exchange, queueName, key string, queueType SimpleQueueType) (Channel, <-chan amqp.Delivery, error) {
+	// Ensure queue exists and is bound
+	ch, _, err := DeclareAndBind(b.conn, exchange, queueName, key, queueType)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Limit unacked messages per consumer (prefetch)
+	if err := ch.Qos(10, 0, false); err != nil {
+		_ = ch.Close()
+		return nil, nil, err
+	}
+
+	deliveries, err := ch.Consume(
+		queueName,
+		"",    // consumer name auto-generated
+		false, // autoAck
+		false, // exclusive
+		false, // noLocal
+		false, // noWait
+		nil,   // args
+	)
+	if err != nil {
+		_ = ch.Close()
+		return nil, nil, err
+	}
+
+	return WatchFlowControl(b.conn).Channel(ch), deliveries, nil
+}
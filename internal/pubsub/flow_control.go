@@ -0,0 +1,172 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"sync"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// ErrBrokerBlocked is returned instead of publishing while the broker has
+// blocked the connection (usually a memory or disk alarm).
+var ErrBrokerBlocked = errors.New("broker is blocking publishers")
+
+// FlowControl tracks connection.blocked / connection.unblocked notifications
+// for one connection.
+type FlowControl struct {
+	mu        sync.Mutex
+	blocked   bool
+	reason    string
+	unblocked chan struct{} // closed when the current block is lifted
+
+	gauge *expvar.Int
+}
+
+// publishTimeout bounds a publish whose context has no deadline. A broker
+// that blocks the connection stops reading from it, and amqp091-go ignores
+// the context, so without this a block that arrives mid-publish would hang
+// the caller until it lifts. Tests shorten it.
+var publishTimeout = 5 * time.Second
+
+// flowControls has the FlowControl of every open connection, so watching a
+// connection twice (AMQP does it too) doesn't count its blocks twice.
+var flowControls sync.Map // *amqp.Connection -> *FlowControl
+
+// WatchFlowControl subscribes to the blocked notifications of conn. Call it
+// right after dialing so no notification is missed. Every call for the same
+// connection returns the same FlowControl.
+func WatchFlowControl(conn *amqp.Connection) *FlowControl {
+	fc := &FlowControl{
+		unblocked: make(chan struct{}),
+		gauge:     new(expvar.Int),
+	}
+	close(fc.unblocked)
+	if existing, loaded := flowControls.LoadOrStore(conn, fc); loaded {
+		return existing.(*FlowControl)
+	}
+	metrics.Set("broker_blocked", fc.gauge)
+
+	// buffered so the connection's reader never waits on us
+	notifications := conn.NotifyBlocked(make(chan amqp.Blocking, 4))
+	go func() {
+		for b := range notifications {
+			fc.set(b)
+		}
+		// closed along with the connection
+		flowControls.Delete(conn)
+	}()
+
+	return fc
+}
+
+func (fc *FlowControl) set(b amqp.Blocking) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+
+	if b.Active == fc.blocked {
+		return
+	}
+	fc.blocked = b.Active
+	fc.reason = b.Reason
+
+	if b.Active {
+		fmt.Println("[pubsub] Broker blocked publishers:", b.Reason)
+		fc.unblocked = make(chan struct{})
+		fc.gauge.Set(1)
+		metrics.Add("broker_blocked_total", 1)
+		return
+	}
+	fmt.Println("[pubsub] Broker unblocked publishers")
+	close(fc.unblocked)
+	fc.gauge.Set(0)
+}
+
+// Blocked reports whether publishing is currently blocked, and why.
+func (fc *FlowControl) Blocked() (bool, string) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	return fc.blocked, fc.reason
+}
+
+// Wait returns nil once publishing is allowed. While the broker is blocking
+// it fails fast with ErrBrokerBlocked, unless ctx has a deadline, in which
+// case it waits for the block to lift until that deadline.
+func (fc *FlowControl) Wait(ctx context.Context) error {
+	fc.mu.Lock()
+	blocked, reason, unblocked := fc.blocked, fc.reason, fc.unblocked
+	fc.mu.Unlock()
+
+	if !blocked {
+		return nil
+	}
+	if _, ok := ctx.Deadline(); ok {
+		select {
+		case <-unblocked:
+			return nil
+		case <-ctx.Done():
+		}
+	}
+
+	metrics.Add("publish_rejected_blocked", 1)
+	return fmt.Errorf("%w: %s", ErrBrokerBlocked, reason)
+}
+
+// Publisher wraps pub so every publish waits for (or fails on) a block,
+// and gives up after publishTimeout if ctx has no deadline of its own.
+func (fc *FlowControl) Publisher(pub Publisher) Publisher {
+	return &flowControlledPublisher{fc: fc, pub: pub}
+}
+
+// Channel is Publisher for a Channel, for code that also declares queues,
+// like PublishDelayed and the subscribers.
+func (fc *FlowControl) Channel(ch Channel) Channel {
+	return flowControlledChannel{Channel: ch, pub: fc.Publisher(ch)}
+}
+
+type flowControlledChannel struct {
+	Channel
+	pub Publisher
+}
+
+func (c flowControlledChannel) PublishWithContext(ctx context.Context, exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error {
+	return c.pub.PublishWithContext(ctx, exchange, key, mandatory, immediate, msg)
+}
+
+type flowControlledPublisher struct {
+	fc  *FlowControl
+	pub Publisher
+}
+
+func (p *flowControlledPublisher) PublishWithContext(ctx context.Context, exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error {
+	if err := p.fc.Wait(ctx); err != nil {
+		return err
+	}
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, publishTimeout)
+		defer cancel()
+	}
+
+	// the publish itself may not return until the broker reads again, so
+	// wait for it no longer than ctx allows; if it's stuck it still goes
+	// out once the block lifts
+	done := make(chan error, 1)
+	go func() {
+		done <- p.pub.PublishWithContext(ctx, exchange, key, mandatory, immediate, msg)
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+	}
+
+	metrics.Add("publish_timed_out", 1)
+	if blocked, reason := p.fc.Blocked(); blocked {
+		return fmt.Errorf("%w: %s", ErrBrokerBlocked, reason)
+	}
+	return ctx.Err()
+}
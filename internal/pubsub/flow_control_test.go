@@ -0,0 +1,163 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"net"
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/devbroker"
+)
+
+func newTestFlowControl() *FlowControl {
+	fc := &FlowControl{unblocked: make(chan struct{}), gauge: new(expvar.Int)}
+	close(fc.unblocked)
+	return fc
+}
+
+// stuckPublisher never returns until released, like amqp091-go writing to
+// a broker that has stopped reading.
+type stuckPublisher struct {
+	started chan struct{}
+	release chan struct{}
+}
+
+func newStuckPublisher() *stuckPublisher {
+	return &stuckPublisher{started: make(chan struct{}, 1), release: make(chan struct{})}
+}
+
+func (p *stuckPublisher) PublishWithContext(context.Context, string, string, bool, bool, amqp.Publishing) error {
+	p.started <- struct{}{}
+	<-p.release
+	return nil
+}
+
+func TestFlowControlBlockedMidPublish(t *testing.T) {
+	fc := newTestFlowControl()
+	stuck := newStuckPublisher()
+	defer close(stuck.release)
+	pub := fc.Publisher(stuck)
+
+	errs := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		errs <- pub.PublishWithContext(ctx, "peril_topic", "commands.alice", false, false, amqp.Publishing{})
+	}()
+
+	<-stuck.started
+	fc.set(amqp.Blocking{Active: true, Reason: "low on memory"})
+	select {
+	case err := <-errs:
+		if !errors.Is(err, ErrBrokerBlocked) {
+			t.Errorf("err = %v, want ErrBrokerBlocked", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("publish hung on a block that arrived mid-publish")
+	}
+}
+
+func TestFlowControlBoundsPublish(t *testing.T) {
+	defer func(d time.Duration) { publishTimeout = d }(publishTimeout)
+	publishTimeout = 50 * time.Millisecond
+
+	stuck := newStuckPublisher()
+	defer close(stuck.release)
+
+	// no deadline from the caller: publishTimeout still applies
+	start := time.Now()
+	err := newTestFlowControl().Publisher(stuck).PublishWithContext(context.Background(), "", "q", false, false, amqp.Publishing{})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("err = %v, want a timeout", err)
+	}
+	if took := time.Since(start); took > time.Second {
+		t.Errorf("publish took %v", took)
+	}
+}
+
+func TestFlowControlWait(t *testing.T) {
+	fc := newTestFlowControl()
+	inner := &countingPublisher{}
+	pub := fc.Publisher(inner)
+	publish := func(ctx context.Context) error {
+		return pub.PublishWithContext(ctx, "", "q", false, false, amqp.Publishing{})
+	}
+
+	if err := publish(context.Background()); err != nil {
+		t.Fatalf("unblocked: %v", err)
+	}
+
+	fc.set(amqp.Blocking{Active: true, Reason: "disk alarm"})
+	if blocked, reason := fc.Blocked(); !blocked || reason != "disk alarm" {
+		t.Errorf("Blocked = %v, %q", blocked, reason)
+	}
+
+	// without a deadline it fails fast
+	if err := publish(context.Background()); !errors.Is(err, ErrBrokerBlocked) {
+		t.Errorf("blocked: err = %v", err)
+	}
+
+	// with one it waits for the block to lift
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		fc.set(amqp.Blocking{Active: false})
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := publish(ctx); err != nil {
+		t.Errorf("waiting out the block: %v", err)
+	}
+
+	if len(inner.keys) != 2 {
+		t.Errorf("%d publishes got through, want 2", len(inner.keys))
+	}
+}
+
+func TestWatchFlowControl(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	b := devbroker.New(devbroker.Options{})
+	go b.Serve(ln)
+	defer b.Close()
+
+	conn, err := amqp.Dial("amqp://guest:guest@" + ln.Addr().String() + "/")
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer conn.Close()
+
+	fc := WatchFlowControl(conn)
+	if again := WatchFlowControl(conn); again != fc {
+		t.Error("watching a connection twice gave two FlowControls")
+	}
+	ch, err := conn.Channel()
+	if err != nil {
+		t.Fatalf("Channel: %v", err)
+	}
+	pub := fc.Channel(ch)
+
+	b.SetBlocked("test alarm")
+	deadline := time.Now().Add(time.Second)
+	for blocked, _ := fc.Blocked(); !blocked; blocked, _ = fc.Blocked() {
+		if time.Now().After(deadline) {
+			t.Fatal("connection.blocked never arrived")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if err := pub.PublishWithContext(context.Background(), "", "q", false, false, amqp.Publishing{}); !errors.Is(err, ErrBrokerBlocked) {
+		t.Errorf("publish while blocked: err = %v", err)
+	}
+
+	b.Unblock()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := pub.PublishWithContext(ctx, "", "q", false, false, amqp.Publishing{}); err != nil {
+		t.Errorf("publish after unblock: %v", err)
+	}
+}
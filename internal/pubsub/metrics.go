@@ -0,0 +1,17 @@
+package pubsub
+
+import (
+	"expvar"
+	"fmt"
+)
+
+// metrics are published with expvar under "pubsub", so any binary that
+// serves HTTP exposes them on /debug/vars as well.
+var metrics = expvar.NewMap("pubsub")
+
+// PrintMetrics prints every pubsub metric, one per line.
+func PrintMetrics() {
+	metrics.Do(func(kv expvar.KeyValue) {
+		fmt.Printf("* %s: %v\n", kv.Key, kv.Value)
+	})
+}
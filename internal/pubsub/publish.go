@@ -8,14 +8,20 @@ import (
 )
 
 // PublishJSON marshals val as JSON and publishes it to an exchange with a routing key.
-func PublishJSON[T any](ch *amqp.Channel, exchange, key string, val T) error {
+func PublishJSON[T any](pub Publisher, exchange, key string, val T) error {
+	return PublishJSONWithContext(context.Background(), pub, exchange, key, val)
+}
+
+// PublishJSONWithContext is PublishJSON with a context; a deadline on ctx
+// lets a flow-controlled publisher wait out a broker block.
+func PublishJSONWithContext[T any](ctx context.Context, pub Publisher, exchange, key string, val T) error {
 	body, err := json.Marshal(val)
 	if err != nil {
 		return err
 	}
 
-	return ch.PublishWithContext(
-		context.Background(),
+	return pub.PublishWithContext(
+		ctx,
 		exchange,
 		key,
 		false, // mandatory
@@ -0,0 +1,92 @@
+package pubsub
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+)
+
+// delayQueuePrefix names the parking queues used for delayed messages.
+const delayQueuePrefix = "peril_delay"
+
+// delayQueueGrace keeps an idle delay queue around a little longer than its
+// TTL so the broker never expires it while it still holds messages.
+const delayQueueGrace = time.Minute
+
+// delayBucket is what delays are rounded to. Each distinct delay needs a
+// parking queue of its own, so without rounding every millisecond would
+// leave another queue behind.
+const delayBucket = time.Second
+
+// PublishDelayed encodes val with the topic's codec and publishes it so that
+// it reaches the topic's exchange with key once delay has passed. The delay
+// is rounded to the nearest second, and one that rounds to zero is an error:
+// publish those straight away with Publish.
+//
+// There is no delayed-message plugin involved: the message is parked in a
+// durable queue whose x-message-ttl equals the delay and whose dead-letter
+// exchange/routing key are the real destination. When the TTL runs out
+// RabbitMQ dead-letters the message to where it was meant to go. Every
+// message in a parking queue shares one TTL, so nothing gets stuck behind a
+// longer delay, and because the messages live in the broker they survive
+// restarts of the publisher.
+//
+// Pass the channel through FlowControl.Channel so the publish can't hang on
+// a blocked broker.
+func PublishDelayed[T any](ch Channel, topic routing.Topic[T], key string, val T, delay time.Duration) error {
+	rounded := delay.Round(delayBucket)
+	if rounded <= 0 {
+		return fmt.Errorf("delay %v is under a second, too short to schedule", delay)
+	}
+
+	body, err := topic.Codec.Encode(val)
+	if err != nil {
+		return err
+	}
+
+	exchange := topic.Exchange
+
+	ttl := rounded.Milliseconds()
+	queueName := fmt.Sprintf("%s.%s.%s.%d", delayQueuePrefix, exchange, key, ttl)
+
+	if _, err := ch.QueueDeclare(
+		queueName,
+		true,  // durable
+		false, // autoDelete
+		false, // exclusive
+		false, // noWait
+		amqp.Table{
+			"x-message-ttl":             ttl,
+			"x-dead-letter-exchange":    exchange,
+			"x-dead-letter-routing-key": key,
+			"x-expires":                 ttl + delayQueueGrace.Milliseconds(),
+		},
+	); err != nil {
+		return fmt.Errorf("could not declare delay queue %s: %v", queueName, err)
+	}
+
+	// publish straight to the parking queue through the default exchange
+	return ch.PublishWithContext(
+		context.Background(),
+		"",
+		queueName,
+		false, // mandatory
+		false, // immediate
+		amqp.Publishing{
+			ContentType:  topic.Codec.ContentType,
+			DeliveryMode: amqp.Persistent,
+			Timestamp:    time.Now(),
+			Body:         body,
+		},
+	)
+}
+
+// PublishAt is PublishDelayed with an absolute delivery time, so it fails
+// for a time less than half a second away or already gone.
+func PublishAt[T any](ch Channel, topic routing.Topic[T], key string, val T, at time.Time) error {
+	return PublishDelayed(ch, topic, key, val, time.Until(at))
+}
@@ -0,0 +1,93 @@
+package pubsub
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/devbroker"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+)
+
+func TestPublishDelayed(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	b := devbroker.New(devbroker.Options{})
+	go b.Serve(ln)
+	defer b.Close()
+	if err := b.DeclareExchange("peril_direct", "direct"); err != nil {
+		t.Fatalf("DeclareExchange: %v", err)
+	}
+	if err := b.DeclareQueue("paused", nil); err != nil {
+		t.Fatalf("DeclareQueue: %v", err)
+	}
+	if err := b.BindQueue("paused", "peril_direct", routing.PauseKey); err != nil {
+		t.Fatalf("BindQueue: %v", err)
+	}
+
+	conn, err := amqp.Dial("amqp://guest:guest@" + ln.Addr().String() + "/")
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer conn.Close()
+	ch, err := conn.Channel()
+	if err != nil {
+		t.Fatalf("Channel: %v", err)
+	}
+
+	topic := routing.Topic[string]{
+		Exchange: routing.ExchangePerilDirect,
+		Key:      routing.PauseKey,
+		Codec: routing.Codec[string]{
+			ContentType: "text/plain",
+			Encode:      func(s string) ([]byte, error) { return []byte(s), nil },
+		},
+	}
+
+	for _, delay := range []time.Duration{0, -time.Second, 300 * time.Millisecond} {
+		if err := PublishDelayed(ch, topic, topic.Key, "x", delay); err == nil {
+			t.Errorf("PublishDelayed(%v) succeeded, want an error", delay)
+		}
+	}
+
+	// delays in the same second share a parking queue
+	start := time.Now()
+	for _, delay := range []time.Duration{800 * time.Millisecond, time.Second, 1234 * time.Millisecond} {
+		if err := PublishDelayed(ch, topic, topic.Key, delay.String(), delay); err != nil {
+			t.Fatalf("PublishDelayed(%v): %v", delay, err)
+		}
+	}
+	var parking []string
+	for _, q := range b.Queues() {
+		if strings.HasPrefix(q.Name, delayQueuePrefix) {
+			parking = append(parking, q.Name)
+		}
+	}
+	if want := "peril_delay.peril_direct.pause.1000"; len(parking) != 1 || parking[0] != want {
+		t.Errorf("parking queues %v, want just %s", parking, want)
+	}
+
+	got := 0
+	for got < 3 && time.Since(start) < 3*time.Second {
+		_, ok, err := ch.Get("paused", true)
+		if err != nil {
+			t.Fatalf("Get: %v", err)
+		}
+		if !ok {
+			time.Sleep(20 * time.Millisecond)
+			continue
+		}
+		if waited := time.Since(start); waited < 900*time.Millisecond {
+			t.Errorf("delivered after %v, want about a second", waited)
+		}
+		got++
+	}
+	if got != 3 {
+		t.Errorf("%d of 3 delayed messages arrived", got)
+	}
+}
@@ -8,15 +8,19 @@ import (
 	amqp "github.com/rabbitmq/amqp091-go"
 )
 
-func PublishGob[T any](ch *amqp.Channel, exchange, key string, val T) error {
+func PublishGob[T any](pub Publisher, exchange, key string, val T) error {
+	return PublishGobWithContext(context.Background(), pub, exchange, key, val)
+}
+
+func PublishGobWithContext[T any](ctx context.Context, pub Publisher, exchange, key string, val T) error {
 	var buf bytes.Buffer
 	enc := gob.NewEncoder(&buf)
 	if err := enc.Encode(val); err != nil {
 		return err
 	}
 
-	return ch.PublishWithContext(
-		context.Background(),
+	return pub.PublishWithContext(
+		ctx,
 		exchange,
 		key,
 		false, // mandatory
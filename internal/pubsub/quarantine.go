@@ -0,0 +1,93 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+)
+
+// quarantined counts quarantined messages per source queue.
+var quarantined = new(expvar.Map)
+
+func init() {
+	metrics.Set("quarantined", quarantined)
+}
+
+// quarantine republishes a message that could not be decoded to the
+// peril_quarantine exchange with its original body and properties plus
+// headers saying where it came from and why it failed, and only then acks
+// it. If that fails the message is nacked to the dead-letter exchange
+// instead, so it is never lost.
+//
+// Over STOMP the quarantine exchange can't be declared, so there every
+// message that would be quarantined goes to the dead-letter exchange.
+func (c *consumer[T]) quarantine(msg amqp.Delivery, decodeErr error) {
+	fmt.Printf("[pubsub] Decode failed -> quarantine (%s): %v\n", c.queueName, decodeErr)
+
+	if !c.quarantineDeclared {
+		err := declareQuarantine(c.ch)
+		if errors.Is(err, ErrUnsupported) {
+			fmt.Println("[pubsub] Can't quarantine over this transport, NackDiscard to the dead-letter exchange instead:", err)
+			metrics.Add("quarantine_unsupported", 1)
+			_ = msg.Nack(false, false)
+			return
+		}
+		if err != nil {
+			fmt.Println("[pubsub] Failed to declare quarantine, NackDiscard:", err)
+			_ = msg.Nack(false, false)
+			return
+		}
+		c.quarantineDeclared = true
+	}
+
+	headers := amqp.Table{}
+	for k, v := range msg.Headers {
+		headers[k] = v
+	}
+	headers["x-quarantine-error"] = decodeErr.Error()
+	headers["x-quarantine-queue"] = c.queueName
+	headers["x-quarantine-exchange"] = msg.Exchange
+	headers["x-quarantine-routing-key"] = msg.RoutingKey
+
+	err := c.ch.PublishWithContext(context.Background(), routing.ExchangePerilQuarantine, msg.RoutingKey, false, false, amqp.Publishing{
+		Headers:         headers,
+		ContentType:     msg.ContentType,
+		ContentEncoding: msg.ContentEncoding,
+		DeliveryMode:    msg.DeliveryMode,
+		Priority:        msg.Priority,
+		CorrelationId:   msg.CorrelationId,
+		ReplyTo:         msg.ReplyTo,
+		Expiration:      msg.Expiration,
+		MessageId:       msg.MessageId,
+		Timestamp:       msg.Timestamp,
+		Type:            msg.Type,
+		UserId:          msg.UserId,
+		AppId:           msg.AppId,
+		Body:            msg.Body,
+	})
+	if err != nil {
+		fmt.Println("[pubsub] Failed to quarantine message, NackDiscard:", err)
+		_ = msg.Nack(false, false)
+		return
+	}
+
+	quarantined.Add(c.queueName, 1)
+	_ = msg.Ack(false)
+}
+
+// declareQuarantine makes sure the quarantine exchange and its durable queue
+// exist; the exchange is a fanout so every routing key ends up in the queue.
+func declareQuarantine(ch Channel) error {
+	if err := ch.ExchangeDeclare(routing.ExchangePerilQuarantine, "fanout", true, false, false, false, nil); err != nil {
+		return err
+	}
+	if _, err := ch.QueueDeclare(routing.QuarantineQueue, true, false, false, false, nil); err != nil {
+		return err
+	}
+	return ch.QueueBind(routing.QuarantineQueue, "", routing.ExchangePerilQuarantine, false, nil)
+}
@@ -0,0 +1,123 @@
+package pubsub
+
+import (
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/devbroker"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+)
+
+func TestQuarantine(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	b := devbroker.New(devbroker.Options{})
+	go b.Serve(ln)
+	defer b.Close()
+	if err := b.DeclareExchange("peril_topic", "topic"); err != nil {
+		t.Fatalf("DeclareExchange: %v", err)
+	}
+	if err := b.DeclareExchange("peril_dlx", "fanout"); err != nil {
+		t.Fatalf("DeclareExchange: %v", err)
+	}
+
+	conn, err := amqp.Dial("amqp://guest:guest@" + ln.Addr().String() + "/")
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer conn.Close()
+	pubCh, err := conn.Channel()
+	if err != nil {
+		t.Fatalf("Channel: %v", err)
+	}
+
+	ch, deliveries, err := AMQP(conn).Consume("peril_topic", "logs_test", "game_logs.*", SimpleQueueDurable)
+	if err != nil {
+		t.Fatalf("Consume: %v", err)
+	}
+	handled := make(chan string, 16)
+	c := newConsumer(ch, "logs_test", func(_ string, msg map[string]string) AckType {
+		handled <- msg["text"]
+		return Ack
+	}, "application/json", func(b []byte) (map[string]string, error) {
+		var msg map[string]string
+		err := json.Unmarshal(b, &msg)
+		return msg, err
+	})
+	go c.run(deliveries)
+
+	publish := func(p amqp.Publishing) {
+		t.Helper()
+		if err := pubCh.Publish("peril_topic", "game_logs.alice", false, false, p); err != nil {
+			t.Fatalf("Publish: %v", err)
+		}
+	}
+	publish(amqp.Publishing{
+		ContentType:  "application/json",
+		DeliveryMode: amqp.Transient,
+		Expiration:   "60000",
+		MessageId:    "bad-json",
+		Body:         []byte("{not json"),
+	})
+	publish(amqp.Publishing{
+		ContentType:  "application/gob",
+		DeliveryMode: amqp.Persistent,
+		MessageId:    "wrong-type",
+		Body:         []byte(`{"text":"hi"}`),
+	})
+	publish(amqp.Publishing{ContentType: "application/json", Body: []byte(`{"text":"ok"}`)})
+
+	select {
+	case got := <-handled:
+		if got != "ok" {
+			t.Errorf("handled %q, want ok", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("good message wasn't handled")
+	}
+
+	got := map[string]amqp.Delivery{}
+	deadline := time.Now().Add(time.Second)
+	for len(got) < 2 && time.Now().Before(deadline) {
+		msg, ok, err := pubCh.Get(routing.QuarantineQueue, true)
+		if err != nil {
+			t.Fatalf("Get: %v", err)
+		}
+		if !ok {
+			time.Sleep(10 * time.Millisecond)
+			continue
+		}
+		got[msg.MessageId] = msg
+	}
+	if len(got) != 2 {
+		t.Fatalf("quarantined %d messages, want 2", len(got))
+	}
+
+	bad := got["bad-json"]
+	if string(bad.Body) != "{not json" || bad.ContentType != "application/json" {
+		t.Errorf("bad-json body %q, content type %q", bad.Body, bad.ContentType)
+	}
+	if bad.DeliveryMode != amqp.Transient || bad.Expiration != "60000" {
+		t.Errorf("bad-json delivery mode %d, expiration %q, want them kept", bad.DeliveryMode, bad.Expiration)
+	}
+	if bad.Headers["x-quarantine-queue"] != "logs_test" ||
+		bad.Headers["x-quarantine-exchange"] != "peril_topic" ||
+		bad.Headers["x-quarantine-routing-key"] != "game_logs.alice" ||
+		bad.Headers["x-quarantine-error"] == "" {
+		t.Errorf("bad-json headers %v", bad.Headers)
+	}
+
+	wrong := got["wrong-type"]
+	if wrong.ContentType != "application/gob" || wrong.DeliveryMode != amqp.Persistent {
+		t.Errorf("wrong-type content type %q, delivery mode %d", wrong.ContentType, wrong.DeliveryMode)
+	}
+	if wrong.Headers["x-quarantine-error"] != "content type is application/gob, want application/json" {
+		t.Errorf("wrong-type error header %q", wrong.Headers["x-quarantine-error"])
+	}
+}
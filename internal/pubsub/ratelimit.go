@@ -0,0 +1,187 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+)
+
+// ErrRateLimited is returned instead of publishing when a routing prefix is
+// over its limit in routing.RateLimits.
+var ErrRateLimited = errors.New("rate limited")
+
+type tokenBucket struct {
+	perSecond float64
+	burst     float64
+	tokens    float64
+	last      time.Time
+}
+
+// take spends a token. When none is left it reports how long until the next
+// one, and claims that future token if the caller is willing to wait that
+// long (maxWait).
+func (b *tokenBucket) take(now time.Time, maxWait time.Duration) (bool, time.Duration) {
+	b.tokens += now.Sub(b.last).Seconds() * b.perSecond
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+
+	wait := time.Duration((1 - b.tokens) / b.perSecond * float64(time.Second))
+	if wait > maxWait {
+		return false, wait
+	}
+	b.tokens--
+	return true, wait
+}
+
+// rateLimiter keeps one token bucket per "<prefix>.<username>".
+type rateLimiter struct {
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+	now     func() time.Time // time.Now, except in tests
+}
+
+func newRateLimiter() *rateLimiter {
+	return &rateLimiter{buckets: map[string]*tokenBucket{}, now: time.Now}
+}
+
+// take spends a token for routingKey (see tokenBucket.take). Keys without a
+// configured limit are always allowed.
+func (l *rateLimiter) take(routingKey string, maxWait time.Duration) (bool, time.Duration) {
+	bucketKey, limit, ok := routing.RateLimitFor(routingKey)
+	if !ok {
+		return true, 0
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	b, ok := l.buckets[bucketKey]
+	if !ok {
+		b = &tokenBucket{
+			perSecond: limit.PerSecond,
+			burst:     float64(limit.Burst),
+			tokens:    float64(limit.Burst),
+			last:      now,
+		}
+		l.buckets[bucketKey] = b
+	}
+	return b.take(now, maxWait)
+}
+
+// RateLimited wraps pub so publishing follows routing.RateLimits. Over the
+// limit it fails fast with ErrRateLimited, unless ctx has a deadline that
+// leaves enough time to wait for the next token.
+func RateLimited(pub Publisher) Publisher {
+	return &rateLimitedPublisher{pub: pub, limiter: newRateLimiter()}
+}
+
+type rateLimitedPublisher struct {
+	pub     Publisher
+	limiter *rateLimiter
+}
+
+func (p *rateLimitedPublisher) PublishWithContext(ctx context.Context, exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error {
+	var maxWait time.Duration
+	if deadline, ok := ctx.Deadline(); ok {
+		maxWait = time.Until(deadline)
+	}
+
+	ok, wait := p.limiter.take(key, maxWait)
+	if !ok {
+		metrics.Add("publish_rate_limited", 1)
+		return fmt.Errorf("%w: %s", ErrRateLimited, key)
+	}
+	if wait > 0 {
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	return p.pub.PublishWithContext(ctx, exchange, key, mandatory, immediate, msg)
+}
+
+// throttleDelay is how long an over-limit message waits before it is
+// delivered to its queue again. Tests shorten it.
+var throttleDelay = 2 * time.Second
+
+// throttledKeyHeader keeps the original routing key of a throttled message,
+// since dead-lettering it back to its queue replaces the key.
+const throttledKeyHeader = "x-peril-routing-key"
+
+// throttled enforces routing.RateLimits per username on the consuming side.
+// A message over its sender's limit is parked in "peril_throttle.<queue>",
+// which dead-letters it back to the queue after throttleDelay, and the
+// original is acked. It reports whether the message was parked.
+func (c *consumer[T]) throttled(msg amqp.Delivery) bool {
+	key := c.routingKey(msg)
+
+	if ok, _ := c.limiter.take(key, 0); ok {
+		return false
+	}
+
+	throttleQueue := c.throttleQueue()
+	if !c.throttleDeclared {
+		if _, err := c.ch.QueueDeclare(
+			throttleQueue,
+			true,  // durable
+			false, // autoDelete
+			false, // exclusive
+			false, // noWait
+			amqp.Table{
+				"x-message-ttl":             throttleDelay.Milliseconds(),
+				"x-dead-letter-exchange":    "",
+				"x-dead-letter-routing-key": c.queueName,
+				"x-expires":                 (10 * time.Minute).Milliseconds(),
+			},
+		); err != nil {
+			// better to let it through than to lose it
+			fmt.Println("[pubsub] Failed to declare throttle queue, not throttling:", err)
+			return false
+		}
+		c.throttleDeclared = true
+	}
+
+	headers := amqp.Table{}
+	for k, v := range msg.Headers {
+		headers[k] = v
+	}
+	headers[throttledKeyHeader] = key
+
+	err := c.ch.PublishWithContext(context.Background(), "", throttleQueue, false, false, amqp.Publishing{
+		Headers:         headers,
+		ContentType:     msg.ContentType,
+		ContentEncoding: msg.ContentEncoding,
+		DeliveryMode:    msg.DeliveryMode,
+		Timestamp:       msg.Timestamp,
+		Body:            msg.Body,
+	})
+	if err != nil {
+		fmt.Println("[pubsub] Failed to throttle message, not throttling:", err)
+		return false
+	}
+
+	fmt.Println("[pubsub] Over rate limit -> throttled:", key)
+	metrics.Add("consume_throttled", 1)
+	_ = msg.Ack(false)
+	return true
+}
+
+func (c *consumer[T]) throttleQueue() string {
+	return "peril_throttle." + c.queueName
+}
@@ -0,0 +1,310 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/devbroker"
+)
+
+type fakeClock struct {
+	mu sync.Mutex
+	t  time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{t: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.t
+}
+
+func (c *fakeClock) advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.t = c.t.Add(d)
+}
+
+func TestTokenBucket(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	b := &tokenBucket{perSecond: 2, burst: 3, tokens: 3, last: start}
+
+	steps := []struct {
+		at      time.Duration
+		maxWait time.Duration
+		ok      bool
+		wait    time.Duration
+	}{
+		// the burst goes at once
+		{0, 0, true, 0},
+		{0, 0, true, 0},
+		{0, 0, true, 0},
+		// then it's over the limit until the next token, in half a second
+		{0, 0, false, 500 * time.Millisecond},
+		{100 * time.Millisecond, 0, false, 400 * time.Millisecond},
+		// one is back
+		{500 * time.Millisecond, 0, true, 0},
+		// a caller willing to wait claims the next token now
+		{500 * time.Millisecond, time.Second, true, 500 * time.Millisecond},
+		// so the one after that is a whole second away
+		{500 * time.Millisecond, 0, false, time.Second},
+		// refilling stops at the burst
+		{time.Hour, 0, true, 0},
+		{time.Hour, 0, true, 0},
+		{time.Hour, 0, true, 0},
+		{time.Hour, 0, false, 500 * time.Millisecond},
+	}
+	for i, s := range steps {
+		ok, wait := b.take(start.Add(s.at), s.maxWait)
+		if ok != s.ok || wait != s.wait {
+			t.Errorf("step %d at %v: take = %v, %v; want %v, %v", i, s.at, ok, wait, s.ok, s.wait)
+		}
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	clock := newFakeClock()
+	l := newRateLimiter()
+	l.now = clock.now
+
+	// commands are 4 a second with a burst of 10
+	for i := 0; i < 10; i++ {
+		if ok, _ := l.take("commands.alice", 0); !ok {
+			t.Fatalf("command %d refused within the burst", i+1)
+		}
+	}
+	if ok, wait := l.take("commands.alice", 0); ok || wait != 250*time.Millisecond {
+		t.Errorf("over the burst: take = %v, %v", ok, wait)
+	}
+
+	// every player has their own bucket
+	if ok, _ := l.take("commands.bob", 0); !ok {
+		t.Error("bob limited by alice's commands")
+	}
+
+	// keys without a limit, or with more than two words, are never limited
+	for _, key := range []string{"pause", "army_moves.alice", "chat.to.alice"} {
+		for i := 0; i < 100; i++ {
+			if ok, _ := l.take(key, 0); !ok {
+				t.Fatalf("%s limited", key)
+			}
+		}
+	}
+
+	clock.advance(250 * time.Millisecond)
+	if ok, _ := l.take("commands.alice", 0); !ok {
+		t.Error("no token after a quarter second")
+	}
+	if ok, _ := l.take("commands.alice", 0); ok {
+		t.Error("two tokens after a quarter second")
+	}
+}
+
+type countingPublisher struct {
+	mu   sync.Mutex
+	keys []string
+}
+
+func (p *countingPublisher) PublishWithContext(_ context.Context, _, key string, _, _ bool, _ amqp.Publishing) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.keys = append(p.keys, key)
+	return nil
+}
+
+func TestRateLimitedPublisher(t *testing.T) {
+	inner := &countingPublisher{}
+	pub := RateLimited(inner).(*rateLimitedPublisher)
+	clock := newFakeClock()
+	pub.limiter.now = clock.now
+
+	publish := func(ctx context.Context) error {
+		return pub.PublishWithContext(ctx, "peril_topic", "diplomacy.alice", false, false, amqp.Publishing{})
+	}
+	for i := 0; i < 5; i++ {
+		if err := publish(context.Background()); err != nil {
+			t.Fatalf("publish %d: %v", i+1, err)
+		}
+	}
+
+	// without a deadline it fails fast
+	if err := publish(context.Background()); !errors.Is(err, ErrRateLimited) {
+		t.Errorf("over the limit: err = %v", err)
+	}
+
+	// a deadline too close to wait for the next token fails fast as well
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := publish(ctx); !errors.Is(err, ErrRateLimited) {
+		t.Errorf("short deadline: err = %v", err)
+	}
+
+	// with time to spare it waits for the token, a second at 1/s
+	clock.advance(900 * time.Millisecond)
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	start := time.Now()
+	if err := publish(ctx); err != nil {
+		t.Errorf("long deadline: err = %v", err)
+	}
+	if waited := time.Since(start); waited < 90*time.Millisecond {
+		t.Errorf("published after %v, want about 100ms", waited)
+	}
+
+	if len(inner.keys) != 6 {
+		t.Errorf("%d messages got through, want 6", len(inner.keys))
+	}
+}
+
+func TestThrottleRedelivery(t *testing.T) {
+	defer func(d time.Duration) { throttleDelay = d }(throttleDelay)
+	throttleDelay = 100 * time.Millisecond
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	b := devbroker.New(devbroker.Options{})
+	go b.Serve(ln)
+	defer b.Close()
+	if err := b.DeclareExchange("peril_topic", "topic"); err != nil {
+		t.Fatalf("DeclareExchange: %v", err)
+	}
+	if err := b.DeclareExchange("peril_dlx", "fanout"); err != nil {
+		t.Fatalf("DeclareExchange: %v", err)
+	}
+
+	conn, err := amqp.Dial("amqp://guest:guest@" + ln.Addr().String() + "/")
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer conn.Close()
+	pubCh, err := conn.Channel()
+	if err != nil {
+		t.Fatalf("Channel: %v", err)
+	}
+
+	ch, deliveries, err := AMQP(conn).Consume("peril_topic", "chat_test", "chat.*", SimpleQueueDurable)
+	if err != nil {
+		t.Fatalf("Consume: %v", err)
+	}
+	handled := make(chan string, 16)
+	c := newConsumer(ch, "chat_test", func(key, body string) AckType {
+		handled <- key + " " + body
+		return Ack
+	}, "", func(b []byte) (string, error) { return string(b), nil })
+	clock := newFakeClock()
+	c.limiter.now = clock.now
+	go c.run(deliveries)
+
+	// chat is 1 a second with a burst of 5: the last two are throttled
+	for i := 1; i <= 7; i++ {
+		if err := pubCh.Publish("peril_topic", "chat.alice", false, false, amqp.Publishing{Body: []byte(strconv.Itoa(i))}); err != nil {
+			t.Fatalf("Publish: %v", err)
+		}
+	}
+	want := []string{"chat.alice 1", "chat.alice 2", "chat.alice 3", "chat.alice 4", "chat.alice 5"}
+	for _, w := range want {
+		select {
+		case got := <-handled:
+			if got != w {
+				t.Errorf("handled %q, want %q", got, w)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("%q wasn't handled", w)
+		}
+	}
+	select {
+	case got := <-handled:
+		t.Fatalf("%q handled over the limit", got)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// once the sender has tokens again the parked messages come back,
+	// still under the key they were published with
+	clock.advance(10 * time.Second)
+	got := map[string]bool{}
+	for len(got) < 2 {
+		select {
+		case msg := <-handled:
+			got[msg] = true
+		case <-time.After(2 * time.Second):
+			t.Fatalf("throttled messages weren't redelivered, got %v", got)
+		}
+	}
+	if !got["chat.alice 6"] || !got["chat.alice 7"] {
+		t.Errorf("redelivered %v", got)
+	}
+}
+
+func TestForgedThrottleKey(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	b := devbroker.New(devbroker.Options{})
+	go b.Serve(ln)
+	defer b.Close()
+	if err := b.DeclareExchange("peril_topic", "topic"); err != nil {
+		t.Fatalf("DeclareExchange: %v", err)
+	}
+
+	conn, err := amqp.Dial("amqp://guest:guest@" + ln.Addr().String() + "/")
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer conn.Close()
+	pubCh, err := conn.Channel()
+	if err != nil {
+		t.Fatalf("Channel: %v", err)
+	}
+
+	ch, deliveries, err := AMQP(conn).Consume("peril_topic", "chat_test", "chat.*", SimpleQueueDurable)
+	if err != nil {
+		t.Fatalf("Consume: %v", err)
+	}
+	handled := make(chan string, 16)
+	c := newConsumer(ch, "chat_test", func(key, _ string) AckType {
+		handled <- key
+		return Ack
+	}, "", func(b []byte) (string, error) { return string(b), nil })
+	c.limiter.now = newFakeClock().now
+	go c.run(deliveries)
+
+	// a key with no limit in the header doesn't get mallory past the
+	// limit, and another player's key doesn't get him their name
+	for i, forged := range []string{"x", "chat.alice", "x", "chat.alice", "x", "x", "chat.alice"} {
+		err := pubCh.Publish("peril_topic", "chat.mallory", false, false, amqp.Publishing{
+			Headers: amqp.Table{throttledKeyHeader: forged},
+			Body:    []byte(strconv.Itoa(i)),
+		})
+		if err != nil {
+			t.Fatalf("Publish: %v", err)
+		}
+	}
+	for i := 0; i < 5; i++ {
+		select {
+		case key := <-handled:
+			if key != "chat.mallory" {
+				t.Errorf("handled under %q, want chat.mallory", key)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("only %d messages handled", i)
+		}
+	}
+	select {
+	case key := <-handled:
+		t.Fatalf("handled under %q over the limit", key)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
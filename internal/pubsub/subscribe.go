@@ -0,0 +1,140 @@
+package pubsub
+
+import (
+	"fmt"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// subscribe starts consuming the queue through broker, decodes every
+// delivery with unmarshaller and settles it with whatever the handler
+// returns. The handler also gets the key the message was published with.
+func subscribe[T any](
+	broker Broker,
+	exchange,
+	queueName,
+	key string,
+	queueType SimpleQueueType,
+	handler func(string, T) AckType,
+	contentType string,
+	unmarshaller func([]byte) (T, error),
+) error {
+	ch, deliveries, err := broker.Consume(exchange, queueName, key, queueType)
+	if err != nil {
+		return err
+	}
+
+	c := newConsumer(ch, queueName, handler, contentType, unmarshaller)
+	go func() {
+		c.run(deliveries)
+		_ = ch.Close()
+	}()
+
+	return nil
+}
+
+// consumer decodes and settles the deliveries of one queue.
+type consumer[T any] struct {
+	ch           Channel
+	queueName    string
+	handler      func(string, T) AckType // routing key, message
+	contentType  string                  // empty accepts any content type
+	unmarshaller func([]byte) (T, error)
+
+	limiter            *rateLimiter
+	throttleDeclared   bool
+	quarantineDeclared bool
+}
+
+func newConsumer[T any](
+	ch Channel,
+	queueName string,
+	handler func(string, T) AckType,
+	contentType string,
+	unmarshaller func([]byte) (T, error),
+) *consumer[T] {
+	return &consumer[T]{
+		ch:           ch,
+		queueName:    queueName,
+		handler:      handler,
+		contentType:  contentType,
+		unmarshaller: unmarshaller,
+		limiter:      newRateLimiter(),
+	}
+}
+
+// run handles every message until deliveries is closed.
+func (c *consumer[T]) run(deliveries <-chan amqp.Delivery) {
+	for msg := range deliveries {
+		if c.contentType != "" && msg.ContentType != "" && msg.ContentType != c.contentType {
+			// as undecodable as a bad body, so keep it for inspection too
+			c.quarantine(msg, fmt.Errorf("content type is %s, want %s", msg.ContentType, c.contentType))
+			continue
+		}
+
+		if c.throttled(msg) {
+			continue
+		}
+
+		val, err := c.unmarshaller(msg.Body)
+		if err != nil {
+			// Poison message: park it so it doesn't loop forever but can still be inspected
+			c.quarantine(msg, err)
+			continue
+		}
+
+		acknowledge(msg, c.handler(c.routingKey(msg), val))
+	}
+}
+
+// routingKey is the key msg was published with. Throttled messages come
+// back from their throttle queue under a different key, so they carry the
+// original in a header. Anyone can set that header though, so it only
+// counts on a message the throttle queue really dead-lettered back to us.
+func (c *consumer[T]) routingKey(msg amqp.Delivery) string {
+	if original, ok := msg.Headers[throttledKeyHeader].(string); ok && c.fromThrottle(msg) {
+		return original
+	}
+	return msg.RoutingKey
+}
+
+// fromThrottle reports whether msg came back from this queue's throttle
+// queue: through the default exchange, straight to this queue, with its
+// latest x-death saying it expired there.
+func (c *consumer[T]) fromThrottle(msg amqp.Delivery) bool {
+	if msg.Exchange != "" || msg.RoutingKey != c.queueName {
+		return false
+	}
+	deaths, _ := msg.Headers["x-death"].([]any)
+	if len(deaths) == 0 {
+		return false
+	}
+	death, _ := deaths[0].(amqp.Table)
+	return death["queue"] == c.throttleQueue() && death["reason"] == "expired"
+}
+
+// ignoreKey adapts a handler that doesn't care about the routing key.
+func ignoreKey[T any](handler func(T) AckType) func(string, T) AckType {
+	return func(_ string, val T) AckType {
+		return handler(val)
+	}
+}
+
+// acknowledge settles msg according to the handler's AckType.
+func acknowledge(msg amqp.Delivery, action AckType) {
+	switch action {
+	case Ack:
+		fmt.Println("[pubsub] Ack")
+		_ = msg.Ack(false)
+	case NackRequeue:
+		fmt.Println("[pubsub] NackRequeue")
+		_ = msg.Nack(false, true)
+	case NackDiscard:
+		fmt.Println("[pubsub] NackDiscard")
+		_ = msg.Nack(false, false)
+	default:
+		// Safe default for unexpected return values: discard
+		fmt.Println("[pubsub] Unknown AckType -> NackDiscard")
+		_ = msg.Nack(false, false)
+	}
+}
@@ -0,0 +1,64 @@
+package pubsub
+
+import (
+	"context"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+)
+
+// Publish encodes val with the topic's codec and publishes it to the topic's
+// exchange with key, usually from topic.RoutingKey.
+func Publish[T any](pub Publisher, topic routing.Topic[T], key string, val T) error {
+	return PublishWithContext(context.Background(), pub, topic, key, val)
+}
+
+// PublishWithContext is Publish with a context, see PublishJSONWithContext.
+func PublishWithContext[T any](ctx context.Context, pub Publisher, topic routing.Topic[T], key string, val T) error {
+	body, err := topic.Codec.Encode(val)
+	if err != nil {
+		return err
+	}
+
+	return pub.PublishWithContext(
+		ctx,
+		topic.Exchange,
+		key,
+		false, // mandatory
+		false, // immediate
+		amqp.Publishing{
+			ContentType: topic.Codec.ContentType,
+			Body:        body,
+		},
+	)
+}
+
+// Subscribe consumes queueName, bound to the topic's exchange with key, and
+// decodes every message with the topic's codec. The queue is durable or
+// transient as the topic's QueueSpec says.
+func Subscribe[T any](
+	broker Broker,
+	topic routing.Topic[T],
+	queueName,
+	key string,
+	handler func(T) AckType,
+) error {
+	return SubscribeKeyed(broker, topic, queueName, key, ignoreKey(handler))
+}
+
+// SubscribeKeyed is Subscribe for handlers that need the routing key each
+// message was published with, e.g. to check who sent it.
+func SubscribeKeyed[T any](
+	broker Broker,
+	topic routing.Topic[T],
+	queueName,
+	key string,
+	handler func(string, T) AckType,
+) error {
+	queueType := SimpleQueueTransient
+	if topic.Queue.Durable {
+		queueType = SimpleQueueDurable
+	}
+	return subscribe(broker, topic.Exchange, queueName, key, queueType, handler, topic.Codec.ContentType, topic.Codec.Decode)
+}
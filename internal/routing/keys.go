@@ -0,0 +1,136 @@
+package routing
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// Wildcards for binding patterns: AnyWord matches exactly one word of a
+// routing key and AnyWords matches zero or more.
+const (
+	AnyWord  = "*"
+	AnyWords = "#"
+)
+
+// MaxKeyLength is the longest routing key AMQP allows (it's a shortstr).
+const MaxKeyLength = 255
+
+// MaxUsernameLength leaves room for the longest prefix we put in front of a
+// username, like diplomacy_updates.<username>.
+const MaxUsernameLength = 64
+
+var ErrEmptyWord = errors.New("routing key words can't be empty")
+
+// ValidateWord checks that s can be used as one word of a routing key.
+// Dots would split it into more words and "*" or "#" would turn it into a
+// wildcard when it ends up in a binding, so none of those are allowed.
+func ValidateWord(s string) error {
+	if s == "" {
+		return ErrEmptyWord
+	}
+	for _, r := range s {
+		switch {
+		case r == '.' || r == '*' || r == '#':
+			return fmt.Errorf("%q can't contain '.', '*' or '#'", s)
+		case unicode.IsSpace(r) || !unicode.IsPrint(r):
+			return fmt.Errorf("%q can't contain spaces or control characters", s)
+		}
+	}
+	return nil
+}
+
+// ValidateUsername checks that a username can be routed safely: it goes
+// into queue names and routing keys like army_moves.<username>.
+func ValidateUsername(username string) error {
+	if err := ValidateWord(username); err != nil {
+		if errors.Is(err, ErrEmptyWord) {
+			return errors.New("username can't be empty")
+		}
+		return fmt.Errorf("invalid username: %v", err)
+	}
+	if len(username) > MaxUsernameLength {
+		return fmt.Errorf("username can't be longer than %d bytes", MaxUsernameLength)
+	}
+	return nil
+}
+
+// Key builds a routing key from its words, e.g. Key(ArmyMovesPrefix, "alice")
+// is "army_moves.alice". Every word must pass ValidateWord, so a word that
+// came from a player can never add words or wildcards to the key.
+func Key(words ...string) (string, error) {
+	for _, w := range words {
+		if err := ValidateWord(w); err != nil {
+			return "", err
+		}
+	}
+	return join(words)
+}
+
+// Pattern builds a binding pattern like Pattern(GameLogSlug, AnyWord). Words
+// are validated like Key, except they may also be AnyWord or AnyWords.
+//
+// Patterns are almost always built from constants, so it panics on a bad
+// word instead of returning an error, like regexp.MustCompile.
+func Pattern(words ...string) string {
+	for _, w := range words {
+		if w == AnyWord || w == AnyWords {
+			continue
+		}
+		if err := ValidateWord(w); err != nil {
+			panic("routing: bad pattern word: " + err.Error())
+		}
+	}
+	p, err := join(words)
+	if err != nil {
+		panic("routing: " + err.Error())
+	}
+	return p
+}
+
+func join(words []string) (string, error) {
+	if len(words) == 0 {
+		return "", ErrEmptyWord
+	}
+	key := strings.Join(words, ".")
+	if len(key) > MaxKeyLength {
+		return "", fmt.Errorf("routing key is %d bytes, the limit is %d", len(key), MaxKeyLength)
+	}
+	return key, nil
+}
+
+// Match reports whether key matches pattern the way a topic exchange does:
+// words are separated by dots, "*" matches exactly one word and "#" zero or
+// more. A pattern without wildcards only matches itself.
+func Match(pattern, key string) bool {
+	return matchWords(strings.Split(pattern, "."), strings.Split(key, "."))
+}
+
+func matchWords(pattern, key []string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case AnyWords:
+			// a run of # is the same as one
+			for len(pattern) > 1 && pattern[1] == AnyWords {
+				pattern = pattern[1:]
+			}
+			for i := 0; i <= len(key); i++ {
+				if matchWords(pattern[1:], key[i:]) {
+					return true
+				}
+			}
+			return false
+		case AnyWord:
+			if len(key) == 0 {
+				return false
+			}
+		default:
+			if len(key) == 0 || key[0] != pattern[0] {
+				return false
+			}
+		}
+		pattern, key = pattern[1:], key[1:]
+	}
+	return len(key) == 0
+}
@@ -0,0 +1,143 @@
+package routing
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		key     string
+		want    bool
+	}{
+		// no wildcards: only the key itself
+		{"pause", "pause", true},
+		{"pause", "pauses", false},
+		{"army_moves.alice", "army_moves.alice", true},
+		{"army_moves.alice", "army_moves.bob", false},
+
+		// * is exactly one word
+		{"army_moves.*", "army_moves.alice", true},
+		{"army_moves.*", "army_moves", false},
+		{"army_moves.*", "army_moves.alice.bob", false},
+		{"*.alice", "war.alice", true},
+		{"*", "pause", true},
+		{"*", "a.b", false},
+		{"a.*.c", "a.b.c", true},
+		{"a.*.c", "a.c", false},
+
+		// # is zero or more words
+		{"#", "pause", true},
+		{"#", "a.b.c", true},
+		{"war.#", "war", true},
+		{"war.#", "war.alice", true},
+		{"war.#", "war.alice.bob", true},
+		{"war.#", "wars.alice", false},
+		{"#.bob", "bob", true},
+		{"#.bob", "war.alice.bob", true},
+		{"#.bob", "war.bob.alice", false},
+		{"a.#.c", "a.c", true},
+		{"a.#.c", "a.b.b.c", true},
+		{"a.#.c", "a.b.b", false},
+		{"#.#", "a", true},
+		{"#.*", "a.b", true},
+		{"#.*", "", true},
+
+		// empty words are words too
+		{"", "", true},
+		{"*", "", true},
+		{"a.*", "a.", true},
+		{"a..c", "a..c", true},
+		{"a.*.c", "a..c", true},
+		{"a.*", "a", false},
+		{"", "a", false},
+	}
+	for _, tt := range tests {
+		if got := Match(tt.pattern, tt.key); got != tt.want {
+			t.Errorf("Match(%q, %q) = %v, want %v", tt.pattern, tt.key, got, tt.want)
+		}
+	}
+}
+
+func TestKey(t *testing.T) {
+	tests := []struct {
+		words   []string
+		want    string
+		wantErr bool
+	}{
+		{[]string{"pause"}, "pause", false},
+		{[]string{ArmyMovesPrefix, "alice"}, "army_moves.alice", false},
+		{[]string{"chat", "to", "alice"}, "chat.to.alice", false},
+		{nil, "", true},
+		{[]string{""}, "", true},
+		{[]string{"army_moves", ""}, "", true},
+		{[]string{"army_moves", "alice.bob"}, "", true},
+		{[]string{"army_moves", "*"}, "", true},
+		{[]string{"army_moves", "#"}, "", true},
+		{[]string{"army_moves", "al ice"}, "", true},
+		{[]string{"army_moves", "al\nice"}, "", true},
+		{[]string{strings.Repeat("a", MaxKeyLength)}, strings.Repeat("a", MaxKeyLength), false},
+		{[]string{strings.Repeat("a", MaxKeyLength), "b"}, "", true},
+	}
+	for _, tt := range tests {
+		got, err := Key(tt.words...)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("Key(%q) error = %v, wantErr %v", tt.words, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("Key(%q) = %q, want %q", tt.words, got, tt.want)
+		}
+	}
+}
+
+func TestPattern(t *testing.T) {
+	if got := Pattern(GameLogSlug, AnyWord); got != "game_logs.*" {
+		t.Errorf("Pattern = %q", got)
+	}
+	if got := Pattern(AnyWords); got != "#" {
+		t.Errorf("Pattern = %q", got)
+	}
+
+	for _, words := range [][]string{nil, {""}, {"a.b"}, {"a", "b*"}} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("Pattern(%q) didn't panic", words)
+				}
+			}()
+			Pattern(words...)
+		}()
+	}
+}
+
+func TestValidateUsername(t *testing.T) {
+	tests := []struct {
+		username string
+		valid    bool
+	}{
+		{"alice", true},
+		{"Alice_42-x", true},
+		{"élodie", true},
+		{strings.Repeat("a", MaxUsernameLength), true},
+		{strings.Repeat("a", MaxUsernameLength+1), false},
+		{strings.Repeat("é", MaxUsernameLength/2+1), false}, // the limit is in bytes
+		{"", false},
+		{"alice.bob", false},
+		{".", false},
+		{"*", false},
+		{"#", false},
+		{"al*ce", false},
+		{"al ice", false},
+		{"alice\t", false},
+		{"alice\x00", false},
+		{"\u200balice", false}, // zero-width space
+	}
+	for _, tt := range tests {
+		err := ValidateUsername(tt.username)
+		if (err == nil) != tt.valid {
+			t.Errorf("ValidateUsername(%q) = %v, want valid %v", tt.username, err, tt.valid)
+		}
+	}
+}
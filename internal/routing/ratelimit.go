@@ -0,0 +1,36 @@
+package routing
+
+import "strings"
+
+// RateLimit is a token bucket: PerSecond tokens are added every second, up
+// to Burst.
+type RateLimit struct {
+	PerSecond float64
+	Burst     int
+}
+
+// RateLimits caps how fast one player may send under each routing prefix.
+// Publishers enforce it for themselves, and consumers enforce it per
+// username found in the routing key (<prefix>.<username>).
+var RateLimits = map[string]RateLimit{
+	CommandsPrefix:  {PerSecond: 4, Burst: 10},
+	DiplomacyPrefix: {PerSecond: 1, Burst: 5},
+	ChatPrefix:      {PerSecond: 1, Burst: 5},
+	GameLogSlug:     {PerSecond: 10, Burst: 20},
+}
+
+// RateLimitFor finds the limit for a routing key like commands.alice and
+// returns the bucket key it should be counted against. Only two-word keys
+// are limited, so what the server sends out under the same prefix, like
+// chat.to.alice, isn't.
+func RateLimitFor(key string) (bucket string, limit RateLimit, ok bool) {
+	parts := strings.Split(key, ".")
+	if len(parts) != 2 {
+		return "", RateLimit{}, false
+	}
+	limit, ok = RateLimits[parts[0]]
+	if !ok {
+		return "", RateLimit{}, false
+	}
+	return parts[0] + "." + parts[1], limit, true
+}
@@ -5,12 +5,44 @@ const (
 
 	WarRecognitionsPrefix = "war"
 
+	WarOutcomesPrefix = "war_outcomes"
+
+	BattlesPrefix = "battles"
+
 	PauseKey = "pause"
 
+	TickKey = "tick"
+
+	TurnStartKey = "turn_start"
+
+	TurnEndKey = "turn_end"
+
+	ScoresKey = "scores"
+
+	GameOverKey = "game_over"
+
 	GameLogSlug = "game_logs"
+
+	CommandsPrefix = "commands"
+
+	PlayerUpdatesPrefix = "player_updates"
+
+	DiplomacyPrefix = "diplomacy"
+
+	DiplomacyUpdatesPrefix = "diplomacy_updates"
+
+	ChatPrefix = "chat"
 )
 
 const (
-	ExchangePerilDirect = "peril_direct"
-	ExchangePerilTopic  = "peril_topic"
+	ExchangePerilDirect     = "peril_direct"
+	ExchangePerilTopic      = "peril_topic"
+	ExchangePerilQuarantine = "peril_quarantine"
+	ExchangePerilDLX        = "peril_dlx"
 )
+
+// DeadLetterQueue collects everything dead-lettered to peril_dlx.
+const DeadLetterQueue = "peril_dlq"
+
+// QuarantineQueue collects messages no subscriber could decode.
+const QuarantineQueue = "peril_quarantine"
@@ -0,0 +1,193 @@
+package routing
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// Codec turns one payload type into message bodies and back.
+type Codec[T any] struct {
+	ContentType string
+	Encode      func(T) ([]byte, error)
+	Decode      func([]byte) (T, error)
+}
+
+// JSON encodes T with encoding/json as application/json.
+func JSON[T any]() Codec[T] {
+	return Codec[T]{
+		ContentType: "application/json",
+		Encode: func(val T) ([]byte, error) {
+			return json.Marshal(val)
+		},
+		Decode: func(body []byte) (T, error) {
+			var val T
+			err := json.Unmarshal(body, &val)
+			return val, err
+		},
+	}
+}
+
+// Gob encodes T with encoding/gob as application/gob.
+func Gob[T any]() Codec[T] {
+	return Codec[T]{
+		ContentType: "application/gob",
+		Encode: func(val T) ([]byte, error) {
+			var buf bytes.Buffer
+			err := gob.NewEncoder(&buf).Encode(val)
+			return buf.Bytes(), err
+		},
+		Decode: func(body []byte) (T, error) {
+			var val T
+			err := gob.NewDecoder(bytes.NewReader(body)).Decode(&val)
+			return val, err
+		},
+	}
+}
+
+// QueueSpec is the queue a subscriber declares for a topic unless it has a
+// reason not to. Name is a template like Topic.Key; a shared queue has no
+// placeholders, a per-player one has {username} in it.
+type QueueSpec struct {
+	Name    string
+	Durable bool // durable and shared, otherwise transient and exclusive
+}
+
+// Topic ties a payload type to where it travels and how it's encoded, so
+// publishing an ArmyMove as gob or subscribing to game logs with the wrong
+// struct doesn't compile.
+//
+// Key is a template whose {placeholder} words are filled in by Topic.Key and
+// replaced with "*" by Topic.Pattern, e.g. "army_moves.{username}".
+type Topic[T any] struct {
+	Name        string
+	Description string
+	Exchange    string
+	Key         string
+	Codec       Codec[T]
+	Queue       QueueSpec
+}
+
+// RoutingKey fills in the key template's placeholders in order. Every value
+// has to pass ValidateWord.
+func (t Topic[T]) RoutingKey(values ...string) (string, error) {
+	return fill(t.Key, values)
+}
+
+// Values is the opposite of RoutingKey: the placeholder values in key, in
+// order. It fails if key isn't one of the topic's keys.
+func (t Topic[T]) Values(key string) ([]string, error) {
+	words := strings.Split(t.Key, ".")
+	keyWords := strings.Split(key, ".")
+	if len(keyWords) != len(words) {
+		return nil, fmt.Errorf("%q is not a key of %s", key, t.Key)
+	}
+	values := []string{}
+	for i, w := range words {
+		if !isPlaceholder(w) {
+			if keyWords[i] != w {
+				return nil, fmt.Errorf("%q is not a key of %s", key, t.Key)
+			}
+			continue
+		}
+		if err := ValidateWord(keyWords[i]); err != nil {
+			return nil, err
+		}
+		values = append(values, keyWords[i])
+	}
+	return values, nil
+}
+
+// Pattern is the binding pattern that matches every key of the topic.
+func (t Topic[T]) Pattern() string {
+	return pattern(t.Key)
+}
+
+func pattern(template string) string {
+	words := strings.Split(template, ".")
+	for i, w := range words {
+		if isPlaceholder(w) {
+			words[i] = AnyWord
+		}
+	}
+	return strings.Join(words, ".")
+}
+
+// QueueName fills in the default queue name's placeholders in order.
+func (t Topic[T]) QueueName(values ...string) (string, error) {
+	return fill(t.Queue.Name, values)
+}
+
+// Placeholders lists the placeholder names in the key template, e.g.
+// ["username"] for "army_moves.{username}".
+func (t Topic[T]) Placeholders() []string {
+	return placeholders(t.Key)
+}
+
+func placeholders(template string) []string {
+	var names []string
+	for _, w := range strings.Split(template, ".") {
+		if isPlaceholder(w) {
+			names = append(names, w[1:len(w)-1])
+		}
+	}
+	return names
+}
+
+// TopicInfo is a Topic without its type parameter, for tools that walk
+// every topic, like the AsyncAPI exporter.
+type TopicInfo struct {
+	Name        string
+	Description string
+	Exchange    string
+	Key         string
+	ContentType string
+	Queue       QueueSpec
+	Payload     reflect.Type
+}
+
+func (t Topic[T]) Info() TopicInfo {
+	return TopicInfo{
+		Name:        t.Name,
+		Description: t.Description,
+		Exchange:    t.Exchange,
+		Key:         t.Key,
+		ContentType: t.Codec.ContentType,
+		Queue:       t.Queue,
+		Payload:     reflect.TypeOf((*T)(nil)).Elem(),
+	}
+}
+
+func (t TopicInfo) Pattern() string {
+	return pattern(t.Key)
+}
+
+func (t TopicInfo) Placeholders() []string {
+	return placeholders(t.Key)
+}
+
+func isPlaceholder(word string) bool {
+	return len(word) > 2 && word[0] == '{' && word[len(word)-1] == '}'
+}
+
+func fill(template string, values []string) (string, error) {
+	words := strings.Split(template, ".")
+	n := 0
+	for i, w := range words {
+		if !isPlaceholder(w) {
+			continue
+		}
+		if n == len(values) {
+			return "", fmt.Errorf("%s needs a value for %s", template, w)
+		}
+		words[i] = values[n]
+		n++
+	}
+	if n != len(values) {
+		return "", fmt.Errorf("%s takes %d values, got %d", template, n, len(values))
+	}
+	return Key(words...)
+}
@@ -0,0 +1,369 @@
+package stomp
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrClosed is returned once the connection has been closed.
+var ErrClosed = errors.New("stomp: connection closed")
+
+const (
+	defaultHeartBeat = 10 * time.Second
+	connectTimeout   = 10 * time.Second
+)
+
+// Options configure Dial.
+type Options struct {
+	Login    string
+	Passcode string
+	Host     string // virtual host, "/" when empty
+
+	// HeartBeat is the interval we offer for heart-beats in both
+	// directions; 0 means 10s and a negative value disables them.
+	HeartBeat time.Duration
+}
+
+// AckMode is the acknowledgement mode of a subscription.
+type AckMode string
+
+const (
+	AckAuto             AckMode = "auto"              // the broker considers a message acked once sent
+	AckClient           AckMode = "client"            // an ACK covers every earlier message too
+	AckClientIndividual AckMode = "client-individual" // every message is acked on its own
+)
+
+// Client is a STOMP 1.2 connection.
+type Client struct {
+	conn net.Conn
+	r    *bufio.Reader
+
+	wmu       sync.Mutex
+	w         *bufio.Writer
+	lastWrite time.Time
+
+	readTimeout time.Duration
+
+	mu       sync.Mutex
+	nextID   int
+	subs     map[string]*Subscription
+	receipts map[string]chan struct{}
+	err      error
+	closed   chan struct{}
+}
+
+// Dial connects and logs in to the STOMP broker at addr (host:port).
+func Dial(addr string, opts Options) (*Client, error) {
+	conn, err := net.DialTimeout("tcp", addr, connectTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Client{
+		conn:     conn,
+		r:        bufio.NewReader(conn),
+		w:        bufio.NewWriter(conn),
+		subs:     map[string]*Subscription{},
+		receipts: map[string]chan struct{}{},
+		closed:   make(chan struct{}),
+	}
+
+	hb := opts.HeartBeat
+	if hb == 0 {
+		hb = defaultHeartBeat
+	}
+	if hb < 0 {
+		hb = 0
+	}
+	host := opts.Host
+	if host == "" {
+		host = "/"
+	}
+
+	connect := NewFrame("CONNECT",
+		"accept-version", "1.2",
+		"host", host,
+		"heart-beat", fmt.Sprintf("%d,%d", hb.Milliseconds(), hb.Milliseconds()),
+	)
+	if opts.Login != "" {
+		connect.Header["login"] = opts.Login
+		connect.Header["passcode"] = opts.Passcode
+	}
+
+	_ = conn.SetDeadline(time.Now().Add(connectTimeout))
+	if err := c.write(connect); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	resp, err := ReadFrame(c.r)
+	for err == nil && resp.IsHeartBeat() {
+		resp, err = ReadFrame(c.r)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	_ = conn.SetDeadline(time.Time{})
+
+	switch resp.Command {
+	case "CONNECTED":
+	case "ERROR":
+		conn.Close()
+		return nil, frameError(resp)
+	default:
+		conn.Close()
+		return nil, fmt.Errorf("stomp: expected CONNECTED, got %s", resp.Command)
+	}
+
+	// heart-beat negotiation: each side uses the slower of what one offers
+	// and the other wants, and 0 on either side turns a direction off
+	sx, sy := parseHeartBeat(resp.Header["heart-beat"])
+	sendEvery := negotiate(hb, sy)
+	expectEvery := negotiate(hb, sx)
+	if expectEvery > 0 {
+		// allow for some network jitter before giving up on the broker
+		c.readTimeout = 2 * expectEvery
+	}
+
+	go c.readLoop()
+	if sendEvery > 0 {
+		go c.heartBeatLoop(sendEvery)
+	}
+
+	return c, nil
+}
+
+func parseHeartBeat(h string) (time.Duration, time.Duration) {
+	x, y, ok := strings.Cut(h, ",")
+	if !ok {
+		return 0, 0
+	}
+	cx, _ := strconv.Atoi(strings.TrimSpace(x))
+	cy, _ := strconv.Atoi(strings.TrimSpace(y))
+	return time.Duration(cx) * time.Millisecond, time.Duration(cy) * time.Millisecond
+}
+
+func negotiate(ours, theirs time.Duration) time.Duration {
+	if ours == 0 || theirs == 0 {
+		return 0
+	}
+	return max(ours, theirs)
+}
+
+func frameError(f Frame) error {
+	msg := f.Header["message"]
+	if len(f.Body) > 0 {
+		msg += ": " + strings.TrimSpace(string(f.Body))
+	}
+	return fmt.Errorf("stomp: broker error: %s", msg)
+}
+
+func (c *Client) write(f Frame) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+
+	if err := WriteFrame(c.w, f); err != nil {
+		return err
+	}
+	if err := c.w.Flush(); err != nil {
+		return err
+	}
+	c.lastWrite = time.Now()
+	return nil
+}
+
+func (c *Client) heartBeatLoop(every time.Duration) {
+	ticker := time.NewTicker(every / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.closed:
+			return
+		case <-ticker.C:
+			c.wmu.Lock()
+			idle := time.Since(c.lastWrite) >= every/2
+			c.wmu.Unlock()
+			if idle {
+				if err := c.write(Frame{}); err != nil {
+					c.shutdown(err)
+					return
+				}
+			}
+		}
+	}
+}
+
+func (c *Client) readLoop() {
+	for {
+		if c.readTimeout > 0 {
+			_ = c.conn.SetReadDeadline(time.Now().Add(c.readTimeout))
+		}
+		f, err := ReadFrame(c.r)
+		if err != nil {
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				err = errors.New("stomp: broker missed its heart-beats")
+			}
+			c.shutdown(err)
+			return
+		}
+
+		switch f.Command {
+		case "":
+			// heart-beat: the read deadline has been pushed back, nothing else to do
+
+		case "MESSAGE":
+			c.mu.Lock()
+			sub := c.subs[f.Header["subscription"]]
+			c.mu.Unlock()
+			if sub != nil {
+				sub.dispatch(f)
+			}
+
+		case "RECEIPT":
+			c.mu.Lock()
+			done, ok := c.receipts[f.Header["receipt-id"]]
+			delete(c.receipts, f.Header["receipt-id"])
+			c.mu.Unlock()
+			if ok {
+				close(done)
+			}
+
+		case "ERROR":
+			c.shutdown(frameError(f))
+			return
+		}
+	}
+}
+
+// shutdown closes the connection once, remembering why.
+func (c *Client) shutdown(err error) {
+	c.mu.Lock()
+	if c.err != nil {
+		c.mu.Unlock()
+		return
+	}
+	c.err = err
+	subs := c.subs
+	c.subs = map[string]*Subscription{}
+	close(c.closed)
+	c.mu.Unlock()
+
+	c.conn.Close()
+	for _, sub := range subs {
+		sub.finish()
+	}
+}
+
+// Err returns why the connection was closed, or nil while it is open.
+func (c *Client) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+func (c *Client) newID(prefix string) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.nextID++
+	return prefix + strconv.Itoa(c.nextID)
+}
+
+// request sends f with a receipt header and waits for the broker's RECEIPT.
+func (c *Client) request(ctx context.Context, f Frame) error {
+	id := c.newID("receipt-")
+	done := make(chan struct{})
+
+	c.mu.Lock()
+	if c.err != nil {
+		c.mu.Unlock()
+		return ErrClosed
+	}
+	c.receipts[id] = done
+	c.mu.Unlock()
+
+	f.Header["receipt"] = id
+	if err := c.write(f); err != nil {
+		c.shutdown(err)
+		return err
+	}
+
+	select {
+	case <-done:
+		return nil
+	case <-c.closed:
+		return fmt.Errorf("%w: %v", ErrClosed, c.Err())
+	case <-ctx.Done():
+		c.mu.Lock()
+		delete(c.receipts, id)
+		c.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+// Send publishes body to destination and waits for the broker to confirm
+// it with a receipt.
+func (c *Client) Send(ctx context.Context, destination string, header map[string]string, body []byte) error {
+	f := NewFrame("SEND", "destination", destination)
+	for k, v := range header {
+		f.Header[k] = v
+	}
+	f.Body = body
+	return c.request(ctx, f)
+}
+
+// Subscribe starts a subscription to destination. Extra headers are passed
+// on as-is, which is how RabbitMQ takes queue names and arguments.
+func (c *Client) Subscribe(destination string, mode AckMode, header map[string]string) (*Subscription, error) {
+	if mode == "" {
+		mode = AckAuto
+	}
+	sub := &Subscription{
+		c:          c,
+		id:         c.newID("sub-"),
+		mode:       mode,
+		deliveries: make(chan Message, 64),
+		stopped:    make(chan struct{}),
+	}
+
+	f := NewFrame("SUBSCRIBE", "destination", destination, "id", sub.id, "ack", string(mode))
+	for k, v := range header {
+		f.Header[k] = v
+	}
+
+	c.mu.Lock()
+	if c.err != nil {
+		c.mu.Unlock()
+		return nil, ErrClosed
+	}
+	c.subs[sub.id] = sub
+	c.mu.Unlock()
+
+	if err := c.request(context.Background(), f); err != nil {
+		c.mu.Lock()
+		delete(c.subs, sub.id)
+		c.mu.Unlock()
+		return nil, err
+	}
+	return sub, nil
+}
+
+// Close disconnects gracefully, waiting briefly for the broker's receipt.
+func (c *Client) Close() error {
+	if c.Err() != nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err := c.request(ctx, NewFrame("DISCONNECT"))
+	c.shutdown(ErrClosed)
+	return err
+}
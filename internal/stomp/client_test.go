@@ -0,0 +1,323 @@
+package stomp_test
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/stomp"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/stomp/stomptest"
+)
+
+func dial(t *testing.T, opts stomp.Options) (*stomptest.Server, *stomp.Client) {
+	t.Helper()
+	srv, err := stomptest.NewServer()
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+	t.Cleanup(srv.Close)
+	c, err := stomp.Dial(srv.Addr, opts)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return srv, c
+}
+
+func send(t *testing.T, c *stomp.Client, dest, body string) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := c.Send(ctx, dest, nil, []byte(body)); err != nil {
+		t.Fatalf("Send(%s): %v", dest, err)
+	}
+}
+
+func receive(t *testing.T, sub *stomp.Subscription) stomp.Message {
+	t.Helper()
+	select {
+	case msg, ok := <-sub.C():
+		if !ok {
+			t.Fatal("subscription closed")
+		}
+		return msg
+	case <-time.After(time.Second):
+		t.Fatal("no message")
+	}
+	return stomp.Message{}
+}
+
+func nothing(t *testing.T, sub *stomp.Subscription) {
+	t.Helper()
+	select {
+	case msg := <-sub.C():
+		t.Fatalf("unexpected message %q", msg.Body)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestSendAndReceive(t *testing.T) {
+	_, c := dial(t, stomp.Options{HeartBeat: -1})
+
+	sub, err := c.Subscribe("/exchange/peril_topic/army_moves.*", stomp.AckAuto, map[string]string{"x-queue-name": "moves"})
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	body := []byte("a\x00b")
+	if err := c.Send(ctx, "/exchange/peril_topic/army_moves.alice", map[string]string{"note": "a:b\nc"}, body); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	send(t, c, "/exchange/peril_topic/war.alice", "not for us")
+
+	msg := receive(t, sub)
+	if msg.Destination() != "/exchange/peril_topic/army_moves.alice" {
+		t.Errorf("destination = %q", msg.Destination())
+	}
+	if string(msg.Body) != string(body) {
+		t.Errorf("body = %q, want %q", msg.Body, body)
+	}
+	if msg.Header["note"] != "a:b\nc" {
+		t.Errorf("note header = %q", msg.Header["note"])
+	}
+	nothing(t, sub)
+}
+
+func TestReceipts(t *testing.T) {
+	srv, c := dial(t, stomp.Options{HeartBeat: -1})
+
+	// Subscribe waits for its receipt, so the queue exists when it returns
+	if _, err := c.Subscribe("/queue/work", stomp.AckClientIndividual, nil); err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	if _, ok := srv.QueueLength("work"); !ok {
+		t.Error("queue missing after Subscribe returned")
+	}
+
+	// and Send waits for the broker to have routed the message
+	send(t, c, "/queue/other", "x")
+	if n, ok := srv.QueueLength("other"); !ok || n != 1 {
+		t.Errorf("QueueLength(other) = %d, %v after Send returned", n, ok)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := c.Send(ctx, "/queue/other", nil, nil); err != context.Canceled {
+		t.Errorf("Send with a canceled context = %v", err)
+	}
+
+	if err := c.Close(); err != nil {
+		t.Errorf("Close: %v", err)
+	}
+	if err := c.Send(context.Background(), "/queue/other", nil, nil); err == nil {
+		t.Error("Send after Close succeeded")
+	}
+}
+
+func TestHeartBeats(t *testing.T) {
+	// stomptest sends heart-beats as often as we ask, so a quiet connection
+	// stays up well past the read timeout
+	_, c := dial(t, stomp.Options{HeartBeat: 20 * time.Millisecond})
+	time.Sleep(200 * time.Millisecond)
+	if err := c.Err(); err != nil {
+		t.Fatalf("connection closed while idle: %v", err)
+	}
+	send(t, c, "/queue/q", "still here")
+}
+
+// silentBroker accepts one connection, answers CONNECT with heartBeat and
+// then only reads, apart from answering DISCONNECT. It sends every frame it
+// reads to frames.
+func silentBroker(t *testing.T, heartBeat string) (string, <-chan stomp.Frame) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	frames := make(chan stomp.Frame, 64)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r, w := bufio.NewReader(conn), bufio.NewWriter(conn)
+		if _, err := stomp.ReadFrame(r); err != nil {
+			return
+		}
+		stomp.WriteFrame(w, stomp.NewFrame("CONNECTED", "version", "1.2", "heart-beat", heartBeat))
+		w.Flush()
+		for {
+			f, err := stomp.ReadFrame(r)
+			if err != nil {
+				close(frames)
+				return
+			}
+			if f.Command == "DISCONNECT" {
+				stomp.WriteFrame(w, stomp.NewFrame("RECEIPT", "receipt-id", f.Header["receipt"]))
+				w.Flush()
+				continue
+			}
+			select {
+			case frames <- f:
+			default:
+			}
+		}
+	}()
+	return ln.Addr().String(), frames
+}
+
+func TestMissedHeartBeats(t *testing.T) {
+	// the broker promises heart-beats every 20ms and never sends any
+	addr, _ := silentBroker(t, "20,0")
+	c, err := stomp.Dial(addr, stomp.Options{HeartBeat: 20 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer c.Close()
+
+	deadline := time.Now().Add(time.Second)
+	for c.Err() == nil && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := c.Err(); err == nil || !strings.Contains(err.Error(), "heart-beats") {
+		t.Errorf("Err = %v, want missed heart-beats", err)
+	}
+}
+
+func TestSendsHeartBeats(t *testing.T) {
+	// the broker wants a heart-beat every 20ms and sends none itself
+	addr, frames := silentBroker(t, "0,20")
+	c, err := stomp.Dial(addr, stomp.Options{HeartBeat: 20 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer c.Close()
+
+	beats := 0
+	timeout := time.After(time.Second)
+	for beats < 3 {
+		select {
+		case f := <-frames:
+			if f.IsHeartBeat() {
+				beats++
+			}
+		case <-timeout:
+			t.Fatalf("got %d heart-beats in a second", beats)
+		}
+	}
+	if err := c.Err(); err != nil {
+		t.Errorf("Err = %v with heart-beats off from the broker", err)
+	}
+}
+
+func TestNoHeartBeats(t *testing.T) {
+	// 0 from the broker turns both directions off, whatever we offer
+	addr, frames := silentBroker(t, "0,0")
+	c, err := stomp.Dial(addr, stomp.Options{HeartBeat: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer c.Close()
+
+	time.Sleep(100 * time.Millisecond)
+	if err := c.Err(); err != nil {
+		t.Errorf("Err = %v", err)
+	}
+	select {
+	case f := <-frames:
+		t.Errorf("got %+v, want nothing", f)
+	default:
+	}
+}
+
+func TestAckAndNack(t *testing.T) {
+	srv, c := dial(t, stomp.Options{HeartBeat: -1})
+
+	dead, err := c.Subscribe("/exchange/peril_dlx/#", stomp.AckAuto, map[string]string{"x-queue-name": "dead"})
+	if err != nil {
+		t.Fatalf("Subscribe dead: %v", err)
+	}
+	sub, err := c.Subscribe("/exchange/peril_topic/game_logs.*", stomp.AckClientIndividual, map[string]string{
+		"x-queue-name":           "logs",
+		"x-dead-letter-exchange": "peril_dlx",
+		"prefetch-count":         "1",
+	})
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	send(t, c, "/exchange/peril_topic/game_logs.alice", "one")
+	send(t, c, "/exchange/peril_topic/game_logs.alice", "two")
+
+	// prefetch 1: the second message waits until the first is settled
+	first := receive(t, sub)
+	if string(first.Body) != "one" {
+		t.Fatalf("first = %q", first.Body)
+	}
+	nothing(t, sub)
+	if n, _ := srv.QueueLength("logs"); n != 1 {
+		t.Errorf("%d messages waiting, want 1", n)
+	}
+
+	// a requeued message comes back first, marked as redelivered
+	if err := first.Nack(true); err != nil {
+		t.Fatalf("Nack: %v", err)
+	}
+	again := receive(t, sub)
+	if string(again.Body) != "one" || again.Header["redelivered"] != "true" {
+		t.Fatalf("after requeue got %q redelivered=%q", again.Body, again.Header["redelivered"])
+	}
+
+	if err := again.Ack(); err != nil {
+		t.Fatalf("Ack: %v", err)
+	}
+	second := receive(t, sub)
+	if string(second.Body) != "two" || second.Header["redelivered"] != "" {
+		t.Fatalf("after ack got %q redelivered=%q", second.Body, second.Header["redelivered"])
+	}
+
+	// without requeue it's dead-lettered with its routing key
+	if err := second.Nack(false); err != nil {
+		t.Fatalf("Nack: %v", err)
+	}
+	msg := receive(t, dead)
+	if string(msg.Body) != "two" || msg.Destination() != "/exchange/peril_dlx/game_logs.alice" {
+		t.Errorf("dead-lettered %q to %s", msg.Body, msg.Destination())
+	}
+	nothing(t, sub)
+	if n, _ := srv.QueueLength("logs"); n != 0 {
+		t.Errorf("%d messages left in logs", n)
+	}
+}
+
+func TestUnsubscribeRequeues(t *testing.T) {
+	_, c := dial(t, stomp.Options{HeartBeat: -1})
+
+	sub, err := c.Subscribe("/queue/jobs", stomp.AckClientIndividual, nil)
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	send(t, c, "/queue/jobs", "job")
+	receive(t, sub)
+	if err := sub.Unsubscribe(); err != nil {
+		t.Fatalf("Unsubscribe: %v", err)
+	}
+	if _, ok := <-sub.C(); ok {
+		t.Error("C still open after Unsubscribe")
+	}
+
+	again, err := c.Subscribe("/queue/jobs", stomp.AckClientIndividual, nil)
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	msg := receive(t, again)
+	if string(msg.Body) != "job" || msg.Header["redelivered"] != "true" {
+		t.Errorf("got %q redelivered=%q", msg.Body, msg.Header["redelivered"])
+	}
+}
@@ -0,0 +1,201 @@
+package stomp
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// maxBodySize guards against a peer announcing an absurd content-length.
+const maxBodySize = 16 << 20
+
+// Frame is a single STOMP frame. A Frame with an empty Command is a
+// heart-beat (a bare end-of-line on the wire).
+type Frame struct {
+	Command string
+	Header  map[string]string
+	Body    []byte
+}
+
+// NewFrame builds a frame from alternating header names and values.
+func NewFrame(command string, header ...string) Frame {
+	f := Frame{Command: command, Header: map[string]string{}}
+	for i := 0; i+1 < len(header); i += 2 {
+		f.Header[header[i]] = header[i+1]
+	}
+	return f
+}
+
+// IsHeartBeat reports whether f is a heart-beat rather than a real frame.
+func (f Frame) IsHeartBeat() bool {
+	return f.Command == ""
+}
+
+// ReadFrame reads the next frame from r. A heart-beat comes back as a Frame
+// with an empty Command, so callers can treat it as activity.
+func ReadFrame(r *bufio.Reader) (Frame, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return Frame{}, err
+	}
+	if line == "" {
+		return Frame{}, nil
+	}
+
+	f := Frame{Command: line, Header: map[string]string{}}
+	escaped := escapesHeaders(f.Command)
+
+	for {
+		line, err := readLine(r)
+		if err != nil {
+			return Frame{}, err
+		}
+		if line == "" {
+			break
+		}
+		name, value, ok := strings.Cut(line, ":")
+		if !ok {
+			return Frame{}, fmt.Errorf("stomp: malformed header %q", line)
+		}
+		if escaped {
+			if name, err = unescape(name); err != nil {
+				return Frame{}, err
+			}
+			if value, err = unescape(value); err != nil {
+				return Frame{}, err
+			}
+		}
+		// STOMP 1.2: when a header repeats, the first value wins
+		if _, ok := f.Header[name]; !ok {
+			f.Header[name] = value
+		}
+	}
+
+	if cl, ok := f.Header["content-length"]; ok {
+		n, err := strconv.Atoi(cl)
+		if err != nil || n < 0 || n > maxBodySize {
+			return Frame{}, fmt.Errorf("stomp: invalid content-length %q", cl)
+		}
+		f.Body = make([]byte, n)
+		if _, err := io.ReadFull(r, f.Body); err != nil {
+			return Frame{}, err
+		}
+		nul, err := r.ReadByte()
+		if err != nil {
+			return Frame{}, err
+		}
+		if nul != 0 {
+			return Frame{}, errors.New("stomp: frame body not terminated by NUL")
+		}
+		return f, nil
+	}
+
+	body, err := r.ReadBytes(0)
+	if err != nil {
+		return Frame{}, err
+	}
+	if len(body) > maxBodySize {
+		return Frame{}, errors.New("stomp: frame body too large")
+	}
+	f.Body = body[:len(body)-1]
+	return f, nil
+}
+
+// WriteFrame writes f to w. The caller flushes.
+func WriteFrame(w *bufio.Writer, f Frame) error {
+	if f.IsHeartBeat() {
+		return w.WriteByte('\n')
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(f.Command)
+	buf.WriteByte('\n')
+
+	escaped := escapesHeaders(f.Command)
+	names := make([]string, 0, len(f.Header))
+	for name := range f.Header {
+		if name != "content-length" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		value := f.Header[name]
+		if escaped {
+			name, value = escape(name), escape(value)
+		}
+		buf.WriteString(name)
+		buf.WriteByte(':')
+		buf.WriteString(value)
+		buf.WriteByte('\n')
+	}
+	if len(f.Body) > 0 {
+		buf.WriteString("content-length:")
+		buf.WriteString(strconv.Itoa(len(f.Body)))
+		buf.WriteByte('\n')
+	}
+	buf.WriteByte('\n')
+	buf.Write(f.Body)
+	buf.WriteByte(0)
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// readLine reads one line, accepting both LF and CRLF endings.
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	line = strings.TrimSuffix(line, "\n")
+	line = strings.TrimSuffix(line, "\r")
+	return line, nil
+}
+
+// CONNECT and CONNECTED frames are the only ones without header escaping,
+// so that STOMP 1.0 peers can still read them.
+func escapesHeaders(command string) bool {
+	return command != "CONNECT" && command != "CONNECTED"
+}
+
+var escaper = strings.NewReplacer(`\`, `\\`, "\r", `\r`, "\n", `\n`, ":", `\c`)
+
+func escape(s string) string {
+	return escaper.Replace(s)
+}
+
+func unescape(s string) (string, error) {
+	if !strings.Contains(s, `\`) {
+		return s, nil
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' {
+			b.WriteByte(s[i])
+			continue
+		}
+		i++
+		if i == len(s) {
+			return "", fmt.Errorf("stomp: dangling escape in %q", s)
+		}
+		switch s[i] {
+		case '\\':
+			b.WriteByte('\\')
+		case 'r':
+			b.WriteByte('\r')
+		case 'n':
+			b.WriteByte('\n')
+		case 'c':
+			b.WriteByte(':')
+		default:
+			return "", fmt.Errorf("stomp: undefined escape \\%c in %q", s[i], s)
+		}
+	}
+	return b.String(), nil
+}
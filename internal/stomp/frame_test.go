@@ -0,0 +1,184 @@
+package stomp
+
+import (
+	"bufio"
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func writeFrame(t *testing.T, f Frame) string {
+	t.Helper()
+	var buf bytes.Buffer
+	w := bufio.NewWriter(&buf)
+	if err := WriteFrame(w, f); err != nil {
+		t.Fatalf("WriteFrame: %v", err)
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+	return buf.String()
+}
+
+func readFrame(t *testing.T, wire string) Frame {
+	t.Helper()
+	f, err := ReadFrame(bufio.NewReader(strings.NewReader(wire)))
+	if err != nil {
+		t.Fatalf("ReadFrame(%q): %v", wire, err)
+	}
+	return f
+}
+
+func TestFrameRoundTrip(t *testing.T) {
+	tests := []struct {
+		name  string
+		frame Frame
+	}{
+		{"no body", NewFrame("SUBSCRIBE", "destination", "/queue/a", "id", "sub-1")},
+		{"text body", Frame{Command: "SEND", Header: map[string]string{"destination": "/queue/a"}, Body: []byte("hello")}},
+		{"body with NULs", Frame{Command: "SEND", Header: map[string]string{"destination": "/queue/a"}, Body: []byte{1, 0, 2, 0, 0}}},
+		{"escaped header", NewFrame("MESSAGE", "x-reason", "line one\nline two: with \\ and \r")},
+		{"escaped header name", NewFrame("MESSAGE", "odd:name", "v")},
+		{"CONNECT is not escaped", NewFrame("CONNECT", "accept-version", "1.2", "host", "/")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := readFrame(t, writeFrame(t, tt.frame))
+			want := tt.frame
+			if len(want.Body) > 0 {
+				// content-length is added on the wire and read back
+				want.Header = map[string]string{}
+				for k, v := range tt.frame.Header {
+					want.Header[k] = v
+				}
+				want.Header["content-length"] = strings.TrimPrefix(headerLine(writeFrame(t, tt.frame), "content-length"), "content-length:")
+			}
+			if want.Body == nil {
+				want.Body = []byte{}
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("got %+v, want %+v", got, want)
+			}
+		})
+	}
+}
+
+func headerLine(wire, name string) string {
+	for _, line := range strings.Split(wire, "\n") {
+		if strings.HasPrefix(line, name+":") {
+			return line
+		}
+	}
+	return ""
+}
+
+func TestWriteFrameEscaping(t *testing.T) {
+	wire := writeFrame(t, NewFrame("SEND", "destination", "/queue/a", "note", "a:b\nc\\d"))
+	if line := headerLine(wire, "note"); line != `note:a\cb\nc\\d` {
+		t.Errorf("escaped header = %q", line)
+	}
+
+	// CONNECT and CONNECTED headers go out as they are
+	wire = writeFrame(t, NewFrame("CONNECT", "login", `a\b`))
+	if line := headerLine(wire, "login"); line != `login:a\b` {
+		t.Errorf("CONNECT header = %q", line)
+	}
+}
+
+func TestWriteFrameContentLength(t *testing.T) {
+	wire := writeFrame(t, Frame{Command: "SEND", Header: map[string]string{"content-length": "999"}, Body: []byte("abc")})
+	if line := headerLine(wire, "content-length"); line != "content-length:3" {
+		t.Errorf("content-length = %q, want the real length", line)
+	}
+	if !strings.HasSuffix(wire, "\n\nabc\x00") {
+		t.Errorf("frame doesn't end with the body and a NUL: %q", wire)
+	}
+
+	wire = writeFrame(t, NewFrame("ACK", "id", "1"))
+	if line := headerLine(wire, "content-length"); line != "" {
+		t.Errorf("empty body got %q", line)
+	}
+}
+
+func TestReadFrame(t *testing.T) {
+	tests := []struct {
+		name string
+		wire string
+		want Frame
+	}{
+		{
+			name: "heart-beat",
+			wire: "\n",
+			want: Frame{},
+		},
+		{
+			name: "CRLF line endings",
+			wire: "RECEIPT\r\nreceipt-id:r-1\r\n\r\n\x00",
+			want: Frame{Command: "RECEIPT", Header: map[string]string{"receipt-id": "r-1"}, Body: []byte{}},
+		},
+		{
+			name: "content-length reads past NULs",
+			wire: "MESSAGE\ncontent-length:3\n\na\x00b\x00",
+			want: Frame{Command: "MESSAGE", Header: map[string]string{"content-length": "3"}, Body: []byte("a\x00b")},
+		},
+		{
+			name: "no content-length reads up to the NUL",
+			wire: "MESSAGE\n\nhello\x00MESSAGE\n\n\x00",
+			want: Frame{Command: "MESSAGE", Header: map[string]string{}, Body: []byte("hello")},
+		},
+		{
+			name: "first repeated header wins",
+			wire: "MESSAGE\nfoo:1\nfoo:2\n\n\x00",
+			want: Frame{Command: "MESSAGE", Header: map[string]string{"foo": "1"}, Body: []byte{}},
+		},
+		{
+			name: "escapes are undone",
+			wire: "MESSAGE\nk\\cey:a\\cb\\nc\\\\d\\re\n\n\x00",
+			want: Frame{Command: "MESSAGE", Header: map[string]string{"k:ey": "a:b\nc\\d\re"}, Body: []byte{}},
+		},
+		{
+			name: "CONNECTED isn't unescaped",
+			wire: "CONNECTED\nserver:a\\cb\n\n\x00",
+			want: Frame{Command: "CONNECTED", Header: map[string]string{"server": `a\cb`}, Body: []byte{}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := readFrame(t, tt.wire)
+			if got.IsHeartBeat() != tt.want.IsHeartBeat() {
+				t.Fatalf("IsHeartBeat = %v, want %v", got.IsHeartBeat(), tt.want.IsHeartBeat())
+			}
+			if tt.want.IsHeartBeat() {
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadFrameErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		wire string
+	}{
+		{"header without colon", "MESSAGE\nnocolon\n\n\x00"},
+		{"undefined escape", "MESSAGE\nk:a\\tb\n\n\x00"},
+		{"dangling escape", "MESSAGE\nk:a\\\n\n\x00"},
+		{"bad content-length", "MESSAGE\ncontent-length:abc\n\n\x00"},
+		{"negative content-length", "MESSAGE\ncontent-length:-1\n\n\x00"},
+		{"huge content-length", "MESSAGE\ncontent-length:999999999\n\n\x00"},
+		{"body longer than content-length", "MESSAGE\ncontent-length:1\n\nab\x00"},
+		{"truncated body", "MESSAGE\ncontent-length:10\n\nab"},
+		{"no NUL", "MESSAGE\n\nhello"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if f, err := ReadFrame(bufio.NewReader(strings.NewReader(tt.wire))); err == nil {
+				t.Errorf("ReadFrame(%q) = %+v, want an error", tt.wire, f)
+			}
+		})
+	}
+}
@@ -0,0 +1,258 @@
+package stomp
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/pubsub"
+)
+
+// ErrUnsupported is returned for AMQP operations STOMP has no frame for. It
+// wraps pubsub.ErrUnsupported so the subscribers can tell.
+var ErrUnsupported = fmt.Errorf("stomp: %w", pubsub.ErrUnsupported)
+
+// ExchangeDestination is RabbitMQ's STOMP destination for an exchange and
+// routing key. The default exchange routes straight to the named queue.
+func ExchangeDestination(exchange, key string) string {
+	if exchange == "" {
+		return "/amq/queue/" + key
+	}
+	return "/exchange/" + exchange + "/" + key
+}
+
+// PublishWithContext publishes msg to exchange with key, which makes a
+// Client a pubsub.Publisher. It returns once the broker sent a receipt.
+func (c *Client) PublishWithContext(ctx context.Context, exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error {
+	return c.Send(ctx, ExchangeDestination(exchange, key), publishingHeader(msg), msg.Body)
+}
+
+// Consume makes a Client a pubsub.Broker. RabbitMQ declares and binds the
+// queue from the SUBSCRIBE headers; every delivery is acked on its own
+// (client-individual), so each AckType maps to one ACK or NACK frame.
+func (c *Client) Consume(exchange, queueName, key string, queueType pubsub.SimpleQueueType) (pubsub.Channel, <-chan amqp.Delivery, error) {
+	transient := strconv.FormatBool(queueType == pubsub.SimpleQueueTransient)
+	sub, err := c.Subscribe(ExchangeDestination(exchange, key), AckClientIndividual, map[string]string{
+		"x-queue-name":           queueName,
+		"durable":                strconv.FormatBool(queueType == pubsub.SimpleQueueDurable),
+		"auto-delete":            transient,
+		"exclusive":              transient,
+		"x-dead-letter-exchange": "peril_dlx",
+		"prefetch-count":         "10",
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ch := &channel{c: c, sub: sub, pending: map[uint64]Message{}}
+	deliveries := make(chan amqp.Delivery)
+	go ch.forward(deliveries)
+
+	return ch, deliveries, nil
+}
+
+// channel is the pubsub.Channel of one STOMP subscription. It also acks
+// the deliveries it hands out.
+type channel struct {
+	c   *Client
+	sub *Subscription
+
+	mu      sync.Mutex
+	nextTag uint64
+	pending map[uint64]Message
+}
+
+func (ch *channel) forward(deliveries chan<- amqp.Delivery) {
+	defer close(deliveries)
+	for m := range ch.sub.C() {
+		ch.mu.Lock()
+		ch.nextTag++
+		tag := ch.nextTag
+		ch.pending[tag] = m
+		ch.mu.Unlock()
+
+		deliveries <- toDelivery(m, tag, ch)
+	}
+}
+
+func (ch *channel) take(tag uint64) (Message, error) {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	m, ok := ch.pending[tag]
+	if !ok {
+		return Message{}, fmt.Errorf("stomp: unknown delivery tag %d", tag)
+	}
+	delete(ch.pending, tag)
+	return m, nil
+}
+
+// Ack, Nack and Reject implement amqp.Acknowledger. client-individual acks
+// never cover other messages, so multiple is ignored.
+func (ch *channel) Ack(tag uint64, multiple bool) error {
+	m, err := ch.take(tag)
+	if err != nil {
+		return err
+	}
+	return m.Ack()
+}
+
+func (ch *channel) Nack(tag uint64, multiple bool, requeue bool) error {
+	m, err := ch.take(tag)
+	if err != nil {
+		return err
+	}
+	return m.Nack(requeue)
+}
+
+func (ch *channel) Reject(tag uint64, requeue bool) error {
+	return ch.Nack(tag, false, requeue)
+}
+
+func (ch *channel) PublishWithContext(ctx context.Context, exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error {
+	return ch.c.PublishWithContext(ctx, exchange, key, mandatory, immediate, msg)
+}
+
+// QueueDeclare declares a queue the only way STOMP can: RabbitMQ creates
+// /queue/ destinations on SUBSCRIBE, so it subscribes with the queue
+// arguments as headers and unsubscribes again. With client acks and a
+// prefetch of one nothing in the queue is consumed on the way.
+func (ch *channel) QueueDeclare(name string, durable, autoDelete, exclusive, noWait bool, args amqp.Table) (amqp.Queue, error) {
+	header := map[string]string{
+		"durable":        strconv.FormatBool(durable),
+		"auto-delete":    strconv.FormatBool(autoDelete),
+		"exclusive":      strconv.FormatBool(exclusive),
+		"prefetch-count": "1",
+	}
+	for k, v := range args {
+		header[k] = fmt.Sprint(v)
+	}
+
+	sub, err := ch.c.Subscribe("/queue/"+name, AckClient, header)
+	if err != nil {
+		return amqp.Queue{}, err
+	}
+	if err := sub.Unsubscribe(); err != nil {
+		return amqp.Queue{}, err
+	}
+	return amqp.Queue{Name: name}, nil
+}
+
+func (ch *channel) ExchangeDeclare(name, kind string, durable, autoDelete, internal, noWait bool, args amqp.Table) error {
+	return ErrUnsupported
+}
+
+func (ch *channel) QueueBind(name, key, exchange string, noWait bool, args amqp.Table) error {
+	return ErrUnsupported
+}
+
+// Close unsubscribes; anything still unacked is redelivered by the broker.
+func (ch *channel) Close() error {
+	return ch.sub.Unsubscribe()
+}
+
+// headers RabbitMQ uses for AMQP properties, plus the STOMP frame headers
+// that should not show up as message headers
+var reservedHeaders = map[string]bool{
+	"destination": true, "message-id": true, "subscription": true, "ack": true,
+	"content-length": true, "content-type": true, "content-encoding": true,
+	"redelivered": true, "persistent": true, "priority": true,
+	"correlation-id": true, "reply-to": true, "expiration": true,
+	"amqp-message-id": true, "timestamp": true, "type": true,
+	"user-id": true, "app-id": true, "receipt": true,
+}
+
+func publishingHeader(msg amqp.Publishing) map[string]string {
+	h := map[string]string{}
+	for k, v := range msg.Headers {
+		h[k] = fmt.Sprint(v)
+	}
+
+	set := func(name, value string) {
+		if value != "" {
+			h[name] = value
+		}
+	}
+	set("content-type", msg.ContentType)
+	set("content-encoding", msg.ContentEncoding)
+	set("correlation-id", msg.CorrelationId)
+	set("reply-to", msg.ReplyTo)
+	set("expiration", msg.Expiration)
+	set("amqp-message-id", msg.MessageId)
+	set("type", msg.Type)
+	set("user-id", msg.UserId)
+	set("app-id", msg.AppId)
+	if msg.DeliveryMode == amqp.Persistent {
+		h["persistent"] = "true"
+	}
+	if msg.Priority > 0 {
+		h["priority"] = strconv.Itoa(int(msg.Priority))
+	}
+	if !msg.Timestamp.IsZero() {
+		h["timestamp"] = strconv.FormatInt(msg.Timestamp.Unix(), 10)
+	}
+	return h
+}
+
+func toDelivery(m Message, tag uint64, ack amqp.Acknowledger) amqp.Delivery {
+	h := m.Header
+	d := amqp.Delivery{
+		Acknowledger:    ack,
+		DeliveryTag:     tag,
+		Headers:         amqp.Table{},
+		ContentType:     h["content-type"],
+		ContentEncoding: h["content-encoding"],
+		CorrelationId:   h["correlation-id"],
+		ReplyTo:         h["reply-to"],
+		Expiration:      h["expiration"],
+		MessageId:       h["amqp-message-id"],
+		Type:            h["type"],
+		UserId:          h["user-id"],
+		AppId:           h["app-id"],
+		Redelivered:     h["redelivered"] == "true",
+		Body:            m.Body,
+	}
+	if h["persistent"] == "true" {
+		d.DeliveryMode = amqp.Persistent
+	}
+	if p, err := strconv.Atoi(h["priority"]); err == nil {
+		d.Priority = uint8(p)
+	}
+	if ts, err := strconv.ParseInt(h["timestamp"], 10, 64); err == nil {
+		d.Timestamp = time.Unix(ts, 0)
+	}
+	d.Exchange, d.RoutingKey = parseDestination(m.Destination())
+
+	for k, v := range h {
+		if !reservedHeaders[k] {
+			d.Headers[k] = v
+		}
+	}
+	return d
+}
+
+// parseDestination maps a destination back to an exchange and routing key.
+func parseDestination(dest string) (exchange, key string) {
+	switch {
+	case strings.HasPrefix(dest, "/exchange/"):
+		exchange, key, _ = strings.Cut(strings.TrimPrefix(dest, "/exchange/"), "/")
+		return exchange, key
+	case strings.HasPrefix(dest, "/amq/queue/"):
+		return "", strings.TrimPrefix(dest, "/amq/queue/")
+	case strings.HasPrefix(dest, "/queue/"):
+		return "", strings.TrimPrefix(dest, "/queue/")
+	case strings.HasPrefix(dest, "/topic/"):
+		return "amq.topic", strings.TrimPrefix(dest, "/topic/")
+	}
+	return "", dest
+}
+
+var (
+	_ pubsub.Broker    = (*Client)(nil)
+	_ pubsub.Publisher = (*Client)(nil)
+	_ pubsub.Channel   = (*channel)(nil)
+)
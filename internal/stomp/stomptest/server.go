@@ -0,0 +1,514 @@
+// Package stomptest provides an in-process STOMP 1.2 broker for exercising
+// the stomp client and the pubsub helpers without RabbitMQ.
+//
+// It understands the RabbitMQ destinations the stomp package uses
+// (/exchange/<name>/<key>, /queue/<name> and /amq/queue/<name>), topic
+// bindings, shared named queues (x-queue-name), prefetch, ACK/NACK with the
+// requeue header, and x-dead-letter-exchange on NACK without requeue. Every
+// exchange behaves like a topic exchange, and exchanges exist as soon as
+// something is bound to them.
+package stomptest
+
+import (
+	"bufio"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/stomp"
+)
+
+// Server is a fake STOMP broker listening on a loopback port.
+type Server struct {
+	// Addr is the host:port to pass to stomp.Dial.
+	Addr string
+
+	ln net.Listener
+
+	mu       sync.Mutex
+	queues   map[string]*queue
+	bindings []binding
+	nextID   int
+	conns    map[*conn]struct{}
+	closed   bool
+}
+
+type binding struct {
+	exchange string
+	pattern  string
+	queue    string
+}
+
+type message struct {
+	id          string
+	exchange    string
+	key         string
+	header      map[string]string
+	body        []byte
+	redelivered bool
+}
+
+type queue struct {
+	name       string
+	autoDelete bool
+	dlx        string
+	hasDLX     bool
+	messages   []*message
+	consumers  []*subscription
+	next       int // round-robin position
+}
+
+type subscription struct {
+	c        *conn
+	id       string
+	queue    *queue
+	ack      string
+	prefetch int
+	unacked  map[string]*message // ack id -> message
+}
+
+type conn struct {
+	s    *Server
+	nc   net.Conn
+	subs map[string]*subscription
+
+	// frames are written in order by writeLoop, so the broker never
+	// blocks on a slow reader while holding its lock
+	omu     sync.Mutex
+	cond    *sync.Cond
+	outbox  []stomp.Frame
+	closing bool
+	done    chan struct{}
+}
+
+// NewServer starts a fake broker on 127.0.0.1 with a random port.
+func NewServer() (*Server, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{
+		Addr:   ln.Addr().String(),
+		ln:     ln,
+		queues: map[string]*queue{},
+		conns:  map[*conn]struct{}{},
+	}
+	go s.accept()
+	return s, nil
+}
+
+// Close stops the server and drops every connection.
+func (s *Server) Close() {
+	s.mu.Lock()
+	s.closed = true
+	conns := make([]*conn, 0, len(s.conns))
+	for c := range s.conns {
+		conns = append(conns, c)
+	}
+	s.mu.Unlock()
+
+	s.ln.Close()
+	for _, c := range conns {
+		c.nc.Close()
+	}
+}
+
+// QueueLength reports how many ready (not yet delivered) messages a queue
+// holds, and whether it exists.
+func (s *Server) QueueLength(name string) (int, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	q, ok := s.queues[name]
+	if !ok {
+		return 0, false
+	}
+	return len(q.messages), true
+}
+
+func (s *Server) accept() {
+	for {
+		nc, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		c := &conn{s: s, nc: nc, subs: map[string]*subscription{}, done: make(chan struct{})}
+		c.cond = sync.NewCond(&c.omu)
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			nc.Close()
+			return
+		}
+		s.conns[c] = struct{}{}
+		s.mu.Unlock()
+		go c.writeLoop()
+		go c.serve()
+	}
+}
+
+func (c *conn) write(f stomp.Frame) {
+	c.omu.Lock()
+	defer c.omu.Unlock()
+	if !c.closing {
+		c.outbox = append(c.outbox, f)
+		c.cond.Signal()
+	}
+}
+
+func (c *conn) writeLoop() {
+	defer close(c.done)
+	w := bufio.NewWriter(c.nc)
+	for {
+		c.omu.Lock()
+		for len(c.outbox) == 0 && !c.closing {
+			c.cond.Wait()
+		}
+		frames := c.outbox
+		c.outbox = nil
+		closing := c.closing
+		c.omu.Unlock()
+
+		for _, f := range frames {
+			if err := stomp.WriteFrame(w, f); err != nil {
+				return
+			}
+		}
+		if err := w.Flush(); err != nil || (closing && len(frames) == 0) {
+			return
+		}
+	}
+}
+
+func (c *conn) serve() {
+	defer c.drop()
+
+	r := bufio.NewReader(c.nc)
+	connected := false
+	for {
+		f, err := stomp.ReadFrame(r)
+		if err != nil {
+			return
+		}
+		if f.IsHeartBeat() {
+			continue
+		}
+
+		if !connected {
+			if f.Command != "CONNECT" && f.Command != "STOMP" {
+				c.error("expected CONNECT")
+				return
+			}
+			connected = true
+			c.connected(f)
+			continue
+		}
+
+		switch f.Command {
+		case "SEND":
+			c.s.send(f)
+		case "SUBSCRIBE":
+			if msg := c.s.subscribe(c, f); msg != "" {
+				c.error(msg)
+				return
+			}
+		case "UNSUBSCRIBE":
+			c.s.unsubscribe(c, f.Header["id"])
+		case "ACK", "NACK":
+			c.s.settle(c, f)
+		case "DISCONNECT":
+			c.receipt(f)
+			return
+		default:
+			c.error("unsupported frame " + f.Command)
+			return
+		}
+
+		// SUBSCRIBE answers its own receipt after the queue is set up
+		if f.Command != "SUBSCRIBE" {
+			c.receipt(f)
+		}
+	}
+}
+
+func (c *conn) connected(f stomp.Frame) {
+	resp := stomp.NewFrame("CONNECTED", "version", "1.2", "server", "stomptest", "heart-beat", "0,0")
+
+	// echo the client's send interval back as what we expect, and send
+	// heart-beats as often as the client asks for them
+	if cx, cy, ok := strings.Cut(f.Header["heart-beat"], ","); ok {
+		want, _ := strconv.Atoi(cy)
+		resp.Header["heart-beat"] = strconv.Itoa(want) + "," + cx
+		if want > 0 {
+			go c.heartBeats(time.Duration(want) * time.Millisecond)
+		}
+	}
+	c.write(resp)
+}
+
+func (c *conn) heartBeats(every time.Duration) {
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+			c.write(stomp.Frame{})
+		}
+	}
+}
+
+func (c *conn) receipt(f stomp.Frame) {
+	if id, ok := f.Header["receipt"]; ok {
+		c.write(stomp.NewFrame("RECEIPT", "receipt-id", id))
+	}
+}
+
+func (c *conn) error(msg string) {
+	c.write(stomp.NewFrame("ERROR", "message", msg))
+}
+
+// drop requeues everything the connection had unacked and removes its
+// subscriptions.
+func (c *conn) drop() {
+	// let queued frames (such as a DISCONNECT receipt) go out first
+	c.omu.Lock()
+	c.closing = true
+	c.cond.Signal()
+	c.omu.Unlock()
+	select {
+	case <-c.done:
+	case <-time.After(time.Second):
+	}
+	c.nc.Close()
+
+	c.s.mu.Lock()
+	defer c.s.mu.Unlock()
+	for id := range c.subs {
+		c.s.removeSubscription(c, id)
+	}
+	delete(c.s.conns, c)
+}
+
+func (s *Server) send(f stomp.Frame) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	dest := f.Header["destination"]
+	header := map[string]string{}
+	for k, v := range f.Header {
+		if k != "destination" && k != "receipt" && k != "content-length" {
+			header[k] = v
+		}
+	}
+
+	switch {
+	case strings.HasPrefix(dest, "/exchange/"):
+		exchange, key, _ := strings.Cut(strings.TrimPrefix(dest, "/exchange/"), "/")
+		s.route(exchange, key, header, f.Body)
+	case strings.HasPrefix(dest, "/queue/"), strings.HasPrefix(dest, "/amq/queue/"):
+		name := strings.TrimPrefix(strings.TrimPrefix(dest, "/amq"), "/queue/")
+		q := s.declare(name, map[string]string{})
+		s.enqueue(q, &message{exchange: "", key: name, header: header, body: f.Body})
+	}
+}
+
+// route delivers to every queue bound to exchange with a matching pattern.
+// The empty exchange is RabbitMQ's default exchange: the key is the queue.
+func (s *Server) route(exchange, key string, header map[string]string, body []byte) {
+	if exchange == "" {
+		if q, ok := s.queues[key]; ok {
+			s.enqueue(q, &message{key: key, header: header, body: body})
+		}
+		return
+	}
+	seen := map[string]bool{}
+	for _, b := range s.bindings {
+		if b.exchange != exchange || seen[b.queue] || !routing.Match(b.pattern, key) {
+			continue
+		}
+		seen[b.queue] = true
+		if q, ok := s.queues[b.queue]; ok {
+			s.enqueue(q, &message{exchange: exchange, key: key, header: header, body: body})
+		}
+	}
+}
+
+func (s *Server) declare(name string, header map[string]string) *queue {
+	q, ok := s.queues[name]
+	if !ok {
+		q = &queue{name: name}
+		s.queues[name] = q
+	}
+	if header["auto-delete"] == "true" || header["exclusive"] == "true" {
+		q.autoDelete = true
+	}
+	if dlx, ok := header["x-dead-letter-exchange"]; ok {
+		q.dlx, q.hasDLX = dlx, true
+	}
+	return q
+}
+
+func (s *Server) subscribe(c *conn, f stomp.Frame) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	dest := f.Header["destination"]
+	var q *queue
+	switch {
+	case strings.HasPrefix(dest, "/exchange/"):
+		exchange, pattern, _ := strings.Cut(strings.TrimPrefix(dest, "/exchange/"), "/")
+		name := f.Header["x-queue-name"]
+		if name == "" {
+			s.nextID++
+			name = "stomp-subscription-" + strconv.Itoa(s.nextID)
+			f.Header["auto-delete"] = "true"
+		}
+		q = s.declare(name, f.Header)
+		s.bindings = append(s.bindings, binding{exchange: exchange, pattern: pattern, queue: name})
+	case strings.HasPrefix(dest, "/queue/"):
+		q = s.declare(strings.TrimPrefix(dest, "/queue/"), f.Header)
+	case strings.HasPrefix(dest, "/amq/queue/"):
+		var ok bool
+		if q, ok = s.queues[strings.TrimPrefix(dest, "/amq/queue/")]; !ok {
+			return "no queue for " + dest
+		}
+	default:
+		return "unknown destination " + dest
+	}
+
+	ack := f.Header["ack"]
+	if ack == "" {
+		ack = "auto"
+	}
+	prefetch, _ := strconv.Atoi(f.Header["prefetch-count"])
+	sub := &subscription{c: c, id: f.Header["id"], queue: q, ack: ack, prefetch: prefetch, unacked: map[string]*message{}}
+	c.subs[sub.id] = sub
+	q.consumers = append(q.consumers, sub)
+
+	c.receipt(f)
+	s.dispatch(q)
+	return ""
+}
+
+func (s *Server) unsubscribe(c *conn, id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.removeSubscription(c, id)
+}
+
+// removeSubscription must be called with s.mu held.
+func (s *Server) removeSubscription(c *conn, id string) {
+	sub, ok := c.subs[id]
+	if !ok {
+		return
+	}
+	delete(c.subs, id)
+
+	q := sub.queue
+	for i, other := range q.consumers {
+		if other == sub {
+			q.consumers = append(q.consumers[:i], q.consumers[i+1:]...)
+			break
+		}
+	}
+	for _, m := range sub.unacked {
+		m.redelivered = true
+		q.messages = append([]*message{m}, q.messages...)
+	}
+
+	if q.autoDelete && len(q.consumers) == 0 {
+		delete(s.queues, q.name)
+		kept := s.bindings[:0]
+		for _, b := range s.bindings {
+			if b.queue != q.name {
+				kept = append(kept, b)
+			}
+		}
+		s.bindings = kept
+		return
+	}
+	s.dispatch(q)
+}
+
+func (s *Server) settle(c *conn, f stomp.Frame) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id := f.Header["id"]
+	for _, sub := range c.subs {
+		m, ok := sub.unacked[id]
+		if !ok {
+			continue
+		}
+		delete(sub.unacked, id)
+		q := sub.queue
+
+		if f.Command == "NACK" {
+			if f.Header["requeue"] != "false" {
+				m.redelivered = true
+				q.messages = append([]*message{m}, q.messages...)
+			} else if q.hasDLX {
+				s.route(q.dlx, m.key, m.header, m.body)
+			}
+		}
+		s.dispatch(q)
+		return
+	}
+}
+
+// enqueue must be called with s.mu held.
+func (s *Server) enqueue(q *queue, m *message) {
+	s.nextID++
+	m.id = "msg-" + strconv.Itoa(s.nextID)
+	q.messages = append(q.messages, m)
+	s.dispatch(q)
+}
+
+// dispatch hands ready messages to consumers round-robin, respecting
+// prefetch. It must be called with s.mu held.
+func (s *Server) dispatch(q *queue) {
+	for len(q.messages) > 0 {
+		sub := q.nextConsumer()
+		if sub == nil {
+			return
+		}
+		m := q.messages[0]
+		q.messages = q.messages[1:]
+
+		dest := "/exchange/" + m.exchange + "/" + m.key
+		if m.exchange == "" {
+			dest = "/amq/queue/" + m.key
+		}
+		f := stomp.NewFrame("MESSAGE", "destination", dest, "message-id", m.id, "subscription", sub.id)
+		for k, v := range m.header {
+			f.Header[k] = v
+		}
+		if m.redelivered {
+			f.Header["redelivered"] = "true"
+		}
+		if sub.ack != "auto" {
+			s.nextID++
+			ackID := "ack-" + strconv.Itoa(s.nextID)
+			f.Header["ack"] = ackID
+			sub.unacked[ackID] = m
+		}
+		f.Body = m.body
+
+		sub.c.write(f)
+	}
+}
+
+func (q *queue) nextConsumer() *subscription {
+	for i := 0; i < len(q.consumers); i++ {
+		sub := q.consumers[(q.next+i)%len(q.consumers)]
+		if sub.ack == "auto" || sub.prefetch <= 0 || len(sub.unacked) < sub.prefetch {
+			q.next = (q.next + i + 1) % len(q.consumers)
+			return sub
+		}
+	}
+	return nil
+}
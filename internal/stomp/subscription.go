@@ -0,0 +1,96 @@
+package stomp
+
+import (
+	"context"
+	"sync"
+)
+
+// Subscription delivers the MESSAGE frames of one SUBSCRIBE.
+type Subscription struct {
+	c    *Client
+	id   string
+	mode AckMode
+
+	deliveries chan Message
+	stopped    chan struct{}
+	once       sync.Once
+
+	mu   sync.Mutex // guards closing deliveries against dispatch
+	done bool
+}
+
+// Message is a MESSAGE frame received on a subscription.
+type Message struct {
+	Frame
+	sub *Subscription
+}
+
+// Destination is where the message was published, e.g.
+// /exchange/peril_topic/army_moves.alice.
+func (m Message) Destination() string {
+	return m.Header["destination"]
+}
+
+// Ack acknowledges the message. In auto mode it does nothing.
+func (m Message) Ack() error {
+	if m.sub.mode == AckAuto {
+		return nil
+	}
+	return m.sub.c.write(NewFrame("ACK", "id", m.Header["ack"]))
+}
+
+// Nack rejects the message. requeue is a RabbitMQ extension: without it the
+// message is dead-lettered (or dropped) instead of being delivered again.
+func (m Message) Nack(requeue bool) error {
+	if m.sub.mode == AckAuto {
+		return nil
+	}
+	f := NewFrame("NACK", "id", m.Header["ack"])
+	if !requeue {
+		f.Header["requeue"] = "false"
+	}
+	return m.sub.c.write(f)
+}
+
+// C returns the channel of messages; it is closed when the subscription or
+// the connection ends.
+func (s *Subscription) C() <-chan Message {
+	return s.deliveries
+}
+
+// Unsubscribe ends the subscription; unacked messages go back to the broker.
+func (s *Subscription) Unsubscribe() error {
+	s.c.mu.Lock()
+	_, active := s.c.subs[s.id]
+	delete(s.c.subs, s.id)
+	s.c.mu.Unlock()
+
+	s.finish()
+	if !active {
+		return nil
+	}
+	return s.c.request(context.Background(), NewFrame("UNSUBSCRIBE", "id", s.id))
+}
+
+func (s *Subscription) dispatch(f Frame) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.done {
+		return
+	}
+	select {
+	case s.deliveries <- Message{Frame: f, sub: s}:
+	case <-s.stopped:
+	}
+}
+
+func (s *Subscription) finish() {
+	s.once.Do(func() {
+		// wake a blocked dispatch first so it lets go of mu
+		close(s.stopped)
+		s.mu.Lock()
+		s.done = true
+		close(s.deliveries)
+		s.mu.Unlock()
+	})
+}
@@ -0,0 +1,208 @@
+// Package topics is the registry of everything Peril sends over RabbitMQ:
+// which struct travels on which exchange, under which keys and in which
+// encoding.
+//
+// The Topic type itself is routing.Topic; the registry lives here because
+// routing can't import the gamelogic message types without an import cycle.
+package topics
+
+import (
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/gamelogic"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+)
+
+// Commands are how players ask the server to spawn or move units. Only the
+// server consumes them.
+var Commands = routing.Topic[gamelogic.Command]{
+	Name:        "commands",
+	Description: "A player asks the server to join, spawn or move. Nothing changes until the server accepts it.",
+	Exchange:    routing.ExchangePerilTopic,
+	Key:         routing.CommandsPrefix + ".{username}",
+	Codec:       routing.JSON[gamelogic.Command](),
+	Queue:       routing.QueueSpec{Name: routing.CommandsPrefix, Durable: true},
+}
+
+var PlayerUpdates = routing.Topic[gamelogic.PlayerUpdate]{
+	Name:        "player_updates",
+	Description: "A change the server accepted for one player, or why it refused their command.",
+	Exchange:    routing.ExchangePerilTopic,
+	Key:         routing.PlayerUpdatesPrefix + ".{username}",
+	Codec:       routing.JSON[gamelogic.PlayerUpdate](),
+	Queue:       routing.QueueSpec{Name: routing.PlayerUpdatesPrefix + ".{username}"},
+}
+
+// Diplomacy is how players offer, accept and break pacts. Like Commands,
+// only the server consumes them.
+var Diplomacy = routing.Topic[gamelogic.DiplomacyCommand]{
+	Name:        "diplomacy",
+	Description: "A player offers, accepts or breaks an alliance or truce. A pact only holds once both sides have offered it.",
+	Exchange:    routing.ExchangePerilTopic,
+	Key:         routing.DiplomacyPrefix + ".{username}",
+	Codec:       routing.JSON[gamelogic.DiplomacyCommand](),
+	Queue:       routing.QueueSpec{Name: routing.DiplomacyPrefix, Durable: true},
+}
+
+var DiplomacyUpdates = routing.Topic[gamelogic.DiplomacyUpdate]{
+	Name:        "diplomacy_updates",
+	Description: "What happened to an offer or pact a player is part of, or why the server refused their diplomacy command.",
+	Exchange:    routing.ExchangePerilTopic,
+	Key:         routing.DiplomacyUpdatesPrefix + ".{username}",
+	Codec:       routing.JSON[gamelogic.DiplomacyUpdate](),
+	Queue:       routing.QueueSpec{Name: routing.DiplomacyUpdatesPrefix + ".{username}"},
+}
+
+// ArmyMoves are keyed by the player they're for, not the mover: each player
+// gets their own copy of a move, with only what they can see.
+var ArmyMoves = routing.Topic[gamelogic.ArmyMove]{
+	Name:        "army_moves",
+	Description: "The server accepted a move the recipient can see. It carries only the mover's units in territories the recipient can see.",
+	Exchange:    routing.ExchangePerilTopic,
+	Key:         routing.ArmyMovesPrefix + ".{username}",
+	Codec:       routing.JSON[gamelogic.ArmyMove](),
+	Queue:       routing.QueueSpec{Name: routing.ArmyMovesPrefix + ".{username}"},
+}
+
+// WarRecognitions go to both sides, keyed by each of them. The server
+// settles every war itself; the seed lets the players (or anyone watching)
+// work out the same result.
+var WarRecognitions = routing.Topic[gamelogic.RecognitionOfWar]{
+	Name:        "war",
+	Description: "A move ran into another player's units and the two are now at war, as the server saw them, with the combat resolver and dice seed.",
+	Exchange:    routing.ExchangePerilTopic,
+	Key:         routing.WarRecognitionsPrefix + ".{username}",
+	Codec:       routing.JSON[gamelogic.RecognitionOfWar](),
+	Queue:       routing.QueueSpec{Name: routing.WarRecognitionsPrefix + ".{username}"},
+}
+
+var WarOutcomes = routing.Topic[gamelogic.WarResult]{
+	Name:        "war_outcomes",
+	Description: "How a war ended, sent to each side. Casualties follow as player updates.",
+	Exchange:    routing.ExchangePerilTopic,
+	Key:         routing.WarOutcomesPrefix + ".{username}",
+	Codec:       routing.JSON[gamelogic.WarResult](),
+	Queue:       routing.QueueSpec{Name: routing.WarOutcomesPrefix + ".{username}"},
+}
+
+// Battles go to everyone who had units in the location, keyed by each of
+// them and by the location, after the results of the battle's wars.
+var Battles = routing.Topic[gamelogic.Battle]{
+	Name:        "battles",
+	Description: "All the fighting in one contested location: who was there, every war fought between them, and who is left.",
+	Exchange:    routing.ExchangePerilTopic,
+	Key:         routing.BattlesPrefix + ".{username}.{location}",
+	Codec:       routing.JSON[gamelogic.Battle](),
+	Queue:       routing.QueueSpec{Name: routing.BattlesPrefix + ".{username}"},
+}
+
+// Chat goes to the server, keyed by the sender, and comes back out as
+// ChatMessages to whoever it's for. Only the server consumes it.
+var Chat = routing.Topic[gamelogic.ChatMessage]{
+	Name:        "chat",
+	Description: "A player says something to everyone, whispers to one player or talks to their allies. Nobody hears it until the server passes it on.",
+	Exchange:    routing.ExchangePerilTopic,
+	Key:         routing.ChatPrefix + ".{username}",
+	Codec:       routing.JSON[gamelogic.ChatMessage](),
+	Queue:       routing.QueueSpec{Name: routing.ChatPrefix, Durable: true},
+}
+
+var ChatMessages = routing.Topic[gamelogic.ChatMessage]{
+	Name:        "chat_messages",
+	Description: "A chat message for the recipient, after moderation, or their own message back with why the server refused it.",
+	Exchange:    routing.ExchangePerilTopic,
+	Key:         routing.ChatPrefix + ".to.{username}",
+	Codec:       routing.JSON[gamelogic.ChatMessage](),
+	Queue:       routing.QueueSpec{Name: routing.ChatPrefix + ".to.{username}"},
+}
+
+var Pause = routing.Topic[routing.PlayingState]{
+	Name:        "pause",
+	Description: "The server paused or resumed the game.",
+	Exchange:    routing.ExchangePerilDirect,
+	Key:         routing.PauseKey,
+	Codec:       routing.JSON[routing.PlayingState](),
+	Queue:       routing.QueueSpec{Name: routing.PauseKey + ".{username}"},
+}
+
+var GameLogs = routing.Topic[routing.GameLog]{
+	Name:        "game_logs",
+	Description: "Something worth remembering happened; the server appends it to game.log.",
+	Exchange:    routing.ExchangePerilTopic,
+	Key:         routing.GameLogSlug + ".{username}",
+	Codec:       routing.Gob[routing.GameLog](),
+	Queue:       routing.QueueSpec{Name: routing.GameLogSlug, Durable: true},
+}
+
+// Ticks go to everyone, like Pause. Each player's own income also arrives
+// as a player update.
+var Ticks = routing.Topic[gamelogic.Tick]{
+	Name:        "tick",
+	Description: "The server's clock ticked and paid every player the income of the territories they hold. Each player's income comes as a player update.",
+	Exchange:    routing.ExchangePerilDirect,
+	Key:         routing.TickKey,
+	Codec:       routing.JSON[gamelogic.Tick](),
+	Queue:       routing.QueueSpec{Name: routing.TickKey + ".{username}"},
+}
+
+// TurnStarts and TurnEnds only flow in turn mode. A turn is sent again
+// when the game resumes, with its end pushed back.
+var TurnStarts = routing.Topic[gamelogic.TurnStart]{
+	Name:        "turn_start",
+	Description: "A turn opened (or resumed after a pause); orders are queued until it ends.",
+	Exchange:    routing.ExchangePerilDirect,
+	Key:         routing.TurnStartKey,
+	Codec:       routing.JSON[gamelogic.TurnStart](),
+	Queue:       routing.QueueSpec{Name: routing.TurnStartKey + ".{username}"},
+}
+
+var TurnEnds = routing.Topic[gamelogic.TurnEnd]{
+	Name:        "turn_end",
+	Description: "A turn closed; every queued order is resolved at once and the results follow.",
+	Exchange:    routing.ExchangePerilDirect,
+	Key:         routing.TurnEndKey,
+	Codec:       routing.JSON[gamelogic.TurnEnd](),
+	Queue:       routing.QueueSpec{Name: routing.TurnEndKey + ".{username}"},
+}
+
+// Scores are keyed by the player they're for, like ArmyMoves: each player
+// only sees who owns the territories they can see. They're sent whenever
+// that view changes, and to a player who joins.
+var Scores = routing.Topic[gamelogic.Scoreboard]{
+	Name:        "scores",
+	Description: "The scores changed: everyone's points, best first, and who owns each territory the recipient can see.",
+	Exchange:    routing.ExchangePerilTopic,
+	Key:         routing.ScoresKey + ".{username}",
+	Codec:       routing.JSON[gamelogic.Scoreboard](),
+	Queue:       routing.QueueSpec{Name: routing.ScoresKey + ".{username}"},
+}
+
+var GameOver = routing.Topic[gamelogic.GameOver]{
+	Name:        "game_over",
+	Description: "Somebody met a victory condition. The server accepts no more spawns or moves.",
+	Exchange:    routing.ExchangePerilDirect,
+	Key:         routing.GameOverKey,
+	Codec:       routing.JSON[gamelogic.GameOver](),
+	Queue:       routing.QueueSpec{Name: routing.GameOverKey + ".{username}"},
+}
+
+// All lists every topic, in the order they are documented.
+func All() []routing.TopicInfo {
+	return []routing.TopicInfo{
+		Commands.Info(),
+		PlayerUpdates.Info(),
+		Diplomacy.Info(),
+		DiplomacyUpdates.Info(),
+		ArmyMoves.Info(),
+		WarRecognitions.Info(),
+		WarOutcomes.Info(),
+		Battles.Info(),
+		Chat.Info(),
+		ChatMessages.Info(),
+		Pause.Info(),
+		Ticks.Info(),
+		TurnStarts.Info(),
+		TurnEnds.Info(),
+		Scores.Info(),
+		GameOver.Info(),
+		GameLogs.Info(),
+	}
+}
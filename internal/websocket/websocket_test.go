@@ -0,0 +1,57 @@
+package websocket
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestUpgradeOrigin(t *testing.T) {
+	allowed := []string{"http://localhost:3000/"}
+	tests := []struct {
+		name   string
+		origin string
+		want   int
+	}{
+		{"no origin", "", http.StatusSwitchingProtocols},
+		{"same host", "http://localhost:8080", http.StatusSwitchingProtocols},
+		{"allowed", "http://localhost:3000", http.StatusSwitchingProtocols},
+		{"allowed, any case", "HTTP://LOCALHOST:3000", http.StatusSwitchingProtocols},
+		{"other port", "http://localhost:9999", http.StatusForbidden},
+		{"other site", "https://evil.example", http.StatusForbidden},
+		{"same host in the path", "https://evil.example/localhost:8080", http.StatusForbidden},
+		{"garbage", "::", http.StatusForbidden},
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.Host = "localhost:8080"
+		if ws, err := Upgrade(w, r, allowed); err == nil {
+			ws.Close()
+		}
+	}))
+	defer srv.Close()
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, srv.URL, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("Connection", "Upgrade")
+			req.Header.Set("Upgrade", "websocket")
+			req.Header.Set("Sec-WebSocket-Version", "13")
+			req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+			if tt.origin != "" {
+				req.Header.Set("Origin", tt.origin)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.want {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.want)
+			}
+		})
+	}
+}
@@ -12,8 +12,8 @@ start_or_run () {
         --name peril_rabbitmq \
         -p 5672:5672 \
         -p 15672:15672 \
-        rabbitmq-stomp \
-        -p 61613:61613
+        -p 61613:61613 \
+        rabbitmq-stomp
 
     fi
 }